@@ -2,23 +2,97 @@ package cmdparser
 
 import (
 	"os"
+	"time"
 
 	flags "github.com/jessevdk/go-flags"
 )
 
 // CmdOptions holds command line options passed
 type CmdOptions struct {
-	LogLevel string   `long:"loglevel" default:"info" description:"Set logging vefrobisty level, e.g. info, error, debug, trace" env:"DBZ2PG_LOGLEVEL"`
-	Postgres string   `long:"postgres" description:"PostgreSQL connection string" env:"DBZ2PG_PGURL"`
-	Kafka    []string `long:"kafka" description:"Kafka connection string" env:"DBZ2PG_KAFKA"`
-	Topic    string   `long:"topic" description:"Topic name (or prefix of the topic name) to consume" env:"DBZ2PG_TOPIC" required:"True"`
-	Timeout  int      `long:"timeout" default:"10" description:"Idle timeout for consuming kafka messages" env:"DBZ2PG_TIMEOUT"`
+	LogLevel             string            `long:"loglevel" default:"info" description:"Set logging vefrobisty level, e.g. info, error, debug, trace" env:"DBZ2PG_LOGLEVEL"`
+	Postgres             string            `long:"postgres" description:"PostgreSQL connection string" env:"DBZ2PG_PGURL"`
+	ApplicationName      string            `long:"application-name" description:"Application name reported to PostgreSQL, e.g. debezium2postgres" env:"DBZ2PG_APPLICATION_NAME"`
+	StatementCacheMode   string            `long:"statement-cache-mode" description:"Cache of the applied statements: prepare them, describe them only, e.g. behind PgBouncer in transaction mode, or none" choice:"prepare" choice:"describe" choice:"none" env:"DBZ2PG_STATEMENT_CACHE_MODE"`
+	Kafka                []string          `long:"kafka" description:"Kafka connection string" env:"DBZ2PG_KAFKA"`
+	Topic                string            `long:"topic" description:"Topic name (or prefix of the topic name) to consume" env:"DBZ2PG_TOPIC" required:"True"`
+	Preflight            []string          `long:"preflight" description:"Target table checked for existence, privileges and primary key before streaming, e.g. public.orders" env:"DBZ2PG_PREFLIGHT" env-delim:","`
+	Timeout              int               `long:"timeout" default:"10" description:"Idle timeout for consuming kafka messages" env:"DBZ2PG_TIMEOUT"`
+	BatchSize            int               `long:"batch-size" default:"1" description:"Number of messages applied in a single transaction" env:"DBZ2PG_BATCH_SIZE"`
+	CollapseBatches      bool              `long:"collapse-batches" description:"Collapse changes of each row within the batch into the last one, so bursts of changes of the same row are applied once" env:"DBZ2PG_COLLAPSE_BATCHES"`
+	IsolateItems         bool              `long:"isolate-items" description:"Apply each CDC item of the batch within a savepoint, so failing items are handled by the error policy while the rest of the batch commits" env:"DBZ2PG_ISOLATE_ITEMS"`
+	FlushInterval        time.Duration     `long:"flush-interval" default:"1s" description:"Time after which the batch is applied even if it's not full" env:"DBZ2PG_FLUSH_INTERVAL"`
+	MaxWritesPerSecond   float64           `long:"max-writes-per-second" description:"Maximum number of messages applied per second; 0 means unlimited" env:"DBZ2PG_MAX_WRITES_PER_SECOND"`
+	SnapshotCopy         bool              `long:"snapshot-copy" description:"Load rows of the initial snapshot with COPY instead of skipping them" env:"DBZ2PG_SNAPSHOT_COPY"`
+	SnapshotCopySize     int               `long:"snapshot-copy-size" default:"10000" description:"Number of snapshot rows loaded with a single COPY" env:"DBZ2PG_SNAPSHOT_COPY_SIZE"`
+	SnapshotCopyInterval time.Duration     `long:"snapshot-copy-interval" default:"5s" description:"Time after which buffered snapshot rows are loaded" env:"DBZ2PG_SNAPSHOT_COPY_INTERVAL"`
+	ShutdownGrace        int               `long:"shutdown-grace" default:"5" description:"Time in seconds to apply already consumed messages on shutdown" env:"DBZ2PG_SHUTDOWN_GRACE"`
+	GroupTransactions    bool              `long:"group-transactions" description:"Apply changes of each source transaction atomically using the transaction metadata of the connector" env:"DBZ2PG_GROUP_TRANSACTIONS"`
+	TransactionMaxEvents int               `long:"transaction-max-events" default:"100000" description:"Number of buffered changes of a source transaction applied before it's complete; 0 means no limit" env:"DBZ2PG_TRANSACTION_MAX_EVENTS"`
+	TransactionTimeout   time.Duration     `long:"transaction-timeout" default:"5m" description:"Time after which the changes of an incomplete source transaction are applied; 0 means no limit" env:"DBZ2PG_TRANSACTION_TIMEOUT"`
+	Ledger               string            `long:"ledger" description:"Table recording offsets of the applied messages to skip replayed ones, e.g. public.dbz2pg_ledger" env:"DBZ2PG_LEDGER"`
+	OffsetTable          string            `long:"offset-table" description:"Table saving the last applied offset of each topic to resume consuming after it, e.g. public.dbz2pg_offsets" env:"DBZ2PG_OFFSET_TABLE"`
+	LagThreshold         time.Duration     `long:"lag-threshold" description:"Delay between the source change and its applying to warn about, e.g. 5m; 0 disables warnings" env:"DBZ2PG_LAG_THRESHOLD"`
+	DLQTopic             string            `long:"dlq-topic" description:"Topic name to send messages that cannot be applied" env:"DBZ2PG_DLQ_TOPIC"`
+	ErrorPolicy          string            `long:"error-policy" default:"skip" description:"Handling of CDC items failing to apply: log and skip them, pass them to the dead-letter topic or stop applying" choice:"skip" choice:"dlq" choice:"halt" env:"DBZ2PG_ERROR_POLICY"`
+	StartOffset          int64             `long:"start-offset" description:"Offset to start consuming from, e.g. to replay messages" env:"DBZ2PG_START_OFFSET"`
+	EndOffset            int64             `long:"end-offset" description:"Offset to stop consuming and applying at" env:"DBZ2PG_END_OFFSET"`
+	ValueCompression     string            `long:"value-compression" description:"Decompress message values compressed by the producer before decoding them" choice:"gzip" choice:"snappy" choice:"lz4" env:"DBZ2PG_VALUE_COMPRESSION"`
+	BinaryHandling       string            `long:"binary-handling" default:"bytes" description:"Encoding of binary values, i.e. binary.handling.mode of the connector" choice:"bytes" choice:"base64" choice:"base64-url-safe" choice:"hex" env:"DBZ2PG_BINARY_HANDLING"`
+	DecimalHandling      string            `long:"decimal-handling" description:"Encoding of decimal values, i.e. decimal.handling.mode of the connector; values sent otherwise fail" choice:"precise" choice:"string" choice:"double" env:"DBZ2PG_DECIMAL_HANDLING"`
+	ClampInfinity        bool              `long:"clamp-infinity" description:"Apply infinite dates and timestamps as 0001-01-01 or 9999-12-31" env:"DBZ2PG_CLAMP_INFINITY"`
+	SpecialNumericAsNull bool              `long:"special-numeric-as-null" description:"Apply NaN and infinite numeric values as NULL" env:"DBZ2PG_SPECIAL_NUMERIC_AS_NULL"`
+	InsertMode           string            `long:"insert-mode" default:"insert" description:"Apply inserts as plain INSERT, guarded by the key to skip already existing rows or ignoring conflicts or updating conflicting rows" choice:"insert" choice:"guarded" choice:"ignore" choice:"upsert" env:"DBZ2PG_INSERT_MODE"`
+	TablePolicies        map[string]string `long:"table-policy" description:"Policies of applying each operation to the table overriding insert-mode and update-mode, as op=policy[:op=policy...], e.g. public.orders:insert=upsert:delete=soft" env:"DBZ2PG_TABLE_POLICIES" env-delim:","`
+	UpsertTables         []string          `long:"upsert-table" description:"Table inserts into are upserts regardless of the insert mode, e.g. public.orders" env:"DBZ2PG_UPSERT_TABLES" env-delim:","`
+	InsertConflicts      map[string]string `long:"insert-conflict" description:"Policy for inserts conflicting with existing rows of the table regardless of the insert mode, e.g. public.events:ignore" env:"DBZ2PG_INSERT_CONFLICTS" env-delim:","`
+	UpdateOnDuplicate    []string          `long:"update-on-duplicate" description:"Table inserts into are retried as updates if they fail with duplicate key, e.g. public.measurements" env:"DBZ2PG_UPDATE_ON_DUPLICATE" env-delim:","`
+	DeleteMissing        map[string]string `long:"delete-missing" description:"Policy for deletes of rows missing in the table: ok to count them only or strict to fail, e.g. public.events:ok" env:"DBZ2PG_DELETE_MISSING" env-delim:","`
+	IdempotentDeletes    bool              `long:"idempotent-deletes" description:"Treat deletes of rows already missing in the tables without delete-missing policy as success, e.g. when replaying messages" env:"DBZ2PG_IDEMPOTENT_DELETES"`
+	StagingTables        []string          `long:"staging-table" description:"Table batches are merged into through an unlogged staging table, e.g. public.events" env:"DBZ2PG_STAGING_TABLES" env-delim:","`
+	StagingKind          string            `long:"staging-kind" default:"unlogged" description:"Kind of staging tables" choice:"unlogged" choice:"temporary" choice:"ordinary" env:"DBZ2PG_STAGING_KIND"`
+	StagingSchema        string            `long:"staging-schema" description:"Schema staging tables are created in, e.g. dbz2pg; the schema of the target table by default" env:"DBZ2PG_STAGING_SCHEMA"`
+	Partitions           map[string]string `long:"partition" description:"Range partitions of the table created when inserted rows have none, as column:interval[:name] with day, week, month or year interval, e.g. public.orders:created_at:month" env:"DBZ2PG_PARTITIONS" env-delim:","`
+	KeyColumns           []string          `long:"key-column" description:"Column identifying rows of the table instead of the message key, checked to be covered by a unique index on startup, e.g. orders.tenant_id" env:"DBZ2PG_KEY_COLUMNS" env-delim:","`
+	ConflictKeys         []string          `long:"conflict-key" description:"Column identifying conflicting rows of upserts and ignored inserts instead of the message key, e.g. orders.order_no" env:"DBZ2PG_CONFLICT_KEYS" env-delim:","`
+	MatchReplicaIdentity bool              `long:"match-replica-identity" description:"Match updated and deleted rows by the full old row image if the source table has REPLICA IDENTITY FULL" env:"DBZ2PG_MATCH_REPLICA_IDENTITY"`
+	UpdateMode           string            `long:"update-mode" default:"update" description:"Apply updates as plain UPDATE or as MERGE inserting missing rows on PostgreSQL 15+" choice:"update" choice:"merge" env:"DBZ2PG_UPDATE_MODE"`
+	UpsertUpdates        bool              `long:"upsert-updates" description:"Apply updates of the tables with upserts as upserts too, so updates of missing rows insert them" env:"DBZ2PG_UPSERT_UPDATES"`
+	PositionGuard        bool              `long:"position-guard" description:"Write the source LSN of changes to the __source_lsn column of the target rows and skip updates and deletes older than it" env:"DBZ2PG_POSITION_GUARD"`
+	ArchiveDeletes       []string          `long:"archive-deletes" description:"Table rows deleted from are archived into the <table>_deleted table first, e.g. public.orders" env:"DBZ2PG_ARCHIVE_DELETES" env-delim:","`
+	HistoryTables        []string          `long:"history-table" description:"Table keeping all versions of the rows valid from and to the source timestamp, as table[:valid_from:valid_to], e.g. public.customers" env:"DBZ2PG_HISTORY_TABLES" env-delim:","`
+	LastWriteWins        []string          `long:"last-write-wins" description:"Table conflicting writes are resolved in by the source timestamp kept in the __updated_ts column, so older changes are skipped, e.g. public.orders" env:"DBZ2PG_LAST_WRITE_WINS" env-delim:","`
+	AppendMode           bool              `long:"append-mode" description:"Append all changes to <table>_cdc_log(op, ts, data jsonb) tables instead of applying them" env:"DBZ2PG_APPEND_MODE"`
+	ApplyDDL             bool              `long:"apply-ddl" description:"Execute DDL statements of the schema change topic against the target" env:"DBZ2PG_APPLY_DDL"`
+	AllowDestructiveDDL  bool              `long:"allow-destructive-ddl" description:"Execute DDL statements dropping tables, columns or data too" env:"DBZ2PG_ALLOW_DESTRUCTIVE_DDL"`
+	TranslateDDL         bool              `long:"translate-ddl" description:"Translate structured table changes of the schema change topic to PostgreSQL DDL instead of executing the source DDL" env:"DBZ2PG_TRANSLATE_DDL"`
+	SchemaTopic          string            `long:"schema-topic" description:"Schema change topic consumed separately, so its changes are applied before the data changes needing them" env:"DBZ2PG_SCHEMA_TOPIC"`
+	PostGIS              bool              `long:"postgis" description:"Apply geometry values as PostGIS geometries" env:"DBZ2PG_POSTGIS"`
+	MetadataColumns      []string          `long:"metadata-column" description:"Column of the target rows holding the metadata of the changes, one of op, source_ts, lsn, topic, partition or offset, as [table:]metadata:column, e.g. op:__op" env:"DBZ2PG_METADATA_COLUMNS" env-delim:","`
+	SchemaDrift          string            `long:"schema-drift" description:"Handle columns missing in the target table: skip them or alter the table, or report differences of the Debezium schema from the target tables only" choice:"skip" choice:"alter" choice:"report" env:"DBZ2PG_SCHEMA_DRIFT"`
+	AutoEvolve           bool              `long:"auto-evolve" description:"Add columns missing in the target table from the Debezium schema, same as --schema-drift=alter" env:"DBZ2PG_AUTO_EVOLVE"`
+	WidenColumns         bool              `long:"widen-columns" description:"Widen target column types too narrow for the values to the types of the Debezium schema" env:"DBZ2PG_WIDEN_COLUMNS"`
+	SchemaChangesTable   string            `long:"schema-changes-table" description:"Table logging target columns added or widened automatically, e.g. public.dbz2pg_schema_changes" env:"DBZ2PG_SCHEMA_CHANGES_TABLE"`
+	AutoCreateTables     bool              `long:"auto-create-tables" description:"Create missing target tables from the Debezium schema of their changes" env:"DBZ2PG_AUTO_CREATE_TABLES"`
+	AutoCreateDryRun     bool              `long:"auto-create-dry-run" description:"Write the DDL of missing target tables to stdout instead of creating them" env:"DBZ2PG_AUTO_CREATE_DRY_RUN"`
+	CaseFold             string            `long:"case-fold" default:"preserve" description:"Case of the table and column names: preserve as sent by the source or fold to lower" choice:"preserve" choice:"lower" env:"DBZ2PG_CASE_FOLD"`
+	CaseInsensitive      []string          `long:"case-insensitive" description:"Column compared in lowercase when matching updated and deleted rows, e.g. customers.email; create index on lower(email) to keep matching indexed" env:"DBZ2PG_CASE_INSENSITIVE" env-delim:","`
+	FlattenStructs       []string          `long:"flatten-struct" description:"Struct column applied as a column per attribute named <column>_<attribute>, e.g. customers.address" env:"DBZ2PG_FLATTEN_STRUCT" env-delim:","`
+	SchemaDefaults       string            `long:"schema-defaults" default:"target" description:"Columns absent in the row image of inserts are omitted, so defaults of the target apply, or set to the defaults declared in the Debezium schema" choice:"target" choice:"source" env:"DBZ2PG_SCHEMA_DEFAULTS"`
+	NullToDefault        []string          `long:"null-to-default" description:"Column omitted from inserts if its value is NULL, so the column default applies, e.g. orders.created_at" env:"DBZ2PG_NULL_TO_DEFAULT" env-delim:","`
+	Views                []string          `long:"view" description:"Target table which is a view with INSTEAD OF triggers, e.g. orders_v, so no affected rows are expected" env:"DBZ2PG_VIEWS" env-delim:","`
+	ColumnExpressions    map[string]string `long:"column-expression" description:"SQL expression computing the column value instead of copying it, e.g. posts.search:to_tsvector('english', $title)" env:"DBZ2PG_COLUMN_EXPRESSIONS"`
+	CharPadding          map[string]string `long:"char-padding" description:"Padding of the fixed-width char column used to match rows: trim or the width to pad to, e.g. orders.code:10" env:"DBZ2PG_CHAR_PADDING" env-delim:","`
+	ColumnRenames        map[string]string `long:"rename-column" description:"Target name of the source column, e.g. orders.cust_id:customer_id" env:"DBZ2PG_RENAME_COLUMNS" env-delim:","`
+	ColumnTypes          map[string]string `long:"column-type" description:"Type to cast the column values to, e.g. orders.status:order_status" env:"DBZ2PG_COLUMN_TYPES" env-delim:","`
+	ValidateSchema       struct{}          `command:"validate-schema" description:"Compare the Debezium schema of the consumed messages with the target tables and exit non-zero on differences, nothing is applied"`
+	Command              string            `no-flag:"true"` // name of the command given, empty to apply the changes
 }
 
 // Parse will parse command line arguments and initialize pgengine
 func Parse() (*CmdOptions, error) {
 	cmdOpts := new(CmdOptions)
 	parser := flags.NewParser(cmdOpts, flags.PrintErrors)
+	parser.SubcommandsOptional = true
 	var err error
 	if _, err = parser.Parse(); err != nil {
 		if !flags.WroteHelp(err) {
@@ -26,5 +100,8 @@ func Parse() (*CmdOptions, error) {
 			return nil, err
 		}
 	}
+	if parser.Active != nil {
+		cmdOpts.Command = parser.Active.Name
+	}
 	return cmdOpts, nil
 }
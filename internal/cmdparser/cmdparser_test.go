@@ -16,3 +16,22 @@ func TestParseFail(t *testing.T) {
 	_, err = Parse()
 	assert.NoError(t, err, "Required options specified")
 }
+
+func TestParseValidateSchema(t *testing.T) {
+	os.Args = []string{"go-test", "--topic=required", "validate-schema"}
+	opts, err := Parse()
+	assert.NoError(t, err)
+	assert.Equal(t, "validate-schema", opts.Command)
+
+	os.Args = []string{"go-test", "--topic=required"}
+	opts, err = Parse()
+	assert.NoError(t, err)
+	assert.Equal(t, "", opts.Command)
+}
+
+func TestParseColumnTypes(t *testing.T) {
+	os.Args = []string{"go-test", "--topic=required", "--column-type=orders.status:order_status", "--column-type=public.docs.body:xml"}
+	opts, err := Parse()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"orders.status": "order_status", "public.docs.body": "xml"}, opts.ColumnTypes)
+}
@@ -0,0 +1,42 @@
+package kafka
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/segmentio/kafka-go/compress"
+)
+
+// Application-level compression of message values, applied by the producer regardless of the transport compression
+const (
+	CompressionGzip   = "gzip"
+	CompressionSnappy = "snappy"
+	CompressionLz4    = "lz4"
+)
+
+// codecs holds the codecs decompressing message values by the compression name
+var codecs = map[string]compress.Codec{
+	CompressionGzip:   &compress.GzipCodec,
+	CompressionSnappy: &compress.SnappyCodec,
+	CompressionLz4:    &compress.Lz4Codec,
+}
+
+// decompress returns the message `value` decompressed with the `compression` codec, empty string means values aren't
+// compressed. Empty values, e.g. tombstones, are returned as is
+func decompress(compression string, value []byte) ([]byte, error) {
+	if compression == "" || len(value) == 0 {
+		return value, nil
+	}
+	codec, ok := codecs[compression]
+	if !ok {
+		return nil, fmt.Errorf("Unknown value compression %q", compression)
+	}
+	r := codec.NewReader(bytes.NewReader(value))
+	defer r.Close()
+	decompressed, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("Value decompression with %s failed: %w", compression, err)
+	}
+	return decompressed, nil
+}
@@ -0,0 +1,70 @@
+package kafka
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+const compressedValue = `{"schema":null,"payload":{"before":null,"after":{"id":1004,"email":"anne@kretchmar.com"},` +
+	`"source":{"schema":"inventory","table":"customers"},"op":"c"}}`
+
+// compressed returns `value` compressed with the codec of the `compression`
+func compressed(t *testing.T, compression string, value string) []byte {
+	var b bytes.Buffer
+	w := codecs[compression].NewWriter(&b)
+	_, err := w.Write([]byte(value))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	return b.Bytes()
+}
+
+func TestDecompress(t *testing.T) {
+	for _, compression := range []string{CompressionGzip, CompressionSnappy, CompressionLz4} {
+		value := compressed(t, compression, compressedValue)
+		assert.NotEqual(t, []byte(compressedValue), value)
+		decompressed, err := decompress(compression, value)
+		assert.NoError(t, err, compression)
+		assert.Equal(t, compressedValue, string(decompressed), compression)
+	}
+
+	value, err := decompress("", []byte(compressedValue))
+	assert.NoError(t, err)
+	assert.Equal(t, compressedValue, string(value), "not compressed")
+	value, err = decompress(CompressionGzip, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, value, "tombstone")
+
+	_, err = decompress(CompressionGzip, []byte(compressedValue))
+	assert.Error(t, err, "not gzip")
+	_, err = decompress("brotli", []byte(compressedValue))
+	assert.EqualError(t, err, `Unknown value compression "brotli"`)
+}
+
+func TestConsumeTopicCompressed(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestConsumeTopicCompressed")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	values := [][]byte{[]byte("garbage"), compressed(t, CompressionGzip, compressedValue)}
+	getReader = func(brokers []string, topic string) kafkaReader {
+		r := &mockKafkaReader{}
+		r.ReadMessageHandler = func() (kafka.Message, error) {
+			r.offset++
+			return kafka.Message{Offset: r.offset, Key: []byte(`{"schema":null,"payload":{"id":1004}}`), Value: values[r.offset-1]}, nil
+		}
+		return r
+	}
+	messages := make(chan Message, 2)
+	consumeTopic(ctx, []string{"foo"}, "customers", 0, 2, CompressionGzip, messages)
+	assert.Len(t, messages, 1, "value failing to decompress is skipped")
+	m := <-messages
+	assert.Equal(t, "c", m.Op)
+	assert.Equal(t, "customers", m.TableName)
+	assert.Equal(t, "anne@kretchmar.com", m.Values["email"])
+	assert.Equal(t, compressedValue, string(m.Value), "value is decompressed")
+}
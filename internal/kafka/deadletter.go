@@ -0,0 +1,53 @@
+package kafka
+
+import (
+	"context"
+
+	kafka "github.com/segmentio/kafka-go"
+)
+
+// DeadLetter is a message that cannot be applied to the target database together with the reason why
+type DeadLetter struct {
+	Message
+	Reason string
+}
+
+type kafkaWriter interface {
+	WriteMessages(ctx context.Context, msgs ...kafka.Message) error
+	Close() error
+}
+
+// getWriter returns a kafka writer to produce messages to `brokers` with `topic`
+var getWriter = func(brokers []string, topic string) kafkaWriter {
+	return kafka.NewWriter(kafka.WriterConfig{
+		Brokers: brokers,
+		Topic:   topic,
+	})
+}
+
+// ProduceDeadLetters function receives messages from the `deadLetters` channel and sends them to the Kafka `topic`
+func ProduceDeadLetters(ctx context.Context, brokers []string, topic string, deadLetters <-chan DeadLetter) {
+	topiclogger := Logger.WithField("topic", topic)
+	writer := getWriter(brokers, topic)
+	defer writer.Close()
+	topiclogger.Println("Starting producing dead letters...")
+	for {
+		select {
+		case dl := <-deadLetters:
+			m := kafka.Message{
+				Key:   dl.Key,
+				Value: dl.Value,
+				Headers: append(dl.Headers,
+					kafka.Header{Key: "dbz2pg.topic", Value: []byte(dl.Topic)},
+					kafka.Header{Key: "dbz2pg.reason", Value: []byte(dl.Reason)}),
+			}
+			if err := writer.WriteMessages(ctx, m); err != nil {
+				topiclogger.Error(err)
+				continue
+			}
+			topiclogger.WithField("reason", dl.Reason).Trace("Dead letter produced")
+		case <-ctx.Done():
+			return
+		}
+	}
+}
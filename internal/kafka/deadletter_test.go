@@ -0,0 +1,53 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	kafka "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockKafkaWriter struct {
+	kafkaWriter
+	messages []kafka.Message
+	err      error
+}
+
+func (w *mockKafkaWriter) WriteMessages(ctx context.Context, msgs ...kafka.Message) error {
+	w.messages = append(w.messages, msgs...)
+	return w.err
+}
+
+func (w *mockKafkaWriter) Close() error {
+	return nil
+}
+
+func TestGetWriter(t *testing.T) {
+	assert.NotNil(t, getWriter([]string{"foo", "bar"}, "baz"))
+}
+
+func TestProduceDeadLetters(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestProduceDeadLetters")
+	w := &mockKafkaWriter{}
+	getWriter = func(brokers []string, topic string) kafkaWriter {
+		return w
+	}
+	dlq := make(chan DeadLetter, 2)
+	dl := DeadLetter{Reason: "unsupported operation"}
+	dl.Topic = "dbserver1.inventory.customers"
+	dl.Value = []byte("foo")
+	dlq <- dl
+	w.err = errors.New("write failed")
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	ProduceDeadLetters(ctx, []string{"foo"}, "dlq", dlq)
+
+	assert.Len(t, w.messages, 1)
+	assert.Equal(t, []byte("foo"), w.messages[0].Value)
+	assert.Contains(t, w.messages[0].Headers, kafka.Header{Key: "dbz2pg.reason", Value: []byte("unsupported operation")})
+	assert.Contains(t, w.messages[0].Headers, kafka.Header{Key: "dbz2pg.topic", Value: []byte("dbserver1.inventory.customers")})
+}
@@ -3,6 +3,7 @@ package kafka
 import (
 	"context"
 	"strings"
+	"sync"
 
 	"github.com/Shopify/sarama"
 	kafka "github.com/segmentio/kafka-go"
@@ -14,6 +15,7 @@ var Logger *logrus.Entry
 
 type kafkaReader interface {
 	ReadMessage(ctx context.Context) (kafka.Message, error)
+	SetOffset(offset int64) error
 	Close() error
 }
 
@@ -45,31 +47,82 @@ func getTopics(brokers []string) ([]string, error) {
 	return topics, err
 }
 
-// Consume function receives messages from Kafka and sends them to the `messages` channel
-func Consume(ctx context.Context, brokers []string, topicPattern string, messages chan<- Message) {
+// Consume function receives messages from Kafka and sends them to the `messages` channel.
+// Topics are consumed starting from `startOffset` up to `endOffset` inclusively, zero value means no bound. The end
+// offset is tracked per topic, `messages` is closed once every topic reached it, so applying stops after all of them.
+// If `offsets` is not nil, topics are resumed after the offsets saved there. Message values are decompressed with
+// the `compression` codec, one of the Compression* constants, empty string means values aren't compressed. Topics
+// listed in `exclude` are skipped, e.g. the schema change topic consumed by ConsumeSchemaChanges
+func Consume(ctx context.Context, brokers []string, topicPattern string, offsets OffsetStore, startOffset, endOffset int64,
+	compression string, messages chan<- Message, exclude ...string) {
 	Logger.Debug("Starting consuming from kafka...")
 	topics, err := getTopics(brokers)
 	if err != nil {
 		Logger.Fatalln(err)
 	}
+	var consumers sync.WaitGroup
 	for _, topic := range topics {
 		Logger.WithField("topic", topic).WithField("prefix", topicPattern).Debug("Checking for prefix")
-		if strings.HasPrefix(topic, topicPattern) {
-			go consumeTopic(context.Background(), brokers, topic, messages)
+		if !strings.HasPrefix(topic, topicPattern) || isExcluded(topic, exclude) {
+			continue
+		}
+		start, err := resumeOffset(ctx, offsets, topic, startOffset)
+		if err != nil {
+			Logger.WithField("topic", topic).Error(err)
+			continue
+		}
+		consumers.Add(1)
+		go func(topic string) {
+			defer consumers.Done()
+			consumeTopic(context.Background(), brokers, topic, start, endOffset, compression, messages)
+		}(topic)
+	}
+	if endOffset > 0 {
+		go func() {
+			consumers.Wait()
+			Logger.Info("End offset reached in all topics")
+			close(messages)
+		}()
+	}
+}
+
+// isExcluded returns true if `topic` is one of the `exclude` ones
+func isExcluded(topic string, exclude []string) bool {
+	for _, t := range exclude {
+		if t == topic {
+			return true
 		}
 	}
+	return false
 }
-func consumeTopic(ctx context.Context, brokers []string, topic string, messages chan<- Message) {
+
+func consumeTopic(ctx context.Context, brokers []string, topic string, startOffset, endOffset int64, compression string,
+	messages chan<- Message) {
 	topiclogger := Logger.WithField("topic", topic)
 	reader := getReader(brokers, topic)
 	defer reader.Close()
-	topiclogger.Println("Starting consuming topic...")
+	if startOffset > 0 {
+		if err := reader.SetOffset(startOffset); err != nil {
+			topiclogger.Error(err)
+			return
+		}
+	}
+	topiclogger.WithField("start", startOffset).WithField("end", endOffset).Println("Starting consuming topic...")
 	for {
 		m, err := reader.ReadMessage(ctx)
 		if err != nil {
 			topiclogger.Error(err)
 			return
 		}
+		if endOffset > 0 && m.Offset > endOffset {
+			// the end offset was skipped, e.g. removed by compaction, or consuming started beyond it
+			topiclogger.WithField("offset", m.Offset).Println("End offset passed")
+			return
+		}
+		if m.Value, err = decompress(compression, m.Value); err != nil {
+			topiclogger.WithField("offset", m.Offset).Error(err)
+			continue
+		}
 		topiclogger.WithField("key", string(m.Key)).WithField("value", string(m.Value)).Trace("Message consumed")
 		msg, err := NewMessage(m)
 		if err != nil {
@@ -77,5 +130,9 @@ func consumeTopic(ctx context.Context, brokers []string, topic string, messages
 			continue
 		}
 		messages <- *msg
+		if endOffset > 0 && m.Offset == endOffset {
+			topiclogger.WithField("offset", m.Offset).Println("End offset reached")
+			return
+		}
 	}
 }
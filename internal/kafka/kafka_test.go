@@ -31,7 +31,7 @@ func TestGetTopics(t *testing.T) {
 	Logger.Logger.ExitFunc = func(int) {
 		t.Log("log.Fatal called")
 	}
-	Consume(ctx, []string{"foo", "bar"}, "baz", make(chan Message, 1))
+	Consume(ctx, []string{"foo", "bar"}, "baz", nil, 0, 0, "", make(chan Message, 1))
 
 	newConsumer = func(addrs []string, config *sarama.Config) (sarama.Consumer, error) {
 		c := mocks.NewConsumer(t, nil)
@@ -39,7 +39,7 @@ func TestGetTopics(t *testing.T) {
 		return c, nil
 	}
 	topics, err := getTopics([]string{"foo", "bar"})
-	Consume(ctx, []string{"foo", "bar"}, "foo", make(chan Message, 1))
+	Consume(ctx, []string{"foo", "bar"}, "foo", nil, 0, 0, "", make(chan Message, 1))
 	assert.NoError(t, err)
 	assert.Equal(t, topics, []string{"foo"})
 }
@@ -47,6 +47,16 @@ func TestGetTopics(t *testing.T) {
 type mockKafkaReader struct {
 	kafkaReader
 	ReadMessageHandler func() (kafka.Message, error)
+	SetOffsetHandler   func(offset int64) error
+	offset             int64
+}
+
+func (r *mockKafkaReader) SetOffset(offset int64) error {
+	if r.SetOffsetHandler != nil {
+		return r.SetOffsetHandler(offset)
+	}
+	r.offset = offset
+	return nil
 }
 
 func (r *mockKafkaReader) ReadMessage(ctx context.Context) (kafka.Message, error) {
@@ -57,9 +67,11 @@ func (r *mockKafkaReader) ReadMessage(ctx context.Context) (kafka.Message, error
 		return r.ReadMessageHandler()
 	}
 	time.Sleep(500 * time.Millisecond)
+	r.offset++
 	return kafka.Message{
-		Value: []byte(`{"schema":{"type":"struct","fields":[{"type":"int32","optional":false,"field":"id"},{"type":"string","optional":false,"field":"first_name"},{"type":"string","optional":false,"field":"last_name"},{"type":"string","optional":false,"field":"email"},{"type":"int64","optional":true,"field":"__source_ts_ms"},{"type":"string","optional":true,"field":"__db"},{"type":"string","optional":true,"field":"__table"},{"type":"string","optional":true,"field":"__op"},{"type":"string","optional":true,"field":"__deleted"}],"optional":false,"name":"dbserver1.inventory.customers.Value"},"payload":{"id":1003,"first_name":"Edward","last_name":"Walker","email":"ed@walker.com","__source_ts_ms":0,"__db":"inventory","__table":"customers","__op":"c","__deleted":"false"}}`),
-		Key:   []byte(`{"schema":{"type":"struct","fields":[{"type":"int32","optional":false,"field":"id"}],"optional":false,"name":"dbserver1.inventory.customers.Key"},"payload":{"id":1003}}`),
+		Offset: r.offset - 1,
+		Value:  []byte(`{"schema":{"type":"struct","fields":[{"type":"int32","optional":false,"field":"id"},{"type":"string","optional":false,"field":"first_name"},{"type":"string","optional":false,"field":"last_name"},{"type":"string","optional":false,"field":"email"},{"type":"int64","optional":true,"field":"__source_ts_ms"},{"type":"string","optional":true,"field":"__db"},{"type":"string","optional":true,"field":"__table"},{"type":"string","optional":true,"field":"__op"},{"type":"string","optional":true,"field":"__deleted"}],"optional":false,"name":"dbserver1.inventory.customers.Value"},"payload":{"id":1003,"first_name":"Edward","last_name":"Walker","email":"ed@walker.com","__source_ts_ms":0,"__db":"inventory","__table":"customers","__op":"c","__deleted":"false"}}`),
+		Key:    []byte(`{"schema":{"type":"struct","fields":[{"type":"int32","optional":false,"field":"id"}],"optional":false,"name":"dbserver1.inventory.customers.Key"},"payload":{"id":1003}}`),
 	}, nil
 }
 
@@ -74,7 +86,7 @@ func TestConsumeTopic(t *testing.T) {
 	getReader = func(brokers []string, topic string) kafkaReader {
 		return &mockKafkaReader{}
 	}
-	consumeTopic(ctx, []string{"foo", "bar"}, "baz", make(chan Message, 10))
+	consumeTopic(ctx, []string{"foo", "bar"}, "baz", 0, 0, "", make(chan Message, 10))
 
 	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
 	defer cancel()
@@ -85,5 +97,53 @@ func TestConsumeTopic(t *testing.T) {
 				return kafka.Message{}, nil
 			}}
 	}
-	consumeTopic(ctx, []string{"foo", "bar"}, "baz", make(chan Message, 10))
+	consumeTopic(ctx, []string{"foo", "bar"}, "baz", 0, 0, "", make(chan Message, 10))
+}
+
+func TestConsumeTopicBounds(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestConsumeTopicBounds")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	getReader = func(brokers []string, topic string) kafkaReader {
+		return &mockKafkaReader{}
+	}
+	messages := make(chan Message, 10)
+	consumeTopic(ctx, []string{"foo", "bar"}, "baz", 5, 7, "", messages)
+	assert.NoError(t, ctx.Err(), "consuming stopped at end offset")
+	assert.Len(t, messages, 3)
+	for offset := int64(5); offset <= 7; offset++ {
+		assert.Equal(t, offset, (<-messages).Offset)
+	}
+
+	getReader = func(brokers []string, topic string) kafkaReader {
+		return &mockKafkaReader{
+			SetOffsetHandler: func(int64) error {
+				return errors.New("offset out of range")
+			}}
+	}
+	consumeTopic(ctx, []string{"foo", "bar"}, "baz", 5, 7, "", messages)
+	assert.Len(t, messages, 0)
+
+	// messages beyond the end offset are never sent
+	getReader = func(brokers []string, topic string) kafkaReader {
+		return &mockKafkaReader{}
+	}
+	consumeTopic(ctx, []string{"foo", "bar"}, "baz", 8, 7, "", messages)
+	assert.NoError(t, ctx.Err())
+	assert.Len(t, messages, 0, "consuming started beyond the end offset")
+
+	// offsets 6 and 7 are removed by compaction
+	compacted := []int64{5, 8, 9}
+	getReader = func(brokers []string, topic string) kafkaReader {
+		return &mockKafkaReader{
+			ReadMessageHandler: func() (kafka.Message, error) {
+				m, err := (&mockKafkaReader{offset: compacted[0]}).ReadMessage(ctx)
+				compacted = compacted[1:]
+				return m, err
+			}}
+	}
+	consumeTopic(ctx, []string{"foo", "bar"}, "baz", 5, 7, "", messages)
+	assert.Len(t, messages, 1, "the end offset was removed by compaction")
+	assert.Equal(t, int64(5), (<-messages).Offset)
 }
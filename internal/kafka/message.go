@@ -1,32 +1,56 @@
 package kafka
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	kafka "github.com/segmentio/kafka-go"
 )
 
 type cdcField struct {
-	Type     string `json:"type"`
-	Optional bool   `json:"optional"`
-	Field    string `json:"field"`
+	Type       string            `json:"type"`
+	Optional   bool              `json:"optional"`
+	Name       string            `json:"name,omitempty"`
+	Parameters map[string]string `json:"parameters,omitempty"`
+	Items      *cdcField         `json:"items,omitempty"`
+	Fields     []cdcField        `json:"fields,omitempty"`
+	Field      string            `json:"field"`
+	Default    interface{}       `json:"default,omitempty"`
 }
 
-type cdcFields struct {
-	Type     string     `json:"type"`
-	Fields   []cdcField `json:"fields,omitempty"`
-	Optional bool       `json:"optional"`
-	Name     string     `json:"name,omitempty"`
-	Field    string     `json:"field"`
+// toField returns the field description of the schema entry
+func (f cdcField) toField() Field {
+	field := Field{
+		Type:       f.Type,
+		Name:       f.Name,
+		Optional:   f.Optional,
+		Parameters: f.Parameters,
+		Default:    f.Default,
+	}
+	if f.Items != nil {
+		items := f.Items.toField()
+		field.Items = &items
+	}
+	for _, attr := range f.Fields {
+		a := attr.toField()
+		a.Attribute = attr.Field
+		field.Fields = append(field.Fields, a)
+	}
+	return field
 }
 
 type cdcSchema struct {
-	Type     string      `json:"type"`
-	Name     string      `json:"name"`
-	Fields   []cdcFields `json:"fields"`
-	Optional bool        `json:"optional"`
+	Type     string     `json:"type"`
+	Name     string     `json:"name"`
+	Fields   []cdcField `json:"fields"`
+	Optional bool       `json:"optional"`
 }
 
 type cdcMessage struct {
@@ -39,14 +63,90 @@ type cdcKey struct {
 	Payload *map[string]interface{} `json:"payload"`
 }
 
+// unavailableValue is the placeholder Debezium sends instead of unchanged TOASTed values
+const unavailableValue = "__debezium_unavailable_value"
+
+// Field describes a column of the CDC item as declared in the Debezium schema
+type Field struct {
+	Type       string            // Kafka Connect type, e.g. int32, string, bytes, struct
+	Name       string            // logical type name, e.g. io.debezium.data.Json
+	Optional   bool              // false for NOT NULL columns
+	Parameters map[string]string // logical type parameters, e.g. length or allowed values
+	Items      *Field            // description of the elements for array fields
+	Fields     []Field           // description of the attributes in declared order for struct fields
+	Attribute  string            // attribute name for fields of the struct
+	Default    interface{}       // default value of the column encoded as its values, nil if none is declared
+}
+
+// SchemaChange describes the DDL statement of the Debezium schema change event
+type SchemaChange struct {
+	DDL          string
+	TableChanges []TableChange
+}
+
+// TableChange describes the table affected by the DDL statement
+type TableChange struct {
+	Type       string        // CREATE, ALTER or DROP
+	ID         string        // fully qualified table name as sent by the source
+	PrimaryKey []string      // names of the primary key columns after the change
+	Columns    []TableColumn // columns of the table after the change in declared order, empty for DROP
+}
+
+// TableColumn describes the column of the table structure after the schema change
+type TableColumn struct {
+	Name     string // column name
+	TypeName string // source type name, e.g. VARCHAR or int4
+	JDBCType int    // java.sql.Types code of the source type, e.g. 2014 for MySQL TIMESTAMP holding UTC time
+	Length   int    // declared length or precision, 0 if none
+	Scale    int    // declared scale of decimals, 0 if none
+	Optional bool   // false for NOT NULL columns
+}
+
+// TransactionBoundary describes the event of the Debezium transaction metadata topic
+type TransactionBoundary struct {
+	Status     string // BEGIN or END
+	ID         string // source transaction id
+	EventCount int64  // number of data change events of the transaction, only known at END
+}
+
+// Transaction describes the source transaction of the data change event as provided by the transaction metadata
+type Transaction struct {
+	ID                  string // source transaction id
+	TotalOrder          int64  // position of the event among all the events of the transaction, 0 if unknown
+	DataCollectionOrder int64  // position of the event among the events of the transaction for its table, 0 if unknown
+}
+
+// LogicalMessage is the content of the logical decoding message emitted by pg_logical_emit_message
+type LogicalMessage struct {
+	Prefix  string
+	Content []byte
+}
+
 // Message is a data structure representing kafka messages
 type Message struct {
 	kafka.Message
-	Op         string
-	TableName  string
-	SchemaName string
-	Keys       map[string]interface{}
-	Values     map[string]interface{}
+	Op           string
+	TableName    string
+	SchemaName   string
+	Keys         map[string]interface{}
+	Values       map[string]interface{} // new row image, explicit nulls are kept while unchanged columns are absent
+	Before       map[string]interface{} // old row image, only available for unflattened change events
+	Fields       map[string]Field
+	Timestamp    time.Time     // time the change was made in the source database, if known
+	Snapshot     string        // snapshot phase of the source, e.g. "true", "last" or "incremental", empty when streaming
+	Position     int64         // position of the change in the source log, e.g. the LSN, zero if unknown
+	SchemaChange *SchemaChange // DDL statement for events of the schema change topic, nil for data changes
+	// TransactionID is the id of the source transaction the change belongs to, if transaction metadata is provided
+	TransactionID string
+	// Transaction is the source transaction metadata of the data change, nil if not provided
+	Transaction *Transaction
+	// TransactionBoundary is the BEGIN or END marker for events of the transaction topic, nil for data changes
+	TransactionBoundary *TransactionBoundary
+	// LogicalMessage is the content of the logical decoding message for events with "m" operation, nil otherwise
+	LogicalMessage *LogicalMessage
+	// Records holds the changes of the event batching several records as arrays of row images, each with its own
+	// row images and keys, nil for events of a single record. Row images of the event itself are empty then
+	Records []Message
 }
 
 // NewMessage used to create and init a new message instance
@@ -56,6 +156,7 @@ func NewMessage(msg kafka.Message) (*Message, error) {
 		Message: msg,
 		Keys:    make(map[string]interface{}),
 		Values:  make(map[string]interface{}),
+		Fields:  make(map[string]Field),
 	}
 	err = message.initKeys()
 	if err != nil {
@@ -70,45 +171,508 @@ func NewMessage(msg kafka.Message) (*Message, error) {
 	return message, nil
 }
 
+// unmarshal decodes JSON `data` into `v` keeping numbers as json.Number, so big integers don't lose precision.
+// Non-standard NaN and infinity tokens some serializers produce are decoded as strings
+func unmarshal(data []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	err := d.Decode(v)
+	if _, ok := err.(*json.SyntaxError); ok {
+		if quoted := quoteSpecialNumbers(data); !bytes.Equal(quoted, data) {
+			return unmarshal(quoted, v)
+		}
+	}
+	return err
+}
+
+// quoteSpecialNumbers returns JSON `data` with bare NaN, Infinity and -Infinity values quoted
+func quoteSpecialNumbers(data []byte) []byte {
+	var (
+		out      = make([]byte, 0, len(data))
+		inString bool
+		escaped  bool
+	)
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case inString:
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		default:
+			quoted := false
+			for _, token := range []string{"NaN", "Infinity", "-Infinity"} {
+				if bytes.HasPrefix(data[i:], []byte(token)) {
+					out = append(out, '"')
+					out = append(out, token...)
+					out = append(out, '"')
+					i += len(token) - 1
+					quoted = true
+					break
+				}
+			}
+			if quoted {
+				continue
+			}
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
 // initKeys inits keys with the values to use in SQL DML statement
 func (m *Message) initKeys() error {
 	var key cdcKey
-	if err := json.Unmarshal(m.Key, &key); err != nil {
+	if err := unmarshal(m.Key, &key); err != nil {
 		return err
 	}
 	if key.Payload == nil {
 		return errors.New("Payload is nil")
 	}
 	m.Keys = *key.Payload
+	m.initFields(key.Schema)
+	return nil
+}
+
+// initFields indexes field descriptions from the Debezium schema by column name
+func (m *Message) initFields(schema *cdcSchema) {
+	if schema == nil {
+		return
+	}
+	for _, f := range schema.Fields {
+		m.Fields[f.Field] = f.toField()
+	}
+}
+
+// initEnvelopeFields indexes field descriptions of the row images from the Debezium envelope schema by column name
+func (m *Message) initEnvelopeFields(schema *cdcSchema) {
+	if schema == nil {
+		return
+	}
+	for _, image := range schema.Fields {
+		if image.Field != "before" && image.Field != "after" {
+			continue
+		}
+		fields := image.Fields
+		if image.Items != nil {
+			// arrays of records batched into the event
+			fields = image.Items.Fields
+		}
+		for _, f := range fields {
+			m.Fields[f.Field] = f.toField()
+		}
+	}
+}
+
+// isEnvelope returns true if payload is a complete Debezium change event, i.e. not flattened by ExtractNewRecordState
+func isEnvelope(payload map[string]interface{}) bool {
+	_, op := payload["op"]
+	_, before := payload["before"]
+	_, after := payload["after"]
+	return op && (before || after)
+}
+
+// isSchemaChange returns true if payload is an event of the Debezium schema change topic
+func isSchemaChange(payload map[string]interface{}) bool {
+	_, ddl := payload["ddl"]
+	_, op := payload["op"]
+	return ddl && !op
+}
+
+// initSchemaChange inits schema name and the DDL statement from the schema change event
+func (m *Message) initSchemaChange(payload map[string]interface{}) error {
+	ddl, ok := payload["ddl"].(string)
+	if !ok {
+		return errors.New("DDL statement is missing")
+	}
+	m.SchemaChange = &SchemaChange{DDL: ddl}
+	if m.SchemaName, _ = payload["schemaName"].(string); m.SchemaName == "" {
+		m.SchemaName, _ = payload["databaseName"].(string)
+	}
+	if source, ok := payload["source"].(map[string]interface{}); ok {
+		m.Timestamp = timestamp(source["ts_ms"])
+	}
+	changes, _ := payload["tableChanges"].([]interface{})
+	for _, c := range changes {
+		change, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		tc := TableChange{}
+		tc.Type, _ = change["type"].(string)
+		tc.ID, _ = change["id"].(string)
+		if table, ok := change["table"].(map[string]interface{}); ok {
+			initTableStructure(&tc, table)
+		}
+		m.SchemaChange.TableChanges = append(m.SchemaChange.TableChanges, tc)
+	}
+	return nil
+}
+
+// initTableStructure inits the primary key and columns of the table change from its structured `table` description
+func initTableStructure(tc *TableChange, table map[string]interface{}) {
+	keys, _ := table["primaryKeyColumnNames"].([]interface{})
+	for _, k := range keys {
+		if name, ok := k.(string); ok {
+			tc.PrimaryKey = append(tc.PrimaryKey, name)
+		}
+	}
+	columns, _ := table["columns"].([]interface{})
+	positions := make(map[string]int, len(columns))
+	for _, c := range columns {
+		column, ok := c.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _ := column["name"].(string)
+		typeName, _ := column["typeName"].(string)
+		positions[name] = intValue(column["position"])
+		tc.Columns = append(tc.Columns, TableColumn{
+			Name:     name,
+			TypeName: typeName,
+			JDBCType: intValue(column["jdbcType"]),
+			Length:   intValue(column["length"]),
+			Scale:    intValue(column["scale"]),
+			Optional: column["optional"] == true,
+		})
+	}
+	sort.SliceStable(tc.Columns, func(i, j int) bool {
+		return positions[tc.Columns[i].Name] < positions[tc.Columns[j].Name]
+	})
+}
+
+// intValue returns the JSON number `v` as int, 0 if it's not a number
+func intValue(v interface{}) int {
+	return int(int64Value(v))
+}
+
+// int64Value returns the JSON number `v` as int64, 0 if it's not a number
+func int64Value(v interface{}) int64 {
+	n, _ := v.(json.Number)
+	i, _ := n.Int64()
+	return i
+}
+
+// isTransactionBoundary returns true if payload is an event of the Debezium transaction metadata topic
+func isTransactionBoundary(payload map[string]interface{}) bool {
+	status, _ := payload["status"].(string)
+	_, id := payload["id"]
+	_, op := payload["op"]
+	return (status == "BEGIN" || status == "END") && id && !op
+}
+
+// initTransactionBoundary inits the transaction boundary from the transaction metadata event
+func (m *Message) initTransactionBoundary(payload map[string]interface{}) {
+	b := &TransactionBoundary{}
+	b.Status, _ = payload["status"].(string)
+	b.ID, _ = payload["id"].(string)
+	if n, ok := payload["event_count"].(json.Number); ok {
+		b.EventCount, _ = n.Int64()
+	}
+	m.TransactionBoundary = b
+	m.TransactionID = b.ID
+}
+
+// isLogicalMessage returns true if payload is the logical decoding message event
+func isLogicalMessage(payload map[string]interface{}) bool {
+	op, _ := payload["op"].(string)
+	return op == "m"
+}
+
+// initLogicalMessage inits the operation and the message content from the logical decoding message event
+func (m *Message) initLogicalMessage(payload map[string]interface{}) error {
+	m.Op = "m"
+	m.LogicalMessage = &LogicalMessage{}
+	if message, ok := payload["message"].(map[string]interface{}); ok {
+		m.LogicalMessage.Prefix, _ = message["prefix"].(string)
+		if content, ok := message["content"].(string); ok {
+			b, err := base64.StdEncoding.DecodeString(content)
+			if err != nil {
+				return err
+			}
+			m.LogicalMessage.Content = b
+		}
+	}
+	if source, ok := payload["source"].(map[string]interface{}); ok {
+		m.Timestamp = timestamp(source["ts_ms"])
+	}
 	return nil
 }
 
 // initValues inits table name, operation and field names with the values to use in SQL DML statement
 func (m *Message) initValues() error {
 	var msg cdcMessage
-	if err := json.Unmarshal(m.Value, &msg); err != nil {
+	if err := unmarshal(m.Value, &msg); err != nil {
 		return err
 	}
 	if msg.Payload == nil {
 		return errors.New("Payload is nil")
 	}
-	for k, v := range *msg.Payload {
+	if isSchemaChange(*msg.Payload) {
+		return m.initSchemaChange(*msg.Payload)
+	}
+	if isLogicalMessage(*msg.Payload) {
+		return m.initLogicalMessage(*msg.Payload)
+	}
+	if isTransactionBoundary(*msg.Payload) {
+		m.initTransactionBoundary(*msg.Payload)
+		return nil
+	}
+	if isEnvelope(*msg.Payload) {
+		m.initEnvelopeFields(msg.Schema)
+		return m.initEnvelope(*msg.Payload)
+	}
+	m.initFields(msg.Schema)
+	payload := *msg.Payload
+	for k, v := range payload {
 		if strings.HasPrefix(k, "__") { // system fields
+			var err error
 			switch k {
 			case "__schema":
-				m.SchemaName = v.(string)
+				m.SchemaName, err = stringField(payload, k, k)
 			case "__table":
-				m.TableName = v.(string)
+				m.TableName, err = stringField(payload, k, k)
 			case "__op":
-				m.Op = v.(string)
+				m.Op, err = stringField(payload, k, k)
+			case "__source_ts_ms":
+				m.Timestamp = timestamp(v)
+			case "__source_snapshot":
+				m.Snapshot = snapshot(v)
+			case "__transaction_id":
+				m.TransactionID, err = stringField(payload, k, k)
+			}
+			if err != nil {
+				return err
 			}
 			continue
 		}
+		if v == unavailableValue {
+			continue
+		}
 		m.Values[k] = v
 	}
+	m.Position = position(payload, "__source_")
+	if m.TransactionID > "" {
+		m.Transaction = &Transaction{
+			ID:                  m.TransactionID,
+			TotalOrder:          int64Value(payload["__transaction_total_order"]),
+			DataCollectionOrder: int64Value(payload["__transaction_data_collection_order"]),
+		}
+	}
+	return nil
+}
+
+// initEnvelope inits table name, operation and row images from the complete Debezium change event
+func (m *Message) initEnvelope(payload map[string]interface{}) (err error) {
+	if m.Op, err = stringField(payload, "op", "op"); err != nil {
+		return err
+	}
+	if after, ok := payload["after"].(map[string]interface{}); ok {
+		for k, v := range after {
+			if v == unavailableValue {
+				delete(after, k)
+			}
+		}
+		m.Values = after
+	}
+	if before, ok := payload["before"].(map[string]interface{}); ok {
+		m.Before = before
+	}
+	if source, ok := payload["source"].(map[string]interface{}); ok {
+		if m.SchemaName, err = stringField(source, "schema", "source.schema"); err != nil {
+			return err
+		}
+		if m.TableName, err = stringField(source, "table", "source.table"); err != nil {
+			return err
+		}
+		m.Timestamp = timestamp(source["ts_ms"])
+		m.Snapshot = snapshot(source["snapshot"])
+		m.Position = position(source, "")
+	}
+	if transaction, ok := payload["transaction"].(map[string]interface{}); ok {
+		if m.TransactionID, err = stringField(transaction, "id", "transaction.id"); err != nil {
+			return err
+		}
+		m.Transaction = &Transaction{
+			ID:                  m.TransactionID,
+			TotalOrder:          int64Value(transaction["total_order"]),
+			DataCollectionOrder: int64Value(transaction["data_collection_order"]),
+		}
+	}
+	after, batchedAfter := payload["after"].([]interface{})
+	before, batchedBefore := payload["before"].([]interface{})
+	if batchedAfter || batchedBefore {
+		return m.initRecords(after, before)
+	}
+	return nil
+}
+
+// initRecords splits the change event which row images are arrays of records, e.g. batched by the SMT, into Records
+// paired by position. Keys of each record are taken from its row images, the message key is used for those missing
+func (m *Message) initRecords(after, before []interface{}) error {
+	if len(after) > 0 && len(before) > 0 && len(after) != len(before) {
+		return fmt.Errorf("Batched records mismatch: %d new and %d old row images", len(after), len(before))
+	}
+	image := func(images []interface{}, i int, path string) (map[string]interface{}, error) {
+		if i >= len(images) || images[i] == nil {
+			return nil, nil
+		}
+		row, ok := images[i].(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Field %s[%d] is %T, record expected", path, i, images[i])
+		}
+		return row, nil
+	}
+	n := len(after)
+	if len(before) > n {
+		n = len(before)
+	}
+	m.Records = make([]Message, 0, n)
+	for i := 0; i < n; i++ {
+		r := *m
+		r.Records = nil
+		values, err := image(after, i, "after")
+		if err != nil {
+			return err
+		}
+		if r.Before, err = image(before, i, "before"); err != nil {
+			return err
+		}
+		r.Values = make(map[string]interface{}, len(values))
+		for k, v := range values {
+			if v != unavailableValue {
+				r.Values[k] = v
+			}
+		}
+		r.Keys = make(map[string]interface{}, len(m.Keys))
+		for k, v := range m.Keys {
+			if value, ok := r.Before[k]; ok {
+				v = value
+			} else if value, ok := values[k]; ok {
+				v = value
+			}
+			r.Keys[k] = v
+		}
+		m.Records = append(m.Records, r)
+	}
 	return nil
 }
 
+// stringField returns the string value of the `key`, empty string if it's missing or null. Values of other types
+// are reported as errors referring to the field by `path`, so they don't end up in SQL statements formatted as garbage
+func stringField(fields map[string]interface{}, key string, path string) (string, error) {
+	switch v := fields[key].(type) {
+	case string:
+		return v, nil
+	case nil:
+		return "", nil
+	default:
+		return "", fmt.Errorf("Invalid %s value %v of type %T, string expected", path, v, v)
+	}
+}
+
+// timestamp converts milliseconds since epoch to time, zero time is returned for invalid values
+func timestamp(ms interface{}) time.Time {
+	n, ok := ms.(json.Number)
+	if !ok {
+		return time.Time{}
+	}
+	i, err := n.Int64()
+	if err != nil || i == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, i*int64(time.Millisecond))
+}
+
+// snapshot returns the snapshot phase of the source, older connectors send it as boolean
+func snapshot(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		if v == "false" {
+			return ""
+		}
+		return v
+	case bool:
+		if v {
+			return "true"
+		}
+	}
+	return ""
+}
+
+// position returns the position of the change in the source log from the `prefix`ed fields: the LSN of PostgreSQL,
+// the last LSN of the sequence sent by newer connectors or the binlog position of MySQL combined with the index of
+// the binlog file. Zero is returned if none is known
+func position(fields map[string]interface{}, prefix string) int64 {
+	if lsn := positionValue(fields[prefix+"lsn"]); lsn > 0 {
+		return lsn
+	}
+	if sequence, ok := fields[prefix+"sequence"].(string); ok {
+		// JSON array of the last committed LSN and the LSN of the change, e.g. ["24023128","24023128"]
+		var lsns []string
+		if err := json.Unmarshal([]byte(sequence), &lsns); err == nil && len(lsns) > 0 {
+			if lsn := positionValue(lsns[len(lsns)-1]); lsn > 0 {
+				return lsn
+			}
+		}
+	}
+	return binlogPosition(fields, prefix)
+}
+
+// binlogPosition returns the MySQL binlog position from the `prefix`ed fields as the index of the binlog file, e.g.
+// 3 of binlog.000003, in the upper and the position within the file in the lower 32 bits, so positions keep growing
+// across binlog rotations. Zero is returned if the file or the position is unknown
+func binlogPosition(fields map[string]interface{}, prefix string) int64 {
+	file, _ := fields[prefix+"file"].(string)
+	pos := positionValue(fields[prefix+"pos"])
+	if pos == 0 || pos >= 1<<32 {
+		return 0
+	}
+	index, err := strconv.ParseInt(file[strings.LastIndex(file, ".")+1:], 10, 32)
+	if err != nil || index < 0 {
+		return 0
+	}
+	return index<<32 | pos
+}
+
+// positionValue returns the position sent as number or numeric string, zero for other values
+func positionValue(v interface{}) int64 {
+	var s string
+	switch v := v.(type) {
+	case json.Number:
+		s = v.String()
+	case string:
+		s = v
+	default:
+		return 0
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// IsSnapshot returns true if the CDC item is a row read by the initial snapshot of the source rather than
+// a streamed change. Rows of incremental snapshots are interleaved with streamed changes, so they are not
+func (m *Message) IsSnapshot() bool {
+	switch m.Snapshot {
+	case "true", "last":
+		return true
+	case "":
+		return m.Op == "r"
+	}
+	return false
+}
+
+// QualifiedTablename returns quoted and schema qualified (if schema is known) name of the target table
 func (m *Message) QualifiedTablename() string {
 	quoteIdent := func(s string) string {
 		return `"` + strings.Replace(s, `"`, `""`, -1) + `"`
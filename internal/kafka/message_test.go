@@ -1,7 +1,9 @@
 package kafka
 
 import (
+	"encoding/json"
 	"testing"
+	"time"
 
 	kafka "github.com/segmentio/kafka-go"
 	"github.com/stretchr/testify/assert"
@@ -15,6 +17,15 @@ func TestNewMessage(t *testing.T) {
 	msg, err := NewMessage(m)
 	assert.NoError(t, err)
 	assert.NotNil(t, msg)
+	assert.True(t, msg.Timestamp.IsZero(), "__source_ts_ms is 0")
+	assert.Equal(t, Field{Type: "int32"}, msg.Fields["id"])
+	assert.Equal(t, Field{Type: "string", Optional: true}, msg.Fields["__op"])
+
+	m.Value = []byte(`{"schema":{"type":"struct","fields":[{"type":"int32","optional":false,"field":"id"},{"type":"string","optional":true,"name":"io.debezium.data.Json","version":1,"field":"doc"}],"optional":false,"name":"dbserver1.inventory.docs.Value"},"payload":{"id":1,"doc":"{\"a\": 1}","__table":"docs","__op":"c"}}`)
+	msg, err = NewMessage(m)
+	assert.NoError(t, err)
+	assert.Equal(t, Field{Type: "string", Name: "io.debezium.data.Json", Optional: true}, msg.Fields["doc"])
+	assert.Equal(t, `{"a": 1}`, msg.Values["doc"])
 
 	m.Value = []byte(`{"schema":null, "payload":null}`)
 	msg, err = NewMessage(m)
@@ -37,6 +48,340 @@ func TestNewMessage(t *testing.T) {
 	assert.Nil(t, msg)
 }
 
+func TestNewMessageEnvelope(t *testing.T) {
+	m := kafka.Message{
+		Value: []byte(`{"schema":{"type":"struct","fields":[{"type":"struct","fields":[{"type":"int32","optional":false,"field":"id"},{"type":"string","optional":true,"name":"io.debezium.data.Json","field":"doc"}],"optional":true,"name":"dbserver1.public.docs.Value","field":"before"},{"type":"struct","fields":[{"type":"int32","optional":false,"field":"id"},{"type":"string","optional":true,"name":"io.debezium.data.Json","field":"doc"}],"optional":true,"name":"dbserver1.public.docs.Value","field":"after"},{"type":"string","optional":false,"field":"op"}],"optional":false,"name":"dbserver1.public.docs.Envelope"},"payload":{"before":{"id":1,"doc":null},"after":{"id":1,"doc":"{}"},"source":{"schema":"public","table":"docs"},"op":"u"}}`),
+		Key:   []byte(`{"schema":null,"payload":{"id":1}}`),
+	}
+	msg, err := NewMessage(m)
+	assert.NoError(t, err)
+	assert.Equal(t, "u", msg.Op)
+	assert.True(t, msg.Timestamp.IsZero(), "no source timestamp")
+	assert.Equal(t, `"public"."docs"`, msg.QualifiedTablename())
+	assert.Equal(t, map[string]interface{}{"id": json.Number("1"), "doc": "{}"}, msg.Values)
+	assert.Equal(t, map[string]interface{}{"id": json.Number("1"), "doc": nil}, msg.Before)
+	assert.Equal(t, Field{Type: "string", Name: "io.debezium.data.Json", Optional: true}, msg.Fields["doc"])
+
+	m.Value = []byte(`{"schema":null,"payload":{"before":null,"after":null,"source":{"table":"docs","ts_ms":1609459200000},"op":"d"}}`)
+	msg, err = NewMessage(m)
+	assert.NoError(t, err)
+	assert.Equal(t, "d", msg.Op)
+	assert.Equal(t, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), msg.Timestamp.UTC())
+	assert.Nil(t, msg.Before)
+	assert.Empty(t, msg.Values)
+	assert.False(t, msg.IsSnapshot())
+}
+
+func TestNewMessageSnapshot(t *testing.T) {
+	m := kafka.Message{
+		Value: []byte(`{"schema":null,"payload":{"before":null,"after":{"id":1},"source":{"table":"docs","snapshot":"last"},"op":"r"}}`),
+		Key:   []byte(`{"schema":null,"payload":{"id":1}}`),
+	}
+	msg, err := NewMessage(m)
+	assert.NoError(t, err)
+	assert.Equal(t, "last", msg.Snapshot)
+	assert.True(t, msg.IsSnapshot())
+
+	m.Value = []byte(`{"schema":null,"payload":{"before":null,"after":{"id":1},"source":{"table":"docs","snapshot":true},"op":"c"}}`)
+	msg, err = NewMessage(m)
+	assert.NoError(t, err)
+	assert.Equal(t, "true", msg.Snapshot, "boolean of older connectors")
+	assert.True(t, msg.IsSnapshot())
+
+	m.Value = []byte(`{"schema":null,"payload":{"before":null,"after":{"id":1},"source":{"table":"docs","snapshot":"incremental"},"op":"r"}}`)
+	msg, err = NewMessage(m)
+	assert.NoError(t, err)
+	assert.False(t, msg.IsSnapshot(), "incremental snapshot")
+
+	m.Value = []byte(`{"schema":null,"payload":{"id":1,"__table":"docs","__op":"r","__source_snapshot":"false"}}`)
+	msg, err = NewMessage(m)
+	assert.NoError(t, err)
+	assert.Empty(t, msg.Snapshot)
+	assert.True(t, msg.IsSnapshot(), "read operation")
+}
+
+func TestNewMessagePosition(t *testing.T) {
+	m := kafka.Message{
+		Value: []byte(`{"schema":null,"payload":{"before":null,"after":{"id":1},"source":{"table":"docs","lsn":24023128,"sequence":"[\"24023000\",\"24023128\"]"},"op":"u"}}`),
+		Key:   []byte(`{"schema":null,"payload":{"id":1}}`),
+	}
+	msg, err := NewMessage(m)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 24023128, msg.Position, "lsn")
+
+	m.Value = []byte(`{"schema":null,"payload":{"before":null,"after":{"id":1},"source":{"table":"docs","lsn":null,"sequence":"[null,\"24023200\"]"},"op":"u"}}`)
+	msg, err = NewMessage(m)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 24023200, msg.Position, "the last LSN of the sequence")
+
+	m.Value = []byte(`{"schema":null,"payload":{"before":null,"after":{"id":1},"source":{"table":"docs","file":"binlog.000003","pos":1234},"op":"u"}}`)
+	msg, err = NewMessage(m)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3<<32+1234, msg.Position, "binlog position")
+	position := msg.Position
+
+	m.Value = []byte(`{"schema":null,"payload":{"before":null,"after":{"id":1},"source":{"table":"docs","file":"binlog.000004","pos":4},"op":"u"}}`)
+	msg, err = NewMessage(m)
+	assert.NoError(t, err)
+	assert.Greater(t, msg.Position, position, "binlog position grows across binlog files")
+
+	m.Value = []byte(`{"schema":null,"payload":{"before":null,"after":{"id":1},"source":{"table":"docs","pos":1234},"op":"u"}}`)
+	msg, err = NewMessage(m)
+	assert.NoError(t, err)
+	assert.Zero(t, msg.Position, "binlog position without the file")
+
+	m.Value = []byte(`{"schema":null,"payload":{"id":1,"__table":"docs","__op":"u","__source_file":"binlog.000002","__source_pos":"99"}}`)
+	msg, err = NewMessage(m)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2<<32+99, msg.Position, "flattened binlog position")
+
+	m.Value = []byte(`{"schema":null,"payload":{"id":1,"__table":"docs","__op":"u","__source_lsn":"24023300"}}`)
+	msg, err = NewMessage(m)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 24023300, msg.Position, "flattened")
+	assert.NotContains(t, msg.Values, "__source_lsn")
+
+	m.Value = []byte(`{"schema":null,"payload":{"before":null,"after":{"id":1},"source":{"table":"docs","lsn":"0/16B3748"},"op":"u"}}`)
+	msg, err = NewMessage(m)
+	assert.NoError(t, err)
+	assert.Zero(t, msg.Position, "unknown")
+}
+
+func TestNewMessageInvalidSource(t *testing.T) {
+	m := kafka.Message{
+		Value: []byte(`{"schema":null,"payload":{"before":null,"after":{"id":1},"source":{"schema":"public","table":42},"op":"c"}}`),
+		Key:   []byte(`{"schema":null,"payload":{"id":1}}`),
+	}
+	_, err := NewMessage(m)
+	assert.EqualError(t, err, "Invalid source.table value 42 of type json.Number, string expected")
+
+	m.Value = []byte(`{"schema":null,"payload":{"before":null,"after":{"id":1},"source":{"schema":{"name":"public"},"table":"docs"},"op":"c"}}`)
+	_, err = NewMessage(m)
+	assert.EqualError(t, err, "Invalid source.schema value map[name:public] of type map[string]interface {}, string expected")
+
+	m.Value = []byte(`{"schema":null,"payload":{"before":null,"after":{"id":1},"source":{"table":"docs"},"transaction":{"id":7},"op":"c"}}`)
+	_, err = NewMessage(m)
+	assert.EqualError(t, err, "Invalid transaction.id value 7 of type json.Number, string expected")
+
+	m.Value = []byte(`{"schema":null,"payload":{"before":null,"after":{"id":1},"source":{"schema":null,"table":"docs"},"op":"c"}}`)
+	msg, err := NewMessage(m)
+	assert.NoError(t, err, "null schema means no schema")
+	assert.Equal(t, `"docs"`, msg.QualifiedTablename())
+
+	// flattened events
+	m.Value = []byte(`{"schema":null,"payload":{"id":1,"__table":1,"__op":"c"}}`)
+	_, err = NewMessage(m)
+	assert.EqualError(t, err, "Invalid __table value 1 of type json.Number, string expected")
+
+	m.Value = []byte(`{"schema":null,"payload":{"id":1,"__table":"docs","__op":true}}`)
+	_, err = NewMessage(m)
+	assert.EqualError(t, err, "Invalid __op value true of type bool, string expected")
+}
+
+func TestNewMessageArrays(t *testing.T) {
+	m := kafka.Message{
+		Value: []byte(`{"schema":{"type":"struct","fields":[{"type":"array","optional":true,"items":{"type":"int32","optional":true},"field":"scores"},{"type":"array","optional":true,"items":{"type":"int32","optional":true,"name":"io.debezium.time.Date","version":1},"field":"days"}],"optional":false},"payload":{"scores":[1,null,3],"days":[],"__table":"t","__op":"c"}}`),
+		Key:   []byte(`{"schema":null,"payload":{"id":1}}`),
+	}
+	msg, err := NewMessage(m)
+	assert.NoError(t, err)
+	assert.Equal(t, Field{Type: "array", Optional: true, Items: &Field{Type: "int32", Optional: true}}, msg.Fields["scores"])
+	assert.Equal(t, &Field{Type: "int32", Optional: true, Name: "io.debezium.time.Date"}, msg.Fields["days"].Items)
+	assert.Equal(t, []interface{}{json.Number("1"), nil, json.Number("3")}, msg.Values["scores"])
+	assert.Equal(t, []interface{}{}, msg.Values["days"])
+}
+
+func TestNewMessageRecords(t *testing.T) {
+	m := kafka.Message{
+		Value: []byte(`{"schema":{"type":"struct","fields":[{"type":"array","items":{"type":"struct","fields":[{"type":"int32","optional":false,"field":"id"},{"type":"string","optional":true,"field":"name"}]},"optional":true,"field":"after"},{"type":"string","optional":false,"field":"op"}],"optional":false},"payload":{"before":null,"after":[{"id":1,"name":"a"},{"id":2,"name":"__debezium_unavailable_value"}],"source":{"schema":"public","table":"t"},"op":"c"}}`),
+		Key:   []byte(`{"schema":null,"payload":{"id":0}}`),
+	}
+	msg, err := NewMessage(m)
+	assert.NoError(t, err)
+	assert.Empty(t, msg.Values)
+	assert.Equal(t, Field{Type: "int32"}, msg.Fields["id"])
+	if assert.Len(t, msg.Records, 2) {
+		assert.Equal(t, "c", msg.Records[0].Op)
+		assert.Equal(t, `"public"."t"`, msg.Records[1].QualifiedTablename())
+		assert.Equal(t, map[string]interface{}{"id": json.Number("1"), "name": "a"}, msg.Records[0].Values)
+		assert.Equal(t, map[string]interface{}{"id": json.Number("2")}, msg.Records[1].Values)
+		assert.Equal(t, map[string]interface{}{"id": json.Number("2")}, msg.Records[1].Keys)
+		assert.Nil(t, msg.Records[1].Before)
+	}
+
+	m.Value = []byte(`{"schema":null,"payload":{"before":[{"id":1},{"id":2}],"after":[{"id":1}],"source":{"schema":"public","table":"t"},"op":"u"}}`)
+	_, err = NewMessage(m)
+	assert.EqualError(t, err, "Batched records mismatch: 1 new and 2 old row images")
+
+	m.Value = []byte(`{"schema":null,"payload":{"before":[1],"after":null,"source":{"schema":"public","table":"t"},"op":"d"}}`)
+	_, err = NewMessage(m)
+	assert.EqualError(t, err, "Field before[0] is json.Number, record expected")
+}
+
+func TestNewMessageStructs(t *testing.T) {
+	m := kafka.Message{
+		Value: []byte(`{"schema":{"type":"struct","fields":[{"type":"struct","optional":true,"fields":[{"type":"string","optional":true,"field":"street"},{"type":"int32","optional":true,"field":"zip"}],"field":"address"}],"optional":false},"payload":{"address":{"street":"Main St","zip":null},"__table":"t","__op":"c"}}`),
+		Key:   []byte(`{"schema":null,"payload":{"id":1}}`),
+	}
+	msg, err := NewMessage(m)
+	assert.NoError(t, err)
+	assert.Equal(t, Field{Type: "struct", Optional: true, Fields: []Field{
+		{Type: "string", Optional: true, Attribute: "street"},
+		{Type: "int32", Optional: true, Attribute: "zip"},
+	}}, msg.Fields["address"])
+	assert.Equal(t, map[string]interface{}{"street": "Main St", "zip": nil}, msg.Values["address"])
+}
+
+func TestNewMessageTransaction(t *testing.T) {
+	m := kafka.Message{
+		Value: []byte(`{"schema":null,"payload":{"status":"END","id":"571:53195832","event_count":2,"data_collections":[{"data_collection":"s1.a","event_count":2}]}}`),
+		Key:   []byte(`{"schema":null,"payload":{"id":"571:53195832"}}`),
+	}
+	msg, err := NewMessage(m)
+	assert.NoError(t, err)
+	assert.Equal(t, &TransactionBoundary{Status: "END", ID: "571:53195832", EventCount: 2}, msg.TransactionBoundary)
+	assert.Equal(t, "571:53195832", msg.TransactionID)
+
+	m.Value = []byte(`{"schema":null,"payload":{"op":"c","before":null,"after":{"id":1},"source":{"table":"a"},"transaction":{"id":"571:53195832","total_order":1,"data_collection_order":1}}}`)
+	msg, err = NewMessage(m)
+	assert.NoError(t, err)
+	assert.Nil(t, msg.TransactionBoundary)
+	assert.Equal(t, "571:53195832", msg.TransactionID)
+	assert.Equal(t, &Transaction{ID: "571:53195832", TotalOrder: 1, DataCollectionOrder: 1}, msg.Transaction)
+
+	m.Value = []byte(`{"schema":null,"payload":{"id":1,"__op":"c","__table":"a","__transaction_id":"571:53195832","__transaction_total_order":3}}`)
+	msg, err = NewMessage(m)
+	assert.NoError(t, err)
+	assert.Equal(t, "571:53195832", msg.TransactionID)
+	assert.Equal(t, &Transaction{ID: "571:53195832", TotalOrder: 3}, msg.Transaction)
+	assert.Equal(t, map[string]interface{}{"id": json.Number("1")}, msg.Values)
+
+	// no transaction metadata
+	m.Value = []byte(`{"schema":null,"payload":{"op":"c","before":null,"after":{"id":1},"source":{"table":"a"}}}`)
+	msg, err = NewMessage(m)
+	assert.NoError(t, err)
+	assert.Nil(t, msg.Transaction)
+	m.Value = []byte(`{"schema":null,"payload":{"id":1,"__op":"c","__table":"a"}}`)
+	msg, err = NewMessage(m)
+	assert.NoError(t, err)
+	assert.Nil(t, msg.Transaction)
+}
+
+func TestNewMessageLogicalMessage(t *testing.T) {
+	m := kafka.Message{
+		Value: []byte(`{"schema":null,"payload":{"op":"m","ts_ms":1636123456789,"source":{"ts_ms":1636123456000},"message":{"prefix":"audit","content":"aGVsbG8="}}}`),
+		Key:   []byte(`{"schema":null,"payload":{"prefix":"audit"}}`),
+	}
+	msg, err := NewMessage(m)
+	assert.NoError(t, err)
+	assert.Equal(t, "m", msg.Op)
+	assert.Equal(t, &LogicalMessage{Prefix: "audit", Content: []byte("hello")}, msg.LogicalMessage)
+	assert.Empty(t, msg.Values)
+	assert.False(t, msg.Timestamp.IsZero())
+
+	m.Value = []byte(`{"schema":null,"payload":{"op":"m","message":{"prefix":"audit","content":"not base64"}}}`)
+	_, err = NewMessage(m)
+	assert.Error(t, err)
+}
+
+func TestNewMessageBigint(t *testing.T) {
+	m := kafka.Message{
+		Value: []byte(`{"schema":{"type":"struct","fields":[{"type":"int64","optional":false,"field":"id"}],"optional":false},"payload":{"id":9007199254740993,"__table":"big","__op":"c"}}`),
+		Key:   []byte(`{"schema":{"type":"struct","fields":[{"type":"int64","optional":false,"field":"id"}],"optional":false},"payload":{"id":9007199254740993}}`),
+	}
+	msg, err := NewMessage(m)
+	assert.NoError(t, err)
+	assert.Equal(t, json.Number("9007199254740993"), msg.Values["id"])
+	assert.Equal(t, json.Number("9007199254740993"), msg.Keys["id"])
+}
+
+func TestNewMessageDefaults(t *testing.T) {
+	m := kafka.Message{
+		Value: []byte(`{"schema":{"type":"struct","fields":[{"type":"int64","optional":false,"field":"id"},{"type":"string","optional":true,"default":"new","field":"status"},{"type":"int32","optional":true,"field":"qty"}],"optional":false},"payload":{"id":1,"__table":"orders","__op":"c"}}`),
+		Key:   []byte(`{"schema":{"type":"struct","fields":[{"type":"int64","optional":false,"field":"id"}],"optional":false},"payload":{"id":1}}`),
+	}
+	msg, err := NewMessage(m)
+	assert.NoError(t, err)
+	assert.Equal(t, "new", msg.Fields["status"].Default)
+	assert.Nil(t, msg.Fields["qty"].Default, "no default declared")
+}
+
+func TestNewMessageNulls(t *testing.T) {
+	m := kafka.Message{
+		Value: []byte(`{"schema":null,"payload":{"id":1,"email":null,"notes":"__debezium_unavailable_value","__table":"customers","__op":"u"}}`),
+		Key:   []byte(`{"schema":null,"payload":{"id":1}}`),
+	}
+	msg, err := NewMessage(m)
+	assert.NoError(t, err)
+	v, ok := msg.Values["email"]
+	assert.True(t, ok, "explicit null is present")
+	assert.Nil(t, v)
+	_, ok = msg.Values["notes"]
+	assert.False(t, ok, "unchanged value is absent")
+
+	m.Value = []byte(`{"schema":null,"payload":{"before":null,"after":{"id":1,"email":null,"notes":"__debezium_unavailable_value"},"op":"u"}}`)
+	msg, err = NewMessage(m)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"id": json.Number("1"), "email": nil}, msg.Values)
+}
+
+func TestNewMessageSchemaChange(t *testing.T) {
+	m := kafka.Message{
+		Key:   []byte(`{"schema":{"type":"struct","fields":[{"type":"string","optional":false,"field":"databaseName"}],"optional":false,"name":"io.debezium.connector.mysql.SchemaChangeKey"},"payload":{"databaseName":"inventory"}}`),
+		Value: []byte(`{"schema":null,"payload":{"source":{"version":"1.9.5.Final","connector":"mysql","name":"dbserver1","ts_ms":1657200000000,"db":"inventory","table":"customers"},"databaseName":"inventory","schemaName":null,"ddl":"ALTER TABLE customers ADD COLUMN phone VARCHAR(20)","tableChanges":[{"type":"ALTER","id":"\"inventory\".\"customers\"","table":{"defaultCharsetName":"utf8mb4","primaryKeyColumnNames":["id"],"columns":[{"name":"id","jdbcType":4,"typeName":"INT","position":1,"optional":false}]}}]}}`),
+	}
+	msg, err := NewMessage(m)
+	assert.NoError(t, err)
+	assert.Equal(t, "", msg.Op)
+	assert.Equal(t, "inventory", msg.SchemaName)
+	assert.Empty(t, msg.Values)
+	assert.Equal(t, int64(1657200000000), msg.Timestamp.UnixNano()/int64(time.Millisecond))
+	if assert.NotNil(t, msg.SchemaChange) {
+		assert.Equal(t, "ALTER TABLE customers ADD COLUMN phone VARCHAR(20)", msg.SchemaChange.DDL)
+		assert.Equal(t, []TableChange{{Type: "ALTER", ID: `"inventory"."customers"`, PrimaryKey: []string{"id"},
+			Columns: []TableColumn{{Name: "id", TypeName: "INT", JDBCType: 4}}}}, msg.SchemaChange.TableChanges)
+	}
+
+	m.Value = []byte(`{"schema":null,"payload":{"databaseName":"inventory","ddl":null}}`)
+	_, err = NewMessage(m)
+	assert.Error(t, err)
+}
+
+func TestNewMessageTableStructure(t *testing.T) {
+	m := kafka.Message{
+		Key:   []byte(`{"schema":null,"payload":{"databaseName":"inventory"}}`),
+		Value: []byte(`{"schema":null,"payload":{"databaseName":"inventory","ddl":"CREATE TABLE t (...)","tableChanges":[{"type":"CREATE","id":"\"inventory\".\"t\"","table":{"primaryKeyColumnNames":["a","b"],"columns":[{"name":"price","typeName":"DECIMAL","length":10,"scale":2,"position":3,"optional":true},{"name":"a","typeName":"INT","length":null,"scale":null,"position":1,"optional":false},{"name":"b","typeName":"VARCHAR","length":20,"position":2,"optional":false}]}}]}}`),
+	}
+	msg, err := NewMessage(m)
+	assert.NoError(t, err)
+	if assert.NotNil(t, msg.SchemaChange) && assert.Len(t, msg.SchemaChange.TableChanges, 1) {
+		tc := msg.SchemaChange.TableChanges[0]
+		assert.Equal(t, []string{"a", "b"}, tc.PrimaryKey)
+		assert.Equal(t, []TableColumn{
+			{Name: "a", TypeName: "INT"},
+			{Name: "b", TypeName: "VARCHAR", Length: 20},
+			{Name: "price", TypeName: "DECIMAL", Length: 10, Scale: 2, Optional: true},
+		}, tc.Columns)
+	}
+}
+
+func TestNewMessageSpecialNumbers(t *testing.T) {
+	m := kafka.Message{
+		Key:   []byte(`{"schema":null,"payload":{"id":1}}`),
+		Value: []byte(`{"schema":null,"payload":{"id":1,"a":NaN,"b":[Infinity,-Infinity],"c":"NaN","d":"x\"NaN","__table":"t","__op":"c"}}`),
+	}
+	msg, err := NewMessage(m)
+	assert.NoError(t, err)
+	assert.Equal(t, "NaN", msg.Values["a"])
+	assert.Equal(t, []interface{}{"Infinity", "-Infinity"}, msg.Values["b"])
+	assert.Equal(t, "NaN", msg.Values["c"])
+	assert.Equal(t, `x"NaN`, msg.Values["d"])
+
+	m.Value = []byte(`{"schema":null,"payload":{"id":Nan}}`)
+	_, err = NewMessage(m)
+	assert.Error(t, err)
+}
+
 func TestQualifiedTableName(t *testing.T) {
 	m := Message{}
 	m.TableName = "bar"
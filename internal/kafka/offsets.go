@@ -0,0 +1,25 @@
+package kafka
+
+import "context"
+
+// OffsetStore keeps offsets of the messages already applied, so consuming can be resumed after restart
+type OffsetStore interface {
+	// Load returns the last saved offset of the topic partition, `ok` is false if nothing is saved yet
+	Load(ctx context.Context, topic string, partition int) (offset int64, ok bool, err error)
+	// Save stores `offset` as the last applied offset of the topic partition
+	Save(ctx context.Context, topic string, partition int, offset int64) error
+}
+
+// resumeOffset returns the offset to start consuming the topic from, i.e. the one following the saved offset
+// if it's beyond `startOffset`
+func resumeOffset(ctx context.Context, store OffsetStore, topic string, startOffset int64) (int64, error) {
+	if store == nil {
+		return startOffset, nil
+	}
+	// topics are consumed from the single partition
+	offset, ok, err := store.Load(ctx, topic, 0)
+	if err != nil || !ok || offset < startOffset {
+		return startOffset, err
+	}
+	return offset + 1, nil
+}
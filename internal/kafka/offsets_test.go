@@ -0,0 +1,48 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryOffsets map[string]int64
+
+func (s memoryOffsets) Load(ctx context.Context, topic string, partition int) (int64, bool, error) {
+	if topic == "broken" {
+		return 0, false, errors.New("connection reset")
+	}
+	offset, ok := s[topic]
+	return offset, ok, nil
+}
+
+func (s memoryOffsets) Save(ctx context.Context, topic string, partition int, offset int64) error {
+	s[topic] = offset
+	return nil
+}
+
+func TestResumeOffset(t *testing.T) {
+	ctx := context.Background()
+	store := memoryOffsets{}
+	offset, err := resumeOffset(ctx, nil, "foo", 5)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 5, offset, "no store")
+
+	offset, err = resumeOffset(ctx, store, "foo", 5)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 5, offset, "nothing saved")
+
+	assert.NoError(t, store.Save(ctx, "foo", 0, 41))
+	offset, err = resumeOffset(ctx, store, "foo", 5)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 42, offset, "resumed after the saved offset")
+
+	offset, err = resumeOffset(ctx, store, "foo", 100)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 100, offset, "start offset beyond the saved one")
+
+	_, err = resumeOffset(ctx, store, "broken", 0)
+	assert.Error(t, err)
+}
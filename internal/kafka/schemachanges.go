@@ -0,0 +1,86 @@
+package kafka
+
+import (
+	"context"
+	"time"
+)
+
+// schemaChangesHold is the time schema changes are held back for at most while no data change events arrive, so
+// the ones preceding the idle period are applied even if no newer data follow
+var schemaChangesHold = 5 * time.Second
+
+// ConsumeSchemaChanges consumes the Debezium schema change `topic` and returns the channel of the data change events
+// received from `data` merged with the schema changes, so each schema change precedes the data change events of later
+// source timestamps. Schema changes newer than the data received so far are held back until the data catch up, data
+// change events without the source timestamp release all the schema changes received, as well as no data received
+// for a while. The returned channel is closed once `data` is closed. The topic is resumed after the offset saved in
+// `offsets` if it's not nil
+func ConsumeSchemaChanges(ctx context.Context, brokers []string, topic string, offsets OffsetStore, compression string,
+	data <-chan Message) <-chan Message {
+	changes := make(chan Message, 16)
+	merged := make(chan Message, 16)
+	if start, err := resumeOffset(ctx, offsets, topic, 0); err != nil {
+		Logger.WithField("topic", topic).Error(err)
+	} else {
+		go consumeTopic(context.Background(), brokers, topic, start, 0, compression, changes)
+	}
+	go sequenceSchemaChanges(changes, data, merged)
+	return merged
+}
+
+// sequenceSchemaChanges sends the data change events of `data` to `merged` preceded by the schema changes of
+// `changes` with the same or earlier source timestamp until `data` is closed. Schema changes held back are sent when
+// no data change events arrive for `schemaChangesHold` and when `data` is closed, `merged` is closed then
+func sequenceSchemaChanges(changes <-chan Message, data <-chan Message, merged chan<- Message) {
+	defer close(merged)
+	var pending []Message
+	receive := func(c Message, ok bool) {
+		if !ok {
+			// consumer stopped, nothing more to hold back
+			changes = nil
+			return
+		}
+		pending = append(pending, c)
+	}
+	release := func() {
+		for _, c := range pending {
+			merged <- c
+		}
+		pending = nil
+	}
+	idle := time.NewTimer(schemaChangesHold)
+	defer idle.Stop()
+	for {
+		select {
+		case c, ok := <-changes:
+			receive(c, ok)
+		case <-idle.C:
+			release()
+			idle.Reset(schemaChangesHold)
+		case m, ok := <-data:
+			if !ok {
+				release()
+				return
+			}
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(schemaChangesHold)
+			// schema changes already consumed must not be overtaken by the data changes following them
+		drain:
+			for changes != nil {
+				select {
+				case c, ok := <-changes:
+					receive(c, ok)
+				default:
+					break drain
+				}
+			}
+			for len(pending) > 0 && (m.Timestamp.IsZero() || !pending[0].Timestamp.After(m.Timestamp)) {
+				merged <- pending[0]
+				pending = pending[1:]
+			}
+			merged <- m
+		}
+	}
+}
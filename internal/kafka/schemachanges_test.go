@@ -0,0 +1,82 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSequenceSchemaChanges(t *testing.T) {
+	at := func(ms int64) time.Time { return time.Unix(0, ms*int64(time.Millisecond)) }
+	change := func(ddl string, ts int64) Message {
+		return Message{SchemaChange: &SchemaChange{DDL: ddl}, Timestamp: at(ts)}
+	}
+	data := func(op string, ts int64) Message {
+		return Message{Op: op, Timestamp: at(ts)}
+	}
+	changes := make(chan Message, 4)
+	in := make(chan Message, 4)
+	merged := make(chan Message, 16)
+	changes <- change("ALTER 1", 10)
+	changes <- change("ALTER 2", 30)
+	in <- data("c", 20)
+	in <- data("u", 30)
+	done := make(chan struct{})
+	go func() {
+		sequenceSchemaChanges(changes, in, merged)
+		close(done)
+	}()
+	var got []string
+	for i := 0; i < 4; i++ {
+		m := <-merged
+		if m.SchemaChange != nil {
+			got = append(got, m.SchemaChange.DDL)
+		} else {
+			got = append(got, m.Op)
+		}
+	}
+	assert.Equal(t, []string{"ALTER 1", "c", "ALTER 2", "u"}, got)
+
+	// schema changes newer than the data are held back, data without timestamps release them
+	changes <- change("ALTER 3", 50)
+	time.Sleep(10 * time.Millisecond)
+	in <- data("d", 40)
+	assert.Equal(t, "d", (<-merged).Op)
+	in <- Message{Op: "c"}
+	assert.Equal(t, "ALTER 3", (<-merged).SchemaChange.DDL)
+	assert.Equal(t, "c", (<-merged).Op)
+
+	// schema changes held back are released once data are closed
+	changes <- change("ALTER 4", 70)
+	time.Sleep(10 * time.Millisecond)
+	close(in)
+	<-done
+	assert.Equal(t, "ALTER 4", (<-merged).SchemaChange.DDL)
+	_, ok := <-merged
+	assert.False(t, ok, "closed with the data")
+}
+
+func TestSequenceSchemaChangesIdle(t *testing.T) {
+	defer func(hold time.Duration) { schemaChangesHold = hold }(schemaChangesHold)
+	schemaChangesHold = 50 * time.Millisecond
+	changes := make(chan Message, 1)
+	in := make(chan Message, 1)
+	merged := make(chan Message, 4)
+	done := make(chan struct{})
+	go func() {
+		sequenceSchemaChanges(changes, in, merged)
+		close(done)
+	}()
+	in <- Message{Op: "c", Timestamp: time.Unix(10, 0)}
+	assert.Equal(t, "c", (<-merged).Op)
+	changes <- Message{SchemaChange: &SchemaChange{DDL: "ALTER"}, Timestamp: time.Unix(20, 0)}
+	select {
+	case m := <-merged:
+		assert.Equal(t, "ALTER", m.SchemaChange.DDL, "released when no data arrive")
+	case <-time.After(time.Second):
+		t.Fatal("schema change held back while idle")
+	}
+	close(in)
+	<-done
+}
@@ -0,0 +1,49 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// appendCDCItem appends CDC item to the `<table>_cdc_log(op, ts, data)` table instead of applying it,
+// leaving the merge to the downstream jobs
+func appendCDCItem(ctx context.Context, conn DBExecutorContext, cfg Config, message kafka.Message) (int64, error) {
+	l := loggerOf(cfg).WithField("op", "append")
+	l.Debug("Starting AppendCDCItem()...")
+	// capture the new row image, or the old one for deletes
+	image := message.Values
+	if message.Op == "d" {
+		switch {
+		case message.Before != nil:
+			image = message.Before
+		case len(message.Values) == 0:
+			image = message.Keys
+		}
+	}
+	if message.Op == "d" {
+		image = metadataRow(cfg, message, image)
+	}
+	data, err := json.Marshal(image)
+	if err != nil {
+		return 0, err
+	}
+	ts := message.Timestamp
+	if ts.IsZero() {
+		ts = message.Time
+	}
+	table := pgx.Identifier{message.TableName + "_cdc_log"}
+	if message.SchemaName > "" {
+		table = pgx.Identifier{message.SchemaName, message.TableName + "_cdc_log"}
+	}
+	sql := fmt.Sprintf("INSERT INTO %s(op, ts, data) VALUES ($1, $2, $3::jsonb)", table.Sanitize())
+	ct, err := conn.Exec(ctx, sql, message.Op, ts, string(data))
+	err = classify(ErrDBExec, err)
+	l.Debug("Exiting AppendCDCItem()...")
+	atomic.AddUint64(&tx, 1)
+	return ct.RowsAffected(), err
+}
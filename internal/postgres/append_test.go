@@ -0,0 +1,63 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppendCDCItem(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestAppendCDCItem")
+	var statements []string
+	var args [][]interface{}
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			statements = append(statements, s)
+			args = append(args, a)
+			return pgconn.CommandTag("INSERT 0 1"), nil
+		},
+	}
+	ts := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	msg := kafka.Message{
+		SchemaName: "inventory",
+		TableName:  "customers",
+		Keys:       map[string]interface{}{"id": 1},
+		Values:     map[string]interface{}{"id": 1, "email": "ed@walker.com"},
+		Timestamp:  ts,
+	}
+	cfg := Config{AppendMode: true}
+	for _, op := range []string{"c", "u", "d"} {
+		msg.Op = op
+		rows, err := applyCDCItem(context.Background(), conn, cfg, msg)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(1), rows)
+	}
+	msg.Before = map[string]interface{}{"id": 1, "email": "old@walker.com"}
+	_, err := applyCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	msg.Op = "r"
+	_, err = applyCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+
+	assert.Len(t, statements, 4, "exactly one append per change, snapshot reads ignored")
+	for _, s := range statements {
+		assert.Equal(t, `INSERT INTO "inventory"."customers_cdc_log"(op, ts, data) VALUES ($1, $2, $3::jsonb)`, s)
+	}
+	assert.Equal(t, []interface{}{"c", ts, `{"email":"ed@walker.com","id":1}`}, args[0])
+	assert.Equal(t, []interface{}{"u", ts, `{"email":"ed@walker.com","id":1}`}, args[1])
+	assert.Equal(t, []interface{}{"d", ts, `{"email":"ed@walker.com","id":1}`}, args[2], "flattened delete")
+	assert.Equal(t, []interface{}{"d", ts, `{"email":"old@walker.com","id":1}`}, args[3], "old row image")
+
+	// key only delete without source timestamp
+	msg = kafka.Message{Op: "d", TableName: "customers", Keys: map[string]interface{}{"id": 1}}
+	msg.Time = ts
+	_, err = appendCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "customers_cdc_log"(op, ts, data) VALUES ($1, $2, $3::jsonb)`, statements[4])
+	assert.Equal(t, []interface{}{"d", ts, `{"id":1}`}, args[4])
+}
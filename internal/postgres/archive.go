@@ -0,0 +1,153 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// ArchiveSuffix is appended to the name of the table to name the table archiving rows deleted from it
+const ArchiveSuffix = "_deleted"
+
+// sqlstateUndefinedTable is the error code PostgreSQL returns for references to nonexistent tables
+const sqlstateUndefinedTable = "42P01"
+
+// number of deleted rows archived during session
+var archivedDeletes uint64
+
+// archivesDeletes returns true if rows deleted from the target table of the CDC item are archived
+func archivesDeletes(cfg Config, m kafka.Message) bool {
+	return cfg.ArchiveDeletes[m.TableName] || cfg.ArchiveDeletes[m.SchemaName+"."+m.TableName]
+}
+
+// archiveTable returns the quoted name of the table archiving rows deleted from the target table of the CDC item
+func archiveTable(m kafka.Message) string {
+	if m.SchemaName == "" {
+		return pgx.Identifier{m.TableName + ArchiveSuffix}.Sanitize()
+	}
+	return pgx.Identifier{m.SchemaName, m.TableName + ArchiveSuffix}.Sanitize()
+}
+
+// isUndefinedTable returns true if `err` is the reference to the nonexistent table reported by PostgreSQL
+func isUndefinedTable(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == sqlstateUndefinedTable
+}
+
+// archiveDelete archives the old row image of the deleted row and deletes it in a single transaction if the target
+// supports them, so the row is never deleted without being archived
+func archiveDelete(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message) (int64, error) {
+	transactor, ok := conn.(DBTransactor)
+	if !ok {
+		if err := archiveRow(ctx, conn, cfg, m); err != nil {
+			return 0, err
+		}
+		return deleteCDCItem(ctx, conn, cfg, m)
+	}
+	tx, err := transactor.Begin(ctx)
+	if err != nil {
+		return 0, classify(ErrDBExec, err)
+	}
+	if err = archiveRow(ctx, tx, cfg, m); err != nil {
+		_ = tx.Rollback(ctx)
+		return 0, err
+	}
+	rowsAffected, err := deleteCDCItem(ctx, tx, cfg, m)
+	if err != nil {
+		_ = tx.Rollback(ctx)
+		return rowsAffected, err
+	}
+	return rowsAffected, classify(ErrDBExec, tx.Commit(ctx))
+}
+
+// archiveRow inserts the old row image of the deleted row, or the key if the image is missing, together with
+// the metadata of the change into the archive table. The archive table is created like the target table if it doesn't
+// exist, columns it doesn't have are skipped
+func archiveRow(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message) error {
+	image := m.Before
+	if len(image) == 0 {
+		image = m.Keys
+	}
+	if len(image) == 0 {
+		return classify(ErrMissingField, errors.New("Neither old row image nor key available to archive deleted row"))
+	}
+	row := make(map[string]interface{}, len(image)+4)
+	for f, v := range metadataRow(cfg, m, image) {
+		row[f] = v
+	}
+	row["__op"] = m.Op
+	row["__source_ts"] = nil
+	if !m.Timestamp.IsZero() {
+		row["__source_ts"] = m.Timestamp.UTC()
+	}
+	row["__topic"] = m.Topic
+	row["__offset"] = m.Offset
+	l := loggerOf(cfg).WithField("table", archiveTable(m))
+	created := false
+	for {
+		fields, refs, args, err := bindRow(cfg, m, row, make([]interface{}, 0, len(row)))
+		if err != nil {
+			return err
+		}
+		sql := fmt.Sprintf("INSERT INTO %s(%s) VALUES (%s)",
+			archiveTable(m),
+			strings.Join(fields, ","),
+			strings.Join(refs, ","))
+		_, err = execArchive(ctx, conn, sql, args)
+		atomic.AddUint64(&tx, 1)
+		if err == nil {
+			atomic.AddUint64(&archivedDeletes, 1)
+			return nil
+		}
+		column := undefinedColumn(err)
+		_, known := row[column]
+		switch {
+		case isUndefinedTable(err) && !created:
+			created = true
+			if err = createArchive(ctx, conn, m); err != nil {
+				return classify(ErrDBExec, err)
+			}
+			l.Info("Archive table created")
+		case known && len(row) > 1:
+			l.WithField("column", column).Warning("Column missing in the archive table skipped")
+			delete(row, column)
+		default:
+			return classify(ErrDBExec, err)
+		}
+	}
+}
+
+// createArchive creates the archive table with the columns of the target table and the metadata of the changes.
+// The columns are copied without their NOT NULL constraints, so keys archived without the old row image fit
+func createArchive(ctx context.Context, conn DBExecutorContext, m kafka.Message) error {
+	table := archiveTable(m)
+	for _, sql := range []string{
+		fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s AS SELECT *, NULL::text AS "__op", NULL::timestamptz AS "__source_ts", `+
+			`NULL::text AS "__topic", NULL::bigint AS "__offset", now() AS "__archived_at" FROM %s WITH NO DATA`,
+			table,
+			m.QualifiedTablename()),
+		fmt.Sprintf(`ALTER TABLE %s ALTER COLUMN "__archived_at" SET DEFAULT now(), ALTER COLUMN "__archived_at" SET NOT NULL`, table),
+	} {
+		_, err := execArchive(ctx, conn, sql, nil)
+		atomic.AddUint64(&tx, 1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execArchive executes the statement within a savepoint if `conn` is a transaction, so the transaction remains usable
+// after the failure caused by the missing archive table or its columns
+func execArchive(ctx context.Context, conn DBExecutorContext, sql string, args []interface{}) (pgconn.CommandTag, error) {
+	if t, ok := conn.(pgx.Tx); ok {
+		return execSavepoint(ctx, t, sql, args)
+	}
+	return conn.Exec(ctx, sql, args...)
+}
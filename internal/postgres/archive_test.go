@@ -0,0 +1,125 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockSavepointTransactor begins transactions starting savepoints
+type mockSavepointTransactor struct {
+	MockDbExec
+	Tx mockSavepointTx
+}
+
+func (m mockSavepointTransactor) Begin(ctx context.Context) (pgx.Tx, error) {
+	return m.Tx, nil
+}
+
+func TestArchiveDeletes(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestArchiveDeletes")
+	var (
+		statements []string
+		args       [][]interface{}
+		failures   map[string]error
+		savepoints int
+		commits    int
+		rollbacks  int
+	)
+	exec := MockDbExec{ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+		statements, args = append(statements, s), append(args, a)
+		for prefix, err := range failures {
+			if strings.HasPrefix(s, prefix) {
+				delete(failures, prefix)
+				return nil, err
+			}
+		}
+		return pgconn.CommandTag("DELETE 1"), nil
+	}}
+	conn := mockSavepointTransactor{Tx: mockSavepointTx{
+		MockDbTx: MockDbTx{
+			MockDbExec:      exec,
+			CommitHandler:   func() error { commits++; return nil },
+			RollbackHandler: func() error { rollbacks++; return nil },
+		},
+		savepoints: &savepoints,
+	}}
+	m := kafka.Message{Op: "d", SchemaName: "public", TableName: "orders",
+		Keys:      map[string]interface{}{"id": int64(1)},
+		Before:    map[string]interface{}{"id": int64(1), "qty": int64(5)},
+		Fields:    map[string]kafka.Field{"id": {Type: "int64"}, "qty": {Type: "int64"}},
+		Timestamp: time.Date(2021, 3, 4, 6, 6, 7, 0, time.FixedZone("CET", 3600)),
+	}
+	m.Topic, m.Offset = "db.public.orders", 42
+	cfg := Config{ArchiveDeletes: map[string]bool{"orders": true}}
+	ctx := context.Background()
+	insert := `INSERT INTO "public"."orders_deleted"("__offset","__op","__source_ts","__topic","id","qty") VALUES ($1,$2,$3,$4,$5,$6)`
+	archived := Stats().ArchivedDeletes
+
+	failures = map[string]error{"INSERT": &pgconn.PgError{Code: "42P01", Message: `relation "public.orders_deleted" does not exist`}}
+	rowsAffected, err := applyCDCItem(ctx, conn, cfg, m)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, rowsAffected)
+	create := []string{
+		`CREATE TABLE IF NOT EXISTS "public"."orders_deleted" AS SELECT *, NULL::text AS "__op", NULL::timestamptz AS "__source_ts", ` +
+			`NULL::text AS "__topic", NULL::bigint AS "__offset", now() AS "__archived_at" FROM "public"."orders" WITH NO DATA`,
+		`ALTER TABLE "public"."orders_deleted" ALTER COLUMN "__archived_at" SET DEFAULT now(), ALTER COLUMN "__archived_at" SET NOT NULL`,
+	}
+	assert.Equal(t, []string{
+		insert,
+		create[0],
+		create[1],
+		insert,
+		`DELETE FROM "public"."orders" WHERE ("id")=($1)`,
+	}, statements, "missing archive table is created")
+	assert.Equal(t, []interface{}{int64(42), "d", m.Timestamp.UTC(), "db.public.orders", int64(1), int64(5)}, args[3])
+	// savepoints of the archive statements are released or rolled back, the transaction is committed
+	assert.Equal(t, 4, savepoints)
+	assert.Equal(t, savepoints+1, commits+rollbacks, "archived and deleted in a single transaction")
+	assert.Equal(t, archived+1, Stats().ArchivedDeletes)
+
+	statements, commits = nil, 0
+	failures = map[string]error{"INSERT": &pgconn.PgError{Code: "42703", Message: `column "qty" of relation "orders_deleted" does not exist`}}
+	_, err = applyCDCItem(ctx, conn, cfg, m)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		insert,
+		`INSERT INTO "public"."orders_deleted"("__offset","__op","__source_ts","__topic","id") VALUES ($1,$2,$3,$4,$5)`,
+		`DELETE FROM "public"."orders" WHERE ("id")=($1)`,
+	}, statements, "columns missing in the archive table are skipped")
+
+	statements, savepoints, commits, rollbacks = nil, 0, 0, 0
+	failures = map[string]error{"INSERT": errors.New("disk full")}
+	_, err = applyCDCItem(ctx, conn, cfg, m)
+	assert.True(t, errors.Is(err, ErrDBExec))
+	assert.Equal(t, []string{insert}, statements, "row is not deleted unless archived")
+	assert.Equal(t, 0, commits)
+	assert.Equal(t, 2, rollbacks, "savepoint and transaction are rolled back")
+
+	statements = nil
+	m.Before = nil
+	_, err = applyCDCItem(ctx, exec, cfg, m)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		`INSERT INTO "public"."orders_deleted"("__offset","__op","__source_ts","__topic","id") VALUES ($1,$2,$3,$4,$5)`,
+		`DELETE FROM "public"."orders" WHERE ("id")=($1)`,
+	}, statements, "key is archived if the old row image is missing")
+
+	// the archive table created for a key-only delete holds the key alone, as no NOT NULL constraints are copied
+	statements = nil
+	failures = map[string]error{"INSERT": &pgconn.PgError{Code: "42P01", Message: `relation "public.orders_deleted" does not exist`}}
+	_, err = applyCDCItem(ctx, exec, cfg, m)
+	assert.NoError(t, err)
+	keyOnly := `INSERT INTO "public"."orders_deleted"("__offset","__op","__source_ts","__topic","id") VALUES ($1,$2,$3,$4,$5)`
+	assert.Equal(t, []string{keyOnly, create[0], create[1], keyOnly, `DELETE FROM "public"."orders" WHERE ("id")=($1)`}, statements)
+	_, _, ok := bulkDeleteKey(cfg, m)
+	assert.False(t, ok, "archived one by one")
+}
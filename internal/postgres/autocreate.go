@@ -0,0 +1,94 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// dryRunTables holds tables which DDL is already written by the dry run of auto-creating tables, so it's written once
+var dryRunTables struct {
+	sync.Mutex
+	written map[string]bool
+}
+
+// createTableDDL returns the statement creating the target table of the CDC item with columns of its Debezium schema.
+// Columns declared not optional are NOT NULL, the primary key is made of the key columns or the message key
+func createTableDDL(cfg Config, m kafka.Message) string {
+	keys := keyColumns(cfg, m)
+	if len(keys) == 0 {
+		for k := range m.Keys {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+	}
+	isKey := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		isKey[k] = true
+	}
+	// columns missing in the schema, e.g. added to the row images by the configuration, are created as well
+	seen := make(map[string]bool, len(m.Fields))
+	var others []string
+	add := func(c string) {
+		if !isKey[c] && !seen[c] {
+			seen[c] = true
+			others = append(others, c)
+		}
+	}
+	for c := range m.Fields {
+		add(c)
+	}
+	for _, row := range []map[string]interface{}{m.Values, m.Before} {
+		for c := range row {
+			add(c)
+		}
+	}
+	sort.Strings(others)
+	definitions := make([]string, 0, len(keys)+len(others)+1)
+	for _, c := range append(keys, others...) {
+		definition := pgx.Identifier{c}.Sanitize() + " " + columnType(cfg, m, c)
+		if f, declared := m.Fields[c]; isKey[c] || declared && !f.Optional {
+			definition += " NOT NULL"
+		}
+		definitions = append(definitions, definition)
+	}
+	if len(keys) > 0 {
+		quoted := make([]string, len(keys))
+		for i, k := range keys {
+			quoted[i] = pgx.Identifier{k}.Sanitize()
+		}
+		definitions = append(definitions, "PRIMARY KEY ("+strings.Join(quoted, ", ")+")")
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", m.QualifiedTablename(), strings.Join(definitions, ", "))
+}
+
+// createTable creates the missing target table of the CDC item, returns false if the table isn't created as the DDL
+// is written to `cfg.AutoCreateOutput` instead
+func createTable(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message) (bool, error) {
+	sql := createTableDDL(cfg, m)
+	l := loggerOf(cfg).WithField("table", m.QualifiedTablename()).WithField("ddl", sql)
+	if cfg.AutoCreateOutput != nil {
+		dryRunTables.Lock()
+		defer dryRunTables.Unlock()
+		if dryRunTables.written == nil {
+			dryRunTables.written = make(map[string]bool)
+		}
+		if !dryRunTables.written[m.QualifiedTablename()] {
+			dryRunTables.written[m.QualifiedTablename()] = true
+			_, err := fmt.Fprintln(cfg.AutoCreateOutput, sql+";")
+			l.Info("Missing target table not created, DDL written")
+			return false, err
+		}
+		return false, nil
+	}
+	if _, err := conn.Exec(ctx, sql); err != nil {
+		return false, classify(ErrDBExec, err)
+	}
+	l.Warning("Missing target table created")
+	return true, nil
+}
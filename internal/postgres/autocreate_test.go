@@ -0,0 +1,90 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateTableDDL(t *testing.T) {
+	m := kafka.Message{
+		Op:         "c",
+		SchemaName: "shop",
+		TableName:  "orders",
+		Keys:       map[string]interface{}{"tenant": "a", "id": 1},
+		Values:     map[string]interface{}{"tenant": "a", "id": 1, "price": "9.90", "note": nil, "__op": "c"},
+		Fields: map[string]kafka.Field{
+			"id":      {Type: "int32"},
+			"tenant":  {Type: "string"},
+			"price":   {Type: "bytes", Name: logicalDecimal},
+			"note":    {Type: "string", Optional: true},
+			"created": {Type: "int64", Name: logicalMicroTimestamp, Optional: true},
+		},
+	}
+	assert.Equal(t, `CREATE TABLE IF NOT EXISTS "shop"."orders" (`+
+		`"id" integer NOT NULL, "tenant" text NOT NULL, "__op" text, "created" timestamp, "note" text, "price" numeric NOT NULL, `+
+		`PRIMARY KEY ("id", "tenant"))`, createTableDDL(Config{}, m))
+
+	cfg := Config{KeyColumns: map[string]bool{"orders.tenant": true}, ColumnTypes: map[string]string{"orders.note": "varchar(200)"}}
+	assert.Equal(t, `CREATE TABLE IF NOT EXISTS "shop"."orders" (`+
+		`"tenant" text NOT NULL, "__op" text, "created" timestamp, "id" integer NOT NULL, "note" varchar(200), "price" numeric NOT NULL, `+
+		`PRIMARY KEY ("tenant"))`, createTableDDL(cfg, m), "configured key columns and types")
+
+	m.Keys = nil
+	assert.Equal(t, `CREATE TABLE IF NOT EXISTS "shop"."orders" (`+
+		`"__op" text, "created" timestamp, "id" integer NOT NULL, "note" text, "price" numeric NOT NULL, "tenant" text NOT NULL)`,
+		createTableDDL(Config{}, m), "table without key")
+}
+
+func TestAutoCreateTables(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestAutoCreateTables")
+	var (
+		statements []string
+		created    bool
+	)
+	conn := MockDbExec{
+		ExecHandler: func(sql string, arguments []interface{}) (pgconn.CommandTag, error) {
+			statements = append(statements, sql)
+			switch {
+			case strings.HasPrefix(sql, "CREATE TABLE"):
+				created = true
+				return pgconn.CommandTag("CREATE TABLE"), nil
+			case !created:
+				return nil, &pgconn.PgError{Code: sqlstateUndefinedTable, Message: `relation "items" does not exist`}
+			}
+			return pgconn.CommandTag("INSERT 0 1"), nil
+		},
+	}
+	msg := kafka.Message{Op: "c", TableName: "items", Keys: map[string]interface{}{"id": 1}, Values: map[string]interface{}{"id": 1},
+		Fields: map[string]kafka.Field{"id": {Type: "int32"}}}
+
+	_, err := applyDriftingCDCItem(context.Background(), conn, Config{}, msg)
+	assert.True(t, isUndefinedTable(err), "disabled")
+
+	// dry run writes the DDL once
+	statements = nil
+	var out bytes.Buffer
+	cfg := Config{AutoCreateTables: true, AutoCreateOutput: &out}
+	for i := 0; i < 2; i++ {
+		_, err = applyDriftingCDCItem(context.Background(), conn, cfg, msg)
+		assert.True(t, isUndefinedTable(err))
+	}
+	assert.Equal(t, "CREATE TABLE IF NOT EXISTS \"items\" (\"id\" integer NOT NULL, PRIMARY KEY (\"id\"));\n", out.String())
+	assert.Equal(t, []string{`INSERT INTO "items"("id") VALUES ($1)`, `INSERT INTO "items"("id") VALUES ($1)`}, statements)
+
+	statements = nil
+	rows, err := applyDriftingCDCItem(context.Background(), conn, Config{AutoCreateTables: true}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+	assert.Equal(t, []string{
+		`INSERT INTO "items"("id") VALUES ($1)`,
+		`CREATE TABLE IF NOT EXISTS "items" ("id" integer NOT NULL, PRIMARY KEY ("id"))`,
+		`INSERT INTO "items"("id") VALUES ($1)`,
+	}, statements)
+}
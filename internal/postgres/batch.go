@@ -0,0 +1,150 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgx/v4"
+)
+
+// applyBatch applies CDC items in a single transaction if the target supports transactions. If any item fails,
+// the transaction is rolled back and items are applied one by one, so the failing ones are reported separately,
+// unless items are isolated within savepoints. Items are separate statements except for multi-row inserts,
+// which are split to stay within the bound parameters limit. Returns true if applying must stop
+func applyBatch(ctx context.Context, conn DBExecutorContext, cfg Config, batch []kafka.Message) bool {
+	if len(batch) == 0 {
+		return false
+	}
+	l := loggerOf(cfg).WithField("batch", len(batch))
+	transactor, ok := conn.(DBTransactor)
+	if !ok {
+		return applyOneByOne(ctx, conn, cfg, batch)
+	}
+	failed, err := applyInTx(ctx, transactor, cfg, collapseBatch(cfg, batch))
+	if err != nil {
+		l.WithError(err).Warning("Batch failed, applying CDC items one by one")
+		return applyOneByOne(ctx, conn, cfg, batch)
+	}
+	type position struct {
+		topic     string
+		partition int
+		offset    int64
+	}
+	// failed items are accounted once with their errors
+	failures := make(map[position]error, len(failed))
+	for _, f := range failed {
+		failures[position{f.message.Topic, f.message.Partition, f.message.Offset}] = f.err
+	}
+	for _, m := range batch {
+		updateStats(m, failures[position{m.Topic, m.Partition, m.Offset}])
+	}
+	for _, f := range failed {
+		atomic.AddUint64(&isolatedFailures, 1)
+		handleFailure(ctx, cfg, f.message, f.err)
+	}
+	saveOffsets(ctx, cfg, batch...)
+	l.WithField("failed", len(failed)).Debug("Batch committed")
+	return false
+}
+
+// applyInTx applies CDC items in a single transaction, which is rolled back if any item fails. Consecutive plain
+// inserts into the same table are combined into multi-row inserts, consecutive deletes by the single key column into
+// bulk deletes. Items of the staging tables are merged at the end. With `cfg.IsolateItems` the failing items are
+// rolled back to their savepoints and returned instead, unless the error policy halts applying
+func applyInTx(ctx context.Context, transactor DBTransactor, cfg Config, batch []kafka.Message) ([]failedItem, error) {
+	tx, err := transactor.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		staged []kafka.Message
+		failed []failedItem
+	)
+	for i := 0; i < len(batch) && err == nil; {
+		run := insertRun(cfg, batch[i:])
+		if len(run) == 1 {
+			run = deleteRun(cfg, batch[i:])
+		}
+		i += len(run)
+		switch {
+		case stagesTable(cfg, run[0]):
+			staged = append(staged, run[0])
+		case cfg.IsolateItems:
+			var f []failedItem
+			f, err = applyIsolated(ctx, tx, cfg, run)
+			failed = append(failed, f...)
+		default:
+			err = applyRun(ctx, tx, cfg, run)
+		}
+	}
+	if err == nil && len(staged) > 0 {
+		err = applyStaged(ctx, tx, cfg, staged)
+	}
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		_ = tx.Rollback(ctx)
+		return nil, err
+	}
+	return failed, nil
+}
+
+// applyRun applies the run of CDC items returned by insertRun or deleteRun
+func applyRun(ctx context.Context, conn DBExecutorContext, cfg Config, run []kafka.Message) error {
+	switch {
+	case len(run) > 1 && run[0].Op == "d":
+		return deleteRows(ctx, conn, cfg, run)
+	case len(run) > 1:
+		return insertRows(ctx, conn, cfg, run)
+	}
+	_, err := applyRecorded(ctx, conn, cfg, run[0])
+	if errors.Is(err, errAlreadyApplied) || errors.Is(err, errVetoed) {
+		return nil
+	}
+	return err
+}
+
+// applyIsolated applies the run of CDC items within a savepoint of the transaction. If the run fails, it's rolled
+// back to the savepoint and items of combined runs are retried one by one, so only the failing ones are returned.
+// Returns the error instead if the transaction can't go on or the error policy halts applying
+func applyIsolated(ctx context.Context, tx pgx.Tx, cfg Config, run []kafka.Message) ([]failedItem, error) {
+	savepoint, err := tx.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applyErr := applyRun(ctx, savepoint, cfg, run)
+	if applyErr == nil {
+		return nil, savepoint.Commit(ctx)
+	}
+	if err = savepoint.Rollback(ctx); err != nil {
+		return nil, err
+	}
+	if cfg.ErrorPolicy == ErrorPolicyHalt && !errors.Is(applyErr, ErrUnsupportedOp) {
+		return nil, applyErr
+	}
+	if len(run) == 1 {
+		return []failedItem{{run[0], applyErr}}, nil
+	}
+	var failed []failedItem
+	for _, m := range run {
+		f, err := applyIsolated(ctx, tx, cfg, []kafka.Message{m})
+		if err != nil {
+			return nil, err
+		}
+		failed = append(failed, f...)
+	}
+	return failed, nil
+}
+
+// applyOneByOne applies CDC items without the common transaction, returns true if applying must stop
+func applyOneByOne(ctx context.Context, conn DBExecutorContext, cfg Config, batch []kafka.Message) bool {
+	for _, m := range batch {
+		if applyMessage(ctx, conn, cfg, m) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,237 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type MockDbTx struct {
+	pgx.Tx
+	MockDbExec
+	CommitHandler   func() error
+	RollbackHandler func() error
+}
+
+func (m MockDbTx) Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error) {
+	return m.MockDbExec.Exec(ctx, sql, arguments...)
+}
+
+// Begin starts the savepoint as the nested transaction sharing the handlers
+func (m MockDbTx) Begin(ctx context.Context) (pgx.Tx, error) {
+	return m, nil
+}
+
+func (m MockDbTx) Commit(ctx context.Context) error {
+	if m.CommitHandler != nil {
+		return m.CommitHandler()
+	}
+	return nil
+}
+
+func (m MockDbTx) Rollback(ctx context.Context) error {
+	if m.RollbackHandler != nil {
+		return m.RollbackHandler()
+	}
+	return nil
+}
+
+type MockDbTransactor struct {
+	MockDbExec
+	Tx MockDbTx
+}
+
+func (m MockDbTransactor) Begin(ctx context.Context) (pgx.Tx, error) {
+	return m.Tx, nil
+}
+
+func TestApplyBatch(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestApplyBatch")
+	var inTx, outOfTx, commits int
+	conn := MockDbTransactor{
+		MockDbExec: MockDbExec{
+			ExecHandler: func(sql string, arguments []interface{}) (pgconn.CommandTag, error) {
+				outOfTx++
+				return pgconn.CommandTag("INSERT 0 1"), nil
+			},
+		},
+		Tx: MockDbTx{
+			MockDbExec: MockDbExec{
+				ExecHandler: func(sql string, arguments []interface{}) (pgconn.CommandTag, error) {
+					inTx++
+					return pgconn.CommandTag("INSERT 0 1"), nil
+				},
+			},
+			CommitHandler: func() error {
+				commits++
+				return nil
+			},
+		},
+	}
+	batch := []kafka.Message{
+		{Op: "c", TableName: "t", Values: map[string]interface{}{"id": 1}},
+		{Op: "c", TableName: "t", Values: map[string]interface{}{"id": 2}},
+	}
+	applyBatch(context.Background(), conn, Config{}, batch)
+	assert.Equal(t, 1, inTx, "inserts combined into a multi-row insert")
+	assert.Equal(t, 0, outOfTx)
+	assert.Equal(t, 1, commits)
+
+	// failing item makes the whole batch applied one by one
+	inTx, commits = 0, 0
+	batch = append(batch, kafka.Message{Op: "x"})
+	applyBatch(context.Background(), conn, Config{}, batch)
+	assert.Equal(t, 1, inTx)
+	assert.Equal(t, 0, commits)
+	assert.Equal(t, 2, outOfTx)
+
+	// failing commit
+	inTx, outOfTx = 0, 0
+	conn.Tx.CommitHandler = func() error { return errors.New("serialization failure") }
+	applyBatch(context.Background(), conn, Config{}, batch[:2])
+	assert.Equal(t, 1, inTx)
+	assert.Equal(t, 2, outOfTx)
+
+	// no transactions support
+	outOfTx = 0
+	applyBatch(context.Background(), conn.MockDbExec, Config{}, batch[:2])
+	assert.Equal(t, 2, outOfTx)
+}
+
+func TestApplyBatchWideRows(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestApplyBatchWideRows")
+	var statements, params int
+	conn := MockDbTransactor{
+		Tx: MockDbTx{
+			MockDbExec: MockDbExec{
+				ExecHandler: func(sql string, arguments []interface{}) (pgconn.CommandTag, error) {
+					statements++
+					params += len(arguments)
+					assert.LessOrEqual(t, len(arguments), maxParams)
+					return pgconn.CommandTag("INSERT 0 1"), nil
+				},
+			},
+		},
+	}
+	values := make(map[string]interface{}, 1600)
+	for i := 0; i < 1600; i++ {
+		values[fmt.Sprintf("c%d", i)] = i
+	}
+	batch := make([]kafka.Message, 50)
+	for i := range batch {
+		batch[i] = kafka.Message{Op: "c", TableName: "wide", Values: values}
+	}
+	applyBatch(context.Background(), conn, Config{}, batch)
+	assert.Equal(t, 2, statements, "multi-row insert split to stay within the limit")
+	assert.Equal(t, 50*1600, params)
+}
+
+func TestApplyFlushInterval(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestApplyFlushInterval")
+	commits := make(chan struct{}, 2)
+	Connect = func(ctx context.Context, connString string) (DBExecutorContext, error) {
+		return MockDbTransactor{
+			Tx: MockDbTx{
+				MockDbExec: MockDbExec{
+					ExecHandler: func(sql string, arguments []interface{}) (pgconn.CommandTag, error) {
+						return pgconn.CommandTag("INSERT 0 1"), nil
+					},
+				},
+				CommitHandler: func() error {
+					commits <- struct{}{}
+					return nil
+				},
+			},
+		}, nil
+	}
+	msgChan := make(chan kafka.Message)
+	done := make(chan struct{})
+	go func() {
+		Apply(context.Background(), "foo", Config{IdleTimeout: time.Second, BatchSize: 10, FlushInterval: 50 * time.Millisecond}, msgChan)
+		close(done)
+	}()
+	for i := 0; i < 2; i++ {
+		msgChan <- kafka.Message{Op: "c", TableName: "t", Values: map[string]interface{}{"id": i}}
+		select {
+		case <-commits:
+		case <-time.After(500 * time.Millisecond):
+			t.Fatal("incomplete batch is not committed within the flush interval")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	<-done
+	assert.Len(t, commits, 0, "nothing left to commit on idle timeout")
+}
+
+func TestApplyBatchIsolated(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestApplyBatchIsolated")
+	var (
+		statements []string
+		savepoints int
+		rollbacks  int
+	)
+	exec := MockDbExec{ExecHandler: func(sql string, arguments []interface{}) (pgconn.CommandTag, error) {
+		statements = append(statements, sql)
+		for _, arg := range arguments {
+			if arg == int64(2) {
+				return nil, &pgconn.PgError{Code: "23514", Message: "check constraint violated"}
+			}
+		}
+		return pgconn.CommandTag("INSERT 0 1"), nil
+	}}
+	conn := mockSavepointTransactor{
+		MockDbExec: exec,
+		Tx: mockSavepointTx{
+			MockDbTx:   MockDbTx{MockDbExec: exec, RollbackHandler: func() error { rollbacks++; return nil }},
+			savepoints: &savepoints,
+		},
+	}
+	batch := []kafka.Message{
+		offsetMessage("foo", 1, "c"),
+		offsetMessage("foo", 2, "c"),
+		offsetMessage("foo", 3, "c"),
+		offsetMessage("foo", 4, "c"),
+	}
+	deadLetters := make(chan kafka.DeadLetter, len(batch))
+	store := memoryOffsets{}
+	cfg := Config{IsolateItems: true, ErrorPolicy: ErrorPolicyDLQ, DeadLetters: deadLetters, Offsets: store}
+	failures, messages := Stats().IsolatedFailures, Stats().Messages
+	assert.False(t, applyBatch(context.Background(), conn, cfg, batch))
+	assert.Equal(t, []string{
+		`INSERT INTO "t"("id") VALUES ($1),($2),($3),($4)`,
+		`INSERT INTO "t"("id") VALUES ($1)`,
+		`INSERT INTO "t"("id") VALUES ($1)`,
+		`INSERT INTO "t"("id") VALUES ($1)`,
+		`INSERT INTO "t"("id") VALUES ($1)`,
+	}, statements, "the failing multi-row insert is retried row by row")
+	assert.Equal(t, 5, savepoints)
+	assert.Equal(t, 2, rollbacks, "only the savepoints of the failing statements are rolled back")
+	assert.Len(t, deadLetters, 1)
+	assert.Equal(t, int64(2), (<-deadLetters).Message.Offset)
+	assert.Equal(t, memoryOffsets{"foo": 4}, store, "the rest of the batch is committed")
+	assert.Equal(t, failures+1, Stats().IsolatedFailures)
+	assert.Equal(t, messages+4, Stats().Messages, "the failing item is accounted once")
+	var pgErr *pgconn.PgError
+	assert.True(t, errors.As(Stats().LastError, &pgErr), "the failing item is accounted with its error")
+
+	// halting policy applies the batch one by one up to the failing item
+	statements, rollbacks = nil, 0
+	store = memoryOffsets{}
+	cfg = Config{IsolateItems: true, ErrorPolicy: ErrorPolicyHalt, Offsets: store}
+	assert.True(t, applyBatch(context.Background(), conn, cfg, batch))
+	assert.Equal(t, []string{
+		`INSERT INTO "t"("id") VALUES ($1),($2),($3),($4)`,
+		`INSERT INTO "t"("id") VALUES ($1)`,
+		`INSERT INTO "t"("id") VALUES ($1)`,
+	}, statements)
+	assert.Equal(t, 2, rollbacks, "the savepoint and the transaction are rolled back")
+	assert.Equal(t, memoryOffsets{"foo": 1}, store, "items after the failing one are not acknowledged")
+}
@@ -0,0 +1,129 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+)
+
+// number of bulk deletes and rows matched by them during session
+var (
+	bulkDeletes    uint64
+	bulkDeleteRows uint64
+)
+
+// bulkDeleteKey returns the name and the type of the single key column if the prepared CDC item is a plain delete which may share
+// the statement with other deletes from the same table. Deletes of composite keys, NULL keys, keys of unknown type or
+// depending on the outcome of each row, e.g. by the delete missing policy or the version guards, are applied one per
+// statement
+func bulkDeleteKey(cfg Config, m kafka.Message) (string, string, bool) {
+	if m.Op != "d" || m.SchemaChange != nil || m.TransactionBoundary != nil || len(m.Records) > 0 {
+		return "", "", false
+	}
+	if cfg.AppendMode || cfg.Ledger > "" || cfg.SchemaDrift > "" || cfg.WidenColumns || hasApplyHooks(cfg) || len(fanOutTargets(cfg, m)) > 0 {
+		return "", "", false
+	}
+	if stagesTable(cfg, m) || isHistoryTable(cfg, m) || archivesDeletes(cfg, m) || deletePolicy(cfg, m) != DeletePolicyDelete ||
+		len(rowVersions(cfg, m)) > 0 {
+		return "", "", false
+	}
+	if policy, err := deleteMissingPolicy(cfg, m); err != nil || policy != "" {
+		return "", "", false
+	}
+	m, err := overrideKeys(cfg, m)
+	if err != nil || len(m.Keys) != 1 || len(matchedColumns(cfg, m)) != 1 {
+		return "", "", false
+	}
+	for column, v := range m.Keys {
+		typ := bulkKeyType(cfg, m, column)
+		return column, typ, v != nil && typ > ""
+	}
+	return "", "", false
+}
+
+// bulkKeyType returns the type of the array the keys of the `column` are bound to by a bulk delete, empty if the key
+// type isn't known from the schema or a cast, or the key isn't matched by a plain parameter, e.g. case insensitive or
+// geometric keys
+func bulkKeyType(cfg Config, m kafka.Message, column string) string {
+	if _, declared := m.Fields[column]; !declared && castFor(cfg, m, column) == "" {
+		return ""
+	}
+	_, ref, err := bindValue(cfg, m, column, m.Keys[column], 1)
+	if err != nil || ref != placeholder(1, castFor(cfg, m, column)) {
+		return ""
+	}
+	if field, _ := matchColumn(cfg, m, column, ref); field != strconv.Quote(column) {
+		return ""
+	}
+	typ := columnType(cfg, m, column)
+	if strings.HasSuffix(typ, "[]") {
+		return ""
+	}
+	return typ
+}
+
+// deleteRun returns the prepared CDC items starting the batch which delete rows of the same table by the same
+// single key column, so they can be applied with a single statement. Returns the first item alone if it can't be combined
+func deleteRun(cfg Config, batch []kafka.Message) []kafka.Message {
+	first := prepareMessage(cfg, batch[0])
+	run := []kafka.Message{first}
+	column, typ, ok := bulkDeleteKey(cfg, first)
+	if !ok {
+		return run
+	}
+	table := first.QualifiedTablename()
+	for _, m := range batch[1:] {
+		m = prepareMessage(cfg, m)
+		if c, t, ok := bulkDeleteKey(cfg, m); !ok || c != column || t != typ || m.QualifiedTablename() != table {
+			break
+		}
+		run = append(run, m)
+	}
+	return run
+}
+
+// deleteRows deletes rows of the CDC items of the same table and key column with as few statements as possible.
+// The keys are bound as a single array of the key type, split between statements so each stays within the limit
+func deleteRows(ctx context.Context, conn DBExecutorContext, cfg Config, run []kafka.Message) error {
+	var (
+		field, typ string
+		keys       []interface{}
+	)
+	flush := func() error {
+		if len(keys) == 0 {
+			return nil
+		}
+		sql := fmt.Sprintf("DELETE FROM %s WHERE %s = ANY($1::%s[])", run[0].QualifiedTablename(), field, typ)
+		_, err := conn.Exec(ctx, sql, keys)
+		atomic.AddUint64(&tx, 1)
+		atomic.AddUint64(&bulkDeletes, 1)
+		atomic.AddUint64(&bulkDeleteRows, uint64(len(keys)))
+		loggerOf(cfg).WithField("table", run[0].QualifiedTablename()).WithField("rows", len(keys)).Debug("Bulk delete applied")
+		keys = nil
+		return classify(ErrDBExec, err)
+	}
+	for _, m := range run {
+		m, err := overrideKeys(cfg, m)
+		if err != nil {
+			return err
+		}
+		if len(keys) >= maxParams {
+			if err = flush(); err != nil {
+				return err
+			}
+		}
+		for column, v := range m.Keys {
+			arg, f, _, err := bindKey(cfg, m, column, v, 1)
+			if err != nil {
+				return err
+			}
+			field, typ = f, bulkKeyType(cfg, m, column)
+			keys = append(keys, arg)
+		}
+	}
+	return flush()
+}
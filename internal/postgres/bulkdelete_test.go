@@ -0,0 +1,98 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBulkDelete(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestBulkDelete")
+	var (
+		statements []string
+		args       [][]interface{}
+	)
+	conn := MockDbTransactor{
+		Tx: MockDbTx{
+			MockDbExec: MockDbExec{
+				ExecHandler: func(sql string, arguments []interface{}) (pgconn.CommandTag, error) {
+					statements = append(statements, sql)
+					args = append(args, arguments)
+					return pgconn.CommandTag("DELETE 1"), nil
+				},
+			},
+		},
+	}
+	fields := map[string]kafka.Field{"id": {Type: "int64"}, "tenant": {Type: "int64"}}
+	del := func(table string, keys map[string]interface{}) kafka.Message {
+		return kafka.Message{Op: "d", TableName: table, Keys: keys, Fields: fields}
+	}
+	id := func(n int64) map[string]interface{} { return map[string]interface{}{"id": n} }
+	uuid := kafka.Message{Op: "d", TableName: "b", Keys: map[string]interface{}{"id": "a0ee"},
+		Fields: map[string]kafka.Field{"id": {Type: "string", Name: logicalUUID}}}
+
+	batch := []kafka.Message{
+		del("a", id(1)),
+		del("a", id(2)),
+		del("a", id(3)),
+		del("a", map[string]interface{}{"id": int64(4), "tenant": int64(1)}),
+		del("a", id(5)),
+		del("a", map[string]interface{}{"id": nil}),
+		uuid,
+		uuid,
+		{Op: "c", TableName: "a", Keys: id(6), Values: id(6), Fields: fields},
+		del("a", id(7)),
+	}
+	stats := Stats()
+	_, err := applyInTx(context.Background(), conn, Config{}, batch)
+	assert.NoError(t, err)
+	// columns of the composite key are matched in any order
+	assert.Contains(t, []string{`DELETE FROM "a" WHERE ("id","tenant")=($1,$2)`, `DELETE FROM "a" WHERE ("tenant","id")=($1,$2)`}, statements[1])
+	statements = append(statements[:1], statements[2:]...)
+	assert.Equal(t, []string{
+		`DELETE FROM "a" WHERE "id" = ANY($1::bigint[])`,
+		`DELETE FROM "a" WHERE ("id")=($1)`,
+		`DELETE FROM "a" WHERE ("id") IS NOT DISTINCT FROM ($1)`,
+		`DELETE FROM "b" WHERE "id" = ANY($1::uuid[])`,
+		`INSERT INTO "a"("id") VALUES ($1)`,
+		`DELETE FROM "a" WHERE ("id")=($1)`,
+	}, statements, "composite keys, NULL keys and single deletes are applied per row")
+	assert.Equal(t, []interface{}{[]interface{}{int64(1), int64(2), int64(3)}}, args[0], "keys are bound as a single array")
+	assert.Equal(t, stats.BulkDeletes+2, Stats().BulkDeletes)
+
+	// keys of unknown type are matched one by one, as the array type can't be inferred
+	statements = nil
+	schemaless := []kafka.Message{{Op: "d", TableName: "a", Keys: id(1)}, {Op: "d", TableName: "a", Keys: id(2)}}
+	_, err = applyInTx(context.Background(), conn, Config{}, schemaless)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`DELETE FROM "a" WHERE ("id")=($1)`, `DELETE FROM "a" WHERE ("id")=($1)`}, statements)
+	statements = nil
+	_, err = applyInTx(context.Background(), conn, Config{ColumnTypes: map[string]string{"a.id": "integer"}}, schemaless)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`DELETE FROM "a" WHERE "id" = ANY($1::integer[])`}, statements, "configured column type")
+
+	// deletes depending on the outcome of each row are not combined
+	statements = nil
+	cfg := Config{DeleteMissing: map[string]string{"a": DeleteMissingOK}}
+	_, err = applyInTx(context.Background(), conn, cfg, batch[:2])
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`DELETE FROM "a" WHERE ("id")=($1)`, `DELETE FROM "a" WHERE ("id")=($1)`}, statements)
+
+	// key columns configured for the table
+	statements = nil
+	cfg = Config{KeyColumns: map[string]bool{"a.code": true}}
+	batch = []kafka.Message{
+		{Op: "d", TableName: "a", Keys: id(1), Before: map[string]interface{}{"id": int64(1), "code": "x"},
+			Fields: map[string]kafka.Field{"id": {Type: "int64"}, "code": {Type: "string"}}},
+		{Op: "d", TableName: "a", Keys: id(2), Before: map[string]interface{}{"id": int64(2), "code": "y"},
+			Fields: map[string]kafka.Field{"id": {Type: "int64"}, "code": {Type: "string"}}},
+	}
+	_, err = applyInTx(context.Background(), conn, cfg, batch)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`DELETE FROM "a" WHERE "code" = ANY($1::text[])`}, statements)
+	assert.Equal(t, []interface{}{[]interface{}{"x", "y"}}, args[len(args)-1])
+}
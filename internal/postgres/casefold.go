@@ -0,0 +1,45 @@
+package postgres
+
+import (
+	"strings"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+)
+
+// Identifier case folding modes
+const (
+	CaseFoldPreserve = "preserve" // use table and column names exactly as sent by the source
+	CaseFoldLower    = "lower"    // lowercase table and column names as PostgreSQL does for unquoted identifiers
+)
+
+// foldCase returns the CDC item with table and column names folded according to `mode`
+func foldCase(mode string, message kafka.Message) kafka.Message {
+	if mode != CaseFoldLower {
+		return message
+	}
+	message.SchemaName = strings.ToLower(message.SchemaName)
+	message.TableName = strings.ToLower(message.TableName)
+	message.Keys = foldKeys(message.Keys)
+	message.Values = foldKeys(message.Values)
+	message.Before = foldKeys(message.Before)
+	if message.Fields != nil {
+		fields := make(map[string]kafka.Field, len(message.Fields))
+		for k, f := range message.Fields {
+			fields[strings.ToLower(k)] = f
+		}
+		message.Fields = fields
+	}
+	return message
+}
+
+// foldKeys returns the copy of the row image with lowercased column names
+func foldKeys(row map[string]interface{}) map[string]interface{} {
+	if row == nil {
+		return nil
+	}
+	folded := make(map[string]interface{}, len(row))
+	for k, v := range row {
+		folded[strings.ToLower(k)] = v
+	}
+	return folded
+}
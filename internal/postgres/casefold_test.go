@@ -0,0 +1,60 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFoldCase(t *testing.T) {
+	msg := kafka.Message{
+		SchemaName: "Sales",
+		TableName:  "Customers",
+		Keys:       map[string]interface{}{"ID": int64(1)},
+		Values:     map[string]interface{}{"FirstName": "John"},
+		Fields:     map[string]kafka.Field{"FirstName": {Type: "string"}},
+	}
+	assert.Equal(t, msg, foldCase(CaseFoldPreserve, msg))
+	assert.Equal(t, msg, foldCase("", msg))
+
+	folded := foldCase(CaseFoldLower, msg)
+	assert.Equal(t, "sales", folded.SchemaName)
+	assert.Equal(t, "customers", folded.TableName)
+	assert.Equal(t, map[string]interface{}{"id": int64(1)}, folded.Keys)
+	assert.Equal(t, map[string]interface{}{"firstname": "John"}, folded.Values)
+	assert.Nil(t, folded.Before)
+	assert.Contains(t, folded.Fields, "firstname")
+	assert.Contains(t, msg.Values, "FirstName", "original message must not be modified")
+}
+
+func TestApplyCaseFold(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestApplyCaseFold")
+	var sql string
+	Connect = func(ctx context.Context, connString string) (DBExecutorContext, error) {
+		return &MockDbExec{
+			ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+				sql = s
+				return pgconn.CommandTag("INSERT 0 1"), nil
+			},
+		}, nil
+	}
+	msgChan := make(chan kafka.Message, 1)
+	msg := kafka.Message{
+		Op:        "c",
+		TableName: "Customers",
+		Values:    map[string]interface{}{"FirstName": "John"},
+	}
+
+	msgChan <- msg
+	Apply(context.Background(), "foo", Config{IdleTimeout: 100 * time.Millisecond}, msgChan)
+	assert.Equal(t, `INSERT INTO "Customers"("FirstName") VALUES ($1)`, sql)
+
+	msgChan <- msg
+	Apply(context.Background(), "foo", Config{IdleTimeout: 100 * time.Millisecond, CaseFold: CaseFoldLower}, msgChan)
+	assert.Equal(t, `INSERT INTO "customers"("firstname") VALUES ($1)`, sql)
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -15,133 +17,817 @@ import (
 // trancsation number applied to the target PostgreSQL during session
 var tx uint64
 
-// Apply function reads messages from `messages` channel and applies changes to the target PostgreSQL database
-func Apply(ctx context.Context, connString string, idleTimeout time.Duration, messages <-chan kafka.Message) {
+// number of CDC items with unsupported operation received during session
+var unsupportedOps uint64
+
+// number of inserts skipped as duplicates of existing rows during session
+var skippedDuplicates uint64
+
+// number of deletes of rows already missing in the target tolerated during session
+var missingDeletes uint64
+
+// Insert modes, i.e. how to apply CDC items with create operation
+const (
+	InsertModePlain   = "insert"  // plain INSERT, replayed items fail or cause duplicates
+	InsertModeGuarded = "guarded" // INSERT ... WHERE NOT EXISTS matching the key, so replays insert nothing
+	InsertModeIgnore  = "ignore"  // INSERT ... ON CONFLICT DO NOTHING, so duplicates are silently skipped
+	InsertModeUpsert  = "upsert"  // INSERT ... ON CONFLICT DO UPDATE, so replays overwrite existing rows
+)
+
+// InsertConflictIgnore is the per table insert conflict policy skipping inserts of rows already existing
+const InsertConflictIgnore = "ignore"
+
+// Per table policies for deletes of rows missing in the target, such deletes are warned about by default
+const (
+	DeleteMissingOK     = "ok"     // expected, e.g. when replaying, so only counted
+	DeleteMissingStrict = "strict" // sign of divergence, so deletes fail
+)
+
+// Apply function reads messages from `messages` channel and applies changes to the target PostgreSQL database until
+// the channel is closed, e.g. once all topics are consumed up to the end offset, or the idle timeout passes
+func Apply(ctx context.Context, connString string, cfg Config, messages <-chan kafka.Message) {
+	resetStats()
+	apply(ctx, connString, cfg, messages, true)
+}
+
+// apply applies messages of the `messages` channel using its own connection to the target database. Staging tables
+// are dropped at the end if `dropStaging` is set, workers sharing them leave it to the caller
+func apply(ctx context.Context, connString string, cfg Config, messages <-chan kafka.Message, dropStaging bool) {
 	conn, err := Connect(context.Background(), connString)
 	if err != nil {
-		Logger.Fatalln(err)
+		fatal(cfg, err)
+		return
+	}
+	if err = createLedger(ctx, conn, cfg); err != nil {
+		fatal(cfg, err)
+		return
+	}
+	if err = createSchemaChangesTable(ctx, conn, cfg); err != nil {
+		fatal(cfg, err)
 		return
 	}
+	cfg = detectUpdateMode(ctx, conn, cfg)
+	if dropStaging {
+		defer dropStagingTables(context.Background(), conn, cfg)
+	}
 	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	idle := time.NewTimer(cfg.IdleTimeout)
+	defer idle.Stop()
+	// flushC stays nil, i.e. never fires, unless batches are flushed on interval
+	var flushC <-chan time.Time
+	var flushTicker *time.Ticker
+	if cfg.BatchSize > 1 && cfg.FlushInterval > 0 {
+		flushTicker = time.NewTicker(cfg.FlushInterval)
+		defer flushTicker.Stop()
+		flushC = flushTicker.C
+	}
+	// copyC stays nil unless snapshot rows are loaded on interval
+	var copyC <-chan time.Time
+	if cfg.SnapshotCopy && cfg.SnapshotCopyInterval > 0 {
+		copyTicker := time.NewTicker(cfg.SnapshotCopyInterval)
+		defer copyTicker.Stop()
+		copyC = copyTicker.C
+	}
+	var snapshot snapshotLoader
+	var batch []kafka.Message
+	txs := make(transactions)
+	defer txs.discard(cfg)
+	var lag lagMonitor
+	limiter := newRateLimiter(cfg.MaxWritesPerSecond)
 	for {
 		select {
-		case m := <-messages:
-			rowsAffected, err := applyCDCItem(ctx, conn, m)
-			if err != nil {
-				Logger.Error(err)
-			} else if rowsAffected == 0 {
-				Logger.Warning("CDC item caused no changes")
+		case m, ok := <-messages:
+			if !ok {
+				// all topics are consumed
+				if !applyBatch(ctx, conn, cfg, batch) {
+					snapshot.load(ctx, conn, cfg)
+				}
+				loggerOf(cfg).Info("All messages consumed")
+				return
+			}
+			if ctx.Err() != nil {
+				// cancelled meanwhile, apply the message together with the queued ones
+				flush(conn, cfg, messages, txs, &snapshot, append(batch, m)...)
+				return
+			}
+			if !idle.Stop() {
+				<-idle.C
+			}
+			if err := limiter.wait(ctx); err != nil {
+				flush(conn, cfg, messages, txs, &snapshot, append(batch, m)...)
+				return
 			}
+			idle.Reset(cfg.IdleTimeout)
+			lag.observe(cfg, m, time.Now())
+			if copiesSnapshot(cfg, m) {
+				// keep the order of changes
+				if applyBatch(ctx, conn, cfg, batch) {
+					return
+				}
+				batch = nil
+				snapshot.add(ctx, conn, cfg, m)
+				continue
+			}
+			// streaming began, so the snapshot rows are loaded before any streamed change
+			snapshot.load(ctx, conn, cfg)
+			if isTransactional(cfg, m) {
+				// keep the order of changes
+				if applyBatch(ctx, conn, cfg, batch) {
+					return
+				}
+				batch = nil
+				if txs.apply(ctx, conn, cfg, m) {
+					return
+				}
+				continue
+			}
+			if cfg.BatchSize <= 1 {
+				if applyMessage(ctx, conn, cfg, m) {
+					return
+				}
+				continue
+			}
+			batch = append(batch, m)
+			if len(batch) >= cfg.BatchSize {
+				if applyBatch(ctx, conn, cfg, batch) {
+					return
+				}
+				batch = nil
+				if flushTicker != nil {
+					flushTicker.Reset(cfg.FlushInterval)
+				}
+			}
+		case <-flushC:
+			if len(batch) > 0 && applyBatch(ctx, conn, cfg, batch) {
+				return
+			}
+			batch = nil
+		case <-copyC:
+			snapshot.load(ctx, conn, cfg)
 		case <-ctx.Done():
+			flush(conn, cfg, messages, txs, &snapshot, batch...)
 			return
-		case <-time.After(idleTimeout):
-			Logger.Print("Idle timeout exceeded")
+		case <-idle.C:
+			if applyBatch(ctx, conn, cfg, batch) {
+				return
+			}
+			snapshot.load(ctx, conn, cfg)
+			loggerOf(cfg).Info("Idle timeout exceeded")
 			return
 		case <-ticker.C:
-			Logger.WithField("transactions", tx).Print("Transactions processed...")
+			if txs.applyExpired(ctx, conn, cfg) {
+				return
+			}
+			loggerOf(cfg).WithField("transactions", atomic.LoadUint64(&tx)).
+				WithField("unsupported", atomic.LoadUint64(&unsupportedOps)).
+				WithField("duplicates", atomic.LoadUint64(&skippedDuplicates)).
+				WithField("missing", atomic.LoadUint64(&missingDeletes)).
+				Info("Transactions processed...")
+		}
+	}
+}
+
+// applyMessage applies CDC item and accounts the result, returns true if applying must stop as the item failed with
+// ErrorPolicyHalt
+func applyMessage(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message) bool {
+	m = prepareMessage(cfg, m)
+	rowsAffected, err := applyLedgered(ctx, conn, cfg, m)
+	if errors.Is(err, errAlreadyApplied) {
+		loggerOf(cfg).WithField("offset", m.Offset).Debug("CDC item already applied, skipped")
+		saveOffsets(ctx, cfg, m)
+		return false
+	}
+	if errors.Is(err, errVetoed) {
+		loggerOf(cfg).WithField("offset", m.Offset).WithError(err).Debug("CDC item skipped")
+		updateStats(m, nil)
+		saveOffsets(ctx, cfg, m)
+		return false
+	}
+	updateStats(m, err)
+	switch {
+	case err != nil:
+		if handleFailure(ctx, cfg, m, err) {
+			return true
+		}
+	case rowsAffected == 0 && changesRows(cfg, m):
+		loggerOf(cfg).Warning("CDC item caused no changes")
+	}
+	if err == nil {
+		saveOffsets(ctx, cfg, m)
+	}
+	return false
+}
+
+// prepareMessage returns the CDC item with table and column names and struct columns transformed as configured
+func prepareMessage(cfg Config, m kafka.Message) kafka.Message {
+	m = withSchemaDefaults(cfg, renameColumns(cfg, flattenStructs(cfg, foldCase(cfg.CaseFold, m))))
+	return prepareRecords(cfg, withVersions(cfg, withMetadata(cfg, m)))
+}
+
+// changesRows returns true if applying the CDC item is expected to affect rows of the target table
+func changesRows(cfg Config, m kafka.Message) bool {
+	if m.Op == "c" && ignoresConflicts(cfg, m) {
+		// duplicates are skipped silently
+		return false
+	}
+	if policy, _ := deleteMissingPolicy(cfg, m); m.Op == "d" && policy == DeleteMissingOK {
+		return false
+	}
+	if m.Op == "d" && deletePolicy(cfg, m) == DeletePolicySkip {
+		return false
+	}
+	if m.Op == "u" && updatePolicy(cfg, m) == UpdatePolicyChanged && len(changedColumns(m).Values) == 0 {
+		return false
+	}
+	if len(rowVersions(cfg, m)) > 0 && (m.Op == "u" || m.Op == "d" || isUpsert(cfg, m)) {
+		// guarded items tell stale changes from missing rows themselves
+		return false
+	}
+	return m.SchemaChange == nil && m.TransactionBoundary == nil && m.Op != "m" && !isView(cfg, m)
+}
+
+// isView returns true if the target of the CDC item is a view, writes through INSTEAD OF triggers
+// report no affected rows, so it's not a sign of the missing row there
+func isView(cfg Config, m kafka.Message) bool {
+	return cfg.Views[m.TableName] || cfg.Views[m.SchemaName+"."+m.TableName]
+}
+
+// flush applies `pending` messages and the ones already queued in the `messages` channel when applying
+// is cancelled, so they are not lost. Flushing stops when the queue is empty or `cfg.ShutdownGrace` is exceeded.
+// CDC items of the source transactions are applied only if the transaction completes meanwhile, buffered snapshot rows
+// are loaded before the streamed changes and at the end
+func flush(conn DBExecutorContext, cfg Config, messages <-chan kafka.Message, txs transactions, snapshot *snapshotLoader,
+	pending ...kafka.Message) {
+	if cfg.ShutdownGrace <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownGrace)
+	defer cancel()
+	defer snapshot.load(ctx, conn, cfg)
+	apply := func(m kafka.Message) bool {
+		if copiesSnapshot(cfg, m) {
+			snapshot.add(ctx, conn, cfg, m)
+			return false
+		}
+		snapshot.load(ctx, conn, cfg)
+		if isTransactional(cfg, m) {
+			return txs.apply(ctx, conn, cfg, m)
 		}
+		return applyMessage(ctx, conn, cfg, m)
 	}
+	for n := 0; ctx.Err() == nil; n++ {
+		if n < len(pending) {
+			if apply(pending[n]) {
+				return
+			}
+			continue
+		}
+		select {
+		case m, ok := <-messages:
+			if !ok || apply(m) {
+				return
+			}
+		default:
+			loggerOf(cfg).WithField("messages", n).Info("Queued messages flushed")
+			return
+		}
+	}
+	loggerOf(cfg).Warning("Shutdown grace period exceeded, queued messages are not applied")
 }
 
-func applyCDCItem(ctx context.Context, conn DBExecutorContext, message kafka.Message) (int64, error) {
-	Logger.WithField("schema", string(message.Key)).Trace("Key used for applying CDC item")
+func applyCDCItem(ctx context.Context, conn DBExecutorContext, cfg Config, message kafka.Message) (int64, error) {
+	loggerOf(cfg).WithField("schema", string(message.Key)).Trace("Key used for applying CDC item")
+	if t := message.Transaction; t != nil {
+		loggerOf(cfg).WithField("transaction", t.ID).WithField("total_order", t.TotalOrder).
+			WithField("data_collection_order", t.DataCollectionOrder).Debug("Source transaction of CDC item")
+	}
+	if message.SchemaChange != nil {
+		if cfg.TranslateDDL && len(message.SchemaChange.TableChanges) > 0 {
+			return applyTableChanges(ctx, conn, cfg, message)
+		}
+		return applySchemaChange(ctx, conn, cfg, *message.SchemaChange)
+	}
+	if message.TransactionBoundary != nil {
+		// nothing to apply unless source transactions are grouped
+		return 0, nil
+	}
+	if targets := fanOutTargets(cfg, message); len(targets) > 0 {
+		return applyFanOut(ctx, conn, cfg, message, targets)
+	}
+	if cfg.AppendMode {
+		switch message.Op {
+		case "c", "u", "d":
+			return appendCDCItem(ctx, conn, cfg, message)
+		}
+	}
+	message, err := overrideKeys(cfg, message)
+	if err != nil {
+		return 0, err
+	}
+	if spec, ok := historySpec(cfg, message); ok {
+		switch message.Op {
+		case "c", "u", "d":
+			return applyHistory(ctx, conn, cfg, message, spec)
+		}
+	}
 	switch message.Op {
 	case "c":
-		return insertCDCItem(ctx, conn, message)
+		return insertCDCItem(ctx, conn, cfg, message)
 	case "u":
-		return updateCDCItem(ctx, conn, message)
+		switch updatePolicy(cfg, message) {
+		case UpdatePolicyUpdate:
+			return updateCDCItem(ctx, conn, cfg, message)
+		case UpdatePolicyMerge:
+			return mergeCDCItem(ctx, conn, cfg, message)
+		case UpdatePolicyChanged:
+			if message = changedColumns(message); len(message.Values) == 0 {
+				// nothing changed
+				return 0, nil
+			}
+			return updateCDCItem(ctx, conn, cfg, message)
+		}
+		if cfg.UpsertUpdates && isUpsert(cfg, message) {
+			return insertCDCItem(ctx, conn, cfg, message)
+		}
+		if cfg.UpdateMode == UpdateModeMerge {
+			return mergeCDCItem(ctx, conn, cfg, message)
+		}
+		return updateCDCItem(ctx, conn, cfg, message)
 	case "d":
-		return deleteCDCItem(ctx, conn, message)
+		switch deletePolicy(cfg, message) {
+		case DeletePolicySkip:
+			return 0, nil
+		case DeletePolicySoft:
+			return softDeleteCDCItem(ctx, conn, cfg, message)
+		}
+		if archivesDeletes(cfg, message) {
+			return archiveDelete(ctx, conn, cfg, message)
+		}
+		return deleteCDCItem(ctx, conn, cfg, message)
 	case "r":
-		// ignore snapshot reading
+		// ignore snapshot reading unless loaded with COPY
+		return 0, nil
+	case "m":
+		if cfg.MessageHandler != nil && message.LogicalMessage != nil {
+			cfg.MessageHandler(*message.LogicalMessage)
+		}
 		return 0, nil
 	}
-	return 0, errors.New("Unsupported operation")
+	return 0, fmt.Errorf("%w: %q", ErrUnsupportedOp, message.Op)
 }
 
-func insertCDCItem(ctx context.Context, conn DBExecutorContext, message kafka.Message) (int64, error) {
-	l := Logger.WithField("op", "insert")
+// sendDeadLetter passes message to the `cfg.DeadLetters` channel if one is configured, otherwise the message is dropped
+func sendDeadLetter(ctx context.Context, cfg Config, message kafka.Message, reason error) {
+	l := loggerOf(cfg).WithError(reason).WithField("topic", message.Topic).WithField("offset", message.Offset)
+	if cfg.DeadLetters == nil {
+		l.Error("CDC item dropped")
+		return
+	}
+	select {
+	case cfg.DeadLetters <- kafka.DeadLetter{Message: message, Reason: reason.Error()}:
+		l.Warning("CDC item sent to the dead-letter queue")
+	case <-ctx.Done():
+	}
+}
+
+func insertCDCItem(ctx context.Context, conn DBExecutorContext, cfg Config, message kafka.Message) (int64, error) {
+	l := loggerOf(cfg).WithField("op", "insert")
 	l.Debug("Starting InsertCDCItem()...")
-	fnumber := len(message.Values)
-	refs := make([]string, 0, fnumber)
-	for i := 1; i <= fnumber; i++ {
-		refs = append(refs, "$"+strconv.Itoa(i))
-	}
-	args := make([]interface{}, 0, fnumber)
-	fields := make([]string, len(args))
-	for f, v := range message.Values {
-		l.WithField("field", f).WithField("value", v).Debug("CDC value used")
-		fields = append(fields, strconv.Quote(f))
-		args = append(args, v)
+	sql, args, err := buildInsertSQL(cfg, message)
+	if err != nil {
+		return 0, err
+	}
+	ignore := ignoresConflicts(cfg, message)
+	upsert := !ignore && isUpsert(cfg, message)
+	ct, err := execInsert(ctx, conn, cfg, message, sql, args)
+	atomic.AddUint64(&tx, 1)
+	if isMissingPartition(err) && createMissingPartition(ctx, conn, cfg, message) {
+		// retried once, so failures after creating the partition are reported
+		ct, err = execInsert(ctx, conn, cfg, message, sql, args)
+		atomic.AddUint64(&tx, 1)
+	}
+	if !upsert && !ignore && isUniqueViolation(err) && updatesOnDuplicate(cfg, message) {
+		return updateDuplicate(ctx, conn, cfg, message)
+	}
+	err = classify(ErrDBExec, err)
+	if ignore && err == nil && ct.RowsAffected() == 0 {
+		atomic.AddUint64(&skippedDuplicates, 1)
+	}
+	if upsert && err == nil && ct.RowsAffected() == 0 && len(rowVersions(cfg, message)) > 0 {
+		// the conflicting row holds a newer version
+		countStale(cfg, message)
+	}
+	l.Debug("Exiting InsertCDCItem()...")
+	return ct.RowsAffected(), err
+}
+
+// buildInsertSQL returns the statement inserting the new row image of the CDC item and its arguments, including
+// the conflict handling configured for the target table
+func buildInsertSQL(cfg Config, message kafka.Message) (string, []interface{}, error) {
+	row := omitNullDefaults(cfg, message, message.Values)
+	fields, refs, args, err := bindRow(cfg, message, row, make([]interface{}, 0, len(row)))
+	if err != nil {
+		return "", nil, err
 	}
 	sql := fmt.Sprintf("INSERT INTO %s(%s) VALUES (%s)",
 		message.QualifiedTablename(),
 		strings.Join(fields, ","),
 		strings.Join(refs, ","))
-	ct, err := conn.Exec(ctx, sql, args...)
-	l.Debug("Exiting InsertCDCItem()...")
-	atomic.AddUint64(&tx, 1)
-	return ct.RowsAffected(), err
+	if len(fields) == 0 {
+		// the row image without columns, e.g. of the table having none besides the generated ones
+		sql = fmt.Sprintf("INSERT INTO %s DEFAULT VALUES", message.QualifiedTablename())
+	}
+	ignore := ignoresConflicts(cfg, message)
+	upsert := !ignore && isUpsert(cfg, message)
+	if insertMode(cfg, message) == InsertModeGuarded && !upsert && !ignore && len(message.Keys) > 0 && len(fields) > 0 {
+		// makes insert idempotent even if the target table has no unique constraint. Parameters of the SELECT list
+		// would be resolved as text, the empty SELECT of the target columns united with it types them as the columns
+		var match string
+		if match, args, err = bindMatch(cfg, message, message.Keys, args); err != nil {
+			return "", nil, err
+		}
+		sql = fmt.Sprintf("INSERT INTO %[1]s(%[2]s) SELECT %[2]s FROM %[1]s WHERE false "+
+			"UNION ALL SELECT %[3]s WHERE NOT EXISTS (SELECT 1 FROM %[1]s WHERE %[4]s)",
+			message.QualifiedTablename(),
+			strings.Join(fields, ","),
+			strings.Join(refs, ","),
+			match)
+	}
+	switch {
+	case upsert:
+		conflicting := conflictColumns(cfg, message)
+		if len(conflicting) == 0 {
+			return "", nil, classify(ErrMissingField, errors.New("Neither key nor conflict columns available to upsert row"))
+		}
+		sql += " ON CONFLICT " + conflictTarget(conflicting) + upsertAction(fields, conflicting)
+		if versions := rowVersions(cfg, message); len(versions) > 0 {
+			sql += " WHERE " + guardVersions(versions, message.QualifiedTablename()+".", func(v rowVersion) string {
+				return "EXCLUDED." + strconv.Quote(v.column)
+			})
+		}
+	case ignore:
+		if err := checkConflictPolicy(cfg, message); err != nil {
+			return "", nil, err
+		}
+		sql += " ON CONFLICT " + conflictTarget(conflictColumns(cfg, message)) + "DO NOTHING"
+	}
+	return sql + returningClause(cfg, message), args, nil
 }
 
-func updateCDCItem(ctx context.Context, conn DBExecutorContext, message kafka.Message) (int64, error) {
-	l := Logger.WithField("op", "update")
-	l.Debug("Starting UpdateCDCItem()...")
-	keyrefs := make([]string, 0, len(message.Keys))
-	for i := 1; i <= len(message.Keys); i++ {
-		keyrefs = append(keyrefs, "$"+strconv.Itoa(i))
+// isUpsert returns true if inserts into the target table of the CDC item are upserts
+func isUpsert(cfg Config, m kafka.Message) bool {
+	return insertMode(cfg, m) == InsertModeUpsert || cfg.UpsertTables[m.TableName] || cfg.UpsertTables[m.SchemaName+"."+m.TableName]
+}
+
+// checkConflictPolicy returns an error if the insert conflict policy of the target table of the CDC item is unsupported
+func checkConflictPolicy(cfg Config, m kafka.Message) error {
+	policy, ok := cfg.InsertConflicts[m.SchemaName+"."+m.TableName]
+	if !ok {
+		policy, ok = cfg.InsertConflicts[m.TableName]
+	}
+	if ok && policy != InsertConflictIgnore {
+		return fmt.Errorf("Invalid insert conflict policy %q, %q expected", policy, InsertConflictIgnore)
 	}
-	keyvals := make([]interface{}, 0, len(message.Keys))
-	keyfields := make([]string, 0, len(message.Keys))
-	for f, v := range message.Keys {
-		keyfields = append(keyfields, strconv.Quote(f))
-		keyvals = append(keyvals, v)
+	return nil
+}
+
+// ignoresConflicts returns true if inserts into the target table of the CDC item skip rows already existing.
+// Only conflicts on the key or conflict columns are skipped if known, other constraint violations still fail.
+// The table policy takes precedence over the insert mode
+func ignoresConflicts(cfg Config, m kafka.Message) bool {
+	if _, ok := cfg.InsertConflicts[m.TableName]; ok {
+		return true
 	}
+	if _, ok := cfg.InsertConflicts[m.SchemaName+"."+m.TableName]; ok {
+		return true
+	}
+	return insertMode(cfg, m) == InsertModeIgnore && !isUpsert(cfg, m)
+}
 
-	valrefs := make([]string, 0, len(message.Values))
-	for i := 1; i <= len(message.Values); i++ {
-		valrefs = append(valrefs, "$"+strconv.Itoa(i+len(message.Keys)))
+// conflictColumns returns quoted names of the columns identifying conflicting rows in stable order, i.e. the columns
+// of the new row image listed in `cfg.ConflictKeys` or the key columns otherwise
+func conflictColumns(cfg Config, message kafka.Message) []string {
+	var columns []string
+	for f := range message.Values {
+		if hasColumn(cfg.ConflictKeys, message, f) {
+			columns = append(columns, strconv.Quote(f))
+		}
+	}
+	if len(columns) == 0 {
+		for f := range message.Keys {
+			columns = append(columns, strconv.Quote(f))
+		}
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// conflictTarget returns the ON CONFLICT target listing the columns, or empty string to handle conflicts
+// on any constraint if the columns are unknown
+func conflictTarget(columns []string) string {
+	if len(columns) == 0 {
+		return ""
+	}
+	return "(" + strings.Join(columns, ",") + ") "
+}
+
+// upsertAction returns the ON CONFLICT action updating the inserted `fields` except the `conflicting` ones
+func upsertAction(fields []string, conflicting []string) string {
+	sets := make([]string, 0, len(fields))
+	for _, f := range fields {
+		if i := sort.SearchStrings(conflicting, f); i < len(conflicting) && conflicting[i] == f {
+			continue
+		}
+		sets = append(sets, f+"=EXCLUDED."+f)
+	}
+	if len(sets) == 0 {
+		return "DO NOTHING"
+	}
+	return "DO UPDATE SET " + strings.Join(sets, ",")
+}
+
+// omitNullDefaults returns the row image without NULL values of the columns listed in `cfg.NullToDefault`,
+// so the target applies the column defaults instead
+func omitNullDefaults(cfg Config, message kafka.Message, row map[string]interface{}) map[string]interface{} {
+	if len(cfg.NullToDefault) == 0 {
+		return row
+	}
+	omitted := make(map[string]interface{}, len(row))
+	for f, v := range row {
+		if v == nil && hasColumn(cfg.NullToDefault, message, f) {
+			continue
+		}
+		omitted[f] = v
 	}
-	vals := make([]interface{}, 0, len(message.Values))
-	fields := make([]string, 0, len(message.Values))
-	for f, v := range message.Values {
+	return omitted
+}
+
+// bindRow binds values of the `row` image as parameters numbered after `args`. Columns with the expression configured
+// are set to the expression instead, unless it references columns missing in the row image. Fields consumed only by
+// the expressions are bound if referenced, but not copied. Returns quoted column names, SQL expressions setting them
+// and arguments
+func bindRow(cfg Config, message kafka.Message, row map[string]interface{}, args []interface{}) ([]string, []string, []interface{}, error) {
+	fields := make([]string, 0, len(row))
+	refs := make([]string, 0, len(row))
+	bound := make(map[string]string, len(row))
+	params := placeholderBuilder{offset: len(args)}
+	// stable column order keeps statements the same for the same columns, e.g. for the statement cache
+	columns := make([]string, 0, len(row))
+	for f := range row {
+		columns = append(columns, f)
+	}
+	sort.Strings(columns)
+	for _, f := range columns {
+		v := row[f]
+		if _, ok := lookupColumn(cfg.ColumnExpressions, message, f); ok || isExpressionField(cfg, message, f) {
+			continue
+		}
+		loggerOf(cfg).WithField("field", f).WithField("value", v).Debug("CDC value used")
+		arg, ref, err := bindValue(cfg, message, f, v, params.next())
+		if err != nil {
+			return nil, nil, nil, err
+		}
 		fields = append(fields, strconv.Quote(f))
-		vals = append(vals, v)
+		args = append(args, arg)
+		refs = append(refs, ref)
+		bound[f] = ref
 	}
-	vals = append(keyvals, vals...)
-	sql := fmt.Sprintf("UPDATE %s SET (%s)=(%s) WHERE (%s)=(%s)",
-		message.QualifiedTablename(),
-		strings.Join(fields, ","),
-		strings.Join(valrefs, ","),
-		strings.Join(keyfields, ","),
-		strings.Join(keyrefs, ","))
-	ct, err := conn.Exec(ctx, sql, vals...)
+	for _, f := range expressionColumns(cfg, message) {
+		expr, _ := lookupColumn(cfg.ColumnExpressions, message, f)
+		if !bindsExpression(cfg, message, expr, row, bound) {
+			loggerOf(cfg).WithField("field", f).Debug("Column expression references missing columns, column is skipped")
+			continue
+		}
+		// fields consumed only by expressions are bound once referenced, so no parameter is left unused
+		for _, c := range referencedColumns(expr) {
+			if _, ok := bound[c]; ok {
+				continue
+			}
+			arg, ref, err := bindValue(cfg, message, c, row[c], params.next())
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			args = append(args, arg)
+			bound[c] = ref
+		}
+		ref, _ := expandExpression(expr, bound)
+		fields = append(fields, strconv.Quote(f))
+		refs = append(refs, ref)
+	}
+	return fields, refs, args, nil
+}
+
+// bindsExpression returns true if all the columns the expression references are bound or consumed only by expressions
+// and present in the row image
+func bindsExpression(cfg Config, message kafka.Message, expr string, row map[string]interface{}, bound map[string]string) bool {
+	for _, c := range referencedColumns(expr) {
+		if _, ok := bound[c]; ok {
+			continue
+		}
+		if _, ok := row[c]; !ok || !isExpressionField(cfg, message, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// reColumnReference matches references to the other columns in the column expressions, e.g. $title or ${Title}
+var reColumnReference = regexp.MustCompile(`\$(\w+)|\$\{([^}]+)\}`)
+
+// expandExpression replaces column references in the expression with the parameters the columns are bound to,
+// returns false if any referenced column is not bound
+func expandExpression(expr string, bound map[string]string) (string, bool) {
+	ok := true
+	expanded := reColumnReference.ReplaceAllStringFunc(expr, func(s string) string {
+		m := reColumnReference.FindStringSubmatch(s)
+		column := m[1] + m[2]
+		ref, found := bound[column]
+		ok = ok && found
+		return ref
+	})
+	return expanded, ok
+}
+
+func updateCDCItem(ctx context.Context, conn DBExecutorContext, cfg Config, message kafka.Message) (int64, error) {
+	l := loggerOf(cfg).WithField("op", "update")
+	l.Debug("Starting UpdateCDCItem()...")
+	sql, vals, err := buildUpdateSQL(cfg, message)
+	if err != nil {
+		return 0, err
+	}
+	ct, err := execReturning(ctx, conn, cfg, message, sql, vals)
+	err = classify(ErrDBExec, err)
 	l.Debug("Exiting UpdateCDCItem()...")
 	atomic.AddUint64(&tx, 1)
+	if versions := rowVersions(cfg, message); len(versions) > 0 && err == nil && ct.RowsAffected() == 0 &&
+		!isStaleMatch(ctx, conn, cfg, message, versions) && !isView(cfg, message) {
+		loggerOf(cfg).Warning("CDC item caused no changes")
+	}
 	return ct.RowsAffected(), err
 }
 
-func deleteCDCItem(ctx context.Context, conn DBExecutorContext, message kafka.Message) (int64, error) {
-	l := Logger.WithField("op", "delete")
-	l.Debug("Starting DeleteCDCItem()...")
-	fnumber := len(message.Keys)
-	refs := make([]string, 0, fnumber)
-	for i := 1; i <= fnumber; i++ {
-		refs = append(refs, "$"+strconv.Itoa(i))
-	}
-	args := make([]interface{}, 0, fnumber)
-	fields := make([]string, 0, fnumber)
-	for f, v := range message.Keys {
-		l.WithField("field", f).WithField("oldvalue", v).Debug("CDC value used")
-		fields = append(fields, strconv.Quote(f))
-		args = append(args, v)
+// buildUpdateSQL returns the statement updating the row matched by the CDC item to its new row image and the arguments
+func buildUpdateSQL(cfg Config, message kafka.Message) (string, []interface{}, error) {
+	if len(message.Values) == 0 {
+		return "", nil, classify(ErrMissingField, errors.New("New row image has no columns to update"))
+	}
+	// match using the message key or the full old row image, see matchedColumns
+	keys := matchedColumns(cfg, message)
+	if len(keys) == 0 {
+		return "", nil, classify(ErrMissingField, errors.New("Neither key nor old row image available to match updated row"))
+	}
+	where, vals, err := bindMatch(cfg, message, keys, make([]interface{}, 0, len(keys)+len(message.Values)))
+	if err != nil {
+		return "", nil, err
+	}
+	fields, valrefs, vals, err := bindRow(cfg, message, message.Values, vals)
+	if err != nil {
+		return "", nil, err
 	}
-	sql := fmt.Sprintf("DELETE FROM %s WHERE (%s)=(%s)",
+	if versions := rowVersions(cfg, message); len(versions) > 0 {
+		var guard string
+		if guard, vals, err = bindVersions(cfg, message, versions, vals); err != nil {
+			return "", nil, err
+		}
+		where += " AND " + guard
+	}
+	sql := fmt.Sprintf("UPDATE %s SET (%s)=(%s) WHERE %s%s",
 		message.QualifiedTablename(),
 		strings.Join(fields, ","),
-		strings.Join(refs, ","))
+		strings.Join(valrefs, ","),
+		where,
+		returningClause(cfg, message))
+	return sql, vals, nil
+}
+
+func deleteCDCItem(ctx context.Context, conn DBExecutorContext, cfg Config, message kafka.Message) (int64, error) {
+	l := loggerOf(cfg).WithField("op", "delete")
+	l.Debug("Starting DeleteCDCItem()...")
+	policy, err := deleteMissingPolicy(cfg, message)
+	if err != nil {
+		return 0, err
+	}
+	sql, args, err := buildDeleteSQL(cfg, message)
+	if err != nil {
+		return 0, err
+	}
 	ct, err := conn.Exec(ctx, sql, args...)
+	err = classify(ErrDBExec, err)
 	l.Debug("Exiting DeleteCDCItem()...")
 	atomic.AddUint64(&tx, 1)
+	versions := rowVersions(cfg, message)
+	guarded := len(versions) > 0
+	if err == nil && ct.RowsAffected() == 0 {
+		switch {
+		case guarded && isStaleMatch(ctx, conn, cfg, message, versions):
+		case policy == DeleteMissingOK:
+			atomic.AddUint64(&missingDeletes, 1)
+		case policy == DeleteMissingStrict:
+			err = classify(ErrRowMissing, fmt.Errorf("Deleted row is missing in %s", message.QualifiedTablename()))
+		case guarded && !isView(cfg, message):
+			loggerOf(cfg).Warning("CDC item caused no changes")
+		}
+	}
 	return ct.RowsAffected(), err
 }
+
+// buildDeleteSQL returns the statement deleting the row matched by the CDC item and the arguments
+func buildDeleteSQL(cfg Config, message kafka.Message) (string, []interface{}, error) {
+	// match using the message key or the full old row image, see matchedColumns
+	keys := matchedColumns(cfg, message)
+	if len(keys) == 0 {
+		return "", nil, classify(ErrMissingField, errors.New("Neither key nor old row image available to match deleted row"))
+	}
+	where, args, err := bindMatch(cfg, message, keys, make([]interface{}, 0, len(keys)))
+	if err != nil {
+		return "", nil, err
+	}
+	if versions := rowVersions(cfg, message); len(versions) > 0 {
+		var guard string
+		if guard, args, err = bindVersions(cfg, message, versions, args); err != nil {
+			return "", nil, err
+		}
+		where += " AND " + guard
+	}
+	sql := fmt.Sprintf("DELETE FROM %s WHERE %s",
+		message.QualifiedTablename(),
+		where)
+	return sql, args, nil
+}
+
+// deleteMissingPolicy returns the policy for deletes of rows missing in the target table of the CDC item,
+// DeleteMissingOK for tables without the policy if deletes are idempotent, empty string otherwise
+func deleteMissingPolicy(cfg Config, m kafka.Message) (string, error) {
+	policy, ok := cfg.DeleteMissing[m.SchemaName+"."+m.TableName]
+	if !ok {
+		policy, ok = cfg.DeleteMissing[m.TableName]
+	}
+	if !ok && cfg.IdempotentDeletes {
+		policy = DeleteMissingOK
+	}
+	switch policy {
+	case "", DeleteMissingOK, DeleteMissingStrict:
+		return policy, nil
+	}
+	return "", fmt.Errorf("Invalid delete missing policy %q, either %q or %q expected", policy, DeleteMissingOK, DeleteMissingStrict)
+}
+
+// bindKey binds the value of the `column` used to match rows as the n-th parameter, returns the argument and
+// the expressions comparing the column to it
+func bindKey(cfg Config, message kafka.Message, column string, v interface{}, n int) (interface{}, string, string, error) {
+	if padding, ok := lookupColumn(cfg.CharPadding, message, column); ok {
+		var err error
+		if v, err = padChar(padding, v); err != nil {
+			return nil, "", "", err
+		}
+	}
+	arg, ref, err := bindValue(cfg, message, column, v, n)
+	if err != nil {
+		return nil, "", "", err
+	}
+	field, ref := matchColumn(cfg, message, column, ref)
+	return arg, field, ref, nil
+}
+
+// matchColumn returns the expressions comparing the `column` to the parameter `ref` in the WHERE clause,
+// case insensitive columns are compared in lowercase. citext columns compare case insensitive themselves, so they are
+// compared directly to keep using their indexes
+func matchColumn(cfg Config, message kafka.Message, column string, ref string) (string, string) {
+	field := strconv.Quote(column)
+	if hasColumn(cfg.CaseInsensitive, message, column) && castFor(cfg, message, column) != "citext" {
+		return "lower(" + field + ")", "lower(" + ref + ")"
+	}
+	return field, ref
+}
+
+// bindMatch appends the values of the `keys` columns matching the row of the CDC item to `args`, returns the condition
+// comparing them to the columns of the target table and the arguments
+func bindMatch(cfg Config, message kafka.Message, keys map[string]interface{}, args []interface{}) (string, []interface{}, error) {
+	refs := make([]string, 0, len(keys))
+	fields := make([]string, 0, len(keys))
+	start := len(args)
+	for f, v := range keys {
+		arg, field, ref, err := bindKey(cfg, message, f, v, len(args)+1)
+		if err != nil {
+			return "", nil, err
+		}
+		fields = append(fields, field)
+		args = append(args, arg)
+		refs = append(refs, ref)
+	}
+	return matchRow(fields, refs, args[start:]), args, nil
+}
+
+// matchRow returns the condition matching `fields` to the parameter `refs` bound to `args`. NULL values are matched
+// using IS NOT DISTINCT FROM, which isn't used otherwise as it prevents index scans
+func matchRow(fields []string, refs []string, args []interface{}) string {
+	op := "="
+	for _, arg := range args {
+		if arg == nil {
+			op = " IS NOT DISTINCT FROM "
+			break
+		}
+	}
+	return "(" + strings.Join(fields, ",") + ")" + op + "(" + strings.Join(refs, ",") + ")"
+}
@@ -8,18 +8,20 @@ import (
 
 	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
 	"github.com/jackc/pgconn"
+	kafkago "github.com/segmentio/kafka-go"
 	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/assert"
 )
 
 type MockDbExec struct {
 	DBExecutorContext
-	ExecHandler func() (pgconn.CommandTag, error)
+	ExecHandler func(sql string, arguments []interface{}) (pgconn.CommandTag, error)
 }
 
 func (m MockDbExec) Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error) {
 	if m.ExecHandler != nil {
-		return m.ExecHandler()
+		return m.ExecHandler(sql, arguments)
 	}
 	return nil, nil
 }
@@ -42,47 +44,189 @@ func TestApply(t *testing.T) {
 	Connect = func(ctx context.Context, connString string) (DBExecutorContext, error) {
 		return nil, errors.New("bad connection")
 	}
-	Apply(ctx, "foo", time.Second, msgChan)
+	Apply(ctx, "foo", Config{IdleTimeout: time.Second}, msgChan)
 
 	Connect = func(ctx context.Context, connString string) (DBExecutorContext, error) {
 		return &MockDbExec{
-			ExecHandler: func() (pgconn.CommandTag, error) {
+			ExecHandler: func(string, []interface{}) (pgconn.CommandTag, error) {
 				return pgconn.CommandTag("no affected rows"), nil
 			},
 		}, nil
 	}
-	Apply(ctx, "foo", time.Second, msgChan)
+	Apply(ctx, "foo", Config{IdleTimeout: time.Second}, msgChan)
+}
+
+func TestApplyUnsupportedOp(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestApplyUnsupportedOp")
+	Connect = func(ctx context.Context, connString string) (DBExecutorContext, error) {
+		return &MockDbExec{}, nil
+	}
+	var msgChan chan kafka.Message = make(chan kafka.Message, 1)
+	var dlqChan chan kafka.DeadLetter = make(chan kafka.DeadLetter, 1)
+	msgChan <- kafka.Message{Op: "x"}
+	ops := unsupportedOps
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	Apply(ctx, "foo", Config{IdleTimeout: 100 * time.Millisecond, DeadLetters: dlqChan}, msgChan)
+
+	assert.Equal(t, ops+1, unsupportedOps)
+	if assert.Len(t, dlqChan, 1) {
+		dl := <-dlqChan
+		assert.Equal(t, "x", dl.Op)
+		assert.Contains(t, dl.Reason, `"x"`)
+	}
+
+	// no dead-letter channel configured, message is dropped
+	msgChan <- kafka.Message{Op: "x"}
+	Apply(ctx, "foo", Config{IdleTimeout: 100 * time.Millisecond}, msgChan)
+	assert.Equal(t, ops+2, unsupportedOps)
+}
+
+func TestApplyClosedChannel(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestApplyClosedChannel")
+	var applied int
+	Connect = func(ctx context.Context, connString string) (DBExecutorContext, error) {
+		return &MockDbExec{ExecHandler: func(sql string, arguments []interface{}) (pgconn.CommandTag, error) {
+			applied++
+			return pgconn.CommandTag("INSERT 0 1"), nil
+		}}, nil
+	}
+	var msgChan chan kafka.Message = make(chan kafka.Message, 4)
+	for _, topic := range []string{"foo", "bar"} {
+		for offset := int64(1); offset <= 2; offset++ {
+			msg := kafka.Message{Op: "c", TableName: "t", Values: map[string]interface{}{"id": offset}}
+			msg.Topic, msg.Offset = topic, offset
+			msgChan <- msg
+		}
+	}
+	close(msgChan)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	Apply(ctx, "foo", Config{IdleTimeout: 5 * time.Second, BatchSize: 10}, msgChan)
+	assert.NoError(t, ctx.Err(), "stopped once the messages of all topics are consumed, not by timeout")
+	assert.Equal(t, 4, applied, "the end offset of one topic doesn't stop applying the others")
+}
+
+func TestApplyCancelFlush(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestApplyCancelFlush")
+	var applied []int64
+	Connect = func(ctx context.Context, connString string) (DBExecutorContext, error) {
+		return &MockDbExec{
+			ExecHandler: func(sql string, arguments []interface{}) (pgconn.CommandTag, error) {
+				applied = append(applied, arguments[0].(int64))
+				return pgconn.CommandTag("INSERT 0 1"), nil
+			},
+		}, nil
+	}
+	msgChan := make(chan kafka.Message, 4)
+	queue := func() {
+		for id := int64(1); id <= 2; id++ {
+			msgChan <- kafka.Message{Op: "c", TableName: "items", Values: map[string]interface{}{"id": id}}
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	queue()
+	Apply(ctx, "foo", Config{IdleTimeout: time.Second, ShutdownGrace: time.Second}, msgChan)
+	assert.Equal(t, []int64{1, 2}, applied, "queued messages applied before exit")
+	assert.Len(t, msgChan, 0)
+
+	applied = nil
+	queue()
+	Apply(ctx, "foo", Config{IdleTimeout: time.Second}, msgChan)
+	assert.Empty(t, applied, "no grace period, queued messages are not applied")
+}
+
+func TestApplyView(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	Logger = logger.WithField("method", "TestApplyView")
+	conn := MockDbExec{
+		ExecHandler: func(string, []interface{}) (pgconn.CommandTag, error) {
+			return pgconn.CommandTag("INSERT 0 0"), nil
+		},
+	}
+	msg := kafka.Message{Op: "c", SchemaName: "public", TableName: "orders_v", Values: map[string]interface{}{"id": 1}}
+	applyMessage(context.Background(), conn, Config{}, msg)
+	if assert.NotNil(t, hook.LastEntry()) {
+		assert.Equal(t, "CDC item caused no changes", hook.LastEntry().Message)
+	}
+
+	hook.Reset()
+	applyMessage(context.Background(), conn, Config{Views: map[string]bool{"public.orders_v": true}}, msg)
+	applyMessage(context.Background(), conn, Config{Views: map[string]bool{"orders_v": true}}, msg)
+	assert.Empty(t, hook.AllEntries(), "no warning for views")
 }
 
 func TestApplyCDCItem(t *testing.T) {
 	Logger = logrus.New().WithField("method", "TestApplyCDCItem")
 
 	msg := kafka.Message{}
-	_, err := applyCDCItem(context.Background(), MockDbExec{}, msg)
+	_, err := applyCDCItem(context.Background(), MockDbExec{}, Config{}, msg)
 	assert.Error(t, err, "Invalid JSON")
 
 	msg.Op = "foo"
-	_, err = applyCDCItem(context.Background(), MockDbExec{}, msg)
-	assert.Error(t, err, "Unsupported operation")
+	_, err = applyCDCItem(context.Background(), MockDbExec{}, Config{}, msg)
+	assert.True(t, errors.Is(err, ErrUnsupportedOp), "Unsupported operation")
 
 	msg.Op = "c"
-	_, err = applyCDCItem(context.Background(), MockDbExec{}, msg)
+	_, err = applyCDCItem(context.Background(), MockDbExec{}, Config{}, msg)
 	assert.NoError(t, err)
 
 	msg.Op = "u"
-	_, err = applyCDCItem(context.Background(), MockDbExec{}, msg)
-	assert.NoError(t, err)
+	_, err = applyCDCItem(context.Background(), MockDbExec{}, Config{}, msg)
+	assert.True(t, errors.Is(err, ErrMissingField), "No columns to update")
 
 	msg.Op = "d"
-	_, err = applyCDCItem(context.Background(), MockDbExec{}, msg)
+	_, err = applyCDCItem(context.Background(), MockDbExec{}, Config{}, msg)
+	assert.Error(t, err, "No key to match deleted row")
+
+	msg.Keys = map[string]interface{}{"foo": "bar"}
+	_, err = applyCDCItem(context.Background(), MockDbExec{}, Config{}, msg)
 	assert.NoError(t, err)
 
 	msg.Op = "r"
-	res, err := applyCDCItem(context.Background(), MockDbExec{}, msg)
+	res, err := applyCDCItem(context.Background(), MockDbExec{}, Config{}, msg)
 	assert.NoError(t, err)
 	assert.Equal(t, int64(0), res, "ignore snapshot reading")
 }
 
+func TestApplyCDCItemTransaction(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	logger.SetLevel(logrus.DebugLevel)
+	Logger = logger.WithField("method", "TestApplyCDCItemTransaction")
+	conn := MockDbExec{
+		ExecHandler: func(string, []interface{}) (pgconn.CommandTag, error) {
+			return pgconn.CommandTag("INSERT 0 1"), nil
+		},
+	}
+	msg := kafka.Message{Op: "c", TableName: "orders", Values: map[string]interface{}{"id": 1},
+		Transaction: &kafka.Transaction{ID: "571:53195832", TotalOrder: 3, DataCollectionOrder: 2}}
+	_, err := applyCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	var entry *logrus.Entry
+	for _, e := range hook.AllEntries() {
+		if e.Message == "Source transaction of CDC item" {
+			entry = e
+		}
+	}
+	if assert.NotNil(t, entry) {
+		assert.Equal(t, logrus.DebugLevel, entry.Level)
+		assert.Equal(t, "571:53195832", entry.Data["transaction"])
+		assert.Equal(t, int64(3), entry.Data["total_order"])
+		assert.Equal(t, int64(2), entry.Data["data_collection_order"])
+	}
+
+	hook.Reset()
+	msg.Transaction = nil
+	_, err = applyCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	for _, e := range hook.AllEntries() {
+		assert.NotEqual(t, "Source transaction of CDC item", e.Message)
+	}
+}
+
 func TestInsertCDCItem(t *testing.T) {
 	Logger = logrus.New().WithField("method", "TestInsertCDCItem")
 	msg := kafka.Message{
@@ -91,8 +235,382 @@ func TestInsertCDCItem(t *testing.T) {
 	}
 	msg.Keys["foo"] = "bar"
 	msg.Values["foo"] = "baz"
-	_, err := insertCDCItem(context.Background(), MockDbExec{}, msg)
+	_, err := insertCDCItem(context.Background(), MockDbExec{}, Config{}, msg)
+	assert.NoError(t, err)
+}
+
+func TestGuardedInsertCDCItem(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestGuardedInsertCDCItem")
+	var sql string
+	existing := map[interface{}]bool{}
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql = s
+			// the last argument is the key the existence is checked for
+			if key := a[len(a)-1]; !existing[key] {
+				existing[key] = true
+				return pgconn.CommandTag("INSERT 0 1"), nil
+			}
+			return pgconn.CommandTag("INSERT 0 0"), nil
+		},
+	}
+	msg := kafka.Message{
+		SchemaName: "public",
+		TableName:  "customers",
+		Keys:       map[string]interface{}{"id": int64(1)},
+		Values:     map[string]interface{}{"email": "ed@walker.com"},
+	}
+	cfg := Config{InsertMode: InsertModeGuarded}
+	rows, err := insertCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, rows)
+	assert.Equal(t, `INSERT INTO "public"."customers"("email") SELECT "email" FROM "public"."customers" WHERE false UNION ALL SELECT $1 WHERE NOT EXISTS (SELECT 1 FROM "public"."customers" WHERE ("id")=($2))`, sql)
+	rows, err = insertCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, rows, "replayed insert affects no rows")
+
+	msg.Keys = map[string]interface{}{}
+	_, err = insertCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "public"."customers"("email") VALUES ($1)`, sql, "no key to guard insert")
+}
+
+func TestCaseInsensitiveMatch(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestCaseInsensitiveMatch")
+	var sql string
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql = s
+			return pgconn.CommandTag("UPDATE 1"), nil
+		},
+	}
+	msg := kafka.Message{
+		SchemaName: "public",
+		TableName:  "customers",
+		Keys:       map[string]interface{}{"email": "Ed@Walker.com"},
+		Values:     map[string]interface{}{"name": "Ed"},
+	}
+	cfg := Config{CaseInsensitive: map[string]bool{"customers.email": true}, InsertMode: InsertModeGuarded}
+	_, err := updateCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `UPDATE "public"."customers" SET ("name")=($2) WHERE (lower("email"))=(lower($1))`, sql)
+	_, err = deleteCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `DELETE FROM "public"."customers" WHERE (lower("email"))=(lower($1))`, sql)
+	_, err = insertCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "public"."customers"("name") SELECT "name" FROM "public"."customers" WHERE false UNION ALL SELECT $1 WHERE NOT EXISTS (SELECT 1 FROM "public"."customers" WHERE (lower("email"))=(lower($2)))`, sql)
+
+	cfg.CaseInsensitive = map[string]bool{"other.customers.email": true}
+	_, err = deleteCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `DELETE FROM "public"."customers" WHERE ("email")=($1)`, sql)
+
+	cfg.CaseInsensitive = map[string]bool{"customers.email": true}
+	cfg.ColumnTypes = map[string]string{"customers.email": "citext"}
+	_, err = deleteCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `DELETE FROM "public"."customers" WHERE ("email")=($1::citext)`, sql, "citext compares case insensitive itself")
+}
+
+func TestCharPaddingMatch(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestCharPaddingMatch")
+	var args []interface{}
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			args = a
+			return pgconn.CommandTag("DELETE 1"), nil
+		},
+	}
+	// bpchar(6) key is padded in the target but trimmed in the event
+	msg := kafka.Message{
+		TableName: "orders",
+		Keys:      map[string]interface{}{"code": "AB1"},
+		Values:    map[string]interface{}{"qty": int64(2)},
+	}
+	cfg := Config{CharPadding: map[string]string{"orders.code": "6"}}
+	_, err := deleteCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"AB1   "}, args)
+	_, err = updateCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"AB1   ", int64(2)}, args)
+
+	msg.Keys = map[string]interface{}{"code": "AB1   "}
+	cfg.CharPadding["orders.code"] = CharPaddingTrim
+	_, err = deleteCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"AB1"}, args)
+
+	msg.Keys = map[string]interface{}{"code": "ÄÖÜ"}
+	cfg.CharPadding["orders.code"] = "4"
+	_, err = deleteCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"ÄÖÜ "}, args, "width is counted in characters")
+
+	cfg.CharPadding["orders.code"] = "wide"
+	_, err = deleteCDCItem(context.Background(), conn, cfg, msg)
+	assert.Error(t, err)
+}
+
+func TestNullToDefault(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestNullToDefault")
+	var (
+		sql  string
+		args []interface{}
+	)
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql, args = s, a
+			return pgconn.CommandTag("INSERT 0 1"), nil
+		},
+	}
+	msg := kafka.Message{
+		SchemaName: "public",
+		TableName:  "orders",
+		Values:     map[string]interface{}{"id": int64(1), "created_at": nil},
+	}
+	cfg := Config{NullToDefault: map[string]bool{"public.orders.created_at": true}}
+	_, err := insertCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "public"."orders"("id") VALUES ($1)`, sql)
+	assert.Equal(t, []interface{}{int64(1)}, args)
+
+	msg.Values["created_at"] = "2020-01-01"
+	_, err = insertCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Len(t, args, 2, "non-NULL values are inserted")
+
+	msg.Values["created_at"] = nil
+	_, err = insertCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Len(t, args, 2, "NULL values are inserted unless configured")
+}
+
+func TestApplyLogicalMessage(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	Logger = logger.WithField("method", "TestApplyLogicalMessage")
+	var execs int
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			execs++
+			return pgconn.CommandTag("INSERT 0 1"), nil
+		},
+	}
+	msg := kafka.Message{Op: "m", LogicalMessage: &kafka.LogicalMessage{Prefix: "audit", Content: []byte("hello")}}
+	var handled []kafka.LogicalMessage
+	cfg := Config{MessageHandler: func(m kafka.LogicalMessage) { handled = append(handled, m) }}
+	_ = applyMessage(context.Background(), conn, cfg, msg)
+	assert.Equal(t, []kafka.LogicalMessage{*msg.LogicalMessage}, handled)
+	assert.Equal(t, 0, execs)
+	assert.Empty(t, hook.AllEntries(), "neither error nor no changes warning")
+
+	// skipped without handler
+	_ = applyMessage(context.Background(), conn, Config{}, msg)
+	assert.Len(t, handled, 1)
+	assert.Empty(t, hook.AllEntries())
+}
+
+func TestIgnoreInsertCDCItem(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	Logger = logger.WithField("method", "TestIgnoreInsertCDCItem")
+	var (
+		sql          string
+		rowsAffected = "INSERT 0 1"
+	)
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql = s
+			return pgconn.CommandTag(rowsAffected), nil
+		},
+	}
+	msg := kafka.Message{
+		Op:        "c",
+		TableName: "events",
+		Keys:      map[string]interface{}{"source": "a", "id": int64(1)},
+		Values:    map[string]interface{}{"id": int64(1)},
+	}
+	cfg := Config{InsertMode: InsertModeIgnore}
+	_ = applyMessage(context.Background(), conn, cfg, msg)
+	assert.Equal(t, `INSERT INTO "events"("id") VALUES ($1) ON CONFLICT ("id","source") DO NOTHING`, sql)
+
+	// duplicate is a silent no-op
+	rowsAffected = "INSERT 0 0"
+	_ = applyMessage(context.Background(), conn, cfg, msg)
+	assert.Empty(t, hook.AllEntries())
+
+	msg.Keys = nil
+	_ = applyMessage(context.Background(), conn, cfg, msg)
+	assert.Equal(t, `INSERT INTO "events"("id") VALUES ($1) ON CONFLICT DO NOTHING`, sql)
+}
+
+func TestInsertConflictIgnore(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	Logger = logger.WithField("method", "TestInsertConflictIgnore")
+	var (
+		sql    string
+		result = "INSERT 0 0"
+		err    error
+	)
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql = s
+			return pgconn.CommandTag(result), err
+		},
+	}
+	msg := kafka.Message{
+		Op:         "c",
+		SchemaName: "public",
+		TableName:  "events",
+		Keys:       map[string]interface{}{"id": int64(1)},
+		Values:     map[string]interface{}{"id": int64(1)},
+	}
+	cfg := Config{InsertMode: InsertModeUpsert, InsertConflicts: map[string]string{"public.events": InsertConflictIgnore}}
+	duplicates := Stats().SkippedDuplicates
+	_ = applyMessage(context.Background(), conn, cfg, msg)
+	assert.Equal(t, `INSERT INTO "public"."events"("id") VALUES ($1) ON CONFLICT ("id") DO NOTHING`, sql,
+		"table policy takes precedence over the insert mode")
+	assert.Empty(t, hook.AllEntries(), "duplicate is a silent no-op")
+	assert.Equal(t, duplicates+1, Stats().SkippedDuplicates)
+
+	result = "INSERT 0 1"
+	_ = applyMessage(context.Background(), conn, cfg, msg)
+	assert.Equal(t, duplicates+1, Stats().SkippedDuplicates, "inserted row is not a duplicate")
+
+	// violations of other constraints are still errors
+	result, err = "", &pgconn.PgError{Code: "23505", Message: `duplicate key value violates unique constraint "events_code_key"`}
+	_ = applyMessage(context.Background(), conn, cfg, msg)
+	assert.Equal(t, logrus.ErrorLevel, hook.LastEntry().Level)
+	assert.Equal(t, duplicates+1, Stats().SkippedDuplicates)
+
+	// other tables follow the insert mode
+	err = nil
+	msg.TableName = "orders"
+	msg.Values = map[string]interface{}{"id": int64(1), "code": "a"}
+	_ = applyMessage(context.Background(), conn, cfg, msg)
+	assert.Contains(t, sql, "DO UPDATE")
+
+	cfg.InsertConflicts = map[string]string{"orders": "merge"}
+	_, err = insertCDCItem(context.Background(), conn, cfg, msg)
+	assert.EqualError(t, err, `Invalid insert conflict policy "merge", "ignore" expected`)
+}
+
+func TestDeleteMissingPolicy(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	Logger = logger.WithField("method", "TestDeleteMissingPolicy")
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			return pgconn.CommandTag("DELETE 0"), nil
+		},
+	}
+	msg := kafka.Message{Op: "d", SchemaName: "public", TableName: "events", Keys: map[string]interface{}{"id": 1}}
+	cfg := Config{DeleteMissing: map[string]string{"public.events": DeleteMissingOK, "orders": DeleteMissingStrict}}
+	missing := Stats().MissingDeletes
+	_ = applyMessage(context.Background(), conn, cfg, msg)
+	assert.Empty(t, hook.AllEntries(), "expected missing row")
+	assert.Equal(t, missing+1, Stats().MissingDeletes)
+
+	// updates of missing rows are still warned about
+	msg.Op, msg.Values = "u", map[string]interface{}{"id": 1, "v": 2}
+	_ = applyMessage(context.Background(), conn, cfg, msg)
+	assert.Equal(t, logrus.WarnLevel, hook.LastEntry().Level)
+	assert.Equal(t, missing+1, Stats().MissingDeletes)
+
+	hook.Reset()
+	msg.Op, msg.TableName = "d", "orders"
+	_, err := applyCDCItem(context.Background(), conn, cfg, msg)
+	assert.True(t, errors.Is(err, ErrRowMissing))
+	assert.EqualError(t, err, `Deleted row is missing in "public"."orders"`)
+	_ = applyMessage(context.Background(), conn, cfg, msg)
+	assert.Equal(t, logrus.ErrorLevel, hook.LastEntry().Level)
+	assert.Equal(t, missing+1, Stats().MissingDeletes)
+
+	// other tables warn
+	hook.Reset()
+	msg.TableName = "customers"
+	_ = applyMessage(context.Background(), conn, cfg, msg)
+	assert.Equal(t, logrus.WarnLevel, hook.LastEntry().Level)
+
+	cfg.DeleteMissing["customers"] = "ignore"
+	_, err = applyCDCItem(context.Background(), conn, cfg, msg)
+	assert.EqualError(t, err, `Invalid delete missing policy "ignore", either "ok" or "strict" expected`)
+}
+
+func TestIdempotentDeletes(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	Logger = logger.WithField("method", "TestIdempotentDeletes")
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			return pgconn.CommandTag("DELETE 0"), nil
+		},
+	}
+	msg := kafka.Message{Op: "d", SchemaName: "public", TableName: "events", Keys: map[string]interface{}{"id": 1}}
+	cfg := Config{IdempotentDeletes: true, DeleteMissing: map[string]string{"orders": DeleteMissingStrict}}
+	missing := Stats().MissingDeletes
+	rowsAffected, err := applyCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, rowsAffected)
+	_ = applyMessage(context.Background(), conn, cfg, msg)
+	assert.Empty(t, hook.AllEntries(), "neither error nor no changes warning")
+	assert.Equal(t, missing+2, Stats().MissingDeletes)
+
+	// the strict policy of the table applies still
+	msg.TableName = "orders"
+	_, err = applyCDCItem(context.Background(), conn, cfg, msg)
+	assert.True(t, errors.Is(err, ErrRowMissing))
+}
+
+func TestUpsertInsertCDCItem(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestUpsertInsertCDCItem")
+	var (
+		sql  string
+		args []interface{}
+	)
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql, args = s, a
+			return pgconn.CommandTag("INSERT 0 1"), nil
+		},
+	}
+	msg := kafka.Message{
+		Op:         "c",
+		SchemaName: "public",
+		TableName:  "orders",
+		Keys:       map[string]interface{}{"id": int64(1)},
+		Values:     map[string]interface{}{"id": int64(1), "qty": int64(2)},
+	}
+	_, err := applyCDCItem(context.Background(), conn, Config{InsertMode: InsertModeUpsert}, msg)
 	assert.NoError(t, err)
+	assert.Regexp(t, `^INSERT INTO "public"."orders"\("(id|qty)","(id|qty)"\) VALUES \(\$1,\$2\) `+
+		`ON CONFLICT \("id"\) DO UPDATE SET "qty"=EXCLUDED."qty"$`, sql)
+
+	// per table, with the configured conflict columns, guarded mode doesn't apply
+	cfg := Config{
+		InsertMode:   InsertModeGuarded,
+		UpsertTables: map[string]bool{"public.orders": true},
+		ConflictKeys: map[string]bool{"orders.order_no": true},
+	}
+	msg.Values = map[string]interface{}{"order_no": "A-1"}
+	_, err = applyCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "public"."orders"("order_no") VALUES ($1) ON CONFLICT ("order_no") DO NOTHING`, sql)
+
+	// updates are applied as upserts if configured, the new row image includes the conflict columns
+	msg.Op = "u"
+	msg.Values = map[string]interface{}{"id": int64(1), "qty": int64(3)}
+	_, err = applyCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Contains(t, sql, "UPDATE \"public\".\"orders\" SET")
+	cfg.UpsertUpdates = true
+	_, err = applyCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Regexp(t, `^INSERT INTO "public"."orders"\("(id|qty)","(id|qty)"\) VALUES \(\$1,\$2\) `+
+		`ON CONFLICT \("id"\) DO UPDATE SET "qty"=EXCLUDED."qty"$`, sql)
+	assert.ElementsMatch(t, []interface{}{int64(1), int64(3)}, args)
+
+	msg.Op, msg.Keys = "c", nil
+	_, err = applyCDCItem(context.Background(), conn, cfg, msg)
+	assert.True(t, errors.Is(err, ErrMissingField))
 }
 
 func TestUpdateCDCItem(t *testing.T) {
@@ -103,7 +621,7 @@ func TestUpdateCDCItem(t *testing.T) {
 	}
 	msg.Keys["foo"] = "bar"
 	msg.Values["foo"] = "baz"
-	_, err := updateCDCItem(context.Background(), MockDbExec{}, msg)
+	_, err := updateCDCItem(context.Background(), MockDbExec{}, Config{}, msg)
 	assert.NoError(t, err)
 }
 
@@ -115,6 +633,179 @@ func TestDeleteCDCItem(t *testing.T) {
 	}
 	msg.Keys["foo"] = "bar"
 	msg.Values["foo"] = "baz"
-	_, err := deleteCDCItem(context.Background(), MockDbExec{}, msg)
+	_, err := deleteCDCItem(context.Background(), MockDbExec{}, Config{}, msg)
+	assert.NoError(t, err)
+}
+
+func TestBuildInsertSQL(t *testing.T) {
+	msg := kafka.Message{
+		Op:         "c",
+		SchemaName: "public",
+		TableName:  "orders",
+		Keys:       map[string]interface{}{"id": int64(1)},
+		Values:     map[string]interface{}{"id": int64(1), "qty": int64(2), "note": nil},
+	}
+	sql, args, err := buildInsertSQL(Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "public"."orders"("id","note","qty") VALUES ($1,$2,$3)`, sql)
+	assert.Equal(t, []interface{}{int64(1), nil, int64(2)}, args)
+
+	sql, args, err = buildInsertSQL(Config{InsertMode: InsertModeGuarded}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "public"."orders"("id","note","qty") SELECT "id","note","qty" FROM "public"."orders" WHERE false `+
+		`UNION ALL SELECT $1,$2,$3 WHERE NOT EXISTS (SELECT 1 FROM "public"."orders" WHERE ("id")=($4))`, sql)
+	assert.Equal(t, []interface{}{int64(1), nil, int64(2), int64(1)}, args)
+
+	sql, _, err = buildInsertSQL(Config{InsertMode: InsertModeUpsert}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "public"."orders"("id","note","qty") VALUES ($1,$2,$3) `+
+		`ON CONFLICT ("id") DO UPDATE SET "note"=EXCLUDED."note","qty"=EXCLUDED."qty"`, sql)
+
+	msg.Values = map[string]interface{}{}
+	sql, args, err = buildInsertSQL(Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "public"."orders" DEFAULT VALUES`, sql)
+	assert.Empty(t, args)
+
+	msg.Keys = nil
+	_, _, err = buildInsertSQL(Config{InsertMode: InsertModeUpsert}, msg)
+	assert.True(t, errors.Is(err, ErrMissingField))
+}
+
+func TestBuildUpdateSQL(t *testing.T) {
+	msg := kafka.Message{
+		Op:        "u",
+		TableName: "orders",
+		Keys:      map[string]interface{}{"id": int64(1)},
+		Values:    map[string]interface{}{"id": int64(1), "qty": int64(3)},
+	}
+	sql, args, err := buildUpdateSQL(Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `UPDATE "orders" SET ("id","qty")=($2,$3) WHERE ("id")=($1)`, sql)
+	assert.Equal(t, []interface{}{int64(1), int64(1), int64(3)}, args)
+
+	// rows without the key are matched by the old row image, NULLs included
+	msg.Keys = nil
+	msg.Before = map[string]interface{}{"qty": nil}
+	sql, args, err = buildUpdateSQL(Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `UPDATE "orders" SET ("id","qty")=($2,$3) WHERE ("qty") IS NOT DISTINCT FROM ($1)`, sql)
+	assert.Equal(t, []interface{}{nil, int64(1), int64(3)}, args)
+
+	msg.Values = map[string]interface{}{}
+	_, _, err = buildUpdateSQL(Config{}, msg)
+	assert.True(t, errors.Is(err, ErrMissingField))
+}
+
+func TestBuildDeleteSQL(t *testing.T) {
+	msg := kafka.Message{
+		Op:        "d",
+		TableName: "orders",
+		Keys:      map[string]interface{}{"id": int64(1)},
+	}
+	sql, args, err := buildDeleteSQL(Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `DELETE FROM "orders" WHERE ("id")=($1)`, sql)
+	assert.Equal(t, []interface{}{int64(1)}, args)
+
+	msg.Keys = nil
+	_, _, err = buildDeleteSQL(Config{}, msg)
+	assert.True(t, errors.Is(err, ErrMissingField))
+}
+
+func TestEmptyRowImages(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestEmptyRowImages")
+	var statements []string
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			statements = append(statements, s)
+			return pgconn.CommandTag("INSERT 0 1"), nil
+		},
+	}
+	ctx := context.Background()
+	msg := kafka.Message{TableName: "t", Keys: map[string]interface{}{}, Values: map[string]interface{}{}}
+	_, err := insertCDCItem(ctx, conn, Config{}, msg)
+	assert.NoError(t, err)
+	_, err = insertCDCItem(ctx, conn, Config{InsertMode: InsertModeGuarded}, msg)
+	assert.NoError(t, err)
+	msg.Keys = map[string]interface{}{"id": 1}
+	_, err = insertCDCItem(ctx, conn, Config{InsertMode: InsertModeUpsert}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		`INSERT INTO "t" DEFAULT VALUES`,
+		`INSERT INTO "t" DEFAULT VALUES`,
+		`INSERT INTO "t" DEFAULT VALUES ON CONFLICT ("id") DO NOTHING`,
+	}, statements, "empty new row image")
+
+	statements = nil
+	_, err = updateCDCItem(ctx, conn, Config{}, msg)
+	assert.True(t, errors.Is(err, ErrMissingField), "empty new row image")
+	msg.Keys = map[string]interface{}{}
+	msg.Values = map[string]interface{}{"v": 1}
+	_, err = updateCDCItem(ctx, conn, Config{}, msg)
+	assert.True(t, errors.Is(err, ErrMissingField), "empty key and old row image")
+	msg.Before = map[string]interface{}{}
+	_, err = deleteCDCItem(ctx, conn, Config{}, msg)
+	assert.True(t, errors.Is(err, ErrMissingField), "empty key and old row image")
+	assert.Empty(t, statements)
+
+	msg.Before = map[string]interface{}{"id": 1}
+	_, err = updateCDCItem(ctx, conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`UPDATE "t" SET ("v")=($2) WHERE ("id")=($1)`}, statements, "old row image matches the row")
+}
+
+func TestDeleteCDCItemWithoutBefore(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestDeleteCDCItemWithoutBefore")
+	var (
+		sql  string
+		args []interface{}
+	)
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql, args = s, a
+			return pgconn.CommandTag("DELETE 1"), nil
+		},
+	}
+	msg, err := kafka.NewMessage(kafkago.Message{
+		Key:   []byte(`{"schema":{"type":"struct","fields":[{"type":"int32","optional":false,"field":"id"}],"optional":false,"name":"dbserver1.inventory.customers.Key"},"payload":{"id":1003}}`),
+		Value: []byte(`{"schema":null,"payload":{"before":null,"after":null,"source":{"schema":"inventory","table":"customers"},"op":"d"}}`),
+	})
+	assert.NoError(t, err)
+	assert.Nil(t, msg.Before)
+	rows, err := applyCDCItem(context.Background(), conn, Config{}, *msg)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+	assert.Equal(t, `DELETE FROM "inventory"."customers" WHERE ("id")=($1)`, sql)
+	assert.Equal(t, []interface{}{int64(1003)}, args)
+
+	// table without key uses old row image
+	msg.Keys = map[string]interface{}{}
+	msg.Before = map[string]interface{}{"email": "ed@walker.com"}
+	_, err = applyCDCItem(context.Background(), conn, Config{}, *msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `DELETE FROM "inventory"."customers" WHERE ("email")=($1)`, sql)
+}
+
+func TestUpdateCDCItemNulls(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestUpdateCDCItemNulls")
+	var (
+		sql  string
+		args []interface{}
+	)
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql, args = s, a
+			return pgconn.CommandTag("UPDATE 1"), nil
+		},
+	}
+	msg, err := kafka.NewMessage(kafkago.Message{
+		Key:   []byte(`{"schema":null,"payload":{"id":1}}`),
+		Value: []byte(`{"schema":null,"payload":{"email":null,"notes":"__debezium_unavailable_value","__table":"customers","__op":"u"}}`),
+	})
+	assert.NoError(t, err)
+	_, err = updateCDCItem(context.Background(), conn, Config{}, *msg)
 	assert.NoError(t, err)
+	assert.Equal(t, `UPDATE "customers" SET ("email")=($2) WHERE ("id")=($1)`, sql, "only explicit null is set")
+	assert.Equal(t, []interface{}{int64(1), nil}, args)
 }
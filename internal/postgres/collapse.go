@@ -0,0 +1,100 @@
+package postgres
+
+import (
+	"sync/atomic"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+)
+
+// number of CDC items of batches collapsed into later changes of the same rows during session
+var collapsedChanges uint64
+
+// collapsesRow returns true if changes of the row of the CDC item may be collapsed with other changes of the same row
+// within the batch, i.e. the item has the key and its table doesn't need every change applied
+func collapsesRow(cfg Config, m kafka.Message) bool {
+	if (m.Op != "c" && m.Op != "u" && m.Op != "d") || len(m.Keys) == 0 || len(m.Records) > 0 {
+		return false
+	}
+	return !cfg.AppendMode && cfg.Ledger == "" && !stagesTable(cfg, m) && !isHistoryTable(cfg, m) && !archivesDeletes(cfg, m)
+}
+
+// collapseBatch returns the CDC items of the batch with changes of each row collapsed into the last one if
+// `cfg.CollapseBatches` is set: insert followed by updates becomes the insert of the final row image, updates followed
+// by the delete become the delete and the insert followed by the delete is dropped altogether. Collapsed items take
+// the place of the last change of the row, so items left are applied in the order received. Schema changes aren't
+// collapsed across
+func collapseBatch(cfg Config, batch []kafka.Message) []kafka.Message {
+	if !cfg.CollapseBatches || len(batch) < 2 {
+		return batch
+	}
+	items := make([]kafka.Message, 0, len(batch))
+	dropped := make([]bool, 0, len(batch))
+	last := make(map[string]int)
+	for _, m := range batch {
+		if m.SchemaChange != nil {
+			last = make(map[string]int)
+		}
+		keyed, err := overrideKeys(cfg, m)
+		if err != nil || !collapsesRow(cfg, keyed) {
+			items, dropped = append(items, m), append(dropped, false)
+			continue
+		}
+		key := m.SchemaName + "." + m.TableName + "\x00" + rowKey(keyed.Keys)
+		i, seen := last[key]
+		if seen {
+			if collapsed, ok := collapseChanges(items[i], m); ok {
+				dropped[i] = true
+				atomic.AddUint64(&collapsedChanges, 1)
+				if collapsed == nil {
+					// inserted and deleted within the batch
+					atomic.AddUint64(&collapsedChanges, 1)
+					delete(last, key)
+					continue
+				}
+				m = *collapsed
+			}
+		}
+		last[key] = len(items)
+		items, dropped = append(items, m), append(dropped, false)
+	}
+	collapsed := items[:0]
+	for i, m := range items {
+		if !dropped[i] {
+			collapsed = append(collapsed, m)
+		}
+	}
+	return collapsed
+}
+
+// collapseChanges returns the change of the row having the effect of the `prev` change followed by `next` one, nil
+// if the row is left as it was. Returns false if the changes can't be collapsed, e.g. the delete followed by
+// the insert
+func collapseChanges(prev, next kafka.Message) (*kafka.Message, bool) {
+	switch prev.Op + next.Op {
+	case "cu", "uu":
+		// columns missing in the later image, e.g. unchanged TOASTed values, keep their earlier values
+		values := make(map[string]interface{}, len(prev.Values)+len(next.Values))
+		fields := make(map[string]kafka.Field, len(prev.Fields)+len(next.Fields))
+		for f, v := range prev.Values {
+			values[f] = v
+		}
+		for f, v := range next.Values {
+			values[f] = v
+		}
+		for f, field := range prev.Fields {
+			fields[f] = field
+		}
+		for f, field := range next.Fields {
+			fields[f] = field
+		}
+		next.Op, next.Values, next.Fields, next.Before = prev.Op, values, fields, prev.Before
+		return &next, true
+	case "ud":
+		// the row to delete still has the values preceding the dropped update
+		next.Before = prev.Before
+		return &next, true
+	case "cd":
+		return nil, true
+	}
+	return nil, false
+}
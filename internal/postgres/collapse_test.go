@@ -0,0 +1,110 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCollapseBatch(t *testing.T) {
+	change := func(op, table string, id int64, values map[string]interface{}) kafka.Message {
+		m := kafka.Message{Op: op, TableName: table, Values: values}
+		if id > 0 {
+			m.Keys = map[string]interface{}{"id": id}
+		}
+		return m
+	}
+	summary := func(batch []kafka.Message) (ops []string, values []map[string]interface{}) {
+		for _, m := range batch {
+			ops = append(ops, m.Op+" "+m.TableName)
+			values = append(values, m.Values)
+		}
+		return
+	}
+	batch := []kafka.Message{
+		change("c", "a", 1, map[string]interface{}{"id": int64(1), "v": "x", "notes": "n"}),
+		change("u", "b", 1, map[string]interface{}{"id": int64(1), "v": "y"}),
+		change("u", "a", 1, map[string]interface{}{"id": int64(1), "v": "z"}),
+		change("c", "a", 2, map[string]interface{}{"id": int64(2)}),
+		change("u", "b", 2, map[string]interface{}{"id": int64(2)}),
+		change("d", "a", 2, nil),
+		change("u", "b", 1, map[string]interface{}{"id": int64(1), "v": "w"}),
+		change("d", "b", 1, nil),
+		change("c", "b", 1, map[string]interface{}{"id": int64(1)}),
+		change("u", "k", 0, map[string]interface{}{"v": 1}),
+		change("u", "k", 0, map[string]interface{}{"v": 2}),
+	}
+	assert.Equal(t, batch, collapseBatch(Config{}, batch), "disabled")
+
+	collapsed := Stats().CollapsedChanges
+	cfg := Config{CollapseBatches: true}
+	ops, values := summary(collapseBatch(cfg, batch))
+	// the insert of the final row image takes the place of the last update, updates followed by the delete are
+	// dropped and rows without the key are not collapsed
+	assert.Equal(t, []string{"c a", "u b", "d b", "c b", "u k", "u k"}, ops)
+	assert.Equal(t, []map[string]interface{}{
+		{"id": int64(1), "v": "z", "notes": "n"},
+		{"id": int64(2)},
+		nil,
+		{"id": int64(1)},
+		{"v": 1},
+		{"v": 2},
+	}, values)
+	assert.Equal(t, collapsed+5, Stats().CollapsedChanges)
+
+	// key columns configured for the table identify rows too
+	keyed := []kafka.Message{
+		{Op: "u", TableName: "k", Values: map[string]interface{}{"code": "a", "v": 1}},
+		{Op: "u", TableName: "k", Values: map[string]interface{}{"code": "a", "v": 2}},
+	}
+	cfg.KeyColumns = map[string]bool{"k.code": true}
+	assert.Len(t, collapseBatch(cfg, keyed), 1)
+
+	// not across schema changes
+	schema := []kafka.Message{batch[0], {SchemaChange: &kafka.SchemaChange{DDL: "ALTER TABLE a ADD c int"}}, batch[2]}
+	assert.Len(t, collapseBatch(cfg, schema), 3)
+
+	cfg.Ledger = "dbz2pg_ledger"
+	assert.Len(t, collapseBatch(cfg, batch), len(batch), "every item is recorded in the ledger")
+}
+
+func TestApplyCollapsedBatch(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestApplyCollapsedBatch")
+	var statements []string
+	conn := MockDbTransactor{Tx: MockDbTx{MockDbExec: MockDbExec{
+		ExecHandler: func(sql string, arguments []interface{}) (pgconn.CommandTag, error) {
+			statements = append(statements, sql)
+			return pgconn.CommandTag("UPDATE 1"), nil
+		},
+	}}}
+	id := map[string]interface{}{"id": int64(1)}
+	batch := []kafka.Message{
+		{Op: "c", TableName: "a", Keys: id, Values: map[string]interface{}{"id": int64(1), "v": 1}},
+		{Op: "u", TableName: "a", Keys: id, Values: map[string]interface{}{"id": int64(1), "v": 2}},
+		{Op: "u", TableName: "a", Keys: id, Values: map[string]interface{}{"id": int64(1), "v": 3}},
+	}
+	messages := Stats().Messages
+	applyBatch(context.Background(), conn, Config{CollapseBatches: true}, batch)
+	assert.Equal(t, []string{`INSERT INTO "a"("id","v") VALUES ($1,$2)`}, statements)
+	assert.Equal(t, messages+3, Stats().Messages, "collapsed items are accounted as applied")
+}
+
+func TestCollapseUpdateDeleteFullImage(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestCollapseUpdateDeleteFullImage")
+	id := map[string]interface{}{"id": int64(1)}
+	batch := []kafka.Message{
+		{Op: "u", TableName: "a", Keys: id, Before: map[string]interface{}{"id": int64(1), "v": "x"},
+			Values: map[string]interface{}{"id": int64(1), "v": "y"}},
+		{Op: "d", TableName: "a", Keys: id, Before: map[string]interface{}{"id": int64(1), "v": "y"}},
+	}
+	cfg := Config{CollapseBatches: true, MatchReplicaIdentity: true}
+	collapsed := collapseBatch(cfg, batch)
+	assert.Len(t, collapsed, 1)
+	assert.Equal(t, "d", collapsed[0].Op)
+	assert.Equal(t, map[string]interface{}{"id": int64(1), "v": "x"}, matchedColumns(cfg, collapsed[0]),
+		"the row is matched by its image preceding the dropped update")
+}
@@ -0,0 +1,212 @@
+package postgres
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+)
+
+// Config holds settings controlling how CDC items are applied to the target database
+type Config struct {
+	// Logger receives the messages about applying CDC items, nil means the package Logger
+	Logger FieldLogger
+	// IdleTimeout stops applying when no messages arrive during this period
+	IdleTimeout time.Duration
+	// BatchSize is the number of CDC items applied in a single transaction, values below 2 disable batching
+	BatchSize int
+	// CollapseBatches collapses changes of each row within the batch into the last one, so bursts of changes of the same
+	// row are applied once. Rows are identified by the message key or KeyColumns, tables without them aren't collapsed
+	CollapseBatches bool
+	// IsolateItems applies each CDC item of the batch within a savepoint, so the failing ones are rolled back to it and
+	// handled by ErrorPolicy while the rest of the batch commits. The batch is applied one by one with ErrorPolicyHalt
+	IsolateItems bool
+	// ErrorPolicy is one of the ErrorPolicy* constants handling CDC items failing to apply, empty string means skip
+	ErrorPolicy string
+	// FlushInterval is the time after which the batch is applied even if it's not full, zero means no such limit
+	FlushInterval time.Duration
+	// MaxWritesPerSecond limits the rate CDC items are applied at, zero means unlimited
+	MaxWritesPerSecond float64
+	// SnapshotCopy loads rows of the initial snapshot of the source with COPY instead of inserting them. Snapshot rows
+	// are skipped otherwise
+	SnapshotCopy bool
+	// SnapshotCopySize is the number of buffered snapshot rows loaded at once, zero means no such limit
+	SnapshotCopySize int
+	// SnapshotCopyInterval is the time after which buffered snapshot rows are loaded, zero means no such limit.
+	// Buffered rows are always loaded before the first streamed change is applied
+	SnapshotCopyInterval time.Duration
+	// ShutdownGrace is the time allowed to apply already queued messages when applying is cancelled
+	ShutdownGrace time.Duration
+	// GroupTransactions buffers CDC items by the source transaction id and applies each source transaction in a single
+	// target transaction once its END marker and all its CDC items are received
+	GroupTransactions bool
+	// TransactionMaxEvents is the number of buffered CDC items of the source transaction applied before it's complete,
+	// zero means no such limit
+	TransactionMaxEvents int
+	// TransactionTimeout is the time after which CDC items of the incomplete source transaction are applied,
+	// zero means no such limit
+	TransactionTimeout time.Duration
+	// Ledger is the table recording offsets of the applied CDC items in the same transaction as the changes, so replayed
+	// items are skipped. Empty string disables the ledger
+	Ledger string
+	// Offsets is the store the offsets of CDC items are saved to once they are applied, so consuming can be resumed
+	// after them. Nil means offsets are not saved
+	Offsets kafka.OffsetStore
+	// MessageHandler is called for the logical decoding messages emitted by pg_logical_emit_message in the source,
+	// nil means such messages are skipped
+	MessageHandler func(message kafka.LogicalMessage)
+	// BeforeApply is called before writing each CDC item, e.g. to enforce policies. The item isn't written if it
+	// returns an error. Items are applied one by one then, i.e. never combined into multi-row statements
+	BeforeApply func(ctx context.Context, m *kafka.Message) error
+	// AfterApply is called after writing each CDC item with the number of rows affected and the error, if any, e.g. for
+	// audit logging. Items of batches are written within the batch transaction, which may still be rolled back
+	AfterApply func(ctx context.Context, m *kafka.Message, rowsAffected int64, err error)
+	// Returning holds the columns inserts and updates of the tables return, e.g. generated keys or computed columns,
+	// keyed by "table" or "schema.table". Items of such tables are applied one by one
+	Returning map[string][]string
+	// OnReturned is called with the columns returned by the insert or update of the CDC item, keyed by column name
+	OnReturned func(ctx context.Context, m *kafka.Message, row map[string]interface{})
+	// LagThreshold is the delay between the source change and its applying OnLag is called after, zero disables it
+	LagThreshold time.Duration
+	// OnLag is called with the current lag when it exceeds LagThreshold
+	OnLag func(lag time.Duration)
+	// OnLagRecovered is called with the current lag when it drops below half of LagThreshold after OnLag was called
+	OnLagRecovered func(lag time.Duration)
+	// DeadLetters receives messages that cannot be applied, nil means such messages are dropped
+	DeadLetters chan<- kafka.DeadLetter
+	// ColumnTypes holds types the column parameters are cast to, keyed by "table.column" or "schema.table.column"
+	ColumnTypes map[string]string
+	// BinaryHandling is the `binary.handling.mode` of the connector, i.e. one of the BinaryHandling* constants
+	BinaryHandling string
+	// DecimalHandling is the `decimal.handling.mode` of the connector, i.e. one of the DecimalHandling* constants.
+	// Decimal values sent in other modes fail, empty string means any mode is accepted
+	DecimalHandling string
+	// ClampInfinity applies infinite dates and timestamps as the minimal or maximal ones, e.g. for targets rejecting them
+	ClampInfinity bool
+	// SpecialNumericAsNull converts NaN and infinite numeric values to NULL, float values are applied as is
+	SpecialNumericAsNull bool
+	// InsertMode is one of the InsertMode* constants, empty string means plain inserts
+	InsertMode string
+	// TablePolicies holds the policies of applying each operation to the table overriding the modes applying to all
+	// tables, keyed by "table" or "schema.table"
+	TablePolicies map[string]TablePolicy
+	// UpsertTables holds tables inserts into are upserts regardless of InsertMode, keyed by "table" or "schema.table"
+	UpsertTables map[string]bool
+	// InsertConflicts holds the policies for inserts conflicting with existing rows of the table regardless of InsertMode,
+	// keyed by "table" or "schema.table". InsertConflictIgnore is the only policy supported
+	InsertConflicts map[string]string
+	// UpdateOnDuplicate holds tables inserts into are retried as updates matching the message key if they fail with
+	// duplicate key, e.g. partitioned tables lacking the unique index for upserts. Keyed by "table" or "schema.table"
+	UpdateOnDuplicate map[string]bool
+	// DeleteMissing holds the policies for deletes of rows missing in the table, keyed by "table" or "schema.table".
+	// Policies are DeleteMissing* constants, such deletes are warned about for other tables
+	DeleteMissing map[string]string
+	// IdempotentDeletes treats deletes of rows missing in the tables without the DeleteMissing policy as success,
+	// i.e. applies the DeleteMissingOK policy to them, so replayed deletes don't alarm
+	IdempotentDeletes bool
+	// StagingTables holds tables the batches are applied to through the unlogged staging table, keyed by "table" or
+	// "schema.table". Changes of each row are collapsed to the last one, loaded into the staging table with COPY and
+	// merged into the table with a single statement per batch. Requires batching and the unique index on the key
+	StagingTables map[string]bool
+	// StagingKind is one of the Staging* constants, empty string means unlogged staging tables
+	StagingKind string
+	// StagingSchema is the schema staging tables are created in, empty string means the schema of the target table.
+	// Temporary staging tables are always created in the temporary schema of the connection
+	StagingSchema string
+	// PositionGuard writes the source position of the CDC items, e.g. the LSN, to PositionColumn of the target rows
+	// and skips updates and deletes of the rows holding newer positions, so changes delivered out of order or
+	// replayed are not applied. Requires the column in all target tables, items without position are not guarded
+	PositionGuard bool
+	// LastWriteWins holds tables conflicting writes are resolved by the source timestamps of the CDC items in, keyed by
+	// "table" or "schema.table". The timestamp is written to UpdatedColumn of the rows, which the table must have, and
+	// updates and deletes of the rows changed later are skipped, so are upserts of them. Writes of the same timestamp
+	// are applied in the order received, items without timestamp are applied regardless
+	LastWriteWins map[string]bool
+	// Partitions holds the specs of the range partitions created when inserts into the partitioned table fail as no
+	// partition is found for the row, keyed by "table" or "schema.table". Inserts are retried once after creating
+	// the partition
+	Partitions map[string]PartitionSpec
+	// ArchiveDeletes holds tables rows deleted from are archived into the table named with ArchiveSuffix appended
+	// before they are deleted, keyed by "table" or "schema.table"
+	ArchiveDeletes map[string]bool
+	// HistoryTables holds the tables keeping all versions of the rows, keyed by "table" or "schema.table". Changes are
+	// effective at the source time of the CDC items, rows are matched by the message key or KeyColumns
+	HistoryTables map[string]HistorySpec
+	// KeyColumns holds columns identifying rows of the target table instead of the message key, keyed by "table.column"
+	// or "schema.table.column". They are used to match updated and deleted rows and as the conflict target
+	KeyColumns map[string]bool
+	// ConflictKeys holds columns identifying conflicting rows of upserts and ignored inserts, keyed by "table.column" or
+	// "schema.table.column". Key columns of the CDC item are used for tables without such columns
+	ConflictKeys map[string]bool
+	// MatchReplicaIdentity matches updated and deleted rows by all columns of the old row image if the source table has
+	// REPLICA IDENTITY FULL, detected from columns besides the key in the image. The key is matched otherwise
+	MatchReplicaIdentity bool
+	// UpdateMode is one of the UpdateMode* constants, empty string means plain updates
+	UpdateMode string
+	// UpsertUpdates applies updates of the tables with upserts as upserts too, so updates of missing rows insert them.
+	// Updates changing the key don't remove the old row then
+	UpsertUpdates bool
+	// ColumnExpressions holds SQL expressions computing the column values instead of copying them, keyed by "table.column"
+	// or "schema.table.column". Expressions reference values of the other columns as $column, e.g. to_tsvector($title)
+	ColumnExpressions map[string]string
+	// ExpressionFields holds fields of the CDC items consumed only by ColumnExpressions, which aren't copied to columns
+	// of their own, keyed by "table.field" or "schema.table.field", see CheckColumnExpressions
+	ExpressionFields map[string]bool
+	// CaseInsensitive holds columns compared in lowercase when matching rows, keyed by "table.column" or "schema.table.column"
+	CaseInsensitive map[string]bool
+	// FlattenStructs holds struct columns applied as a column per attribute named "<column>_<attribute>" instead of
+	// the composite type value, keyed by "table.column" or "schema.table.column"
+	FlattenStructs map[string]bool
+	// CharPadding holds either CharPaddingTrim or the width to pad to for fixed-width char columns, keyed by "table.column"
+	// or "schema.table.column". Values are normalised before matching rows, so the padding of the source doesn't matter
+	CharPadding map[string]string
+	// NullToDefault holds columns omitted from inserts if their value is NULL, so the column default applies instead,
+	// keyed by "table.column" or "schema.table.column"
+	NullToDefault map[string]bool
+	// MetadataColumns holds the columns of the target rows holding the metadata of the CDC items, keyed by one of
+	// the Metadata* constants, for tables keyed by "table" or "schema.table". Columns keyed by empty string apply
+	// to all tables, columns of the table override them
+	MetadataColumns map[string]map[string]string
+	// SchemaDefaults is one of the SchemaDefaults* constants, empty string means defaults of the target apply
+	SchemaDefaults string
+	// ColumnMappers rename source columns to the target ones, keyed by "table" or "schema.table". Columns are renamed
+	// after case folding and flattening, other column settings use the target names
+	ColumnMappers map[string]ColumnMapper
+	// FanOut returns the target tables changes of the source table are written to instead of the table of the same
+	// name, each with its own column mapping. Source columns are the ones already renamed by ColumnMappers. Nil means
+	// changes are written to the table of the same name only
+	FanOut FanOut
+	// Views holds target tables which are views with INSTEAD OF triggers, keyed by "table" or "schema.table"
+	Views map[string]bool
+	// AppendMode appends all changes to the `<table>_cdc_log` tables instead of applying them
+	AppendMode bool
+	// PostGIS means geometry values are applied as PostGIS geometries instead of native types
+	PostGIS bool
+	// SchemaDrift is either SchemaDriftSkip or SchemaDriftAlter to handle columns missing in the target table,
+	// empty string or SchemaDriftReport means such CDC items fail. SchemaDriftReport logs and counts differences of the
+	// Debezium schemas from the target tables too
+	SchemaDrift string
+	// WidenColumns changes types of target columns too narrow for the values, e.g. integer for bigint values, to the
+	// wider types of the Debezium schema and retries the CDC item. Columns are never narrowed
+	WidenColumns bool
+	// SchemaChangesTable is the table logging the target columns added or widened automatically, empty string
+	// means the changes are only logged
+	SchemaChangesTable string
+	// AutoCreateTables creates target tables missing when CDC items are applied to them with columns of the Debezium
+	// schema of the item and the primary key on the key columns or the message key
+	AutoCreateTables bool
+	// AutoCreateOutput receives the DDL of the missing target tables instead of executing it, nil means DDL is executed.
+	// CDC items of the missing tables fail then
+	AutoCreateOutput io.Writer
+	// ApplyDDL executes DDL statements of the schema change events against the target database
+	ApplyDDL bool
+	// AllowDestructiveDDL executes DDL statements dropping tables, columns or data as well
+	AllowDestructiveDDL bool
+	// TranslateDDL translates the structured table changes of the schema change events to PostgreSQL DDL creating
+	// and dropping tables and adding, dropping and renaming columns, instead of executing the source DDL as is
+	TranslateDDL bool
+	// CaseFold is either CaseFoldPreserve or CaseFoldLower to lowercase table and column names before quoting them.
+	// Column types are configured using the folded names
+	CaseFold string
+}
@@ -0,0 +1,176 @@
+package postgres
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pgconn/stmtcache"
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/jackc/pgx/v4/pgxpool"
+)
+
+// Statement cache modes of the target connections
+const (
+	StatementCachePrepare  = "prepare"  // statements are prepared on the server and cached
+	StatementCacheDescribe = "describe" // only descriptions of statements are cached, e.g. for PgBouncer in transaction mode
+	StatementCacheNone     = "none"     // statements are neither prepared nor cached
+)
+
+// statementCacheCapacity is the number of statements cached per connection, the default of pgx
+const statementCacheCapacity = 512
+
+// TLSOptions holds TLS settings of the target connections, the same as the sslmode, sslrootcert, sslcert
+// and sslkey connection parameters
+type TLSOptions struct {
+	Mode     string // disable, require, verify-ca or verify-full. Other modes of libpq, e.g. prefer, are rejected
+	RootCert string // file with the certificates of the trusted certificate authorities
+	Cert     string // file with the client certificate
+	Key      string // file with the private key of the client certificate
+}
+
+// ConnOptions holds settings of the target connections applied on top of the connection string
+type ConnOptions struct {
+	ApplicationName    string      // reported to the server, empty string keeps the one of the connection string
+	StatementCacheMode string      // one of the StatementCache* constants, empty string keeps the one of the connection string
+	TLS                *TLSOptions // replaces TLS settings of the connection string, nil keeps them
+}
+
+// ParseConnConfig returns the config of the connection string with `opts` applied, e.g. for ConnectWithConfig
+func ParseConnConfig(connString string, opts ConnOptions) (*pgx.ConnConfig, error) {
+	connConfig, err := pgx.ParseConfig(connString)
+	if err != nil {
+		return nil, err
+	}
+	if err = applyConnOptions(connConfig, opts); err != nil {
+		return nil, err
+	}
+	return connConfig, nil
+}
+
+// ConnectWithConfig returns object that can execute sql against the target database connected using `connConfig`,
+// which must be created by ParseConnConfig or pgx.ParseConfig
+func ConnectWithConfig(ctx context.Context, connConfig *pgx.ConnConfig) (DBExecutorContext, error) {
+	poolConfig, err := pgxpool.ParseConfig("")
+	if err != nil {
+		return nil, err
+	}
+	poolConfig.ConnConfig = connConfig
+	return connectPool(ctx, poolConfig)
+}
+
+// ConnectWithOptions returns the Connect function applying `opts` on top of the connection string,
+// pool settings of the connection string are kept
+func ConnectWithOptions(opts ConnOptions) func(ctx context.Context, connString string) (DBExecutorContext, error) {
+	return func(ctx context.Context, connString string) (DBExecutorContext, error) {
+		poolConfig, err := pgxpool.ParseConfig(connString)
+		if err != nil {
+			return nil, err
+		}
+		if err = applyConnOptions(poolConfig.ConnConfig, opts); err != nil {
+			return nil, err
+		}
+		return connectPool(ctx, poolConfig)
+	}
+}
+
+// applyConnOptions overrides settings of the connection config with the ones set in `opts`
+func applyConnOptions(connConfig *pgx.ConnConfig, opts ConnOptions) error {
+	if opts.ApplicationName > "" {
+		connConfig.RuntimeParams["application_name"] = opts.ApplicationName
+	}
+	switch opts.StatementCacheMode {
+	case "":
+	case StatementCachePrepare, StatementCacheDescribe:
+		mode := stmtcache.ModePrepare
+		if opts.StatementCacheMode == StatementCacheDescribe {
+			mode = stmtcache.ModeDescribe
+		}
+		connConfig.BuildStatementCache = func(conn *pgconn.PgConn) stmtcache.Cache {
+			return stmtcache.New(conn, mode, statementCacheCapacity)
+		}
+	case StatementCacheNone:
+		connConfig.BuildStatementCache = nil
+	default:
+		return fmt.Errorf("Invalid statement cache mode %q", opts.StatementCacheMode)
+	}
+	if opts.TLS == nil {
+		return nil
+	}
+	tlsConfig, err := opts.TLS.config(connConfig.Host)
+	if err != nil {
+		return err
+	}
+	// the mode is honored strictly, e.g. without falling back to plain connections
+	connConfig.TLSConfig = tlsConfig
+	connConfig.Fallbacks = nil
+	return nil
+}
+
+// config returns the TLS config connecting to the `host`, nil for plain connections
+func (o TLSOptions) config(host string) (*tls.Config, error) {
+	if o.Mode == "disable" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{}
+	switch o.Mode {
+	case "require":
+		tlsConfig.InsecureSkipVerify = true
+	case "verify-ca", "verify-full":
+		if o.RootCert == "" {
+			return nil, fmt.Errorf("Root certificate is required for sslmode %s", o.Mode)
+		}
+		pem, err := ioutil.ReadFile(o.RootCert)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read root certificate: %w", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, errors.New("Unable to add root certificate to the pool")
+		}
+		if o.Mode == "verify-full" {
+			tlsConfig.ServerName = host
+		} else {
+			// the chain is verified, but not the host name
+			tlsConfig.InsecureSkipVerify = true
+			tlsConfig.VerifyPeerCertificate = verifyChain(tlsConfig.RootCAs)
+		}
+	default:
+		return nil, fmt.Errorf("Invalid sslmode %q", o.Mode)
+	}
+	if o.Cert > "" || o.Key > "" {
+		cert, err := tls.LoadX509KeyPair(o.Cert, o.Key)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// verifyChain returns the function verifying the server certificate is signed by one of the `roots`
+func verifyChain(roots *x509.CertPool) func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("Server certificate is missing")
+		}
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			cert, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			certs[i] = cert
+		}
+		opts := x509.VerifyOptions{Roots: roots, Intermediates: x509.NewCertPool()}
+		for _, cert := range certs[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err := certs[0].Verify(opts)
+		return err
+	}
+}
@@ -2,6 +2,7 @@ package postgres
 
 import (
 	"context"
+	"io"
 
 	"github.com/jackc/pgconn"
 	pgx "github.com/jackc/pgx/v4"
@@ -18,6 +19,46 @@ type DBExecutorContext interface {
 	Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error)
 }
 
+// DBTransactor interface represents sql executor able to start transactions, e.g. pgxpool.Pool
+type DBTransactor interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// DBQuerierContext interface represents sql executor able to return query results, e.g. pgxpool.Pool
+type DBQuerierContext interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// DBCopier interface represents sql executor able to load rows in the text format with COPY FROM STDIN
+type DBCopier interface {
+	CopyFromStdin(ctx context.Context, r io.Reader, sql string) (pgconn.CommandTag, error)
+}
+
+// pgConnCopier is the connection of the target database loading rows with COPY, e.g. the connection of the transaction
+type pgConnCopier struct {
+	*pgconn.PgConn
+}
+
+// CopyFromStdin executes the COPY FROM STDIN statement `sql` reading rows from `r`
+func (c pgConnCopier) CopyFromStdin(ctx context.Context, r io.Reader, sql string) (pgconn.CommandTag, error) {
+	return c.CopyFrom(ctx, r, sql)
+}
+
+// pool is the connection pool of the target database, which is able to load rows with COPY too
+type pool struct {
+	*pgxpool.Pool
+}
+
+// CopyFromStdin executes the COPY FROM STDIN statement `sql` on the acquired connection reading rows from `r`
+func (p pool) CopyFromStdin(ctx context.Context, r io.Reader, sql string) (pgconn.CommandTag, error) {
+	conn, err := p.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+	return pgConnCopier{conn.Conn().PgConn()}.CopyFromStdin(ctx, r, sql)
+}
+
 // Connect function returns object that can execute sql against target database
 var Connect func(ctx context.Context, connString string) (DBExecutorContext, error) = connect
 
@@ -26,11 +67,21 @@ func connect(ctx context.Context, connString string) (DBExecutorContext, error)
 	if err != nil {
 		return nil, err
 	}
+	return connectPool(ctx, connConfig)
+}
+
+// connectPool connects the pool logging to Logger
+func connectPool(ctx context.Context, connConfig *pgxpool.Config) (DBExecutorContext, error) {
+	var err error
 	connConfig.ConnConfig.Logger = logrusadapter.NewLogger(Logger)
 	connConfig.ConnConfig.LogLevel, err = pgx.LogLevelFromString(Logger.Logger.Level.String())
 	if err != nil {
 		return nil, err
 	}
 	// connConfig.PreferSimpleProtocol = true
-	return pgxpool.ConnectConfig(ctx, connConfig)
+	p, err := pgxpool.ConnectConfig(ctx, connConfig)
+	if err != nil {
+		return nil, err
+	}
+	return pool{p}, nil
 }
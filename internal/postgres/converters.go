@@ -0,0 +1,40 @@
+package postgres
+
+import (
+	"sync"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+)
+
+// Converter converts the CDC value described by the field schema to the statement parameter, which may be of any
+// type pgx is able to encode. Returned errors are reported as ErrPayloadDecode
+type Converter func(f kafka.Field, v interface{}) (interface{}, error)
+
+// converters holds custom converters keyed by the logical type name
+var converters = struct {
+	sync.RWMutex
+	byName map[string]Converter
+}{byName: make(map[string]Converter)}
+
+// RegisterConverter registers the converter for values of the `logicalName` type, e.g. io.debezium.data.Ltree.
+// Custom converters take precedence over the built-in conversions, nil converter removes the registration
+func RegisterConverter(logicalName string, fn Converter) {
+	converters.Lock()
+	defer converters.Unlock()
+	if fn == nil {
+		delete(converters.byName, logicalName)
+		return
+	}
+	converters.byName[logicalName] = fn
+}
+
+// lookupConverter returns the custom converter registered for the logical type of the field
+func lookupConverter(f kafka.Field) (Converter, bool) {
+	if f.Name == "" {
+		return nil, false
+	}
+	converters.RLock()
+	defer converters.RUnlock()
+	fn, ok := converters.byName[f.Name]
+	return fn, ok
+}
@@ -0,0 +1,52 @@
+package postgres
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegisterConverter(t *testing.T) {
+	const logicalDuration = "io.debezium.time.MicroDuration"
+	defer RegisterConverter(logicalDuration, nil)
+	var schema kafka.Field
+	RegisterConverter(logicalDuration, func(f kafka.Field, v interface{}) (interface{}, error) {
+		schema = f
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, errors.New("duration expected")
+		}
+		us, err := n.Int64()
+		return time.Duration(us) * time.Microsecond, err
+	})
+	f := kafka.Field{Type: "int64", Name: logicalDuration, Parameters: map[string]string{sourceColumnType: "INTERVAL"}}
+	msg := kafka.Message{
+		TableName: "jobs",
+		Values:    map[string]interface{}{"took": json.Number("1500000")},
+		Fields:    map[string]kafka.Field{"took": f, "retries": {Type: "array", Items: &f}},
+	}
+	arg, ref, err := bindValue(Config{ColumnTypes: map[string]string{"jobs.took": "interval"}}, msg, "took", json.Number("1500000"), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, 1500*time.Millisecond, arg)
+	assert.Equal(t, "$1::interval", ref)
+	assert.Equal(t, f, schema, "converter receives the schema including parameters")
+
+	_, _, err = bindValue(Config{}, msg, "took", "n/a", 1)
+	assert.True(t, errors.Is(err, ErrPayloadDecode))
+
+	RegisterConverter(logicalDuration, func(f kafka.Field, v interface{}) (interface{}, error) {
+		return "1 second", nil
+	})
+	arg, _, err = bindValue(Config{}, msg, "retries", []interface{}{json.Number("1000000")}, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"1 second"}`, arg, "array elements are converted too")
+
+	RegisterConverter(logicalDuration, nil)
+	arg, _, err = bindValue(Config{}, msg, "took", json.Number("1500000"), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "1500000 microseconds", arg, "built-in conversion applies again")
+}
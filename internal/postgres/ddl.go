@@ -0,0 +1,59 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+)
+
+// errDestructiveDDL is returned for DDL statements dropping objects or data unless allowed explicitly
+var errDestructiveDDL = errors.New("Destructive DDL statement is not allowed")
+
+var (
+	reDDLKeyword     = regexp.MustCompile(`(?i)^\s*(CREATE|ALTER|DROP|TRUNCATE)\b`)
+	reDestructiveDDL = regexp.MustCompile(`(?is)^\s*(DROP|TRUNCATE)\b|^\s*ALTER\b.*\bDROP\b`)
+	reDropAttribute  = regexp.MustCompile(`(?i)\bDROP\s+(DEFAULT|NOT\s+NULL|IDENTITY|EXPRESSION)\b`)
+	reBacktickQuoted = regexp.MustCompile("`((?:[^`]|``)*)`")
+	backtickEscapes  = strings.NewReplacer("``", "`", `"`, `""`)
+)
+
+// applySchemaChange executes DDL statement of the schema change event against the target database if `cfg.ApplyDDL`
+// is set. Only CREATE, ALTER, DROP and TRUNCATE statements are executed, statements dropping objects or data
+// require `cfg.AllowDestructiveDDL`. Identifiers quoted with backticks by MySQL are quoted the standard way
+func applySchemaChange(ctx context.Context, conn DBExecutorContext, cfg Config, change kafka.SchemaChange) (int64, error) {
+	l := loggerOf(cfg).WithField("op", "ddl").WithField("ddl", change.DDL)
+	if !cfg.ApplyDDL {
+		l.Debug("Schema change skipped")
+		return 0, nil
+	}
+	if !reDDLKeyword.MatchString(change.DDL) {
+		l.Debug("Incompatible DDL statement skipped")
+		return 0, nil
+	}
+	if isDestructiveDDL(change) && !cfg.AllowDestructiveDDL {
+		return 0, errDestructiveDDL
+	}
+	sql := reBacktickQuoted.ReplaceAllStringFunc(change.DDL, func(s string) string {
+		return `"` + backtickEscapes.Replace(s[1:len(s)-1]) + `"`
+	})
+	l.Debug("Applying schema change")
+	ct, err := conn.Exec(ctx, sql)
+	err = classify(ErrDBExec, err)
+	atomic.AddUint64(&tx, 1)
+	return ct.RowsAffected(), err
+}
+
+// isDestructiveDDL returns true if the schema change drops tables, columns, constraints or data
+func isDestructiveDDL(change kafka.SchemaChange) bool {
+	for _, tc := range change.TableChanges {
+		if strings.EqualFold(tc.Type, "DROP") {
+			return true
+		}
+	}
+	// dropping column defaults or constraints doesn't lose data
+	return reDestructiveDDL.MatchString(reDropAttribute.ReplaceAllString(change.DDL, ""))
+}
@@ -0,0 +1,66 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplySchemaChange(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestApplySchemaChange")
+	var statements []string
+	conn := MockDbExec{
+		ExecHandler: func(sql string, arguments []interface{}) (pgconn.CommandTag, error) {
+			statements = append(statements, sql)
+			return pgconn.CommandTag("ALTER TABLE"), nil
+		},
+	}
+	msg, err := kafka.NewMessage(kafkago.Message{
+		Key:   []byte(`{"schema":null,"payload":{"databaseName":"inventory"}}`),
+		Value: []byte(`{"schema":null,"payload":{"source":{"connector":"mysql","db":"inventory","table":"customers"},"databaseName":"inventory","ddl":"ALTER TABLE ` + "`customers`" + ` ADD COLUMN phone VARCHAR(20)","tableChanges":[{"type":"ALTER","id":"\"inventory\".\"customers\""}]}}`),
+	})
+	assert.NoError(t, err)
+
+	_, err = applyCDCItem(context.Background(), conn, Config{}, *msg)
+	assert.NoError(t, err)
+	assert.Empty(t, statements, "DDL is not applied by default")
+
+	cfg := Config{ApplyDDL: true}
+	_, err = applyCDCItem(context.Background(), conn, cfg, *msg)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`ALTER TABLE "customers" ADD COLUMN phone VARCHAR(20)`}, statements)
+
+	statements = nil
+	for _, ddl := range []string{"SET character_set_server=utf8mb4", "USE inventory", "GRANT SELECT ON t TO u"} {
+		_, err = applySchemaChange(context.Background(), conn, cfg, kafka.SchemaChange{DDL: ddl})
+		assert.NoError(t, err)
+	}
+	assert.Empty(t, statements, "incompatible statements are skipped")
+
+	for _, change := range []kafka.SchemaChange{
+		{DDL: "DROP TABLE customers", TableChanges: []kafka.TableChange{{Type: "DROP", ID: "customers"}}},
+		{DDL: "truncate table customers"},
+		{DDL: "ALTER TABLE customers DROP COLUMN phone"},
+		{DDL: "ALTER TABLE customers ADD COLUMN fax text, DROP email"},
+		{DDL: "CREATE TABLE t2 (id int)", TableChanges: []kafka.TableChange{{Type: "DROP", ID: "t1"}}},
+	} {
+		_, err = applySchemaChange(context.Background(), conn, cfg, change)
+		assert.Equal(t, errDestructiveDDL, err, change.DDL)
+		_, err = applySchemaChange(context.Background(), conn, Config{ApplyDDL: true, AllowDestructiveDDL: true}, change)
+		assert.NoError(t, err, change.DDL)
+	}
+	assert.Len(t, statements, 5)
+
+	_, err = applySchemaChange(context.Background(), conn, cfg, kafka.SchemaChange{DDL: "ALTER TABLE customers ALTER COLUMN phone DROP NOT NULL, ALTER COLUMN fax DROP DEFAULT"})
+	assert.NoError(t, err, "dropping constraints is not destructive")
+
+	statements = nil
+	_, err = applySchemaChange(context.Background(), conn, cfg, kafka.SchemaChange{DDL: "CREATE TABLE `my``table` (`a\"b` int)"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`CREATE TABLE "my` + "`" + `table" ("a""b" int)`}, statements)
+}
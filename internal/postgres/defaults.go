@@ -0,0 +1,34 @@
+package postgres
+
+import "github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+
+// Policies for columns declared with the default in the Debezium schema but absent in the row image of inserts
+const (
+	SchemaDefaultsTarget = "target" // columns are omitted, so the defaults of the target table apply
+	SchemaDefaultsSource = "source" // columns are set to the defaults declared in the Debezium schema
+)
+
+// withSchemaDefaults returns the inserted CDC item with the columns absent in the row image set to their defaults
+// declared in the Debezium schema if `cfg.SchemaDefaults` is SchemaDefaultsSource
+func withSchemaDefaults(cfg Config, m kafka.Message) kafka.Message {
+	if cfg.SchemaDefaults != SchemaDefaultsSource || (m.Op != "c" && m.Op != "r") || len(m.Values) == 0 {
+		return m
+	}
+	var values map[string]interface{}
+	for column, f := range m.Fields {
+		if _, ok := m.Values[column]; ok || f.Default == nil || f.Attribute > "" {
+			continue
+		}
+		if values == nil {
+			values = make(map[string]interface{}, len(m.Fields))
+			for k, v := range m.Values {
+				values[k] = v
+			}
+		}
+		values[column] = f.Default
+	}
+	if values != nil {
+		m.Values = values
+	}
+	return m
+}
@@ -0,0 +1,53 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchemaDefaults(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestSchemaDefaults")
+	var (
+		sqls []string
+		args [][]interface{}
+	)
+	conn := MockDbExec{ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+		sqls, args = append(sqls, s), append(args, a)
+		return pgconn.CommandTag("INSERT 0 1"), nil
+	}}
+	m := kafka.Message{Op: "c", SchemaName: "public", TableName: "orders",
+		Keys:   map[string]interface{}{"id": json.Number("1")},
+		Values: map[string]interface{}{"id": json.Number("1"), "note": nil},
+		Fields: map[string]kafka.Field{
+			"id":     {Type: "int64"},
+			"note":   {Type: "string", Optional: true, Default: "none"},
+			"qty":    {Type: "int64", Default: json.Number("1")},
+			"status": {Type: "string", Optional: true},
+		},
+	}
+	ctx := context.Background()
+	apply := func(cfg Config, m kafka.Message) {
+		sqls, args = nil, nil
+		_, err := applyCDCItem(ctx, conn, cfg, prepareMessage(cfg, m))
+		assert.NoError(t, err)
+	}
+
+	apply(Config{}, m)
+	assert.Equal(t, []string{`INSERT INTO "public"."orders"("id","note") VALUES ($1,$2)`}, sqls, "target defaults apply")
+
+	cfg := Config{SchemaDefaults: SchemaDefaultsSource}
+	apply(cfg, m)
+	assert.Equal(t, []string{`INSERT INTO "public"."orders"("id","note","qty") VALUES ($1,$2,$3)`}, sqls)
+	assert.Equal(t, []interface{}{int64(1), nil, int64(1)}, args[0], "explicit NULL is kept, absent column gets its default")
+	assert.NotContains(t, m.Values, "qty", "row image of the message is not modified")
+
+	m.Op = "u"
+	apply(cfg, m)
+	assert.NotContains(t, sqls[0], `"qty"`, "updates keep absent columns")
+}
@@ -0,0 +1,239 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// Schema drift modes, i.e. how to handle columns the source sends but the target table doesn't have
+const (
+	SchemaDriftSkip   = "skip"   // drop unknown columns from the CDC item and retry
+	SchemaDriftAlter  = "alter"  // add unknown columns to the target table and retry
+	SchemaDriftReport = "report" // report differences from the target table without changing anything
+)
+
+// evolvedColumns counts columns added to the target tables by SchemaDriftAlter
+var evolvedColumns uint64
+
+// sqlstateUndefinedColumn is the error code PostgreSQL returns for references to nonexistent columns
+const sqlstateUndefinedColumn = "42703"
+
+var reUndefinedColumn = regexp.MustCompile(`^column "(.*?)"(?: of relation ".*")? does not exist$`)
+
+// undefinedColumn returns the name of the column reported as nonexistent by `err`, or empty string
+func undefinedColumn(err error) string {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != sqlstateUndefinedColumn {
+		return ""
+	}
+	if m := reUndefinedColumn.FindStringSubmatch(pgErr.Message); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// applyDriftingCDCItem applies CDC item handling columns missing in the target table according to `cfg.SchemaDrift`.
+// Only columns of the new row image are handled, columns used to match rows are reported as errors. Missing target
+// tables are created with `cfg.AutoCreateTables`, columns too narrow for the values are widened with
+// `cfg.WidenColumns` once. Within transactions each attempt is made in a savepoint rolled back on failure, so the DDL
+// and the retry don't run in the aborted transaction. Records batched into the CDC item are applied one by one
+func applyDriftingCDCItem(ctx context.Context, conn DBExecutorContext, cfg Config, message kafka.Message) (int64, error) {
+	if len(message.Records) > 0 {
+		return applyRecords(ctx, conn, cfg, message)
+	}
+	if cfg.SchemaDrift == SchemaDriftReport {
+		reportSchemaDrift(ctx, conn, cfg, message)
+	}
+	altered := make(map[string]bool)
+	created, widened := false, false
+	for {
+		rowsAffected, err := attemptCDCItem(ctx, conn, cfg, message)
+		if isOverflow(err) && cfg.WidenColumns && !widened {
+			widened = true
+			n, widenErr := widenColumns(ctx, conn, cfg, message)
+			if widenErr != nil {
+				return rowsAffected, widenErr
+			}
+			if n == 0 {
+				return rowsAffected, err
+			}
+			continue
+		}
+		if isUndefinedTable(err) && cfg.AutoCreateTables && !created && message.SchemaChange == nil {
+			var createErr error
+			if created, createErr = createTable(ctx, conn, cfg, message); createErr != nil {
+				return rowsAffected, createErr
+			}
+			if !created {
+				return rowsAffected, err
+			}
+			continue
+		}
+		column := undefinedColumn(err)
+		if column == "" || altered[column] {
+			return rowsAffected, err
+		}
+		if _, key := message.Keys[column]; key || message.Op == "d" {
+			return rowsAffected, err
+		}
+		if _, ok := message.Values[column]; !ok {
+			return rowsAffected, err
+		}
+		l := loggerOf(cfg).WithField("table", message.QualifiedTablename()).WithField("column", column)
+		switch cfg.SchemaDrift {
+		case SchemaDriftSkip:
+			l.Warning("Column missing in the target table skipped")
+			values := make(map[string]interface{}, len(message.Values))
+			for k, v := range message.Values {
+				if k != column {
+					values[k] = v
+				}
+			}
+			message.Values = values
+		case SchemaDriftAlter:
+			definition := columnDefinition(cfg, message, column)
+			if err := addColumn(ctx, conn, cfg, message, column); err != nil {
+				l.WithError(err).Error("Column missing in the target table can't be added")
+				return 0, err
+			}
+			atomic.AddUint64(&evolvedColumns, 1)
+			l.WithField("definition", definition).Warning("Target table evolved: column missing in the target table added")
+			altered[column] = true
+		default:
+			return rowsAffected, err
+		}
+	}
+}
+
+// retriesCDCItems returns true if failing CDC items are retried after changing the target table or the CDC item
+func retriesCDCItems(cfg Config) bool {
+	return cfg.SchemaDrift == SchemaDriftSkip || cfg.SchemaDrift == SchemaDriftAlter || cfg.WidenColumns || cfg.AutoCreateTables
+}
+
+// attemptCDCItem applies the CDC item within a savepoint if `conn` is a transaction and failing items are retried,
+// so the transaction remains usable after the failure
+func attemptCDCItem(ctx context.Context, conn DBExecutorContext, cfg Config, message kafka.Message) (int64, error) {
+	t, ok := conn.(pgx.Tx)
+	if !ok || !retriesCDCItems(cfg) {
+		return applyCDCItem(ctx, conn, cfg, message)
+	}
+	var rowsAffected int64
+	_, err := withSavepoint(ctx, t, func(savepoint pgx.Tx) (pgconn.CommandTag, error) {
+		var err error
+		rowsAffected, err = applyCDCItem(ctx, savepoint, cfg, message)
+		return nil, err
+	})
+	return rowsAffected, err
+}
+
+// addColumn adds `column` to the target table with the type inferred from the Debezium schema
+func addColumn(ctx context.Context, conn DBExecutorContext, cfg Config, message kafka.Message, column string) error {
+	sql := fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s",
+		message.QualifiedTablename(),
+		pgx.Identifier{column}.Sanitize(),
+		columnDefinition(cfg, message, column))
+	if _, err := conn.Exec(ctx, sql); err != nil {
+		return classify(ErrDBExec, err)
+	}
+	return recordSchemaChange(ctx, conn, cfg, message, column, sql)
+}
+
+// columnDefinition returns the type of the added `column` with the default declared in the Debezium schema, if any.
+// Added columns are always nullable, as rows already in the target table have no values for them
+func columnDefinition(cfg Config, message kafka.Message, column string) string {
+	definition := columnType(cfg, message, column)
+	if d, ok := defaultLiteral(cfg, message, column); ok {
+		definition += " DEFAULT '" + strings.Replace(d, "'", "''", -1) + "'"
+	}
+	return definition
+}
+
+// defaultLiteral returns the default of the `column` declared in the Debezium schema converted from its encoding to
+// the literal of the column type, e.g. epoch microseconds to the timestamp. Defaults of binary and structured types
+// and ones that can't be converted are skipped
+func defaultLiteral(cfg Config, message kafka.Message, column string) (string, bool) {
+	f := message.Fields[column]
+	if f.Default == nil || f.Type == "bytes" && f.Name != logicalDecimal && f.Name != logicalVariableScaleDecimal {
+		return "", false
+	}
+	v, err := convertValue(f, column, castFor(cfg, message, column), f.Default)
+	if err != nil {
+		return "", false
+	}
+	switch v := v.(type) {
+	case time.Time:
+		if columnType(cfg, message, column) == "date" {
+			return v.Format("2006-01-02"), true
+		}
+		return v.Format("2006-01-02 15:04:05.999999"), true
+	case string, json.Number, bool, int64, uint64, float64:
+		return fmt.Sprint(v), true
+	}
+	return "", false
+}
+
+// columnType returns the PostgreSQL type suitable to store values of the `column`
+func columnType(cfg Config, message kafka.Message, column string) string {
+	if cast := castFor(cfg, message, column); cast > "" {
+		return cast
+	}
+	return fieldType(cfg, message.Fields[column])
+}
+
+// fieldType returns the PostgreSQL type matching the Debezium logical type or the Kafka Connect type of the field
+func fieldType(cfg Config, f kafka.Field) string {
+	switch f.Name {
+	case logicalDate, logicalConnectDate:
+		return "date"
+	case logicalTimestamp, logicalMicroTimestamp, logicalNanoTimestamp, logicalConnectTimestamp:
+		return "timestamp"
+	case logicalTime, logicalMicroTime, logicalNanoTime, logicalConnectTime:
+		return "time"
+	case logicalDecimal, logicalVariableScaleDecimal:
+		return "numeric"
+	case logicalPoint:
+		if !cfg.PostGIS {
+			return "point"
+		}
+		return "geometry"
+	case logicalGeometry, logicalGeography:
+		if !cfg.PostGIS {
+			return "bytea"
+		}
+		if f.Name == logicalGeography {
+			return "geography"
+		}
+		return "geometry"
+	}
+	switch f.Type {
+	case "int8", "int16":
+		return "smallint"
+	case "int32":
+		return "integer"
+	case "int64":
+		return "bigint"
+	case "float":
+		return "real"
+	case "double":
+		return "double precision"
+	case "boolean":
+		return "boolean"
+	case "bytes":
+		return "bytea"
+	case "array":
+		if f.Items != nil {
+			return fieldType(cfg, *f.Items) + "[]"
+		}
+	}
+	return "text"
+}
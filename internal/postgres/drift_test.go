@@ -0,0 +1,185 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// driftingTable mocks the target table missing the "added" column until it's altered
+func driftingTable(statements *[]string) MockDbExec {
+	altered := false
+	return MockDbExec{
+		ExecHandler: func(sql string, arguments []interface{}) (pgconn.CommandTag, error) {
+			*statements = append(*statements, sql)
+			if strings.HasPrefix(sql, "ALTER TABLE") {
+				altered = true
+				return pgconn.CommandTag("ALTER TABLE"), nil
+			}
+			if strings.Contains(sql, `"added"`) && !altered {
+				return nil, &pgconn.PgError{Code: "42703", Message: `column "added" of relation "items" does not exist`}
+			}
+			return pgconn.CommandTag("INSERT 0 1"), nil
+		},
+	}
+}
+
+func TestUndefinedColumn(t *testing.T) {
+	assert.Equal(t, "", undefinedColumn(nil))
+	assert.Equal(t, "", undefinedColumn(errors.New(`column "foo" does not exist`)))
+	assert.Equal(t, "", undefinedColumn(&pgconn.PgError{Code: "42P01", Message: `relation "foo" does not exist`}))
+	assert.Equal(t, "foo", undefinedColumn(&pgconn.PgError{Code: "42703", Message: `column "foo" does not exist`}))
+	assert.Equal(t, "foo bar", undefinedColumn(&pgconn.PgError{Code: "42703", Message: `column "foo bar" of relation "baz" does not exist`}))
+}
+
+func TestSchemaDrift(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestSchemaDrift")
+	msg := kafka.Message{
+		Op:        "c",
+		TableName: "items",
+		Values:    map[string]interface{}{"id": int64(1), "added": "foo"},
+		Fields:    map[string]kafka.Field{"added": {Type: "int64"}},
+	}
+
+	var statements []string
+	_, err := applyDriftingCDCItem(context.Background(), driftingTable(&statements), Config{}, msg)
+	assert.Equal(t, "added", undefinedColumn(err))
+	assert.Len(t, statements, 1)
+
+	statements = nil
+	rows, err := applyDriftingCDCItem(context.Background(), driftingTable(&statements), Config{SchemaDrift: SchemaDriftSkip}, msg)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, rows)
+	if assert.Len(t, statements, 2) {
+		assert.Equal(t, `INSERT INTO "items"("id") VALUES ($1)`, statements[1])
+	}
+	assert.Len(t, msg.Values, 2, "message must not be modified")
+
+	statements = nil
+	evolved := Stats().EvolvedColumns
+	rows, err = applyDriftingCDCItem(context.Background(), driftingTable(&statements), Config{SchemaDrift: SchemaDriftAlter}, msg)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, rows)
+	if assert.Len(t, statements, 3) {
+		assert.Equal(t, `ALTER TABLE "items" ADD COLUMN IF NOT EXISTS "added" bigint`, statements[1])
+		assert.Contains(t, statements[2], `"added"`)
+	}
+	assert.Equal(t, evolved+1, Stats().EvolvedColumns)
+
+	// column is missing in the key, not in the new row image
+	statements = nil
+	msg.Op = "d"
+	msg.Keys = map[string]interface{}{"added": "foo"}
+	_, err = applyDriftingCDCItem(context.Background(), driftingTable(&statements), Config{SchemaDrift: SchemaDriftSkip}, msg)
+	assert.Error(t, err)
+	assert.Len(t, statements, 1)
+}
+
+func TestSchemaDriftInTx(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestSchemaDriftInTx")
+	var (
+		statements []string
+		savepoints int
+	)
+	tx := mockSavepointTx{
+		MockDbTx: MockDbTx{
+			MockDbExec:      driftingTable(&statements),
+			RollbackHandler: func() error { statements = append(statements, "ROLLBACK TO SAVEPOINT"); return nil },
+		},
+		savepoints: &savepoints,
+	}
+	msg := kafka.Message{Op: "c", TableName: "items", Values: map[string]interface{}{"id": int64(1), "added": "foo"},
+		Fields: map[string]kafka.Field{"added": {Type: "int64"}}}
+	rows, err := applyDriftingCDCItem(context.Background(), tx, Config{SchemaDrift: SchemaDriftAlter}, msg)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, rows)
+	if assert.Len(t, statements, 4) {
+		assert.Equal(t, []string{"ROLLBACK TO SAVEPOINT", `ALTER TABLE "items" ADD COLUMN IF NOT EXISTS "added" bigint`},
+			statements[1:3], "the failed attempt is rolled back before altering the table")
+	}
+	assert.Equal(t, 2, savepoints, "each attempt is made in its own savepoint")
+
+	statements, savepoints = nil, 0
+	_, err = applyDriftingCDCItem(context.Background(), tx, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Zero(t, savepoints, "no savepoints unless failing items are retried")
+}
+
+func TestSchemaDriftAlterFailure(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestSchemaDriftAlterFailure")
+	conn := MockDbExec{
+		ExecHandler: func(sql string, arguments []interface{}) (pgconn.CommandTag, error) {
+			if strings.HasPrefix(sql, "ALTER TABLE") {
+				return nil, errors.New("permission denied")
+			}
+			return nil, &pgconn.PgError{Code: "42703", Message: `column "added" of relation "items" does not exist`}
+		},
+	}
+	msg := kafka.Message{Op: "u", TableName: "items", Keys: map[string]interface{}{"id": 1}, Values: map[string]interface{}{"added": "foo"}}
+	_, err := applyDriftingCDCItem(context.Background(), conn, Config{SchemaDrift: SchemaDriftAlter}, msg)
+	assert.EqualError(t, err, "permission denied")
+}
+
+func TestColumnDefinition(t *testing.T) {
+	msg := kafka.Message{
+		TableName: "items",
+		Fields: map[string]kafka.Field{
+			"i": {Type: "int32", Default: json.Number("0")},
+			"s": {Type: "string", Default: "it's"},
+			"b": {Type: "boolean", Default: false},
+			"n": {Type: "string", Optional: true},
+			"t": {Type: "int64", Name: logicalMicroTimestamp, Default: json.Number("1614837967123456")},
+			"d": {Type: "int32", Name: logicalDate, Default: json.Number("18690")},
+			"p": {Type: "bytes", Name: logicalDecimal, Parameters: map[string]string{"scale": "2"}, Default: "AQ=="},
+			"x": {Type: "bytes", Default: "AQID"},
+		},
+	}
+	assert.Equal(t, "integer DEFAULT '0'", columnDefinition(Config{}, msg, "i"))
+	assert.Equal(t, "text DEFAULT 'it''s'", columnDefinition(Config{}, msg, "s"))
+	assert.Equal(t, "boolean DEFAULT 'false'", columnDefinition(Config{}, msg, "b"))
+	assert.Equal(t, "text", columnDefinition(Config{}, msg, "n"))
+	assert.Equal(t, "timestamp DEFAULT '2021-03-04 06:06:07.123456'", columnDefinition(Config{}, msg, "t"), "epoch microseconds")
+	assert.Equal(t, "date DEFAULT '2021-03-04'", columnDefinition(Config{}, msg, "d"))
+	assert.Equal(t, "numeric DEFAULT '0.01'", columnDefinition(Config{}, msg, "p"))
+	assert.Equal(t, "bytea", columnDefinition(Config{}, msg, "x"), "binary defaults are skipped")
+}
+
+func TestColumnType(t *testing.T) {
+	msg := kafka.Message{
+		TableName: "items",
+		Fields: map[string]kafka.Field{
+			"i":     {Type: "int32"},
+			"s":     {Type: "string"},
+			"j":     {Type: "string", Name: logicalJSON},
+			"d":     {Type: "int32", Name: logicalDate},
+			"n":     {Type: "bytes", Name: logicalDecimal},
+			"g":     {Type: "struct", Name: logicalGeography},
+			"f":     {Type: "float"},
+			"a":     {Type: "array", Items: &kafka.Field{Type: "double"}},
+			"typed": {Type: "string"},
+		},
+	}
+	cfg := Config{ColumnTypes: map[string]string{"items.typed": "citext"}}
+	for column, expected := range map[string]string{
+		"i":       "integer",
+		"s":       "text",
+		"j":       "jsonb",
+		"d":       "date",
+		"n":       "numeric",
+		"g":       "bytea",
+		"f":       "real",
+		"a":       "double precision[]",
+		"typed":   "citext",
+		"unknown": "text",
+	} {
+		assert.Equal(t, expected, columnType(cfg, msg, column), column)
+	}
+	assert.Equal(t, "geography", columnType(Config{PostGIS: true}, msg, "g"))
+}
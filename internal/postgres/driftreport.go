@@ -0,0 +1,208 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+)
+
+// Kinds of differences between the Debezium schema and the target table
+const (
+	DriftMissingTable  = "missing table"  // the target table doesn't exist
+	DriftMissingColumn = "missing column" // the source column is missing in the target table
+	DriftTypeMismatch  = "type mismatch"  // the target column type can't hold all the values of the source type
+	DriftNullability   = "nullability"    // the source column is optional but the target column is NOT NULL
+)
+
+// driftedColumns counts differences between the Debezium schemas and the target tables reported by SchemaDriftReport
+var driftedColumns uint64
+
+// comparedSchemas holds the keys of the tables and their schema versions already compared with the target tables
+var comparedSchemas sync.Map
+
+// sqlDriftColumns returns whether the table named by $1 exists, names, types and NOT NULL constraints of its columns
+const sqlDriftColumns = `SELECT t.oid IS NOT NULL,
+	array(SELECT attname::text FROM pg_attribute WHERE attrelid = t.oid AND attnum > 0 AND NOT attisdropped ORDER BY attnum),
+	array(SELECT format_type(atttypid, atttypmod) FROM pg_attribute WHERE attrelid = t.oid AND attnum > 0 AND NOT attisdropped ORDER BY attnum),
+	array(SELECT attnotnull FROM pg_attribute WHERE attrelid = t.oid AND attnum > 0 AND NOT attisdropped ORDER BY attnum)
+FROM (SELECT to_regclass($1) AS oid) t`
+
+// typeAliases maps types named by format_type() to the names columnType uses
+var typeAliases = map[string]string{
+	"timestamp without time zone": "timestamp",
+	"time without time zone":      "time",
+}
+
+// SchemaDifference describes the column of the Debezium schema differing from the target table
+type SchemaDifference struct {
+	Table  string // qualified name of the target table
+	Column string // name of the target column, empty for missing tables
+	Kind   string // one of DriftMissingTable, DriftMissingColumn, DriftTypeMismatch or DriftNullability
+	Source string // type of the column the Debezium schema declares, if relevant
+	Target string // type of the target column, if relevant
+}
+
+func (d SchemaDifference) String() string {
+	if d.Column == "" {
+		return d.Table + ": " + d.Kind
+	}
+	s := d.Table + "." + d.Column + ": " + d.Kind
+	if d.Kind == DriftTypeMismatch {
+		s += fmt.Sprintf(" (%s in the source, %s in the target)", d.Source, d.Target)
+	}
+	return s
+}
+
+// schemaVersion returns the fingerprint of the Debezium schema of the CDC item, so changed schemas are compared again
+func schemaVersion(m kafka.Message) string {
+	names := make([]string, 0, len(m.Fields))
+	for c := range m.Fields {
+		names = append(names, c)
+	}
+	sort.Strings(names)
+	h := fnv.New64a()
+	for _, c := range names {
+		f := m.Fields[c]
+		fmt.Fprintf(h, "%s:%s:%s:%t:%v;", c, f.Type, f.Name, f.Optional, f.Parameters)
+	}
+	return fmt.Sprintf("%x", h.Sum64())
+}
+
+// comparesSchema returns true if the row image of the CDC item describes the columns of the target table
+func comparesSchema(m kafka.Message) bool {
+	return m.SchemaChange == nil && m.TransactionBoundary == nil && len(m.Records) == 0 &&
+		(m.Op == "c" || m.Op == "u" || m.Op == "r") && len(m.Fields) > 0
+}
+
+// reportSchemaDrift compares the Debezium schema of the CDC item with the target table once per table and schema
+// version, differences found are logged as warnings and counted in the stats. Nothing is changed in the target
+func reportSchemaDrift(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message) []SchemaDifference {
+	querier, ok := conn.(DBQuerierContext)
+	if !ok || !comparesSchema(m) {
+		return nil
+	}
+	table := m.QualifiedTablename()
+	key := table + "@" + schemaVersion(m)
+	if _, compared := comparedSchemas.Load(key); compared {
+		return nil
+	}
+	diffs, err := compareSchema(ctx, querier, cfg, m)
+	if err != nil {
+		loggerOf(cfg).WithField("table", table).WithError(err).Error("Schema drift can't be checked")
+		return nil
+	}
+	comparedSchemas.Store(key, true)
+	for _, d := range diffs {
+		loggerOf(cfg).WithField("table", d.Table).WithField("column", d.Column).WithField("kind", d.Kind).
+			WithField("source", d.Source).WithField("target", d.Target).Warning("Schema drift detected")
+	}
+	atomic.AddUint64(&driftedColumns, uint64(len(diffs)))
+	return diffs
+}
+
+// compareSchema returns the differences between the columns of the CDC item and the target table
+func compareSchema(ctx context.Context, querier DBQuerierContext, cfg Config, m kafka.Message) ([]SchemaDifference, error) {
+	var (
+		exists         bool
+		columns, types []string
+		notNull        []bool
+	)
+	table := m.QualifiedTablename()
+	if err := querier.QueryRow(ctx, sqlDriftColumns, table).Scan(&exists, &columns, &types, &notNull); err != nil {
+		return nil, classify(ErrDBExec, err)
+	}
+	if !exists {
+		return []SchemaDifference{{Table: table, Kind: DriftMissingTable}}, nil
+	}
+	targetTypes := make(map[string]string, len(columns))
+	targetNotNull := make(map[string]bool, len(columns))
+	for i, c := range columns {
+		targetTypes[c] = types[i]
+		targetNotNull[c] = i < len(notNull) && notNull[i]
+	}
+	names := make([]string, 0, len(m.Values))
+	for c := range m.Values {
+		names = append(names, c)
+	}
+	sort.Strings(names)
+	var diffs []SchemaDifference
+	for _, c := range names {
+		target, ok := targetTypes[c]
+		if !ok {
+			diffs = append(diffs, SchemaDifference{Table: table, Column: c, Kind: DriftMissingColumn, Source: columnType(cfg, m, c)})
+			continue
+		}
+		f, declared := m.Fields[c]
+		if !declared {
+			continue
+		}
+		if source := sourceType(cfg, m, c); !holdsType(target, source) {
+			diffs = append(diffs, SchemaDifference{Table: table, Column: c, Kind: DriftTypeMismatch, Source: source, Target: target})
+		}
+		if f.Optional && targetNotNull[c] {
+			diffs = append(diffs, SchemaDifference{Table: table, Column: c, Kind: DriftNullability, Source: "NULL", Target: "NOT NULL"})
+		}
+	}
+	return diffs, nil
+}
+
+// holdsType returns true if the `target` type, named as format_type() does, holds all the values of the `source` type.
+// Generic text and numeric source types hold values of any length, so they are only checked by applying them
+func holdsType(target, source string) bool {
+	target, source = strings.ToLower(target), strings.ToLower(source)
+	if alias, ok := typeAliases[target]; ok {
+		target = alias
+	}
+	switch {
+	case source == "text":
+		return true
+	case source == "numeric":
+		return target == "numeric" || reNumeric.MatchString(target)
+	}
+	return target == source || widens(source, target)
+}
+
+// ValidateSchema connects to the target database and compares the Debezium schema of the sample `messages` with the
+// target tables they are applied to, once per table and schema version. Messages are read until the channel is
+// closed, e.g. once all topics are consumed up to the end offset, or `cfg.IdleTimeout` passes without new ones.
+// Nothing is changed in the target, the differences found are logged and returned
+func ValidateSchema(ctx context.Context, connString string, cfg Config, messages <-chan kafka.Message) ([]SchemaDifference, error) {
+	conn, err := Connect(ctx, connString)
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := conn.(interface{ Close() }); ok {
+		defer c.Close()
+	}
+	if _, ok := conn.(DBQuerierContext); !ok {
+		return nil, errors.New("Target database connection doesn't support queries")
+	}
+	var diffs []SchemaDifference
+	idle := time.NewTimer(cfg.IdleTimeout)
+	defer idle.Stop()
+	for {
+		select {
+		case m, ok := <-messages:
+			if !ok {
+				return diffs, nil
+			}
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(cfg.IdleTimeout)
+			diffs = append(diffs, reportSchemaDrift(ctx, conn, cfg, prepareMessage(cfg, m))...)
+		case <-idle.C:
+			return diffs, nil
+		case <-ctx.Done():
+			return diffs, ctx.Err()
+		}
+	}
+}
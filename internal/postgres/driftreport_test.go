@@ -0,0 +1,174 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHoldsType(t *testing.T) {
+	for _, c := range []struct {
+		target, source string
+		holds          bool
+	}{
+		{"integer", "integer", true},
+		{"bigint", "integer", true},
+		{"integer", "bigint", false},
+		{"text", "text", true},
+		{"character varying(20)", "text", true},
+		{"character varying(200)", "character varying(50)", true},
+		{"character varying(50)", "character varying(200)", false},
+		{"numeric(10,2)", "numeric", true},
+		{"integer", "numeric", false},
+		{"numeric(12,2)", "numeric(10,2)", true},
+		{"timestamp without time zone", "timestamp", true},
+		{"time without time zone", "time", true},
+		{"boolean", "integer", false},
+		{"real", "double precision", false},
+		{"double precision", "real", true},
+	} {
+		assert.Equal(t, c.holds, holdsType(c.target, c.source), c.source+" -> "+c.target)
+	}
+}
+
+func driftMessage(table string, fields map[string]kafka.Field, values map[string]interface{}) kafka.Message {
+	return kafka.Message{Op: "c", SchemaName: "public", TableName: table, Fields: fields, Values: values}
+}
+
+func TestReportSchemaDrift(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	Logger = logger.WithField("method", "TestReportSchemaDrift")
+	queries := 0
+	conn := MockDbQuerier{
+		QueryRowHandler: func(sql string, args []interface{}) pgx.Row {
+			queries++
+			assert.Equal(t, sqlDriftColumns, sql)
+			if args[0] == `"public"."drift_missing"` {
+				return MockRow{Values: []interface{}{false, []string{}, []string{}, []bool{}}}
+			}
+			return MockRow{Values: []interface{}{true,
+				[]string{"id", "name", "qty", "note"},
+				[]string{"integer", "character varying(20)", "integer", "text"},
+				[]bool{true, false, false, true}}}
+		},
+	}
+	fields := map[string]kafka.Field{
+		"id":    {Type: "int32"},
+		"name":  {Type: "string", Optional: true},
+		"qty":   {Type: "int64", Optional: true},
+		"note":  {Type: "string", Optional: true},
+		"price": {Type: "double", Optional: true},
+	}
+	m := driftMessage("drift_orders", fields, map[string]interface{}{"id": 1, "name": "a", "qty": 2, "note": nil, "price": 1.5})
+	before := Stats().DriftedColumns
+	diffs := reportSchemaDrift(context.Background(), conn, Config{}, m)
+	assert.Equal(t, []SchemaDifference{
+		{Table: `"public"."drift_orders"`, Column: "note", Kind: DriftNullability, Source: "NULL", Target: "NOT NULL"},
+		{Table: `"public"."drift_orders"`, Column: "price", Kind: DriftMissingColumn, Source: "double precision"},
+		{Table: `"public"."drift_orders"`, Column: "qty", Kind: DriftTypeMismatch, Source: "bigint", Target: "integer"},
+	}, diffs)
+	assert.Equal(t, before+3, Stats().DriftedColumns)
+	assert.Len(t, hook.AllEntries(), 3)
+	assert.Equal(t, logrus.WarnLevel, hook.LastEntry().Level)
+	assert.Equal(t, "Schema drift detected", hook.LastEntry().Message)
+	assert.Equal(t, "qty", hook.LastEntry().Data["column"])
+
+	// the same schema version is compared once
+	assert.Empty(t, reportSchemaDrift(context.Background(), conn, Config{}, m))
+	assert.Equal(t, 1, queries)
+
+	// a changed schema is compared again
+	changed := driftMessage("drift_orders", map[string]kafka.Field{"id": {Type: "int32"}}, map[string]interface{}{"id": 1})
+	assert.Empty(t, reportSchemaDrift(context.Background(), conn, Config{}, changed))
+	assert.Equal(t, 2, queries)
+
+	missing := driftMessage("drift_missing", fields, map[string]interface{}{"id": 1})
+	assert.Equal(t, []SchemaDifference{{Table: `"public"."drift_missing"`, Kind: DriftMissingTable}},
+		reportSchemaDrift(context.Background(), conn, Config{}, missing))
+
+	// deletes and schema changes describe no columns
+	deleted := driftMessage("drift_deleted", fields, nil)
+	deleted.Op = "d"
+	assert.Empty(t, reportSchemaDrift(context.Background(), conn, Config{}, deleted))
+	assert.Equal(t, 3, queries)
+}
+
+func TestReportSchemaDriftFailure(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	Logger = logger.WithField("method", "TestReportSchemaDriftFailure")
+	conn := MockDbQuerier{
+		QueryRowHandler: func(sql string, args []interface{}) pgx.Row {
+			return MockRow{Err: errors.New("connection reset")}
+		},
+	}
+	m := driftMessage("drift_failing", map[string]kafka.Field{"id": {Type: "int32"}}, map[string]interface{}{"id": 1})
+	assert.Empty(t, reportSchemaDrift(context.Background(), conn, Config{}, m))
+	assert.Equal(t, logrus.ErrorLevel, hook.LastEntry().Level)
+	// failed comparisons are retried
+	hook.Reset()
+	assert.Empty(t, reportSchemaDrift(context.Background(), conn, Config{}, m))
+	assert.Equal(t, logrus.ErrorLevel, hook.LastEntry().Level)
+}
+
+func TestApplyDriftingCDCItemReport(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	Logger = logger.WithField("method", "TestApplyDriftingCDCItemReport")
+	executed := 0
+	conn := MockDbQuerier{
+		MockDbExec: MockDbExec{ExecHandler: func(sql string, args []interface{}) (pgconn.CommandTag, error) {
+			executed++
+			return pgconn.CommandTag("INSERT 0 1"), nil
+		}},
+		QueryRowHandler: func(sql string, args []interface{}) pgx.Row {
+			return MockRow{Values: []interface{}{true, []string{"id"}, []string{"integer"}, []bool{true}}}
+		},
+	}
+	m := driftMessage("drift_applied", map[string]kafka.Field{"id": {Type: "int32"}, "extra": {Type: "string", Optional: true}},
+		map[string]interface{}{"id": 1, "extra": "x"})
+	rows, err := applyDriftingCDCItem(context.Background(), conn, Config{SchemaDrift: SchemaDriftReport}, m)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), rows)
+	assert.Equal(t, 1, executed, "nothing but the CDC item is executed")
+	assert.Equal(t, "extra", hook.AllEntries()[0].Data["column"])
+}
+
+func TestValidateSchema(t *testing.T) {
+	logger, _ := test.NewNullLogger()
+	Logger = logger.WithField("method", "TestValidateSchema")
+	Connect = func(ctx context.Context, connString string) (DBExecutorContext, error) {
+		return MockDbQuerier{
+			QueryRowHandler: func(sql string, args []interface{}) pgx.Row {
+				return MockRow{Values: []interface{}{true, []string{"id"}, []string{"integer"}, []bool{true}}}
+			},
+		}, nil
+	}
+	messages := make(chan kafka.Message, 3)
+	messages <- driftMessage("validated", map[string]kafka.Field{"id": {Type: "int32"}}, map[string]interface{}{"id": 1})
+	messages <- driftMessage("validated_drift", map[string]kafka.Field{"id": {Type: "int64"}}, map[string]interface{}{"id": 1})
+	messages <- driftMessage("validated_drift", map[string]kafka.Field{"id": {Type: "int64"}}, map[string]interface{}{"id": 2})
+	close(messages)
+	diffs, err := ValidateSchema(context.Background(), "", Config{IdleTimeout: time.Second}, messages)
+	assert.NoError(t, err)
+	assert.Equal(t, []SchemaDifference{{Table: `"public"."validated_drift"`, Column: "id", Kind: DriftTypeMismatch,
+		Source: "bigint", Target: "integer"}}, diffs)
+	assert.Equal(t, `"public"."validated_drift".id: type mismatch (bigint in the source, integer in the target)`, diffs[0].String())
+
+	// no messages within the idle timeout
+	diffs, err = ValidateSchema(context.Background(), "", Config{IdleTimeout: 10 * time.Millisecond}, make(chan kafka.Message))
+	assert.NoError(t, err)
+	assert.Empty(t, diffs)
+
+	Connect = func(ctx context.Context, connString string) (DBExecutorContext, error) {
+		return nil, errors.New("connection refused")
+	}
+	_, err = ValidateSchema(context.Background(), "", Config{}, make(chan kafka.Message))
+	assert.Error(t, err)
+}
@@ -0,0 +1,71 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// sqlstateUniqueViolation is the error code PostgreSQL returns for inserts violating unique constraints
+const sqlstateUniqueViolation = "23505"
+
+// number of inserts applied as updates after failing with duplicate key during session
+var duplicateUpdates uint64
+
+// isUniqueViolation returns true if `err` is the unique constraint violation reported by PostgreSQL
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == sqlstateUniqueViolation
+}
+
+// updatesOnDuplicate returns true if inserts into the target table of the CDC item failing with duplicate key
+// are retried as updates matching the message key
+func updatesOnDuplicate(cfg Config, m kafka.Message) bool {
+	return len(m.Keys) > 0 && (cfg.UpdateOnDuplicate[m.TableName] || cfg.UpdateOnDuplicate[m.SchemaName+"."+m.TableName])
+}
+
+// execInsert executes the insert statement of the CDC item. Inserts into tables falling back to updates or creating
+// missing partitions are executed within a savepoint if `conn` is a transaction, so the transaction remains usable
+// for the update or the retry after the failure
+func execInsert(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message, sql string, args []interface{}) (pgconn.CommandTag, error) {
+	tx, ok := conn.(pgx.Tx)
+	if _, partitioned := partitionSpec(cfg, m); !ok || (!updatesOnDuplicate(cfg, m) && !partitioned) {
+		return execReturning(ctx, conn, cfg, m, sql, args)
+	}
+	return withSavepoint(ctx, tx, func(savepoint pgx.Tx) (pgconn.CommandTag, error) {
+		return execReturning(ctx, savepoint, cfg, m, sql, args)
+	})
+}
+
+// execSavepoint executes the statement within a savepoint of the transaction, which is rolled back if the statement fails
+func execSavepoint(ctx context.Context, tx pgx.Tx, sql string, args []interface{}) (pgconn.CommandTag, error) {
+	return withSavepoint(ctx, tx, func(savepoint pgx.Tx) (pgconn.CommandTag, error) {
+		return savepoint.Exec(ctx, sql, args...)
+	})
+}
+
+// withSavepoint calls `exec` within a savepoint of the transaction, which is rolled back if `exec` fails
+func withSavepoint(ctx context.Context, tx pgx.Tx, exec func(savepoint pgx.Tx) (pgconn.CommandTag, error)) (pgconn.CommandTag, error) {
+	savepoint, err := tx.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ct, err := exec(savepoint)
+	if err != nil {
+		_ = savepoint.Rollback(ctx)
+		return ct, err
+	}
+	return ct, savepoint.Commit(ctx)
+}
+
+// updateDuplicate applies the CDC item which insert failed with duplicate key as the update matching the message key.
+// Updates never fall back to inserts, so the retry happens once at most
+func updateDuplicate(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message) (int64, error) {
+	atomic.AddUint64(&duplicateUpdates, 1)
+	loggerOf(cfg).WithField("table", m.QualifiedTablename()).Debug("Duplicate key on insert, applying CDC item as update")
+	return updateCDCItem(ctx, conn, cfg, m)
+}
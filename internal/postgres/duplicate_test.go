@@ -0,0 +1,87 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockSavepointTx is the transaction starting savepoints as nested transactions
+type mockSavepointTx struct {
+	MockDbTx
+	savepoints *int
+}
+
+func (m mockSavepointTx) Begin(ctx context.Context) (pgx.Tx, error) {
+	*m.savepoints++
+	return m, nil
+}
+
+func TestUpdateOnDuplicate(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestUpdateOnDuplicate")
+	var (
+		statements []string
+		updateErr  error
+	)
+	exec := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			statements = append(statements, s)
+			if strings.HasPrefix(s, "INSERT") {
+				return nil, &pgconn.PgError{Code: "23505", Message: `duplicate key value violates unique constraint "m_pkey"`}
+			}
+			return pgconn.CommandTag("UPDATE 1"), updateErr
+		},
+	}
+	msg := kafka.Message{
+		Op:         "c",
+		SchemaName: "public",
+		TableName:  "measurements",
+		Keys:       map[string]interface{}{"id": 1},
+		Values:     map[string]interface{}{"id": 1},
+	}
+	cfg := Config{UpdateOnDuplicate: map[string]bool{"public.measurements": true}}
+	fallbacks := Stats().DuplicateUpdates
+	rowsAffected, err := applyCDCItem(context.Background(), exec, cfg, msg)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, rowsAffected)
+	assert.Equal(t, []string{`INSERT INTO "public"."measurements"("id") VALUES ($1)`,
+		`UPDATE "public"."measurements" SET ("id")=($2) WHERE ("id")=($1)`}, statements)
+	assert.Equal(t, fallbacks+1, Stats().DuplicateUpdates)
+
+	// failing update is reported without retrying the insert
+	statements = nil
+	updateErr = errors.New("deadlock detected")
+	_, err = applyCDCItem(context.Background(), exec, cfg, msg)
+	assert.EqualError(t, err, "deadlock detected")
+	assert.Len(t, statements, 2)
+	assert.Equal(t, fallbacks+2, Stats().DuplicateUpdates)
+
+	// inserts into transactions use savepoints
+	statements, updateErr = nil, nil
+	var savepoints int
+	tx := mockSavepointTx{MockDbTx: MockDbTx{MockDbExec: exec}, savepoints: &savepoints}
+	_, err = applyCDCItem(context.Background(), tx, cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, savepoints)
+	assert.Len(t, statements, 2)
+
+	// other tables and messages without the key fail
+	statements = nil
+	msg.TableName = "events"
+	_, err = applyCDCItem(context.Background(), exec, cfg, msg)
+	assert.True(t, isUniqueViolation(err))
+	assert.True(t, errors.Is(err, ErrDBExec))
+	assert.Len(t, statements, 1)
+
+	msg.TableName, msg.Keys = "measurements", nil
+	_, err = applyCDCItem(context.Background(), exec, cfg, msg)
+	assert.True(t, isUniqueViolation(err))
+	assert.Equal(t, fallbacks+3, Stats().DuplicateUpdates)
+}
@@ -0,0 +1,46 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+)
+
+const (
+	// ErrorPolicySkip logs CDC items failing to apply and goes on, it's the default
+	ErrorPolicySkip = "skip"
+	// ErrorPolicyDLQ passes CDC items failing to apply to the dead-letter queue and goes on
+	ErrorPolicyDLQ = "dlq"
+	// ErrorPolicyHalt stops applying at the first CDC item failing to apply, offsets of it and later items aren't saved
+	ErrorPolicyHalt = "halt"
+)
+
+// isolatedFailures counts CDC items of batches rolled back to their savepoints
+var isolatedFailures uint64
+
+// failedItem is the CDC item of the batch rolled back to its savepoint together with the error
+type failedItem struct {
+	message kafka.Message
+	err     error
+}
+
+// handleFailure handles the CDC item failed to apply with `err` according to `cfg.ErrorPolicy`, returns true if
+// applying must stop. Items with unsupported operation are passed to the dead-letter queue whatever the policy
+func handleFailure(ctx context.Context, cfg Config, m kafka.Message, err error) bool {
+	switch {
+	case errors.Is(err, ErrUnsupportedOp):
+		atomic.AddUint64(&unsupportedOps, 1)
+		sendDeadLetter(ctx, cfg, m, err)
+	case cfg.ErrorPolicy == ErrorPolicyHalt:
+		loggerOf(cfg).WithError(err).WithField("topic", m.Topic).WithField("offset", m.Offset).
+			Error("CDC item failed, applying stopped")
+		return true
+	case cfg.ErrorPolicy == ErrorPolicyDLQ:
+		sendDeadLetter(ctx, cfg, m, err)
+	default:
+		loggerOf(cfg).Error(err)
+	}
+	return false
+}
@@ -0,0 +1,40 @@
+package postgres
+
+import "errors"
+
+// Kinds of failures applying CDC items, use errors.Is to classify the error, e.g. to decide between retrying
+// and sending the message to the dead-letter queue. The underlying cause is available using errors.As
+var (
+	ErrPayloadDecode = errors.New("Cannot decode CDC value")
+	ErrMissingField  = errors.New("Required CDC field is missing")
+	ErrDBExec        = errors.New("Cannot execute statement")
+	ErrUnsupportedOp = errors.New("Unsupported operation")
+	ErrRowMissing    = errors.New("Row to change is missing")
+)
+
+// applyError wraps the `cause` of the failure with its `kind`, error message is the one of the cause
+type applyError struct {
+	kind  error
+	cause error
+}
+
+func (e *applyError) Error() string {
+	return e.cause.Error()
+}
+
+func (e *applyError) Unwrap() error {
+	return e.cause
+}
+
+func (e *applyError) Is(target error) bool {
+	return target == e.kind
+}
+
+// classify wraps non-nil `err` with the failure `kind` unless it's classified already
+func classify(kind error, err error) error {
+	var classified *applyError
+	if err == nil || errors.As(err, &classified) {
+		return err
+	}
+	return &applyError{kind: kind, cause: err}
+}
@@ -0,0 +1,56 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyErrors(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestApplyErrors")
+	pgErr := &pgconn.PgError{Code: "23505", Message: "duplicate key value violates unique constraint"}
+	conn := MockDbExec{
+		ExecHandler: func(string, []interface{}) (pgconn.CommandTag, error) {
+			return nil, pgErr
+		},
+	}
+	kinds := []error{ErrPayloadDecode, ErrMissingField, ErrDBExec, ErrUnsupportedOp}
+	assertKind := func(err error, kind error) {
+		for _, k := range kinds {
+			assert.Equal(t, k == kind, errors.Is(err, k), "%v is %v", err, k)
+		}
+	}
+
+	_, err := applyCDCItem(context.Background(), conn, Config{}, kafka.Message{Op: "c", TableName: "t", Values: map[string]interface{}{"id": 1}})
+	assertKind(err, ErrDBExec)
+	var cause *pgconn.PgError
+	if assert.True(t, errors.As(err, &cause)) {
+		assert.Equal(t, "23505", cause.Code)
+	}
+	assert.Equal(t, pgErr.Error(), err.Error())
+
+	_, err = applyCDCItem(context.Background(), conn, Config{}, kafka.Message{
+		Op:        "c",
+		TableName: "t",
+		Values:    map[string]interface{}{"data": "not base64!"},
+		Fields:    map[string]kafka.Field{"data": {Type: "bytes"}},
+	})
+	assertKind(err, ErrPayloadDecode)
+
+	_, err = applyCDCItem(context.Background(), conn, Config{}, kafka.Message{Op: "d", TableName: "t"})
+	assertKind(err, ErrMissingField)
+
+	_, err = applyCDCItem(context.Background(), conn, Config{}, kafka.Message{Op: "x", TableName: "t"})
+	assertKind(err, ErrUnsupportedOp)
+
+	_, err = applyCDCItem(context.Background(), conn, Config{AppendMode: true}, kafka.Message{Op: "c", TableName: "t"})
+	assertKind(err, ErrDBExec)
+
+	assert.Nil(t, classify(ErrDBExec, nil))
+	assert.True(t, errors.Is(classify(ErrDBExec, classify(ErrPayloadDecode, pgErr)), ErrPayloadDecode), "classified once")
+}
@@ -0,0 +1,127 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// expressionColumns returns names of the columns of the target table of the CDC item computed by the expressions
+// configured in `cfg.ColumnExpressions` in stable order
+func expressionColumns(cfg Config, m kafka.Message) []string {
+	var columns []string
+	qualified, unqualified := m.SchemaName+"."+m.TableName+".", m.TableName+"."
+	for k := range cfg.ColumnExpressions {
+		switch {
+		case strings.HasPrefix(k, qualified):
+			columns = append(columns, strings.TrimPrefix(k, qualified))
+		case strings.HasPrefix(k, unqualified) && !strings.Contains(strings.TrimPrefix(k, unqualified), "."):
+			column := strings.TrimPrefix(k, unqualified)
+			if _, ok := cfg.ColumnExpressions[qualified+column]; !ok {
+				columns = append(columns, column)
+			}
+		}
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// referencedColumns returns names of the columns the column expression references
+func referencedColumns(expr string) []string {
+	var columns []string
+	for _, m := range reColumnReference.FindAllStringSubmatch(expr, -1) {
+		columns = append(columns, m[1]+m[2])
+	}
+	return columns
+}
+
+// isExpressionField returns true if the field of the CDC item is only referenced by column expressions and isn't
+// copied to the column of its own
+func isExpressionField(cfg Config, m kafka.Message, field string) bool {
+	return hasColumn(cfg.ExpressionFields, m, field)
+}
+
+// sqlTableColumns checks the table named by $1 exists and returns names and types of its columns
+const sqlTableColumns = `SELECT t.oid IS NOT NULL,
+	array(SELECT attname::text FROM pg_attribute WHERE attrelid = t.oid AND attnum > 0 AND NOT attisdropped ORDER BY attnum),
+	array(SELECT format_type(atttypid, atttypmod) FROM pg_attribute WHERE attrelid = t.oid AND attnum > 0 AND NOT attisdropped ORDER BY attnum)
+FROM (SELECT to_regclass($1) AS oid) t`
+
+// CheckColumnExpressions connects to the target database and checks the column `expressions`, keyed by "table.column"
+// or "schema.table.column", compute values of existing columns by preparing them. Referenced fields missing among
+// columns of the table are consumed only by the expressions, they are returned keyed the same way to be used as
+// Config.ExpressionFields. All the problems found are reported in the returned error
+func CheckColumnExpressions(ctx context.Context, connString string, expressions map[string]string) (map[string]bool, error) {
+	var keys []string
+	for k := range expressions {
+		if !strings.Contains(k, ".") {
+			return nil, fmt.Errorf("Invalid column expression %q, table.column expected", k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	conn, err := Connect(ctx, connString)
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := conn.(interface{ Close() }); ok {
+		defer c.Close()
+	}
+	querier, ok := conn.(DBQuerierContext)
+	if !ok {
+		return nil, errors.New("Target database connection doesn't support queries")
+	}
+	var problems []string
+	fields := make(map[string]bool)
+	for _, k := range keys {
+		i := strings.LastIndex(k, ".")
+		table, column := k[:i], k[i+1:]
+		var (
+			exists         bool
+			columns, types []string
+		)
+		name := pgx.Identifier(strings.Split(table, ".")).Sanitize()
+		if err := querier.QueryRow(ctx, sqlTableColumns, name).Scan(&exists, &columns, &types); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", table, err))
+			continue
+		}
+		if !exists {
+			problems = append(problems, table+": table does not exist")
+			continue
+		}
+		columnTypes := make(map[string]string, len(columns))
+		for j, c := range columns {
+			columnTypes[c] = types[j]
+		}
+		if _, ok := columnTypes[column]; !ok {
+			problems = append(problems, fmt.Sprintf("%s: computed column %q does not exist", table, column))
+			continue
+		}
+		// columns of the table are referenced as parameters of their types, types of other fields are inferred
+		bound := make(map[string]string)
+		params := placeholderBuilder{}
+		for _, f := range referencedColumns(expressions[k]) {
+			if _, seen := bound[f]; seen {
+				continue
+			}
+			bound[f] = placeholder(params.next(), columnTypes[f])
+			if _, ok := columnTypes[f]; !ok {
+				fields[table+"."+f] = true
+			}
+		}
+		expanded, _ := expandExpression(expressions[k], bound)
+		sql := fmt.Sprintf("PREPARE dbz2pg_expression AS SELECT (%s)::%s; DEALLOCATE dbz2pg_expression", expanded, columnTypes[column])
+		if _, err := conn.Exec(ctx, sql); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: expression of column %q is invalid: %v", table, column, err))
+		}
+	}
+	if len(problems) > 0 {
+		return nil, errors.New("Column expressions check failed: " + strings.Join(problems, "; "))
+	}
+	return fields, nil
+}
@@ -0,0 +1,98 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBindRowExpressions(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestBindRowExpressions")
+	cfg := Config{
+		ColumnExpressions: map[string]string{
+			"people.full_name":    "$first_name || ' ' || $last_name",
+			"places.geom":         "ST_SetSRID(ST_MakePoint($lon, $lat), 4326)",
+			"public.places.label": "$name || '!'",
+			"places.label":        "$name",
+		},
+		ExpressionFields: map[string]bool{"places.lon": true, "places.lat": true},
+	}
+	people := kafka.Message{TableName: "people", Values: map[string]interface{}{"first_name": "Ada", "last_name": "Lovelace"}}
+	fields, refs, args, err := bindRow(cfg, people, people.Values, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`"first_name"`, `"last_name"`, `"full_name"`}, fields, "columns missing in the row are computed")
+	assert.Equal(t, []string{"$1", "$2", "$1 || ' ' || $2"}, refs)
+	assert.Equal(t, []interface{}{"Ada", "Lovelace"}, args)
+
+	// fields consumed only by expressions are not copied
+	places := kafka.Message{TableName: "places", SchemaName: "public", Values: map[string]interface{}{"lat": 48.2, "lon": 16.37, "name": "Vienna"}}
+	fields, refs, args, err = bindRow(cfg, places, places.Values, []interface{}{"key"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`"name"`, `"geom"`, `"label"`}, fields)
+	assert.Equal(t, []string{"$2", "ST_SetSRID(ST_MakePoint($3, $4), 4326)", "$2 || '!'"}, refs)
+	assert.Equal(t, []interface{}{"key", "Vienna", 16.37, 48.2}, args)
+
+	// expressions referencing missing fields are skipped without binding any of them
+	delete(places.Values, "lat")
+	fields, refs, args, err = bindRow(cfg, places, places.Values, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`"name"`, `"label"`}, fields)
+	assert.Equal(t, []string{"$1", "$1 || '!'"}, refs, "the expression of the qualified table applies")
+	assert.Equal(t, []interface{}{"Vienna"}, args)
+}
+
+func TestCheckColumnExpressions(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestCheckColumnExpressions")
+	rows := map[string]MockRow{
+		`"people"`: {Values: []interface{}{true, []string{"id", "first_name", "last_name", "full_name"},
+			[]string{"integer", "text", "text", "text"}}},
+		`"public"."places"`: {Values: []interface{}{true, []string{"id", "name", "geom"}, []string{"integer", "text", "geometry"}}},
+		`"missing"`:         {Values: []interface{}{false, []string{}, []string{}}},
+		`"broken"`:          {Err: errors.New("connection reset")},
+	}
+	var statements []string
+	Connect = func(ctx context.Context, connString string) (DBExecutorContext, error) {
+		return MockDbQuerier{
+			MockDbExec: MockDbExec{ExecHandler: func(sql string, arguments []interface{}) (pgconn.CommandTag, error) {
+				statements = append(statements, sql)
+				if strings.Contains(sql, "nosuchfunc") {
+					return nil, &pgconn.PgError{Severity: "ERROR", Code: "42883", Message: "function nosuchfunc(text) does not exist"}
+				}
+				return pgconn.CommandTag("DEALLOCATE"), nil
+			}},
+			QueryRowHandler: func(sql string, args []interface{}) pgx.Row {
+				return rows[args[0].(string)]
+			},
+		}, nil
+	}
+	fields, err := CheckColumnExpressions(context.Background(), "foo", map[string]string{
+		"people.full_name":   "$first_name || ' ' || ${last_name}",
+		"public.places.geom": "ST_SetSRID(ST_MakePoint($lon, $lat), 4326)",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"public.places.lon": true, "public.places.lat": true}, fields)
+	assert.Equal(t, []string{
+		`PREPARE dbz2pg_expression AS SELECT ($1::text || ' ' || $2::text)::text; DEALLOCATE dbz2pg_expression`,
+		`PREPARE dbz2pg_expression AS SELECT (ST_SetSRID(ST_MakePoint($1, $2), 4326))::geometry; DEALLOCATE dbz2pg_expression`,
+	}, statements)
+
+	_, err = CheckColumnExpressions(context.Background(), "foo", map[string]string{
+		"broken.a":         "$b",
+		"missing.a":        "$b",
+		"people.full_name": "nosuchfunc($first_name)",
+		"people.nick":      "$first_name",
+	})
+	assert.EqualError(t, err, "Column expressions check failed: broken: connection reset; missing: table does not exist; "+
+		"people: expression of column \"full_name\" is invalid: ERROR: function nosuchfunc(text) does not exist (SQLSTATE 42883); "+
+		"people: computed column \"nick\" does not exist")
+
+	_, err = CheckColumnExpressions(context.Background(), "foo", map[string]string{"id": "1"})
+	assert.Error(t, err, "table is missing")
+}
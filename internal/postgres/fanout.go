@@ -0,0 +1,95 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+)
+
+// TargetSpec describes the target table changes of the source table are written to
+type TargetSpec struct {
+	Schema  string       // schema of the target table, empty string keeps the source one
+	Table   string       // name of the target table
+	Columns ColumnMapper // maps source columns to the target ones, empty name skips the column. Nil keeps all columns
+}
+
+// FanOut returns the target tables changes of the source table are written to, none means the source table itself
+type FanOut func(schema, table string) []TargetSpec
+
+// fanOutTargets returns the target tables of the CDC item if it's fanned out
+func fanOutTargets(cfg Config, m kafka.Message) []TargetSpec {
+	if cfg.FanOut == nil || m.SchemaChange != nil || m.TransactionBoundary != nil {
+		return nil
+	}
+	return cfg.FanOut(m.SchemaName, m.TableName)
+}
+
+// applyFanOut applies the CDC item to each of the `targets` in a single transaction if the target database supports
+// transactions. Returns the total number of rows affected
+func applyFanOut(ctx context.Context, conn DBExecutorContext, cfg Config, message kafka.Message, targets []TargetSpec) (int64, error) {
+	// targets are applied as is, so they are not fanned out again
+	cfg.FanOut = nil
+	transactor, ok := conn.(DBTransactor)
+	if !ok {
+		return applyTargets(ctx, conn, cfg, message, targets)
+	}
+	tx, err := transactor.Begin(ctx)
+	if err != nil {
+		return 0, classify(ErrDBExec, err)
+	}
+	rowsAffected, err := applyTargets(ctx, tx, cfg, message, targets)
+	if err != nil {
+		_ = tx.Rollback(ctx)
+		return rowsAffected, err
+	}
+	return rowsAffected, classify(ErrDBExec, tx.Commit(ctx))
+}
+
+// applyTargets applies the CDC item to each of the `targets`, stops at the first failing one
+func applyTargets(ctx context.Context, conn DBExecutorContext, cfg Config, message kafka.Message, targets []TargetSpec) (int64, error) {
+	var total int64
+	for _, target := range targets {
+		rowsAffected, err := applyCDCItem(ctx, conn, cfg, targetMessage(message, target))
+		total += rowsAffected
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// targetMessage returns the CDC item written to the `target` table with columns mapped by its mapper
+func targetMessage(message kafka.Message, target TargetSpec) kafka.Message {
+	if target.Schema > "" {
+		message.SchemaName = target.Schema
+	}
+	message.TableName = target.Table
+	if target.Columns == nil {
+		return message
+	}
+	mapRow := func(row map[string]interface{}) map[string]interface{} {
+		if row == nil {
+			return nil
+		}
+		mapped := make(map[string]interface{}, len(row))
+		for k, v := range row {
+			if dst := target.Columns(k); dst > "" {
+				mapped[dst] = v
+			}
+		}
+		return mapped
+	}
+	message.Keys = mapRow(message.Keys)
+	message.Values = mapRow(message.Values)
+	message.Before = mapRow(message.Before)
+	if message.Fields != nil {
+		fields := make(map[string]kafka.Field, len(message.Fields))
+		for k, f := range message.Fields {
+			if dst := target.Columns(k); dst > "" {
+				fields[dst] = f
+			}
+		}
+		message.Fields = fields
+	}
+	return message
+}
@@ -0,0 +1,68 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/cybertec-postgresql/debezium2postgres/internal/postgres/postgrestest"
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyFanOut(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestApplyFanOut")
+	exec := postgrestest.NewMemExecutor(1)
+	cfg := Config{FanOut: func(schema, table string) []TargetSpec {
+		if table != "orders" {
+			return nil
+		}
+		return []TargetSpec{
+			{Table: "order_totals", Columns: func(src string) string {
+				switch src {
+				case "id":
+					return "order_id"
+				case "total":
+					return src
+				}
+				return ""
+			}},
+			{Schema: "audit", Table: "order_customers", Columns: func(src string) string {
+				if src == "total" {
+					return ""
+				}
+				return src
+			}},
+		}
+	}}
+	m := kafka.Message{Op: "c", SchemaName: "public", TableName: "orders",
+		Keys:   map[string]interface{}{"id": 1},
+		Values: map[string]interface{}{"id": 1, "total": 10, "customer": "c1"},
+	}
+	rowsAffected, err := applyCDCItem(context.Background(), exec, cfg, m)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, rowsAffected)
+	exec.AssertSQL(t,
+		`INSERT INTO "public"."order_totals"("order_id","total") VALUES ($1,$2)`,
+		`INSERT INTO "audit"."order_customers"("customer","id") VALUES ($1,$2)`,
+	)
+
+	exec.Reset()
+	var commits int
+	tx := MockDbExec{ExecHandler: func(sql string, a []interface{}) (pgconn.CommandTag, error) {
+		return exec.Exec(context.Background(), sql, a...)
+	}}
+	conn := MockDbTransactor{Tx: MockDbTx{MockDbExec: tx, CommitHandler: func() error { commits++; return nil }}}
+	_, err = applyCDCItem(context.Background(), conn, cfg, m)
+	assert.NoError(t, err)
+	assert.Len(t, exec.Statements(), 2)
+	assert.Equal(t, 1, commits, "targets are written in a single transaction")
+
+	exec.Reset()
+	m.TableName = "customers"
+	_, err = applyCDCItem(context.Background(), exec, cfg, m)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`INSERT INTO "public"."customers"("customer","id","total") VALUES ($1,$2,$3)`}, exec.SQL(), "not fanned out")
+	assert.False(t, isMultiRowInsert(cfg, kafka.Message{Op: "c", TableName: "orders", Values: m.Values}))
+}
@@ -0,0 +1,60 @@
+package postgres
+
+import (
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+)
+
+// flattenStructs returns the CDC item with struct columns listed in `cfg.FlattenStructs` replaced by a column
+// per attribute named `<column>_<attribute>`. A NULL struct sets all the attribute columns to NULL
+func flattenStructs(cfg Config, message kafka.Message) kafka.Message {
+	if len(cfg.FlattenStructs) == 0 {
+		return message
+	}
+	var flattened []string
+	for column, f := range message.Fields {
+		if f.Type == "struct" && len(f.Fields) > 0 && hasColumn(cfg.FlattenStructs, message, column) {
+			flattened = append(flattened, column)
+		}
+	}
+	if len(flattened) == 0 {
+		return message
+	}
+	fields := make(map[string]kafka.Field, len(message.Fields))
+	for k, f := range message.Fields {
+		fields[k] = f
+	}
+	message.Keys = flattenRow(message.Keys, message.Fields, flattened)
+	message.Values = flattenRow(message.Values, message.Fields, flattened)
+	message.Before = flattenRow(message.Before, message.Fields, flattened)
+	for _, column := range flattened {
+		for _, attr := range fields[column].Fields {
+			fields[column+"_"+attr.Attribute] = attr
+		}
+		delete(fields, column)
+	}
+	message.Fields = fields
+	return message
+}
+
+// flattenRow returns the copy of the row image with the `columns` replaced by their attributes
+func flattenRow(row map[string]interface{}, fields map[string]kafka.Field, columns []string) map[string]interface{} {
+	if row == nil {
+		return nil
+	}
+	flat := make(map[string]interface{}, len(row))
+	for k, v := range row {
+		flat[k] = v
+	}
+	for _, column := range columns {
+		v, ok := flat[column]
+		if !ok {
+			continue
+		}
+		delete(flat, column)
+		attrs, _ := v.(map[string]interface{})
+		for _, attr := range fields[column].Fields {
+			flat[column+"_"+attr.Attribute] = attrs[attr.Attribute]
+		}
+	}
+	return flat
+}
@@ -0,0 +1,37 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlattenStructs(t *testing.T) {
+	address := kafka.Field{Type: "struct", Optional: true, Fields: []kafka.Field{
+		{Type: "string", Optional: true, Attribute: "street"},
+		{Type: "int32", Optional: true, Attribute: "zip"},
+	}}
+	msg := kafka.Message{
+		TableName: "customers",
+		Keys:      map[string]interface{}{"id": int64(1)},
+		Values:    map[string]interface{}{"id": int64(1), "address": map[string]interface{}{"street": "Main St", "zip": nil}},
+		Fields:    map[string]kafka.Field{"id": {Type: "int64"}, "address": address},
+	}
+	assert.Equal(t, msg, flattenStructs(Config{}, msg))
+	assert.Equal(t, msg, flattenStructs(Config{FlattenStructs: map[string]bool{"orders.address": true}}, msg))
+
+	cfg := Config{FlattenStructs: map[string]bool{"customers.address": true}}
+	flat := flattenStructs(cfg, msg)
+	assert.Equal(t, map[string]interface{}{"id": int64(1), "address_street": "Main St", "address_zip": nil}, flat.Values)
+	assert.Equal(t, msg.Keys, flat.Keys)
+	assert.Nil(t, flat.Before)
+	assert.Equal(t, "int32", flat.Fields["address_zip"].Type)
+	assert.NotContains(t, flat.Fields, "address")
+	assert.Contains(t, msg.Values, "address", "original message must not be modified")
+
+	// NULL struct sets all attribute columns to NULL
+	msg.Values = map[string]interface{}{"id": int64(1), "address": nil}
+	flat = flattenStructs(cfg, msg)
+	assert.Equal(t, map[string]interface{}{"id": int64(1), "address_street": nil, "address_zip": nil}, flat.Values)
+}
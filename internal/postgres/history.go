@@ -0,0 +1,151 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+)
+
+// Default columns of the history tables
+const (
+	HistoryValidFrom = "valid_from"
+	HistoryValidTo   = "valid_to"
+)
+
+// HistorySpec describes the table keeping all versions of the rows as the slowly changing dimension of type 2.
+// Inserts add the current version, updates close the current version and add the new one, deletes close it
+type HistorySpec struct {
+	ValidFrom string // column holding the source time the version became current, HistoryValidFrom by default
+	ValidTo   string // column holding the source time the version was superseded, NULL for the current version. HistoryValidTo by default
+}
+
+// ParseHistoryTable parses the history table in the table[:valid_from:valid_to] form, e.g. public.customers
+func ParseHistoryTable(s string) (string, HistorySpec, error) {
+	parts := strings.Split(s, ":")
+	switch {
+	case len(parts) == 1 && parts[0] > "":
+		return parts[0], HistorySpec{}, nil
+	case len(parts) == 3 && parts[0] > "" && parts[1] > "" && parts[2] > "":
+		return parts[0], HistorySpec{ValidFrom: parts[1], ValidTo: parts[2]}, nil
+	}
+	return "", HistorySpec{}, fmt.Errorf("Invalid history table %q, table[:valid_from:valid_to] expected", s)
+}
+
+// historySpec returns the spec of the history table the CDC item changes, if it's one, with the default columns applied
+func historySpec(cfg Config, m kafka.Message) (HistorySpec, bool) {
+	spec, ok := cfg.HistoryTables[m.SchemaName+"."+m.TableName]
+	if !ok {
+		spec, ok = cfg.HistoryTables[m.TableName]
+	}
+	if spec.ValidFrom == "" {
+		spec.ValidFrom = HistoryValidFrom
+	}
+	if spec.ValidTo == "" {
+		spec.ValidTo = HistoryValidTo
+	}
+	return spec, ok
+}
+
+// isHistoryTable returns true if the CDC item changes the history table
+func isHistoryTable(cfg Config, m kafka.Message) bool {
+	_, ok := historySpec(cfg, m)
+	return ok
+}
+
+// applyHistory applies the CDC item to the history table using the source time of the change as the effective time.
+// Closing the current version and adding the new one happen in a single transaction if the target supports them
+func applyHistory(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message, spec HistorySpec) (int64, error) {
+	if m.Timestamp.IsZero() {
+		return 0, classify(ErrMissingField, errors.New("Source time of the change is required to apply it to history table"))
+	}
+	switch m.Op {
+	case "c":
+		return insertVersion(ctx, conn, cfg, m, spec)
+	case "d":
+		return closeVersion(ctx, conn, cfg, m, spec)
+	}
+	transactor, ok := conn.(DBTransactor)
+	if !ok {
+		return updateVersion(ctx, conn, cfg, m, spec)
+	}
+	tx, err := transactor.Begin(ctx)
+	if err != nil {
+		return 0, classify(ErrDBExec, err)
+	}
+	rowsAffected, err := updateVersion(ctx, tx, cfg, m, spec)
+	if err != nil {
+		_ = tx.Rollback(ctx)
+		return rowsAffected, err
+	}
+	return rowsAffected, classify(ErrDBExec, tx.Commit(ctx))
+}
+
+// updateVersion closes the current version of the updated row and adds the new one
+func updateVersion(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message, spec HistorySpec) (int64, error) {
+	closed, err := closeVersion(ctx, conn, cfg, m, spec)
+	if err != nil {
+		return closed, err
+	}
+	inserted, err := insertVersion(ctx, conn, cfg, m, spec)
+	return closed + inserted, err
+}
+
+// insertVersion adds the new row image as the current version valid from the source time of the change
+func insertVersion(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message, spec HistorySpec) (int64, error) {
+	if len(m.Values) == 0 {
+		return 0, classify(ErrMissingField, errors.New("New row image is required to add row version"))
+	}
+	row := make(map[string]interface{}, len(m.Values)+1)
+	for f, v := range omitNullDefaults(cfg, m, m.Values) {
+		row[f] = v
+	}
+	row[spec.ValidFrom] = m.Timestamp.UTC()
+	fields, refs, args, err := bindRow(cfg, m, row, make([]interface{}, 0, len(row)))
+	if err != nil {
+		return 0, err
+	}
+	sql := fmt.Sprintf("INSERT INTO %s(%s) VALUES (%s)",
+		m.QualifiedTablename(),
+		strings.Join(fields, ","),
+		strings.Join(refs, ","))
+	ct, err := conn.Exec(ctx, sql, args...)
+	atomic.AddUint64(&tx, 1)
+	return ct.RowsAffected(), classify(ErrDBExec, err)
+}
+
+// closeVersion sets the end of the current version of the row matching the key to the source time of the change
+func closeVersion(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message, spec HistorySpec) (int64, error) {
+	keys := m.Keys
+	if len(keys) == 0 {
+		keys = m.Before
+	}
+	if len(keys) == 0 {
+		return 0, classify(ErrMissingField, errors.New("Neither key nor old row image available to match current row version"))
+	}
+	args := []interface{}{m.Timestamp.UTC()}
+	refs := make([]string, 0, len(keys))
+	fields := make([]string, 0, len(keys))
+	for f, v := range keys {
+		arg, field, ref, err := bindKey(cfg, m, f, v, len(args)+1)
+		if err != nil {
+			return 0, err
+		}
+		fields = append(fields, field)
+		args = append(args, arg)
+		refs = append(refs, ref)
+	}
+	validTo := strconv.Quote(spec.ValidTo)
+	sql := fmt.Sprintf("UPDATE %s SET %s=$1 WHERE %s AND %s IS NULL",
+		m.QualifiedTablename(),
+		validTo,
+		matchRow(fields, refs, args[1:]),
+		validTo)
+	ct, err := conn.Exec(ctx, sql, args...)
+	atomic.AddUint64(&tx, 1)
+	return ct.RowsAffected(), classify(ErrDBExec, err)
+}
@@ -0,0 +1,87 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHistoryTable(t *testing.T) {
+	table, spec, err := ParseHistoryTable("public.customers")
+	assert.NoError(t, err)
+	assert.Equal(t, "public.customers", table)
+	assert.Equal(t, HistorySpec{}, spec)
+
+	table, spec, err = ParseHistoryTable("customers:since:until")
+	assert.NoError(t, err)
+	assert.Equal(t, "customers", table)
+	assert.Equal(t, HistorySpec{ValidFrom: "since", ValidTo: "until"}, spec)
+
+	for _, s := range []string{"", "customers:since", "customers::until", ":since:until"} {
+		_, _, err = ParseHistoryTable(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestApplyHistory(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestApplyHistory")
+	var (
+		sqls []string
+		args [][]interface{}
+	)
+	exec := MockDbExec{ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+		sqls, args = append(sqls, s), append(args, a)
+		return pgconn.CommandTag("UPDATE 1"), nil
+	}}
+	ts := time.Date(2021, 3, 4, 6, 6, 7, 0, time.FixedZone("CET", 3600))
+	utc := ts.UTC()
+	cfg := Config{HistoryTables: map[string]HistorySpec{"public.customers": {}}}
+	m := kafka.Message{Op: "c", SchemaName: "public", TableName: "customers",
+		Keys:      map[string]interface{}{"id": int64(1)},
+		Values:    map[string]interface{}{"id": int64(1), "name": "foo"},
+		Timestamp: ts,
+	}
+	ctx := context.Background()
+
+	_, err := applyCDCItem(ctx, exec, cfg, m)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`INSERT INTO "public"."customers"("id","name","valid_from") VALUES ($1,$2,$3)`}, sqls)
+	assert.Equal(t, []interface{}{int64(1), "foo", utc}, args[0])
+
+	sqls, args = nil, nil
+	var commits int
+	conn := MockDbTransactor{Tx: MockDbTx{MockDbExec: exec, CommitHandler: func() error { commits++; return nil }}}
+	m.Op = "u"
+	m.Values = map[string]interface{}{"id": int64(1), "name": "bar"}
+	rowsAffected, err := applyCDCItem(ctx, conn, cfg, m)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, rowsAffected)
+	assert.Equal(t, []string{
+		`UPDATE "public"."customers" SET "valid_to"=$1 WHERE ("id")=($2) AND "valid_to" IS NULL`,
+		`INSERT INTO "public"."customers"("id","name","valid_from") VALUES ($1,$2,$3)`,
+	}, sqls)
+	assert.Equal(t, []interface{}{utc, int64(1)}, args[0])
+	assert.Equal(t, 1, commits, "closed and inserted in a single transaction")
+
+	sqls, args = nil, nil
+	cfg.HistoryTables = map[string]HistorySpec{"customers": {ValidFrom: "since", ValidTo: "until"}}
+	m.Op, m.Values = "d", nil
+	_, err = applyCDCItem(ctx, exec, cfg, m)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`UPDATE "public"."customers" SET "until"=$1 WHERE ("id")=($2) AND "until" IS NULL`}, sqls)
+
+	m.Timestamp = time.Time{}
+	_, err = applyCDCItem(ctx, exec, cfg, m)
+	assert.True(t, errors.Is(err, ErrMissingField), "source time is required")
+
+	m.Op, m.Values = "c", map[string]interface{}{"id": int64(1)}
+	assert.False(t, isMultiRowInsert(cfg, m), "rows get the validity")
+	cfg.StagingTables = map[string]bool{"customers": true}
+	assert.False(t, stagesTable(cfg, m), "applied one by one")
+}
@@ -0,0 +1,32 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+)
+
+// errVetoed is returned for CDC items the BeforeApply hook skipped writing
+var errVetoed = errors.New("CDC item vetoed by BeforeApply")
+
+// hasApplyHooks returns true if the hooks are called around applying CDC items, so each item is applied separately
+func hasApplyHooks(cfg Config) bool {
+	return cfg.BeforeApply != nil || cfg.AfterApply != nil
+}
+
+// applyHooked applies the CDC item calling `cfg.BeforeApply` before and `cfg.AfterApply` after writing it. The item
+// isn't written and errVetoed is returned if BeforeApply fails, AfterApply isn't called then
+func applyHooked(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message) (int64, error) {
+	if cfg.BeforeApply != nil {
+		if err := cfg.BeforeApply(ctx, &m); err != nil {
+			return 0, fmt.Errorf("%w: %v", errVetoed, err)
+		}
+	}
+	rowsAffected, err := applyDriftingCDCItem(ctx, conn, cfg, m)
+	if cfg.AfterApply != nil {
+		cfg.AfterApply(ctx, &m, rowsAffected, err)
+	}
+	return rowsAffected, err
+}
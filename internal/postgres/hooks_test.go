@@ -0,0 +1,69 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyHooks(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestApplyHooks")
+	var events []string
+	conn := MockDbTransactor{
+		MockDbExec: MockDbExec{ExecHandler: func(sql string, arguments []interface{}) (pgconn.CommandTag, error) {
+			events = append(events, sql)
+			if arguments[0] == int64(3) {
+				return nil, errors.New("check constraint violated")
+			}
+			return pgconn.CommandTag("INSERT 0 1"), nil
+		}},
+	}
+	conn.Tx = MockDbTx{MockDbExec: conn.MockDbExec}
+	store := memoryOffsets{}
+	cfg := Config{
+		Offsets: store,
+		BeforeApply: func(ctx context.Context, m *kafka.Message) error {
+			events = append(events, fmt.Sprintf("before %d", m.Offset))
+			if m.Offset == 2 {
+				return errors.New("denied")
+			}
+			m.TableName = "audited_" + m.TableName
+			return nil
+		},
+		AfterApply: func(ctx context.Context, m *kafka.Message, rowsAffected int64, err error) {
+			events = append(events, fmt.Sprintf("after %d %s: %d %v", m.Offset, m.TableName, rowsAffected, err))
+		},
+	}
+	for offset := int64(1); offset <= 3; offset++ {
+		applyMessage(context.Background(), conn, cfg, offsetMessage("foo", offset, "c"))
+	}
+	assert.Equal(t, []string{
+		"before 1",
+		`INSERT INTO "audited_t"("id") VALUES ($1)`,
+		"after 1 audited_t: 1 <nil>",
+		"before 2",
+		"before 3",
+		`INSERT INTO "audited_t"("id") VALUES ($1)`,
+		"after 3 audited_t: 0 check constraint violated",
+	}, events, "vetoed item is neither written nor passed to AfterApply")
+	assert.Equal(t, memoryOffsets{"foo": 2}, store, "vetoed item is skipped")
+
+	// items of batches are applied one by one
+	events = nil
+	applyBatch(context.Background(), conn, cfg, []kafka.Message{offsetMessage("foo", 4, "c"), offsetMessage("foo", 5, "c")})
+	assert.Equal(t, []string{
+		"before 4",
+		`INSERT INTO "audited_t"("id") VALUES ($1)`,
+		"after 4 audited_t: 1 <nil>",
+		"before 5",
+		`INSERT INTO "audited_t"("id") VALUES ($1)`,
+		"after 5 audited_t: 1 <nil>",
+	}, events)
+	assert.Equal(t, memoryOffsets{"foo": 5}, store)
+}
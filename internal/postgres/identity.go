@@ -0,0 +1,35 @@
+package postgres
+
+import "github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+
+const (
+	// ReplicaIdentityDefault is the replica identity of source tables sending only the key in the old row image
+	ReplicaIdentityDefault = "default"
+	// ReplicaIdentityFull is the replica identity of source tables sending all columns in the old row image
+	ReplicaIdentityFull = "full"
+)
+
+// replicaIdentity detects the replica identity of the source table of the CDC item from the old row image, which has
+// columns besides the key only if the table has REPLICA IDENTITY FULL
+func replicaIdentity(m kafka.Message) string {
+	for column := range m.Before {
+		if _, ok := m.Keys[column]; !ok {
+			return ReplicaIdentityFull
+		}
+	}
+	return ReplicaIdentityDefault
+}
+
+// matchedColumns returns the columns matching the updated or deleted row of the CDC item: the key, or the old row
+// image if the table has no key. With `cfg.MatchReplicaIdentity` the full old row image is matched whenever the
+// source sends it, unless key columns are configured for the table
+func matchedColumns(cfg Config, m kafka.Message) map[string]interface{} {
+	if len(m.Keys) == 0 {
+		return m.Before
+	}
+	if cfg.MatchReplicaIdentity && replicaIdentity(m) == ReplicaIdentityFull && len(keyColumns(cfg, m)) == 0 {
+		loggerOf(cfg).WithField("table", m.QualifiedTablename()).Debug("Full old row image matched")
+		return m.Before
+	}
+	return m.Keys
+}
@@ -0,0 +1,58 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/cybertec-postgresql/debezium2postgres/internal/postgres/postgrestest"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplicaIdentity(t *testing.T) {
+	key := map[string]interface{}{"id": 1}
+	assert.Equal(t, ReplicaIdentityDefault, replicaIdentity(kafka.Message{Keys: key}))
+	assert.Equal(t, ReplicaIdentityDefault, replicaIdentity(kafka.Message{Keys: key, Before: map[string]interface{}{"id": 1}}))
+	assert.Equal(t, ReplicaIdentityFull, replicaIdentity(kafka.Message{Keys: key, Before: map[string]interface{}{"id": 1, "name": nil}}))
+	assert.Equal(t, ReplicaIdentityFull, replicaIdentity(kafka.Message{Before: map[string]interface{}{"name": "x"}}), "table without key")
+}
+
+func TestMatchReplicaIdentity(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestMatchReplicaIdentity")
+	conn := postgrestest.NewMemExecutor(1)
+	key := map[string]interface{}{"id": 1}
+	identityDefault := kafka.Message{Op: "d", TableName: "a", Keys: key, Before: map[string]interface{}{"id": 1}}
+	identityFull := kafka.Message{Op: "d", TableName: "a", Keys: key, Before: map[string]interface{}{"id": 1, "name": "x"}}
+	cfg := Config{MatchReplicaIdentity: true}
+
+	_, err := applyCDCItem(context.Background(), conn, cfg, identityDefault)
+	assert.NoError(t, err)
+	_, err = applyCDCItem(context.Background(), conn, Config{}, identityFull)
+	assert.NoError(t, err)
+	conn.AssertSQL(t, `DELETE FROM "a" WHERE ("id")=($1)`, `DELETE FROM "a" WHERE ("id")=($1)`)
+
+	conn.Reset()
+	_, err = applyCDCItem(context.Background(), conn, cfg, identityFull)
+	assert.NoError(t, err)
+	identityFull.Op, identityFull.Values = "u", map[string]interface{}{"id": 1, "name": "y"}
+	identityFull.Before["name"] = nil
+	_, err = applyCDCItem(context.Background(), conn, cfg, identityFull)
+	assert.NoError(t, err)
+	statements := conn.Statements()
+	assert.Len(t, statements, 2)
+	assert.Contains(t, []string{`DELETE FROM "a" WHERE ("id","name")=($1,$2)`, `DELETE FROM "a" WHERE ("name","id")=($1,$2)`},
+		statements[0].SQL, "the full old row image is matched")
+	assert.ElementsMatch(t, []interface{}{1, "x"}, statements[0].Args)
+	assert.Contains(t, []string{
+		`UPDATE "a" SET ("id","name")=($3,$4) WHERE ("id","name") IS NOT DISTINCT FROM ($1,$2)`,
+		`UPDATE "a" SET ("id","name")=($3,$4) WHERE ("name","id") IS NOT DISTINCT FROM ($1,$2)`,
+	}, statements[1].SQL)
+
+	// configured key columns take precedence
+	conn.Reset()
+	cfg.KeyColumns = map[string]bool{"a.id": true}
+	_, err = applyCDCItem(context.Background(), conn, cfg, identityFull)
+	assert.NoError(t, err)
+	conn.AssertSQL(t, `UPDATE "a" SET ("id","name")=($2,$3) WHERE ("id")=($1)`)
+}
@@ -0,0 +1,51 @@
+package postgres
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+)
+
+// keyColumns returns names of the columns configured in `cfg.KeyColumns` to identify rows of the target table
+// of the CDC item in stable order, nil if the message key is used
+func keyColumns(cfg Config, m kafka.Message) []string {
+	var columns []string
+	qualified, unqualified := m.SchemaName+"."+m.TableName+".", m.TableName+"."
+	for k := range cfg.KeyColumns {
+		switch {
+		case strings.HasPrefix(k, qualified):
+			columns = append(columns, strings.TrimPrefix(k, qualified))
+		case strings.HasPrefix(k, unqualified) && !strings.Contains(strings.TrimPrefix(k, unqualified), "."):
+			columns = append(columns, strings.TrimPrefix(k, unqualified))
+		}
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// overrideKeys returns the CDC item identifying the row by the key columns configured for the target table instead
+// of the message key. Values are taken from the old row image, the message key or the new row image in this order
+func overrideKeys(cfg Config, m kafka.Message) (kafka.Message, error) {
+	columns := keyColumns(cfg, m)
+	if len(columns) == 0 {
+		return m, nil
+	}
+	keys := make(map[string]interface{}, len(columns))
+	for _, c := range columns {
+		v, ok := m.Before[c]
+		if !ok {
+			v, ok = m.Keys[c]
+		}
+		if !ok {
+			v, ok = m.Values[c]
+		}
+		if !ok {
+			return m, classify(ErrMissingField, fmt.Errorf("Key column %q is missing in CDC item", c))
+		}
+		keys[c] = v
+	}
+	m.Keys = keys
+	return m, nil
+}
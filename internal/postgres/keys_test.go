@@ -0,0 +1,106 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOverrideKeys(t *testing.T) {
+	cfg := Config{KeyColumns: map[string]bool{
+		"orders.tenant_id":          true,
+		"public.orders.external_id": true,
+		"sales.orders.id":           true,
+		"items.id":                  true,
+	}}
+	msg := kafka.Message{
+		SchemaName: "public",
+		TableName:  "orders",
+		Keys:       map[string]interface{}{"id": 1, "tenant_id": 7},
+		Values:     map[string]interface{}{"id": 1, "tenant_id": 8, "external_id": "x-2"},
+		Before:     map[string]interface{}{"external_id": "x-1"},
+	}
+	assert.Equal(t, []string{"external_id", "tenant_id"}, keyColumns(cfg, msg))
+	m, err := overrideKeys(cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"tenant_id": 7, "external_id": "x-1"}, m.Keys, "old row image first")
+	assert.Equal(t, msg.Values, m.Values)
+
+	msg.Before = nil
+	m, err = overrideKeys(cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"tenant_id": 7, "external_id": "x-2"}, m.Keys)
+
+	delete(msg.Values, "external_id")
+	_, err = overrideKeys(cfg, msg)
+	assert.True(t, errors.Is(err, ErrMissingField))
+
+	// tables without key columns keep the message key
+	msg.TableName = "customers"
+	assert.Nil(t, keyColumns(cfg, msg))
+	m, err = overrideKeys(cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, msg.Keys, m.Keys)
+}
+
+func TestTargetKeyColumns(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestTargetKeyColumns")
+	var (
+		sql  string
+		args []interface{}
+	)
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql, args = s, a
+			return pgconn.CommandTag("UPDATE 1"), nil
+		},
+	}
+	// the source keys on the natural key, the target on the surrogate one and dedupes on the natural key
+	cfg := Config{KeyColumns: map[string]bool{"accounts.login": true}}
+	msg := kafka.Message{
+		Op:        "u",
+		TableName: "accounts",
+		Keys:      map[string]interface{}{"tenant": "acme", "login": "bob"},
+		Values:    map[string]interface{}{"login": "bob"},
+	}
+	_, err := applyCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `UPDATE "accounts" SET ("login")=($2) WHERE ("login")=($1)`, sql)
+	assert.Equal(t, []interface{}{"bob", "bob"}, args)
+
+	msg.Op = "d"
+	_, err = applyCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `DELETE FROM "accounts" WHERE ("login")=($1)`, sql)
+
+	msg.Op = "c"
+	cfg.InsertMode = InsertModeUpsert
+	_, err = applyCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "accounts"("login") VALUES ($1) ON CONFLICT ("login") DO NOTHING`, sql)
+
+	cfg.InsertMode = InsertModeGuarded
+	_, err = applyCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "accounts"("login") SELECT "login" FROM "accounts" WHERE false UNION ALL SELECT $1 WHERE NOT EXISTS (SELECT 1 FROM "accounts" WHERE ("login")=($2))`, sql)
+
+	msg.Op = "u"
+	cfg.UpdateMode = UpdateModeMerge
+	_, err = applyCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `MERGE INTO "accounts" AS t USING (SELECT 1) AS s ON (t."login")=($2) `+
+		`WHEN MATCHED THEN UPDATE SET "login"=$1 WHEN NOT MATCHED THEN INSERT ("login") VALUES ($1)`, sql)
+
+	// messages without the key are matched by the target key too
+	msg.Op = "d"
+	msg.Keys = nil
+	_, err = applyCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `DELETE FROM "accounts" WHERE ("login")=($1)`, sql)
+	assert.Equal(t, []interface{}{"bob"}, args)
+}
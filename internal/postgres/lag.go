@@ -0,0 +1,34 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+)
+
+// lagMonitor tracks whether applying lags behind the source by more than `cfg.LagThreshold`
+type lagMonitor struct {
+	lagging bool
+}
+
+// observe calls `cfg.OnLag` when the lag of the CDC item exceeds the threshold and `cfg.OnLagRecovered` when it drops
+// below half of the threshold again, so lag fluctuating around the threshold doesn't fire callbacks repeatedly.
+// CDC items without the source timestamp are ignored
+func (l *lagMonitor) observe(cfg Config, m kafka.Message, now time.Time) {
+	if cfg.LagThreshold <= 0 || m.Timestamp.IsZero() {
+		return
+	}
+	lag := now.Sub(m.Timestamp)
+	switch {
+	case !l.lagging && lag > cfg.LagThreshold:
+		l.lagging = true
+		if cfg.OnLag != nil {
+			cfg.OnLag(lag)
+		}
+	case l.lagging && lag < cfg.LagThreshold/2:
+		l.lagging = false
+		if cfg.OnLagRecovered != nil {
+			cfg.OnLagRecovered(lag)
+		}
+	}
+}
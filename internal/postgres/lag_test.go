@@ -0,0 +1,61 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLagMonitor(t *testing.T) {
+	var lags, recoveries []time.Duration
+	cfg := Config{
+		LagThreshold:   time.Minute,
+		OnLag:          func(lag time.Duration) { lags = append(lags, lag) },
+		OnLagRecovered: func(lag time.Duration) { recoveries = append(recoveries, lag) },
+	}
+	now := time.Now()
+	var l lagMonitor
+	for _, delay := range []time.Duration{
+		10 * time.Second,
+		2 * time.Minute, // lagging
+		3 * time.Minute,
+		50 * time.Second, // below threshold, not recovered yet
+		61 * time.Second,
+		20 * time.Second, // recovered
+		25 * time.Second,
+	} {
+		l.observe(cfg, kafka.Message{Timestamp: now.Add(-delay)}, now)
+	}
+	l.observe(cfg, kafka.Message{}, now)
+	assert.Equal(t, []time.Duration{2 * time.Minute}, lags)
+	assert.Equal(t, []time.Duration{20 * time.Second}, recoveries)
+
+	l = lagMonitor{}
+	l.observe(Config{OnLag: cfg.OnLag}, kafka.Message{Timestamp: now.Add(-time.Hour)}, now)
+	assert.Len(t, lags, 1, "disabled without threshold")
+}
+
+func TestApplyLag(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestApplyLag")
+	Connect = func(ctx context.Context, connString string) (DBExecutorContext, error) {
+		return MockDbExec{
+			ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+				return pgconn.CommandTag("INSERT 0 1"), nil
+			},
+		}, nil
+	}
+	var lag time.Duration
+	msgChan := make(chan kafka.Message, 1)
+	msgChan <- kafka.Message{Op: "c", TableName: "t", Values: map[string]interface{}{"id": 1}, Timestamp: time.Unix(1609459200, 0)}
+	Apply(context.Background(), "foo", Config{
+		IdleTimeout:  100 * time.Millisecond,
+		LagThreshold: time.Hour,
+		OnLag:        func(l time.Duration) { lag = l },
+	}, msgChan)
+	assert.Greater(t, int64(lag), int64(time.Hour))
+}
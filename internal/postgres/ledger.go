@@ -0,0 +1,80 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// errAlreadyApplied is returned for CDC items which offsets are already recorded in the ledger
+var errAlreadyApplied = errors.New("CDC item already applied")
+
+// ledgerTable returns the quoted name of the ledger table, which may be schema qualified
+func ledgerTable(cfg Config) string {
+	return pgx.Identifier(strings.Split(cfg.Ledger, ".")).Sanitize()
+}
+
+// createLedger creates the ledger table if `cfg.Ledger` is set and the table doesn't exist yet
+func createLedger(ctx context.Context, conn DBExecutorContext, cfg Config) error {
+	if cfg.Ledger == "" {
+		return nil
+	}
+	_, err := conn.Exec(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	topic text NOT NULL,
+	partition integer NOT NULL,
+	"offset" bigint NOT NULL,
+	applied_at timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (topic, partition, "offset"))`, ledgerTable(cfg)))
+	return classify(ErrDBExec, err)
+}
+
+// recordOffset records the offset of the CDC item in the ledger, returns false if it's already recorded
+func recordOffset(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message) (bool, error) {
+	ct, err := conn.Exec(ctx,
+		fmt.Sprintf(`INSERT INTO %s(topic, partition, "offset") VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`, ledgerTable(cfg)),
+		m.Topic, m.Partition, m.Offset)
+	if err != nil {
+		return false, classify(ErrDBExec, err)
+	}
+	return ct.RowsAffected() > 0, nil
+}
+
+// applyRecorded applies the CDC item unless its offset is already recorded in the ledger, in which case
+// errAlreadyApplied is returned. The offset is recorded using `conn`, so both are committed together
+// if `conn` is a transaction
+func applyRecorded(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message) (int64, error) {
+	if cfg.Ledger == "" {
+		return applyHooked(ctx, conn, cfg, m)
+	}
+	recorded, err := recordOffset(ctx, conn, cfg, m)
+	if err != nil {
+		return 0, err
+	}
+	if !recorded {
+		return 0, errAlreadyApplied
+	}
+	return applyHooked(ctx, conn, cfg, m)
+}
+
+// applyLedgered applies the CDC item and records its offset in the ledger in a single transaction
+// if `cfg.Ledger` is set and the target supports transactions
+func applyLedgered(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message) (int64, error) {
+	transactor, ok := conn.(DBTransactor)
+	if cfg.Ledger == "" || !ok {
+		return applyRecorded(ctx, conn, cfg, m)
+	}
+	tx, err := transactor.Begin(ctx)
+	if err != nil {
+		return 0, classify(ErrDBExec, err)
+	}
+	rowsAffected, err := applyRecorded(ctx, tx, cfg, m)
+	if err != nil {
+		_ = tx.Rollback(ctx)
+		return rowsAffected, err
+	}
+	return rowsAffected, classify(ErrDBExec, tx.Commit(ctx))
+}
@@ -0,0 +1,96 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockLedger simulates the ledger table, offsets recorded in the transaction are kept only if it's committed
+type mockLedger struct {
+	committed map[int64]bool
+	staged    map[int64]bool
+	writes    int
+	fail      bool
+}
+
+func (l *mockLedger) conn() MockDbTransactor {
+	return MockDbTransactor{
+		Tx: MockDbTx{
+			MockDbExec: MockDbExec{
+				ExecHandler: func(sql string, arguments []interface{}) (pgconn.CommandTag, error) {
+					if strings.Contains(sql, `"dbz2pg_ledger"`) {
+						offset := arguments[2].(int64)
+						if l.committed[offset] || l.staged[offset] {
+							return pgconn.CommandTag("INSERT 0 0"), nil
+						}
+						l.staged[offset] = true
+						return pgconn.CommandTag("INSERT 0 1"), nil
+					}
+					if l.fail {
+						return nil, errors.New("connection lost")
+					}
+					l.writes++
+					return pgconn.CommandTag("INSERT 0 1"), nil
+				},
+			},
+			CommitHandler: func() error {
+				for offset := range l.staged {
+					l.committed[offset] = true
+				}
+				l.staged = make(map[int64]bool)
+				return nil
+			},
+			RollbackHandler: func() error {
+				l.staged = make(map[int64]bool)
+				return nil
+			},
+		},
+	}
+}
+
+func TestApplyLedger(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestApplyLedger")
+	ledger := &mockLedger{committed: make(map[int64]bool), staged: make(map[int64]bool)}
+	conn := ledger.conn()
+	cfg := Config{Ledger: "dbz2pg_ledger"}
+	m := kafka.Message{Op: "c", TableName: "t", Values: map[string]interface{}{"id": 1}}
+	m.Topic, m.Offset = "dbserver1.inventory.t", 42
+
+	// crash while applying the change, the offset must not be recorded
+	ledger.fail = true
+	_ = applyMessage(context.Background(), conn, cfg, m)
+	assert.Empty(t, ledger.committed)
+
+	ledger.fail = false
+	_ = applyMessage(context.Background(), conn, cfg, m)
+	assert.Equal(t, 1, ledger.writes)
+	assert.True(t, ledger.committed[42])
+
+	// replay of the recorded offset is a no-op
+	_ = applyMessage(context.Background(), conn, cfg, m)
+	assert.Equal(t, 1, ledger.writes)
+	applyBatch(context.Background(), conn, cfg, []kafka.Message{m})
+	assert.Equal(t, 1, ledger.writes)
+}
+
+func TestCreateLedger(t *testing.T) {
+	var sql string
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql = s
+			return pgconn.CommandTag("CREATE TABLE"), nil
+		},
+	}
+	assert.NoError(t, createLedger(context.Background(), conn, Config{}))
+	assert.Empty(t, sql)
+	assert.NoError(t, createLedger(context.Background(), conn, Config{Ledger: "public.dbz2pg_ledger"}))
+	assert.Contains(t, sql, `CREATE TABLE IF NOT EXISTS "public"."dbz2pg_ledger"`)
+	assert.Contains(t, sql, `PRIMARY KEY (topic, partition, "offset")`)
+}
@@ -0,0 +1,51 @@
+package postgres
+
+import "github.com/sirupsen/logrus"
+
+// FieldLogger is the logging facility CDC items are applied with, see Config.Logger
+type FieldLogger interface {
+	WithField(key string, value interface{}) FieldLogger
+	WithError(err error) FieldLogger
+	Trace(args ...interface{})
+	Debug(args ...interface{})
+	Info(args ...interface{})
+	Warning(args ...interface{})
+	Error(args ...interface{})
+}
+
+// logrusLogger adapts the logrus entry to FieldLogger
+type logrusLogger struct {
+	*logrus.Entry
+}
+
+// NewLogrusLogger returns FieldLogger writing to the logrus `entry`
+func NewLogrusLogger(entry *logrus.Entry) FieldLogger {
+	return logrusLogger{entry}
+}
+
+// WithField returns the logger adding the field to the messages
+func (l logrusLogger) WithField(key string, value interface{}) FieldLogger {
+	return logrusLogger{l.Entry.WithField(key, value)}
+}
+
+// WithError returns the logger adding the error to the messages
+func (l logrusLogger) WithError(err error) FieldLogger {
+	return logrusLogger{l.Entry.WithError(err)}
+}
+
+// loggerOf returns the logger configured in `cfg`, the package Logger by default
+func loggerOf(cfg Config) FieldLogger {
+	if cfg.Logger != nil {
+		return cfg.Logger
+	}
+	return logrusLogger{Logger}
+}
+
+// fatal reports the error applying cannot start with, the application exits unless the logger is configured in `cfg`
+func fatal(cfg Config, err error) {
+	if cfg.Logger != nil {
+		cfg.Logger.Error(err)
+		return
+	}
+	Logger.Fatalln(err)
+}
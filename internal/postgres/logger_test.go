@@ -0,0 +1,78 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+// capturingLogger records the messages with their fields
+type capturingLogger struct {
+	fields   string
+	messages *[]string
+}
+
+func (l capturingLogger) WithField(key string, value interface{}) FieldLogger {
+	return capturingLogger{fmt.Sprintf("%s %s=%v", l.fields, key, value), l.messages}
+}
+
+func (l capturingLogger) WithError(err error) FieldLogger {
+	return l.WithField("error", err)
+}
+
+func (l capturingLogger) log(level string, args ...interface{}) {
+	*l.messages = append(*l.messages, level+": "+fmt.Sprint(args...)+l.fields)
+}
+
+func (l capturingLogger) Trace(args ...interface{})   { l.log("trace", args...) }
+func (l capturingLogger) Debug(args ...interface{})   { l.log("debug", args...) }
+func (l capturingLogger) Info(args ...interface{})    { l.log("info", args...) }
+func (l capturingLogger) Warning(args ...interface{}) { l.log("warning", args...) }
+func (l capturingLogger) Error(args ...interface{})   { l.log("error", args...) }
+
+func TestConfigLogger(t *testing.T) {
+	global, hook := test.NewNullLogger()
+	Logger = global.WithField("method", "TestConfigLogger")
+	var messages []string
+	cfg := Config{Logger: capturingLogger{messages: &messages}}
+	affected := "UPDATE 0"
+	conn := MockDbExec{ExecHandler: func(sql string, arguments []interface{}) (pgconn.CommandTag, error) {
+		if affected == "" {
+			return nil, errors.New("connection reset")
+		}
+		return pgconn.CommandTag(affected), nil
+	}}
+	msg := kafka.Message{
+		Op:        "u",
+		TableName: "orders",
+		Keys:      map[string]interface{}{"id": int64(1)},
+		Values:    map[string]interface{}{"id": int64(1)},
+	}
+	applyMessage(context.Background(), conn, cfg, msg)
+	assert.Contains(t, messages, "warning: CDC item caused no changes")
+	assert.Contains(t, messages, "debug: Starting UpdateCDCItem()... op=update")
+
+	messages = nil
+	affected = ""
+	applyMessage(context.Background(), conn, cfg, msg)
+	assert.Contains(t, messages, "error: connection reset")
+
+	messages = nil
+	msg.Op = "x"
+	applyMessage(context.Background(), conn, cfg, msg)
+	assert.Contains(t, messages, `error: CDC item dropped error=Unsupported operation: "x" topic= offset=0`)
+	assert.Empty(t, hook.AllEntries(), "nothing routed to the package logger")
+
+	messages = nil
+	applyMessage(context.Background(), conn, Config{}, msg)
+	assert.Empty(t, messages)
+	assert.NotEmpty(t, hook.AllEntries(), "package logger by default")
+	Logger = logrus.New().WithField("method", "TestConfigLogger")
+}
@@ -0,0 +1,26 @@
+package postgres
+
+import (
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+)
+
+// UpdatedColumn is the column of the last-write-wins tables holding the source timestamp of the last change of the row
+const UpdatedColumn = "__updated_ts"
+
+// lastWriteWinsTable returns true if the target table of the CDC item resolves conflicting writes by the timestamps
+func lastWriteWinsTable(cfg Config, m kafka.Message) bool {
+	return cfg.LastWriteWins[m.TableName] || cfg.LastWriteWins[m.SchemaName+"."+m.TableName]
+}
+
+// winsLastWrite returns true if changes of the CDC item made before the last change applied to the row are skipped.
+// Items without the source timestamp are applied regardless
+func winsLastWrite(cfg Config, m kafka.Message) bool {
+	return !cfg.AppendMode && !m.Timestamp.IsZero() && lastWriteWinsTable(cfg, m)
+}
+
+// updatedValue returns the source timestamp of the CDC item as the value of UpdatedColumn
+func updatedValue(m kafka.Message) string {
+	return m.Timestamp.UTC().Format(time.RFC3339Nano)
+}
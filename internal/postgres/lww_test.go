@@ -0,0 +1,98 @@
+package postgres
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLastWriteWins(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestLastWriteWins")
+	var (
+		sqls     []string
+		args     [][]interface{}
+		affected = "UPDATE 1"
+	)
+	conn := MockDbQuerier{
+		MockDbExec: MockDbExec{ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sqls, args = append(sqls, s), append(args, a)
+			return pgconn.CommandTag(affected), nil
+		}},
+		QueryRowHandler: func(s string, a []interface{}) pgx.Row {
+			sqls, args = append(sqls, s), append(args, a)
+			return MockRow{Values: []interface{}{true}}
+		},
+	}
+	ts := "2021-03-04T05:06:07.89Z"
+	msg := kafka.Message{
+		Op:         "u",
+		SchemaName: "public",
+		TableName:  "orders",
+		Keys:       map[string]interface{}{"id": int64(1)},
+		Values:     map[string]interface{}{"id": int64(1), "qty": int64(5)},
+		Fields:     map[string]kafka.Field{"id": {Type: "int64"}, "qty": {Type: "int64"}},
+		Timestamp:  time.Date(2021, 3, 4, 6, 6, 7, 890000000, time.FixedZone("CET", 3600)),
+	}
+	cfg := Config{LastWriteWins: map[string]bool{"public.orders": true}}
+	ctx := context.Background()
+	apply := func(m kafka.Message) {
+		sqls, args = nil, nil
+		_, err := applyCDCItem(ctx, conn, cfg, prepareMessage(cfg, m))
+		assert.NoError(t, err)
+	}
+
+	apply(msg)
+	assert.Equal(t, []string{`UPDATE "public"."orders" SET ("__updated_ts","id","qty")=($2::timestamptz,$3,$4) WHERE ("id")=($1) ` +
+		`AND ("__updated_ts" IS NULL OR "__updated_ts"<=$5::timestamptz)`}, sqls)
+	assert.Equal(t, []interface{}{int64(1), ts, int64(1), int64(5), ts}, args[0])
+
+	stale := atomic.LoadUint64(&staleChanges)
+	affected = "DELETE 0"
+	del := msg
+	del.Op, del.Values = "d", nil
+	apply(del)
+	if assert.Len(t, sqls, 2) {
+		assert.Equal(t, `DELETE FROM "public"."orders" WHERE ("id")=($1) AND ("__updated_ts" IS NULL OR "__updated_ts"<=$2::timestamptz)`, sqls[0])
+		assert.Equal(t, `SELECT EXISTS (SELECT 1 FROM "public"."orders" WHERE ("id")=($1) AND ("__updated_ts">$2::timestamptz))`, sqls[1])
+		assert.Equal(t, []interface{}{int64(1), ts}, args[1])
+	}
+	assert.Equal(t, stale+1, atomic.LoadUint64(&staleChanges), "deleted row changed later")
+
+	cfg.UpsertTables = map[string]bool{"orders": true}
+	ins := msg
+	ins.Op = "c"
+	affected = "INSERT 0 0"
+	apply(ins)
+	assert.Equal(t, []string{`INSERT INTO "public"."orders"("__updated_ts","id","qty") VALUES ($1::timestamptz,$2,$3) ON CONFLICT ("id") ` +
+		`DO UPDATE SET "__updated_ts"=EXCLUDED."__updated_ts","qty"=EXCLUDED."qty" ` +
+		`WHERE ("public"."orders"."__updated_ts" IS NULL OR "public"."orders"."__updated_ts"<=EXCLUDED."__updated_ts")`}, sqls)
+	assert.Equal(t, stale+2, atomic.LoadUint64(&staleChanges), "conflicting row changed later")
+
+	// both guards apply
+	cfg.PositionGuard = true
+	msg.Position = 42
+	affected = "UPDATE 1"
+	apply(msg)
+	assert.Equal(t, []string{`UPDATE "public"."orders" SET ("__source_lsn","__updated_ts","id","qty")=($2,$3::timestamptz,$4,$5) ` +
+		`WHERE ("id")=($1) AND ("__source_lsn" IS NULL OR "__source_lsn"<=$6) AND ("__updated_ts" IS NULL OR "__updated_ts"<=$7::timestamptz)`}, sqls)
+
+	other := msg
+	other.TableName = "customers"
+	other.Position = 0
+	apply(other)
+	assert.Equal(t, []string{`UPDATE "public"."customers" SET ("id","qty")=($2,$3) WHERE ("id")=($1)`}, sqls, "other tables are not guarded")
+
+	unknown := msg
+	unknown.Position, unknown.Timestamp = 0, time.Time{}
+	apply(unknown)
+	assert.Equal(t, []string{`UPDATE "public"."orders" SET ("id","qty")=($2,$3) WHERE ("id")=($1)`}, sqls, "timestamp unknown")
+
+	assert.False(t, stagesTable(Config{StagingTables: map[string]bool{"orders": true}, LastWriteWins: cfg.LastWriteWins}, msg))
+}
@@ -0,0 +1,104 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+)
+
+// Update modes, i.e. how to apply CDC items with update operation
+const (
+	UpdateModeUpdate = "update" // UPDATE matching the key, updates of missing rows change nothing
+	UpdateModeMerge  = "merge"  // MERGE matching the key, updates of missing rows insert them. Requires PostgreSQL 15+
+)
+
+// mergeMinVersion is the server_version_num of the first PostgreSQL release supporting MERGE
+const mergeMinVersion = 150000
+
+// detectUpdateMode returns `cfg` with the update mode supported by the target database, i.e. merges fall back
+// to plain updates if the server is older than PostgreSQL 15 or its version cannot be determined
+func detectUpdateMode(ctx context.Context, conn DBExecutorContext, cfg Config) Config {
+	if cfg.UpdateMode != UpdateModeMerge {
+		return cfg
+	}
+	l := loggerOf(cfg).WithField("mode", cfg.UpdateMode)
+	querier, ok := conn.(DBQuerierContext)
+	if !ok {
+		l.Warning("Server version is unknown, applying updates as plain updates")
+		cfg.UpdateMode = UpdateModeUpdate
+		return cfg
+	}
+	var version string
+	if err := querier.QueryRow(ctx, "SHOW server_version_num").Scan(&version); err != nil {
+		l.WithError(err).Warning("Server version is unknown, applying updates as plain updates")
+		cfg.UpdateMode = UpdateModeUpdate
+		return cfg
+	}
+	if n, err := strconv.Atoi(version); err != nil || n < mergeMinVersion {
+		l.WithField("version", version).Warning("Server doesn't support MERGE, applying updates as plain updates")
+		cfg.UpdateMode = UpdateModeUpdate
+	}
+	return cfg
+}
+
+// mergeCDCItem applies the update with a single MERGE statement updating the row matching the key if it exists
+// or inserting the new row image otherwise. Parameters are assigned to the target columns directly, so they are typed
+// as the columns rather than as the source of the MERGE
+func mergeCDCItem(ctx context.Context, conn DBExecutorContext, cfg Config, message kafka.Message) (int64, error) {
+	l := loggerOf(cfg).WithField("op", "merge")
+	l.Debug("Starting MergeCDCItem()...")
+	if len(message.Keys) == 0 || len(message.Values) == 0 {
+		return 0, classify(ErrMissingField, errors.New("Both key and new row image are required to merge row"))
+	}
+	fields, refs, args, err := bindRow(cfg, message, message.Values, make([]interface{}, 0, len(message.Keys)+len(message.Values)))
+	if err != nil {
+		return 0, err
+	}
+	sets := make([]string, len(fields))
+	columnRefs := make(map[string]string, len(fields))
+	for i, f := range fields {
+		sets[i] = f + "=" + refs[i]
+		columnRefs[f] = refs[i]
+	}
+	keyrefs := make([]string, 0, len(message.Keys))
+	keyfields := make([]string, 0, len(message.Keys))
+	keyargs := len(args)
+	for f, v := range message.Keys {
+		arg, field, ref, err := bindKey(cfg, message, f, v, len(args)+1)
+		if err != nil {
+			return 0, err
+		}
+		// the target column, source columns are named the same
+		quoted := strconv.Quote(f)
+		keyfields = append(keyfields, strings.Replace(field, quoted, "t."+quoted, 1))
+		args = append(args, arg)
+		keyrefs = append(keyrefs, ref)
+	}
+	matched := "WHEN MATCHED"
+	versions := rowVersions(cfg, message)
+	if len(versions) > 0 {
+		matched += " AND " + guardVersions(versions, "t.", func(v rowVersion) string { return columnRefs[strconv.Quote(v.column)] })
+	}
+	sql := fmt.Sprintf("MERGE INTO %s AS t USING (SELECT 1) AS s ON %s "+
+		"%s THEN UPDATE SET %s WHEN NOT MATCHED THEN INSERT (%s) VALUES (%s)",
+		message.QualifiedTablename(),
+		matchRow(keyfields, keyrefs, args[keyargs:]),
+		matched,
+		strings.Join(sets, ","),
+		strings.Join(fields, ","),
+		strings.Join(refs, ","))
+	ct, err := conn.Exec(ctx, sql, args...)
+	err = classify(ErrDBExec, err)
+	l.Debug("Exiting MergeCDCItem()...")
+	atomic.AddUint64(&tx, 1)
+	if len(versions) > 0 && err == nil && ct.RowsAffected() == 0 {
+		// missing rows are inserted, so the matched row holds a newer position
+		countStale(cfg, message)
+	}
+	return ct.RowsAffected(), err
+}
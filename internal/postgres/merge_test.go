@@ -0,0 +1,80 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectUpdateMode(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestDetectUpdateMode")
+	version := func(row MockRow) MockDbQuerier {
+		return MockDbQuerier{QueryRowHandler: func(sql string, args []interface{}) pgx.Row {
+			assert.Equal(t, "SHOW server_version_num", sql)
+			return row
+		}}
+	}
+	cfg := Config{UpdateMode: UpdateModeMerge}
+	ctx := context.Background()
+	assert.Equal(t, UpdateModeMerge, detectUpdateMode(ctx, version(MockRow{Values: []interface{}{"150004"}}), cfg).UpdateMode)
+	assert.Equal(t, UpdateModeUpdate, detectUpdateMode(ctx, version(MockRow{Values: []interface{}{"140011"}}), cfg).UpdateMode)
+	assert.Equal(t, UpdateModeUpdate, detectUpdateMode(ctx, version(MockRow{Err: errors.New("connection reset")}), cfg).UpdateMode)
+	assert.Equal(t, UpdateModeUpdate, detectUpdateMode(ctx, MockDbExec{}, cfg).UpdateMode, "queries are not supported")
+	assert.Equal(t, "", detectUpdateMode(ctx, MockDbExec{}, Config{}).UpdateMode)
+}
+
+func TestMergeCDCItem(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestMergeCDCItem")
+	var (
+		sql  string
+		args []interface{}
+	)
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql, args = s, a
+			return pgconn.CommandTag("MERGE 1"), nil
+		},
+	}
+	msg := kafka.Message{
+		Op:         "u",
+		SchemaName: "public",
+		TableName:  "orders",
+		Keys:       map[string]interface{}{"id": int64(1)},
+		Values:     map[string]interface{}{"id": int64(2)},
+		Fields:     map[string]kafka.Field{"id": {Type: "int64"}},
+	}
+	cfg := Config{UpdateMode: UpdateModeMerge}
+	rowsAffected, err := applyCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, rowsAffected)
+	assert.Equal(t, `MERGE INTO "public"."orders" AS t USING (SELECT 1) AS s ON (t."id")=($2) `+
+		`WHEN MATCHED THEN UPDATE SET "id"=$1 WHEN NOT MATCHED THEN INSERT ("id") VALUES ($1)`, sql)
+	assert.Equal(t, []interface{}{int64(2), int64(1)}, args, "new key is merged into the row matching the old one")
+
+	// explicit casts are kept, key columns compared case insensitively are qualified
+	msg.Keys = map[string]interface{}{"code": "A"}
+	msg.Values = map[string]interface{}{"code": "a"}
+	cfg.ColumnTypes = map[string]string{"orders.code": "varchar"}
+	cfg.CaseInsensitive = map[string]bool{"orders.code": true}
+	_, err = mergeCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `MERGE INTO "public"."orders" AS t USING (SELECT 1) AS s ON (lower(t."code"))=(lower($2::varchar)) `+
+		`WHEN MATCHED THEN UPDATE SET "code"=$1::varchar WHEN NOT MATCHED THEN INSERT ("code") VALUES ($1::varchar)`, sql)
+
+	msg.Keys = nil
+	_, err = mergeCDCItem(context.Background(), conn, cfg, msg)
+	assert.True(t, errors.Is(err, ErrMissingField))
+
+	// plain updates otherwise
+	msg.Keys = map[string]interface{}{"code": "A"}
+	_, err = applyCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Contains(t, sql, "UPDATE")
+	assert.NotContains(t, sql, "MERGE")
+}
@@ -0,0 +1,126 @@
+package postgres
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+)
+
+// Metadata of the CDC items which may be written to the columns of the target rows
+const (
+	MetadataOp        = "op"        // operation code, i.e. c, u, d or r
+	MetadataSourceTs  = "source_ts" // time of the change in the source database
+	MetadataLSN       = "lsn"       // position of the change in the source log
+	MetadataTopic     = "topic"     // Kafka topic the CDC item is consumed from
+	MetadataPartition = "partition" // Kafka partition the CDC item is consumed from
+	MetadataOffset    = "offset"    // Kafka offset of the CDC item
+)
+
+// metadataFields describes the values of the metadata as fields of the Debezium schema
+var metadataFields = map[string]kafka.Field{
+	MetadataOp:        {Type: "string"},
+	MetadataSourceTs:  {Type: "string", Name: logicalZonedTimestamp},
+	MetadataLSN:       {Type: "int64"},
+	MetadataTopic:     {Type: "string"},
+	MetadataPartition: {Type: "int32"},
+	MetadataOffset:    {Type: "int64"},
+}
+
+// ParseMetadataColumn parses the metadata column in the [table:]metadata:column form, e.g. public.orders:op:__op.
+// Returns empty table for the columns of all tables
+func ParseMetadataColumn(s string) (string, string, string, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) == 2 {
+		parts = append([]string{""}, parts...)
+	}
+	if len(parts) != 3 || parts[2] == "" {
+		return "", "", "", fmt.Errorf("Invalid metadata column %q, [table:]metadata:column expected", s)
+	}
+	if _, ok := metadataFields[parts[1]]; !ok {
+		return "", "", "", fmt.Errorf("Unknown metadata %q of column %q", parts[1], s)
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+// metadataColumns returns the columns of the target table of the CDC item holding its metadata, keyed by metadata.
+// Columns of the table override the ones of all tables
+func metadataColumns(cfg Config, m kafka.Message) map[string]string {
+	if len(cfg.MetadataColumns) == 0 {
+		return nil
+	}
+	columns := make(map[string]string)
+	for _, table := range []string{"", m.TableName, m.SchemaName + "." + m.TableName} {
+		for metadata, column := range cfg.MetadataColumns[table] {
+			columns[metadata] = column
+		}
+	}
+	return columns
+}
+
+// metadataValue returns the value of the metadata of the CDC item
+func metadataValue(m kafka.Message, metadata string) interface{} {
+	switch metadata {
+	case MetadataOp:
+		return m.Op
+	case MetadataSourceTs:
+		if m.Timestamp.IsZero() {
+			return nil
+		}
+		return updatedValue(m)
+	case MetadataLSN:
+		if m.Position == 0 {
+			return nil
+		}
+		return m.Position
+	case MetadataTopic:
+		return m.Topic
+	case MetadataPartition:
+		return int64(m.Partition)
+	case MetadataOffset:
+		return m.Offset
+	}
+	return nil
+}
+
+// withMetadata returns the CDC item with its metadata added to the new row image in the columns configured
+// in `cfg.MetadataColumns`. Deletes have no new row image, the metadata is added to the rows archiving them
+func withMetadata(cfg Config, m kafka.Message) kafka.Message {
+	columns := metadataColumns(cfg, m)
+	if len(columns) == 0 || m.SchemaChange != nil || m.TransactionBoundary != nil {
+		return m
+	}
+	fields := make(map[string]kafka.Field, len(m.Fields)+len(columns))
+	for k, f := range m.Fields {
+		fields[k] = f
+	}
+	for metadata, column := range columns {
+		if _, ok := fields[column]; !ok {
+			fields[column] = metadataFields[metadata]
+		}
+	}
+	m.Fields = fields
+	if m.Op == "c" || m.Op == "u" || m.Op == "r" {
+		m.Values = metadataRow(cfg, m, m.Values)
+	}
+	return m
+}
+
+// metadataRow returns the copy of the row image with the metadata of the CDC item added in the columns configured
+// in `cfg.MetadataColumns`, columns of the row image are kept
+func metadataRow(cfg Config, m kafka.Message, image map[string]interface{}) map[string]interface{} {
+	columns := metadataColumns(cfg, m)
+	if len(columns) == 0 {
+		return image
+	}
+	row := make(map[string]interface{}, len(image)+len(columns))
+	for k, v := range image {
+		row[k] = v
+	}
+	for metadata, column := range columns {
+		if _, ok := row[column]; !ok {
+			row[column] = metadataValue(m, metadata)
+		}
+	}
+	return row
+}
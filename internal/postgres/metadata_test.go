@@ -0,0 +1,91 @@
+package postgres
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMetadataColumn(t *testing.T) {
+	table, metadata, column, err := ParseMetadataColumn("op:__op")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"", MetadataOp, "__op"}, []string{table, metadata, column})
+
+	table, metadata, column, err = ParseMetadataColumn("public.orders:source_ts:__source_ts_ms")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"public.orders", MetadataSourceTs, "__source_ts_ms"}, []string{table, metadata, column})
+
+	for _, s := range []string{"", "op", "op:", "xid:__xid", "a:b:op:__op"} {
+		_, _, _, err = ParseMetadataColumn(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestMetadataColumns(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestMetadataColumns")
+	var (
+		sqls    []string
+		args    [][]interface{}
+		missing string
+	)
+	conn := MockDbExec{ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+		sqls, args = append(sqls, s), append(args, a)
+		if missing > "" && strings.Contains(s, missing) {
+			return nil, &pgconn.PgError{Code: "42703", Message: `column ` + missing + ` of relation "orders" does not exist`}
+		}
+		return pgconn.CommandTag("INSERT 0 1"), nil
+	}}
+	m := kafka.Message{Op: "c", SchemaName: "public", TableName: "orders",
+		Keys:      map[string]interface{}{"id": int64(1)},
+		Values:    map[string]interface{}{"id": int64(1)},
+		Fields:    map[string]kafka.Field{"id": {Type: "int64"}},
+		Position:  24023128,
+		Timestamp: time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC),
+	}
+	m.Topic, m.Partition, m.Offset = "db.public.orders", 2, 42
+	cfg := Config{MetadataColumns: map[string]map[string]string{
+		"":       {MetadataOp: "__op", MetadataLSN: "__lsn"},
+		"orders": {MetadataLSN: "lsn", MetadataSourceTs: "__source_ts_ms", MetadataTopic: "__topic", MetadataOffset: "__offset"},
+	}}
+	ctx := context.Background()
+	apply := func(cfg Config, m kafka.Message) error {
+		sqls, args = nil, nil
+		_, err := applyCDCItem(ctx, conn, cfg, prepareMessage(cfg, m))
+		return err
+	}
+
+	assert.NoError(t, apply(cfg, m))
+	assert.Equal(t, []string{`INSERT INTO "public"."orders"("__offset","__op","__source_ts_ms","__topic","id","lsn") ` +
+		`VALUES ($1,$2,$3::timestamptz,$4,$5,$6)`}, sqls, "columns of the table override the ones of all tables")
+	assert.Equal(t, []interface{}{int64(42), "c", "2021-03-04T05:06:07Z", "db.public.orders", int64(1), int64(24023128)}, args[0])
+
+	u := m
+	u.Op = "u"
+	assert.NoError(t, apply(cfg, u))
+	assert.Contains(t, sqls[0], `SET ("__offset","__op","__source_ts_ms","__topic","id","lsn")=`)
+	assert.Contains(t, args[0], "u")
+
+	global := Config{MetadataColumns: map[string]map[string]string{"": {MetadataOp: "__op", MetadataPartition: "__partition"}}}
+	d := m
+	d.Op, d.Values, d.Before = "d", nil, map[string]interface{}{"id": int64(1)}
+	assert.NoError(t, apply(global, d))
+	assert.Equal(t, []string{`DELETE FROM "public"."orders" WHERE ("id")=($1)`}, sqls, "deleted rows carry no metadata")
+
+	global.ArchiveDeletes = map[string]bool{"orders": true}
+	assert.NoError(t, apply(global, d))
+	assert.Equal(t, `INSERT INTO "public"."orders_deleted"("__offset","__op","__partition","__source_ts","__topic","id") `+
+		`VALUES ($1,$2,$3,$4,$5,$6)`, sqls[0], "archived rows carry metadata")
+	assert.Equal(t, int64(2), args[0][2])
+
+	missing = `"__op"`
+	global = Config{MetadataColumns: global.MetadataColumns, SchemaDrift: SchemaDriftSkip}
+	_, err := applyDriftingCDCItem(ctx, conn, global, prepareMessage(global, m))
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "public"."orders"("__partition","id") VALUES ($1,$2)`, sqls[len(sqls)-1], "missing columns are skipped")
+}
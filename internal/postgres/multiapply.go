@@ -0,0 +1,42 @@
+package postgres
+
+import (
+	"context"
+	"sync"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+)
+
+// MultiApply applies messages of several sources concurrently, e.g. of topics or partitions consumed separately,
+// keyed by the source name. Each source is applied by its own worker using its own connection in the order messages
+// are received, so changes of the same key keep their order as long as the key is always read from the same source,
+// as Kafka partitions guarantee. Sources are expected to feed distinct tables, changes of the table fed by several
+// sources are applied in no particular order. Staging tables shared by the workers are dropped once all of them
+// finished, temporary ones are dropped with the connections of the workers. Returns when all workers finished
+func MultiApply(ctx context.Context, connString string, cfg Config, sources map[string]<-chan kafka.Message) {
+	resetStats()
+	var wg sync.WaitGroup
+	for name, messages := range sources {
+		wg.Add(1)
+		go func(name string, messages <-chan kafka.Message) {
+			defer wg.Done()
+			l := loggerOf(cfg).WithField("source", name)
+			l.Debug("Worker started")
+			apply(ctx, connString, cfg, messages, false)
+			l.Debug("Worker finished")
+		}(name, messages)
+	}
+	wg.Wait()
+	if cfg.StagingKind == StagingTemporary || !hasStagingTables() {
+		return
+	}
+	conn, err := Connect(context.Background(), connString)
+	if err != nil {
+		loggerOf(cfg).WithError(err).Warning("Staging tables not dropped")
+		return
+	}
+	if c, ok := conn.(interface{ Close() }); ok {
+		defer c.Close()
+	}
+	dropStagingTables(context.Background(), conn, cfg)
+}
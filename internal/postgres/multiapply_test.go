@@ -0,0 +1,92 @@
+package postgres
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiApply(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestMultiApply")
+	var (
+		mu     sync.Mutex
+		conns  int
+		values = make(map[string][]interface{})
+	)
+	Connect = func(ctx context.Context, connString string) (DBExecutorContext, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		conns++
+		return &MockDbExec{
+			ExecHandler: func(sql string, arguments []interface{}) (pgconn.CommandTag, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				values[sql] = append(values[sql], arguments[0])
+				return pgconn.CommandTag("INSERT 0 1"), nil
+			},
+		}, nil
+	}
+	sources := make(map[string]<-chan kafka.Message)
+	for _, table := range []string{"orders", "customers"} {
+		messages := make(chan kafka.Message, 10)
+		for i := int64(1); i <= 10; i++ {
+			messages <- kafka.Message{Op: "c", SchemaName: "public", TableName: table,
+				Keys:   map[string]interface{}{"id": i},
+				Values: map[string]interface{}{"id": i},
+				Fields: map[string]kafka.Field{"id": {Type: "int64"}},
+			}
+		}
+		sources[table] = messages
+	}
+	MultiApply(context.Background(), "foo", Config{IdleTimeout: 100 * time.Millisecond}, sources)
+
+	assert.Equal(t, 2, conns, "worker per source")
+	ordered := []interface{}{int64(1), int64(2), int64(3), int64(4), int64(5), int64(6), int64(7), int64(8), int64(9), int64(10)}
+	assert.Equal(t, ordered, values[`INSERT INTO "public"."orders"("id") VALUES ($1)`], "applied in the order of the source")
+	assert.Equal(t, ordered, values[`INSERT INTO "public"."customers"("id") VALUES ($1)`], "applied in the order of the source")
+	assert.EqualValues(t, 20, Stats().Messages)
+}
+
+func TestMultiApplyDropStaging(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestMultiApplyDropStaging")
+	var (
+		mu         sync.Mutex
+		statements []string
+	)
+	Connect = func(ctx context.Context, connString string) (DBExecutorContext, error) {
+		return &MockDbExec{
+			ExecHandler: func(sql string, arguments []interface{}) (pgconn.CommandTag, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				statements = append(statements, sql)
+				return pgconn.CommandTag("DROP TABLE"), nil
+			},
+		}, nil
+	}
+	staging.Lock()
+	staging.tables = map[string]bool{`"public"."dbz2pg_staging_events"`: true}
+	staging.Unlock()
+	finished := make(chan kafka.Message)
+	running := make(chan kafka.Message)
+	done := make(chan struct{})
+	close(finished)
+	go func() {
+		MultiApply(context.Background(), "foo", Config{IdleTimeout: 5 * time.Second}, map[string]<-chan kafka.Message{
+			"finished": finished, "running": running,
+		})
+		close(done)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	assert.Empty(t, statements, "staging tables are kept while other workers run")
+	mu.Unlock()
+	close(running)
+	<-done
+	assert.Equal(t, []string{`DROP TABLE IF EXISTS "public"."dbz2pg_staging_events"`}, statements)
+}
@@ -0,0 +1,128 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+)
+
+// maxParams is the maximum number of parameters PostgreSQL accepts for a single statement
+const maxParams = 65535
+
+// number of multi-row inserts and rows inserted by them during session
+var (
+	multiRowInserts    uint64
+	multiRowInsertRows uint64
+)
+
+// isMultiRowInsert returns true if the prepared CDC item is a plain insert which may share the statement with
+// other inserts into the same table. Inserts depending on the outcome of each row, e.g. ignoring conflicts,
+// adding missing columns or recording offsets in the ledger, are applied one per statement
+func isMultiRowInsert(cfg Config, m kafka.Message) bool {
+	if m.Op != "c" || m.SchemaChange != nil || m.TransactionBoundary != nil || len(m.Values) == 0 {
+		return false
+	}
+	if cfg.AppendMode || cfg.Ledger > "" || cfg.SchemaDrift > "" || cfg.WidenColumns || hasApplyHooks(cfg) || len(fanOutTargets(cfg, m)) > 0 ||
+		len(returningColumns(cfg, m)) > 0 {
+		return false
+	}
+	if mode := insertMode(cfg, m); mode != "" && mode != InsertModePlain {
+		return false
+	}
+	return !isUpsert(cfg, m) && !ignoresConflicts(cfg, m) && !updatesOnDuplicate(cfg, m) && !stagesTable(cfg, m) &&
+		!isHistoryTable(cfg, m)
+}
+
+// insertedColumns returns the sorted names of the columns the CDC item inserts
+func insertedColumns(cfg Config, m kafka.Message) string {
+	row := omitNullDefaults(cfg, m, m.Values)
+	columns := make([]string, 0, len(row))
+	for f := range row {
+		columns = append(columns, f)
+	}
+	sort.Strings(columns)
+	return strings.Join(columns, ",")
+}
+
+// insertRun returns the prepared CDC items starting the batch which insert the same columns into the same table,
+// so they can be applied with a single statement. Returns the first item alone if it can't be combined
+func insertRun(cfg Config, batch []kafka.Message) []kafka.Message {
+	first := prepareMessage(cfg, batch[0])
+	run := []kafka.Message{first}
+	if !isMultiRowInsert(cfg, first) {
+		return run
+	}
+	table, columns := first.QualifiedTablename(), insertedColumns(cfg, first)
+	for _, m := range batch[1:] {
+		m = prepareMessage(cfg, m)
+		if !isMultiRowInsert(cfg, m) || m.QualifiedTablename() != table || insertedColumns(cfg, m) != columns {
+			break
+		}
+		run = append(run, m)
+	}
+	return run
+}
+
+// insertRows inserts rows of the CDC items of the same table and columns with as few statements as possible,
+// rows are split between statements so the number of parameters of each stays within the limit
+func insertRows(ctx context.Context, conn DBExecutorContext, cfg Config, run []kafka.Message) error {
+	var (
+		fields []string
+		rows   []string
+		args   []interface{}
+	)
+	flush := func() error {
+		if len(rows) == 0 {
+			return nil
+		}
+		sql := fmt.Sprintf("INSERT INTO %s(%s) VALUES %s",
+			run[0].QualifiedTablename(),
+			strings.Join(fields, ","),
+			strings.Join(rows, ","))
+		_, err := conn.Exec(ctx, sql, args...)
+		atomic.AddUint64(&tx, 1)
+		atomic.AddUint64(&multiRowInserts, 1)
+		atomic.AddUint64(&multiRowInsertRows, uint64(len(rows)))
+		loggerOf(cfg).WithField("table", run[0].QualifiedTablename()).WithField("rows", len(rows)).Debug("Multi-row insert applied")
+		rows, args = nil, nil
+		return classify(ErrDBExec, err)
+	}
+	for _, m := range run {
+		row := omitNullDefaults(cfg, m, m.Values)
+		f, refs, rowArgs, err := bindRow(cfg, m, row, args)
+		if err != nil {
+			return err
+		}
+		if len(rowArgs) > maxParams && len(rows) > 0 {
+			if err = flush(); err != nil {
+				return err
+			}
+			if f, refs, rowArgs, err = bindRow(cfg, m, row, nil); err != nil {
+				return err
+			}
+		}
+		if fields == nil {
+			fields = f
+		}
+		// columns of the row image are bound in any order
+		position := make(map[string]int, len(f))
+		for i, field := range f {
+			position[field] = i
+		}
+		ordered := make([]string, len(fields))
+		for i, field := range fields {
+			j, ok := position[field]
+			if !ok {
+				return classify(ErrMissingField, fmt.Errorf("Column %s is missing in the row inserted together", field))
+			}
+			ordered[i] = refs[j]
+		}
+		rows = append(rows, "("+strings.Join(ordered, ",")+")")
+		args = rowArgs
+	}
+	return flush()
+}
@@ -0,0 +1,75 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMultiRowInsert(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestMultiRowInsert")
+	var (
+		statements []string
+		args       [][]interface{}
+	)
+	conn := MockDbTransactor{
+		Tx: MockDbTx{
+			MockDbExec: MockDbExec{
+				ExecHandler: func(sql string, arguments []interface{}) (pgconn.CommandTag, error) {
+					statements = append(statements, sql)
+					args = append(args, arguments)
+					return pgconn.CommandTag("INSERT 0 1"), nil
+				},
+			},
+		},
+	}
+	insert := func(table string, id int, name interface{}) kafka.Message {
+		values := map[string]interface{}{"id": id}
+		if name != nil {
+			values["name"] = name
+		}
+		return kafka.Message{Op: "c", TableName: table, Keys: map[string]interface{}{"id": id}, Values: values}
+	}
+	batch := []kafka.Message{
+		insert("a", 1, "x"),
+		insert("a", 2, "y"),
+		insert("a", 3, "z"),
+		{Op: "d", TableName: "a", Keys: map[string]interface{}{"id": 1}},
+		insert("a", 4, "w"),
+		insert("a", 5, nil),
+		insert("b", 6, nil),
+		insert("b", 7, nil),
+	}
+	s := Stats()
+	applyBatch(context.Background(), conn, Config{}, batch)
+	assert.Len(t, statements, 5, "inserts are combined keeping the order relative to other changes")
+	assert.Equal(t, `INSERT INTO "a"("id","name") VALUES ($1,$2),($3,$4),($5,$6)`, statements[0])
+	assert.Equal(t, []interface{}{1, "x", 2, "y", 3, "z"}, args[0])
+	assert.Equal(t, `DELETE FROM "a" WHERE ("id")=($1)`, statements[1])
+	assert.Equal(t, `INSERT INTO "a"("id","name") VALUES ($1,$2)`, statements[2], "columns differ")
+	assert.Equal(t, `INSERT INTO "a"("id") VALUES ($1)`, statements[3])
+	assert.Equal(t, `INSERT INTO "b"("id") VALUES ($1),($2)`, statements[4])
+	assert.Equal(t, []interface{}{6, 7}, args[4])
+	assert.Equal(t, s.MultiRowInserts+2, Stats().MultiRowInserts)
+	assert.Greater(t, Stats().MultiRowInsertAvg, float64(1))
+
+	// inserts depending on the outcome of each row are not combined
+	for _, c := range []struct {
+		cfg        Config
+		statements int
+	}{
+		{Config{InsertMode: InsertModeIgnore}, 2},
+		{Config{InsertMode: InsertModeGuarded}, 2},
+		{Config{UpsertTables: map[string]bool{"b": true}}, 2},
+		{Config{Ledger: "ledger"}, 4}, // offsets recorded too
+		{Config{SchemaDrift: SchemaDriftSkip}, 2},
+	} {
+		statements = nil
+		applyBatch(context.Background(), conn, c.cfg, batch[6:])
+		assert.Len(t, statements, c.statements, c.cfg)
+	}
+}
@@ -0,0 +1,93 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// OffsetTable is the offset store keeping the last applied offset of each topic partition in the target database
+type OffsetTable struct {
+	conn    DBExecutorContext
+	querier DBQuerierContext
+	table   string // quoted name of the offsets table
+}
+
+// NewOffsetTable connects to the target database and creates the offsets `table`, which may be schema qualified,
+// if it doesn't exist yet
+func NewOffsetTable(ctx context.Context, connString string, table string) (*OffsetTable, error) {
+	conn, err := Connect(ctx, connString)
+	if err != nil {
+		return nil, err
+	}
+	querier, ok := conn.(DBQuerierContext)
+	if !ok {
+		return nil, errors.New("Target database connection doesn't support queries")
+	}
+	s := &OffsetTable{conn: conn, querier: querier, table: pgx.Identifier(strings.Split(table, ".")).Sanitize()}
+	_, err = conn.Exec(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	topic text NOT NULL,
+	partition integer NOT NULL,
+	"offset" bigint NOT NULL,
+	saved_at timestamptz NOT NULL DEFAULT now(),
+	PRIMARY KEY (topic, partition))`, s.table))
+	if err != nil {
+		return nil, classify(ErrDBExec, err)
+	}
+	return s, nil
+}
+
+// Load returns the last saved offset of the topic partition
+func (s *OffsetTable) Load(ctx context.Context, topic string, partition int) (int64, bool, error) {
+	var offset int64
+	err := s.querier.QueryRow(ctx,
+		fmt.Sprintf(`SELECT "offset" FROM %s WHERE topic = $1 AND partition = $2`, s.table),
+		topic, partition).Scan(&offset)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	return offset, true, nil
+}
+
+// Save stores the offset of the topic partition, offsets never move backwards
+func (s *OffsetTable) Save(ctx context.Context, topic string, partition int, offset int64) error {
+	_, err := s.conn.Exec(ctx, fmt.Sprintf(`INSERT INTO %s AS o(topic, partition, "offset") VALUES ($1, $2, $3)
+	ON CONFLICT (topic, partition) DO UPDATE SET "offset" = EXCLUDED."offset", saved_at = now()
+	WHERE o."offset" < EXCLUDED."offset"`, s.table), topic, partition, offset)
+	return classify(ErrDBExec, err)
+}
+
+// saveOffsets saves the highest offset of each topic partition among applied CDC items if `cfg.Offsets` is set
+func saveOffsets(ctx context.Context, cfg Config, applied ...kafka.Message) {
+	if cfg.Offsets == nil {
+		return
+	}
+	type partition struct {
+		topic string
+		n     int
+	}
+	var order []partition
+	offsets := make(map[partition]int64)
+	for _, m := range applied {
+		p := partition{m.Topic, m.Partition}
+		offset, seen := offsets[p]
+		if !seen {
+			order = append(order, p)
+		}
+		if !seen || m.Offset > offset {
+			offsets[p] = m.Offset
+		}
+	}
+	for _, p := range order {
+		if err := cfg.Offsets.Save(ctx, p.topic, p.n, offsets[p]); err != nil {
+			loggerOf(cfg).WithField("topic", p.topic).WithField("offset", offsets[p]).WithError(err).Error("Offset not saved")
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryOffsets map[string]int64
+
+func (s memoryOffsets) Load(ctx context.Context, topic string, partition int) (int64, bool, error) {
+	offset, ok := s[topic]
+	return offset, ok, nil
+}
+
+func (s memoryOffsets) Save(ctx context.Context, topic string, partition int, offset int64) error {
+	s[topic] = offset
+	return nil
+}
+
+func offsetMessage(topic string, offset int64, op string) kafka.Message {
+	return kafka.Message{
+		Message:   kafkago.Message{Topic: topic, Offset: offset},
+		Op:        op,
+		TableName: "t",
+		Values:    map[string]interface{}{"id": offset},
+	}
+}
+
+func TestApplySavesOffsets(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestApplySavesOffsets")
+	store := memoryOffsets{}
+	Connect = func(ctx context.Context, connString string) (DBExecutorContext, error) {
+		return &MockDbExec{
+			ExecHandler: func(string, []interface{}) (pgconn.CommandTag, error) {
+				return pgconn.CommandTag("INSERT 0 1"), nil
+			},
+		}, nil
+	}
+	msgChan := make(chan kafka.Message, 3)
+	msgChan <- offsetMessage("foo", 5, "c")
+	msgChan <- offsetMessage("bar", 7, "c")
+	msgChan <- offsetMessage("foo", 6, "x")
+	Apply(context.Background(), "foo", Config{IdleTimeout: 100 * time.Millisecond, Offsets: store}, msgChan)
+	assert.Equal(t, memoryOffsets{"foo": 5, "bar": 7}, store, "failed item is not saved")
+
+	offset, ok, err := store.Load(context.Background(), "foo", 0)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.EqualValues(t, 5, offset)
+}
+
+func TestApplyBatchSavesOffsets(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestApplyBatchSavesOffsets")
+	store := memoryOffsets{}
+	conn := MockDbTransactor{Tx: MockDbTx{MockDbExec: MockDbExec{
+		ExecHandler: func(string, []interface{}) (pgconn.CommandTag, error) {
+			return pgconn.CommandTag("INSERT 0 1"), nil
+		},
+	}}}
+	batch := []kafka.Message{offsetMessage("foo", 3, "c"), offsetMessage("foo", 2, "c"), offsetMessage("bar", 1, "c")}
+	applyBatch(context.Background(), conn, Config{Offsets: store}, batch)
+	assert.Equal(t, memoryOffsets{"foo": 3, "bar": 1}, store, "highest offset of each topic saved once committed")
+
+	conn.Tx.CommitHandler = func() error { return errors.New("serialization failure") }
+	applyBatch(context.Background(), conn, Config{Offsets: store}, []kafka.Message{offsetMessage("foo", 4, "c")})
+	assert.EqualValues(t, 4, store["foo"], "saved once applied one by one")
+}
+
+func TestOffsetTable(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestOffsetTable")
+	var statements []string
+	saved := map[string]int64{"foo": 41}
+	Connect = func(ctx context.Context, connString string) (DBExecutorContext, error) {
+		return MockDbQuerier{
+			MockDbExec: MockDbExec{
+				ExecHandler: func(sql string, args []interface{}) (pgconn.CommandTag, error) {
+					statements = append(statements, sql)
+					return pgconn.CommandTag("INSERT 0 1"), nil
+				},
+			},
+			QueryRowHandler: func(sql string, args []interface{}) pgx.Row {
+				assert.Contains(t, sql, `FROM "public"."offsets"`)
+				if offset, ok := saved[args[0].(string)]; ok {
+					return MockRow{Values: []interface{}{offset}}
+				}
+				return MockRow{Err: pgx.ErrNoRows}
+			},
+		}, nil
+	}
+	store, err := NewOffsetTable(context.Background(), "foo", "public.offsets")
+	assert.NoError(t, err)
+	assert.Len(t, statements, 1)
+	assert.True(t, strings.HasPrefix(statements[0], `CREATE TABLE IF NOT EXISTS "public"."offsets"`))
+
+	offset, ok, err := store.Load(context.Background(), "foo", 0)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.EqualValues(t, 41, offset)
+	_, ok, err = store.Load(context.Background(), "bar", 0)
+	assert.NoError(t, err)
+	assert.False(t, ok, "nothing saved")
+
+	assert.NoError(t, store.Save(context.Background(), "foo", 0, 42))
+	assert.Contains(t, statements[1], `INSERT INTO "public"."offsets"`)
+
+	Connect = func(ctx context.Context, connString string) (DBExecutorContext, error) {
+		return MockDbExec{}, nil
+	}
+	_, err = NewOffsetTable(context.Background(), "foo", "offsets")
+	assert.Error(t, err, "queries are not supported")
+}
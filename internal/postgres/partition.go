@@ -0,0 +1,183 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// Intervals of the range partitions created on demand
+const (
+	PartitionDay   = "day"
+	PartitionWeek  = "week" // starting on Monday
+	PartitionMonth = "month"
+	PartitionYear  = "year"
+)
+
+// sqlstateCheckViolation is the error code PostgreSQL returns for rows violating check constraints and for rows
+// no partition is found for
+const sqlstateCheckViolation = "23514"
+
+// number of partitions created on demand during session
+var partitionsCreated uint64
+
+// PartitionSpec describes range partitions of the target table created when inserted rows have no partition
+type PartitionSpec struct {
+	Column   string // partition key column, e.g. created_at
+	Interval string // one of the Partition* constants
+	// Name is the name of the partition with {table}, {year}, {month} and {day} replaced by the name of the table
+	// and the start of the partition, e.g. {table}_y{year}m{month}. Empty string means the table name suffixed
+	// by the start, e.g. orders_2021_03 for monthly partitions
+	Name string
+}
+
+// ParsePartitionSpec parses the partition spec in the column:interval[:name] form, e.g. created_at:month
+func ParsePartitionSpec(s string) (PartitionSpec, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) < 2 || parts[0] == "" {
+		return PartitionSpec{}, fmt.Errorf("Invalid partition spec %q, column:interval[:name] expected", s)
+	}
+	spec := PartitionSpec{Column: parts[0], Interval: parts[1]}
+	if len(parts) == 3 {
+		spec.Name = parts[2]
+	}
+	if _, _, err := spec.bounds(time.Time{}); err != nil {
+		return PartitionSpec{}, err
+	}
+	return spec, nil
+}
+
+// bounds returns the range of the partition the `key` belongs to
+func (spec PartitionSpec) bounds(key time.Time) (from, to time.Time, err error) {
+	y, m, d := key.Date()
+	switch spec.Interval {
+	case PartitionDay:
+		from = time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+		return from, from.AddDate(0, 0, 1), nil
+	case PartitionWeek:
+		from = time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+		from = from.AddDate(0, 0, -(int(from.Weekday())+6)%7)
+		return from, from.AddDate(0, 0, 7), nil
+	case PartitionMonth:
+		from = time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+		return from, from.AddDate(0, 1, 0), nil
+	case PartitionYear:
+		from = time.Date(y, 1, 1, 0, 0, 0, 0, time.UTC)
+		return from, from.AddDate(1, 0, 0), nil
+	}
+	return from, to, fmt.Errorf("Invalid partition interval %q, either %q, %q, %q or %q expected",
+		spec.Interval, PartitionDay, PartitionWeek, PartitionMonth, PartitionYear)
+}
+
+// name returns the name of the partition of the `table` starting at `from`
+func (spec PartitionSpec) name(table string, from time.Time) string {
+	name := spec.Name
+	if name == "" {
+		switch spec.Interval {
+		case PartitionYear:
+			name = "{table}_{year}"
+		case PartitionMonth:
+			name = "{table}_{year}_{month}"
+		default:
+			name = "{table}_{year}_{month}_{day}"
+		}
+	}
+	return strings.NewReplacer(
+		"{table}", table,
+		"{year}", from.Format("2006"),
+		"{month}", from.Format("01"),
+		"{day}", from.Format("02"),
+	).Replace(name)
+}
+
+// partitionSpec returns the spec of the partitions of the target table of the CDC item, if any
+func partitionSpec(cfg Config, m kafka.Message) (PartitionSpec, bool) {
+	spec, ok := cfg.Partitions[m.SchemaName+"."+m.TableName]
+	if !ok {
+		spec, ok = cfg.Partitions[m.TableName]
+	}
+	return spec, ok
+}
+
+// isMissingPartition returns true if `err` is reported by PostgreSQL for the row no partition is found for
+func isMissingPartition(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == sqlstateCheckViolation && strings.HasPrefix(pgErr.Message, "no partition of relation")
+}
+
+// partitionKey returns the value of the partition key column of the inserted row
+func partitionKey(cfg Config, m kafka.Message, column string) (time.Time, error) {
+	v, ok := m.Values[column]
+	if !ok || v == nil {
+		return time.Time{}, fmt.Errorf("Partition key column %q is missing in CDC item", column)
+	}
+	arg, _, err := bindValue(cfg, m, column, v, 1)
+	if err != nil {
+		return time.Time{}, err
+	}
+	switch arg := arg.(type) {
+	case time.Time:
+		return arg.UTC(), nil
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999Z07:00", "2006-01-02T15:04:05.999999999",
+			"2006-01-02 15:04:05.999999999", "2006-01-02"} {
+			if t, err := time.Parse(layout, arg); err == nil {
+				return t.UTC(), nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("Invalid partition key %v of type %T, date or timestamp expected", arg, arg)
+}
+
+// createMissingPartition creates the partition of the row the CDC item inserts, returns true if the insert should be
+// retried, i.e. the partition is created, possibly concurrently by another session. Partitions are created within
+// a savepoint if `conn` is a transaction, so it remains usable for the retry if creating fails
+func createMissingPartition(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message) bool {
+	spec, ok := partitionSpec(cfg, m)
+	if !ok {
+		return false
+	}
+	l := loggerOf(cfg).WithField("table", m.QualifiedTablename())
+	key, err := partitionKey(cfg, m, spec.Column)
+	if err != nil {
+		l.WithError(err).Warning("Partition not created")
+		return false
+	}
+	from, to, err := spec.bounds(key)
+	if err != nil {
+		l.WithError(err).Warning("Partition not created")
+		return false
+	}
+	partition := pgx.Identifier{spec.name(m.TableName, from)}
+	if m.SchemaName > "" {
+		partition = pgx.Identifier{m.SchemaName, spec.name(m.TableName, from)}
+	}
+	const literal = "2006-01-02 15:04:05Z07:00"
+	sql := fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES FROM ('%s') TO ('%s')",
+		partition.Sanitize(),
+		m.QualifiedTablename(),
+		from.Format(literal),
+		to.Format(literal))
+	l = l.WithField("partition", partition.Sanitize())
+	if t, ok := conn.(pgx.Tx); ok {
+		_, err = execSavepoint(ctx, t, sql, nil)
+	} else {
+		_, err = conn.Exec(ctx, sql)
+	}
+	atomic.AddUint64(&tx, 1)
+	if err != nil {
+		// the partition may be created concurrently, the retry tells
+		l.WithError(err).Warning("Partition not created, retrying CDC item anyway")
+		return true
+	}
+	atomic.AddUint64(&partitionsCreated, 1)
+	l.Info("Missing partition created")
+	return true
+}
@@ -0,0 +1,134 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePartitionSpec(t *testing.T) {
+	spec, err := ParsePartitionSpec("created_at:month")
+	assert.NoError(t, err)
+	assert.Equal(t, PartitionSpec{Column: "created_at", Interval: PartitionMonth}, spec)
+	spec, err = ParsePartitionSpec("day:week:{table}_w{year}{month}{day}")
+	assert.NoError(t, err)
+	assert.Equal(t, PartitionSpec{Column: "day", Interval: PartitionWeek, Name: "{table}_w{year}{month}{day}"}, spec)
+	_, err = ParsePartitionSpec("created_at")
+	assert.Error(t, err)
+	_, err = ParsePartitionSpec("created_at:quarter")
+	assert.Error(t, err)
+}
+
+func TestPartitionBounds(t *testing.T) {
+	key := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC) // Thursday
+	for _, c := range []struct {
+		interval string
+		from, to string
+		name     string
+	}{
+		{PartitionDay, "2021-03-04", "2021-03-05", "orders_2021_03_04"},
+		{PartitionWeek, "2021-03-01", "2021-03-08", "orders_2021_03_01"},
+		{PartitionMonth, "2021-03-01", "2021-04-01", "orders_2021_03"},
+		{PartitionYear, "2021-01-01", "2022-01-01", "orders_2021"},
+	} {
+		spec := PartitionSpec{Column: "created_at", Interval: c.interval}
+		from, to, err := spec.bounds(key)
+		assert.NoError(t, err)
+		assert.Equal(t, c.from, from.Format("2006-01-02"), c.interval)
+		assert.Equal(t, c.to, to.Format("2006-01-02"), c.interval)
+		assert.Equal(t, c.name, spec.name("orders", from), c.interval)
+	}
+	spec := PartitionSpec{Interval: PartitionMonth, Name: "{table}_y{year}m{month}"}
+	assert.Equal(t, "orders_y2021m03", spec.name("orders", key))
+}
+
+func TestCreateMissingPartition(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestCreateMissingPartition")
+	var (
+		statements []string
+		createErr  error
+		partitions = map[string]bool{}
+	)
+	exec := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			statements = append(statements, s)
+			if strings.HasPrefix(s, "CREATE") {
+				if createErr != nil {
+					return nil, createErr
+				}
+				partitions["2021_03"] = true
+				return pgconn.CommandTag("CREATE TABLE"), nil
+			}
+			if !partitions["2021_03"] {
+				return nil, &pgconn.PgError{Code: "23514", Message: `no partition of relation "orders" found for row`}
+			}
+			return pgconn.CommandTag("INSERT 0 1"), nil
+		},
+	}
+	msg := kafka.Message{
+		Op:         "c",
+		SchemaName: "public",
+		TableName:  "orders",
+		Keys:       map[string]interface{}{"id": 1},
+		Values:     map[string]interface{}{"id": 1, "created_at": json.Number("1614834367000")},
+		Fields:     map[string]kafka.Field{"created_at": {Type: "int64", Name: logicalTimestamp}},
+	}
+	cfg := Config{Partitions: map[string]PartitionSpec{"public.orders": {Column: "created_at", Interval: PartitionMonth}}}
+	created := Stats().PartitionsCreated
+	rowsAffected, err := applyCDCItem(context.Background(), exec, cfg, msg)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, rowsAffected)
+	assert.Equal(t, []string{
+		`INSERT INTO "public"."orders"("created_at","id") VALUES ($1,$2)`,
+		`CREATE TABLE IF NOT EXISTS "public"."orders_2021_03" PARTITION OF "public"."orders" ` +
+			`FOR VALUES FROM ('2021-03-01 00:00:00Z') TO ('2021-04-01 00:00:00Z')`,
+		`INSERT INTO "public"."orders"("created_at","id") VALUES ($1,$2)`,
+	}, statements)
+	assert.Equal(t, created+1, Stats().PartitionsCreated)
+
+	// partitions created concurrently are used by the retry, transactions use savepoints
+	statements, partitions = nil, map[string]bool{}
+	createErr = &pgconn.PgError{Code: "42P07", Message: `relation "orders_2021_03" already exists`}
+	var savepoints int
+	tx := mockSavepointTx{MockDbTx: MockDbTx{MockDbExec: MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			ct, err := exec.ExecHandler(s, a)
+			if strings.HasPrefix(s, "CREATE") {
+				partitions["2021_03"] = true
+			}
+			return ct, err
+		},
+	}}, savepoints: &savepoints}
+	_, err = applyCDCItem(context.Background(), tx, cfg, msg)
+	assert.NoError(t, err)
+	assert.Len(t, statements, 3)
+	assert.Equal(t, 3, savepoints)
+	assert.Equal(t, created+1, Stats().PartitionsCreated)
+
+	// the retry happens once
+	statements, partitions = nil, map[string]bool{}
+	_, err = applyCDCItem(context.Background(), exec, cfg, msg)
+	assert.True(t, isMissingPartition(err))
+	assert.Len(t, statements, 3)
+
+	// other tables and rows without the partition key fail
+	statements = nil
+	msg.TableName = "events"
+	_, err = applyCDCItem(context.Background(), exec, cfg, msg)
+	assert.True(t, isMissingPartition(err))
+	assert.Len(t, statements, 1)
+	statements = nil
+	msg.TableName = "orders"
+	delete(msg.Values, "created_at")
+	_, err = applyCDCItem(context.Background(), exec, cfg, msg)
+	assert.True(t, errors.Is(err, ErrDBExec))
+	assert.Len(t, statements, 1)
+}
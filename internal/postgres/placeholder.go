@@ -0,0 +1,24 @@
+package postgres
+
+import "strconv"
+
+// placeholder returns the reference to the n-th statement parameter with an explicit cast if specified
+func placeholder(n int, cast string) string {
+	ref := "$" + strconv.Itoa(n)
+	if cast > "" {
+		return ref + "::" + cast
+	}
+	return ref
+}
+
+// placeholderBuilder numbers statement parameters consecutively after the `offset` parameters already bound,
+// so rows bound one after another into the same statement continue the numbering, e.g. ($1,$2),($3,$4)
+type placeholderBuilder struct {
+	offset int
+}
+
+// next returns the number of the next statement parameter
+func (p *placeholderBuilder) next() int {
+	p.offset++
+	return p.offset
+}
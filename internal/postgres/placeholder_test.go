@@ -0,0 +1,37 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlaceholderBuilder(t *testing.T) {
+	params := placeholderBuilder{}
+	assert.Equal(t, 1, params.next())
+	assert.Equal(t, 2, params.next())
+	params = placeholderBuilder{offset: 3}
+	assert.Equal(t, 4, params.next())
+
+	assert.Equal(t, "$7", placeholder(7, ""))
+	assert.Equal(t, "$7::jsonb", placeholder(7, "jsonb"))
+}
+
+func TestBindRowPlaceholders(t *testing.T) {
+	cfg := Config{ColumnTypes: map[string]string{"a.doc": "jsonb"}}
+	row := func(id int, doc string) kafka.Message {
+		return kafka.Message{Op: "c", TableName: "a", Values: map[string]interface{}{"id": id, "doc": doc, "name": "x"}}
+	}
+	// the second row of the batched insert continues the numbering of the first
+	first, second := row(1, "{}"), row(2, "[]")
+	fields, refs, args, err := bindRow(cfg, first, first.Values, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`"doc"`, `"id"`, `"name"`}, fields)
+	assert.Equal(t, []string{"$1::jsonb", "$2", "$3"}, refs)
+	fields, refs, args, err = bindRow(cfg, second, second.Values, args)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`"doc"`, `"id"`, `"name"`}, fields)
+	assert.Equal(t, []string{"$4::jsonb", "$5", "$6"}, refs)
+	assert.Equal(t, []interface{}{"{}", 1, "x", "[]", 2, "x"}, args)
+}
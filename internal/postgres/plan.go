@@ -0,0 +1,331 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// Update policies of the table, i.e. how to apply CDC items with update operation
+const (
+	UpdatePolicyUpdate  = UpdateModeUpdate // UPDATE of all the columns of the new row image
+	UpdatePolicyMerge   = UpdateModeMerge  // MERGE inserting missing rows, requires PostgreSQL 15+
+	UpdatePolicyChanged = "changed"        // UPDATE of the columns differing from the old row image only
+)
+
+// Delete policies of the table, i.e. how to apply CDC items with delete operation
+const (
+	DeletePolicyDelete = "delete" // DELETE matching the key
+	DeletePolicySoft   = "soft"   // UPDATE marking the row matching the key deleted
+	DeletePolicySkip   = "skip"   // deletes are skipped, so rows are kept
+)
+
+// SoftDeleteColumn is the boolean column soft deletes set to true unless configured otherwise
+const SoftDeleteColumn = "__deleted"
+
+// TablePolicy holds the policies of applying CDC items of each operation to the table. Empty policies fall back to
+// the modes applying to all tables
+type TablePolicy struct {
+	Insert           string // one of InsertMode* constants
+	Update           string // one of UpdatePolicy* constants
+	Delete           string // one of DeletePolicy* constants
+	SoftDeleteColumn string // boolean column soft deletes set, SoftDeleteColumn by default
+}
+
+// String returns the policy in the form ParseTablePolicy accepts
+func (p TablePolicy) String() string {
+	var parts []string
+	for _, kv := range [][2]string{{"insert", p.Insert}, {"update", p.Update}, {"delete", p.Delete},
+		{"soft-delete-column", p.SoftDeleteColumn}} {
+		if kv[1] > "" {
+			parts = append(parts, kv[0]+"="+kv[1])
+		}
+	}
+	return strings.Join(parts, ":")
+}
+
+// ParseTablePolicy parses the table policy in the op=policy[:op=policy...] form, e.g. insert=upsert:delete=soft.
+// Operations are insert, update and delete, soft-delete-column names the column soft deletes set
+func ParseTablePolicy(s string) (TablePolicy, error) {
+	var p TablePolicy
+	for _, part := range strings.Split(s, ":") {
+		i := strings.Index(part, "=")
+		if i <= 0 || i == len(part)-1 {
+			return p, fmt.Errorf("Invalid table policy %q, op=policy[:op=policy...] expected", s)
+		}
+		op, policy := part[:i], part[i+1:]
+		var valid []string
+		switch op {
+		case "insert":
+			p.Insert, valid = policy, []string{InsertModePlain, InsertModeGuarded, InsertModeIgnore, InsertModeUpsert}
+		case "update":
+			p.Update, valid = policy, []string{UpdatePolicyUpdate, UpdatePolicyMerge, UpdatePolicyChanged}
+		case "delete":
+			p.Delete, valid = policy, []string{DeletePolicyDelete, DeletePolicySoft, DeletePolicySkip}
+		case "soft-delete-column":
+			p.SoftDeleteColumn = policy
+			continue
+		default:
+			return p, fmt.Errorf("Unknown operation %q of table policy %q, insert, update or delete expected", op, s)
+		}
+		if !contains(valid, policy) {
+			return p, fmt.Errorf("Invalid %s policy %q, one of %s expected", op, policy, strings.Join(valid, ", "))
+		}
+	}
+	return p, nil
+}
+
+// contains returns true if `s` is one of `values`
+func contains(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// tablePolicy returns the policy of the target table of the CDC item
+func tablePolicy(cfg Config, m kafka.Message) (TablePolicy, bool) {
+	p, ok := cfg.TablePolicies[m.SchemaName+"."+m.TableName]
+	if !ok {
+		p, ok = cfg.TablePolicies[m.TableName]
+	}
+	return p, ok
+}
+
+// hasTablePolicy returns true if the target table of the CDC item has the policy configured
+func hasTablePolicy(cfg Config, m kafka.Message) bool {
+	_, ok := tablePolicy(cfg, m)
+	return ok
+}
+
+// insertMode returns the insert mode of the target table of the CDC item
+func insertMode(cfg Config, m kafka.Message) string {
+	if p, _ := tablePolicy(cfg, m); p.Insert > "" {
+		return p.Insert
+	}
+	return cfg.InsertMode
+}
+
+// updatePolicy returns the update policy configured for the target table of the CDC item, empty string if none
+func updatePolicy(cfg Config, m kafka.Message) string {
+	p, _ := tablePolicy(cfg, m)
+	return p.Update
+}
+
+// deletePolicy returns the delete policy of the target table of the CDC item, DeletePolicyDelete by default
+func deletePolicy(cfg Config, m kafka.Message) string {
+	if p, _ := tablePolicy(cfg, m); p.Delete > "" {
+		return p.Delete
+	}
+	return DeletePolicyDelete
+}
+
+// softDeleteColumn returns the column soft deletes from the target table of the CDC item set
+func softDeleteColumn(cfg Config, m kafka.Message) string {
+	if p, _ := tablePolicy(cfg, m); p.SoftDeleteColumn > "" {
+		return p.SoftDeleteColumn
+	}
+	return SoftDeleteColumn
+}
+
+// Plan returns the effective policies of the tables with policies configured, i.e. completed with the modes
+// applying to all tables, keyed by table
+func Plan(cfg Config) map[string]TablePolicy {
+	plan := make(map[string]TablePolicy, len(cfg.TablePolicies))
+	for table, p := range cfg.TablePolicies {
+		if p.Insert == "" {
+			p.Insert = cfg.InsertMode
+			if p.Insert == "" {
+				p.Insert = InsertModePlain
+			}
+		}
+		if p.Update == "" {
+			p.Update = cfg.UpdateMode
+			if p.Update == "" {
+				p.Update = UpdatePolicyUpdate
+			}
+		}
+		if p.Delete == "" {
+			p.Delete = DeletePolicyDelete
+		}
+		if p.Delete == DeletePolicySoft && p.SoftDeleteColumn == "" {
+			p.SoftDeleteColumn = SoftDeleteColumn
+		}
+		plan[table] = p
+	}
+	return plan
+}
+
+// LogPlan logs the effective policies of each table with policies configured, so misconfigurations are visible
+// before any change is applied
+func LogPlan(cfg Config) {
+	plan := Plan(cfg)
+	tables := make([]string, 0, len(plan))
+	for table := range plan {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		p := plan[table]
+		l := loggerOf(cfg).WithField("table", table).WithField("insert", p.Insert).WithField("update", p.Update).
+			WithField("delete", p.Delete)
+		if p.SoftDeleteColumn > "" {
+			l = l.WithField("soft-delete-column", p.SoftDeleteColumn)
+		}
+		if keys := keyColumns(cfg, planMessage(table)); len(keys) > 0 {
+			l = l.WithField("keys", strings.Join(keys, ","))
+		}
+		l.Info("Table plan")
+	}
+}
+
+// planMessage returns the CDC item of the table named as "table" or "schema.table" to look its settings up
+func planMessage(table string) kafka.Message {
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		return kafka.Message{SchemaName: table[:i], TableName: table[i+1:]}
+	}
+	return kafka.Message{TableName: table}
+}
+
+// sqlPlan checks the table named by $1 exists and returns which of the columns $2 it lacks and whether it has
+// the primary key
+const sqlPlan = `SELECT t.oid IS NOT NULL,
+	array(SELECT c FROM unnest($2::text[]) c WHERE NOT EXISTS (
+		SELECT 1 FROM pg_attribute WHERE attrelid = t.oid AND attname = c AND attnum > 0 AND NOT attisdropped)),
+	EXISTS (SELECT 1 FROM pg_index WHERE indrelid = t.oid AND indisprimary)
+FROM (SELECT to_regclass($1) AS oid) t`
+
+// CheckPlan connects to the target database and checks the tables of the plan exist, have the key columns configured
+// for them or the primary key otherwise, and the soft delete columns. Merges require PostgreSQL 15+.
+// All the problems found are reported in the returned error
+func CheckPlan(ctx context.Context, connString string, cfg Config) error {
+	plan := Plan(cfg)
+	if len(plan) == 0 {
+		return nil
+	}
+	conn, err := Connect(ctx, connString)
+	if err != nil {
+		return err
+	}
+	if c, ok := conn.(interface{ Close() }); ok {
+		defer c.Close()
+	}
+	querier, ok := conn.(DBQuerierContext)
+	if !ok {
+		return errors.New("Target database connection doesn't support queries")
+	}
+	tables := make([]string, 0, len(plan))
+	for table := range plan {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	var problems []string
+	merges := false
+	for _, table := range tables {
+		p := plan[table]
+		merges = merges || p.Update == UpdatePolicyMerge
+		keys := keyColumns(cfg, planMessage(table))
+		columns := append([]string{}, keys...)
+		if p.Delete == DeletePolicySoft {
+			columns = append(columns, p.SoftDeleteColumn)
+		}
+		var (
+			exists, primary bool
+			missing         []string
+		)
+		name := pgx.Identifier(strings.Split(table, ".")).Sanitize()
+		err := querier.QueryRow(ctx, sqlPlan, name, columns).Scan(&exists, &missing, &primary)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s: %v", table, err))
+		case !exists:
+			problems = append(problems, table+": table does not exist")
+		default:
+			for _, c := range missing {
+				problems = append(problems, fmt.Sprintf("%s: column %q does not exist", table, c))
+			}
+			if len(keys) == 0 && !primary {
+				problems = append(problems, table+": primary key is missing")
+			}
+		}
+	}
+	if merges {
+		var version string
+		if err := querier.QueryRow(ctx, "SHOW server_version_num").Scan(&version); err != nil {
+			problems = append(problems, fmt.Sprintf("server version: %v", err))
+		} else if n, err := strconv.Atoi(version); err != nil || n < mergeMinVersion {
+			problems = append(problems, "merge update policy requires PostgreSQL 15+, server version is "+version)
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New("Table plan check failed: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// changedColumns returns the CDC item with the new row image reduced to the columns differing from the old row image.
+// The row image is kept whole if there is no old row image to compare with
+func changedColumns(m kafka.Message) kafka.Message {
+	if len(m.Before) == 0 {
+		return m
+	}
+	values := make(map[string]interface{}, len(m.Values))
+	for c, v := range m.Values {
+		if old, ok := m.Before[c]; !ok || !reflect.DeepEqual(old, v) {
+			values[c] = v
+		}
+	}
+	m.Values = values
+	return m
+}
+
+// softDeleteCDCItem marks the row matching the key deleted instead of deleting it. Policies for deletes of missing
+// rows apply as to plain deletes
+func softDeleteCDCItem(ctx context.Context, conn DBExecutorContext, cfg Config, message kafka.Message) (int64, error) {
+	keys := matchedColumns(cfg, message)
+	if len(keys) == 0 {
+		return 0, classify(ErrMissingField, errors.New("Neither key nor old row image available to match deleted row"))
+	}
+	policy, err := deleteMissingPolicy(cfg, message)
+	if err != nil {
+		return 0, err
+	}
+	args := make([]interface{}, 0, len(keys))
+	refs := make([]string, 0, len(keys))
+	fields := make([]string, 0, len(keys))
+	for f, v := range keys {
+		arg, field, ref, err := bindKey(cfg, message, f, v, len(args)+1)
+		if err != nil {
+			return 0, err
+		}
+		fields = append(fields, field)
+		args = append(args, arg)
+		refs = append(refs, ref)
+	}
+	sql := fmt.Sprintf("UPDATE %s SET %s=true WHERE %s",
+		message.QualifiedTablename(),
+		strconv.Quote(softDeleteColumn(cfg, message)),
+		matchRow(fields, refs, args))
+	ct, err := conn.Exec(ctx, sql, args...)
+	err = classify(ErrDBExec, err)
+	atomic.AddUint64(&tx, 1)
+	if err == nil && ct.RowsAffected() == 0 {
+		switch policy {
+		case DeleteMissingOK:
+			atomic.AddUint64(&missingDeletes, 1)
+		case DeleteMissingStrict:
+			err = classify(ErrRowMissing, fmt.Errorf("Deleted row is missing in %s", message.QualifiedTablename()))
+		}
+	}
+	return ct.RowsAffected(), err
+}
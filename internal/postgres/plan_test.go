@@ -0,0 +1,145 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTablePolicy(t *testing.T) {
+	p, err := ParseTablePolicy("insert=upsert:delete=soft:soft-delete-column=is_deleted")
+	assert.NoError(t, err)
+	assert.Equal(t, TablePolicy{Insert: InsertModeUpsert, Delete: DeletePolicySoft, SoftDeleteColumn: "is_deleted"}, p)
+	assert.Equal(t, "insert=upsert:delete=soft:soft-delete-column=is_deleted", p.String())
+
+	p, err = ParseTablePolicy("update=changed")
+	assert.NoError(t, err)
+	assert.Equal(t, TablePolicy{Update: UpdatePolicyChanged}, p)
+
+	for _, s := range []string{"", "insert", "insert=", "=upsert", "truncate=skip", "insert=soft", "delete=ok"} {
+		_, err = ParseTablePolicy(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	Logger = logger.WithField("method", "TestPlan")
+	cfg := Config{
+		InsertMode: InsertModeGuarded,
+		TablePolicies: map[string]TablePolicy{
+			"public.orders": {Insert: InsertModeUpsert, Delete: DeletePolicySoft},
+			"order_items":   {Insert: InsertModeIgnore, Update: UpdatePolicyChanged},
+		},
+		KeyColumns: map[string]bool{"order_items.order_id": true, "order_items.line": true},
+	}
+	assert.Equal(t, map[string]TablePolicy{
+		"public.orders": {Insert: InsertModeUpsert, Update: UpdatePolicyUpdate, Delete: DeletePolicySoft, SoftDeleteColumn: SoftDeleteColumn},
+		"order_items":   {Insert: InsertModeIgnore, Update: UpdatePolicyChanged, Delete: DeletePolicyDelete},
+	}, Plan(cfg))
+
+	LogPlan(cfg)
+	if assert.Len(t, hook.AllEntries(), 2) {
+		assert.Equal(t, logrus.Fields{"method": "TestPlan", "table": "order_items", "insert": "ignore", "update": "changed",
+			"delete": "delete", "keys": "line,order_id"}, hook.AllEntries()[0].Data)
+		assert.Equal(t, "soft", hook.AllEntries()[1].Data["delete"])
+	}
+
+	var columns [][]string
+	rows := map[string]MockRow{
+		`"public"."orders"`: {Values: []interface{}{true, []string{SoftDeleteColumn}, true}},
+		`"order_items"`:     {Values: []interface{}{true, []string{}, false}},
+	}
+	Connect = func(ctx context.Context, connString string) (DBExecutorContext, error) {
+		return MockDbQuerier{
+			QueryRowHandler: func(sql string, args []interface{}) pgx.Row {
+				if sql == "SHOW server_version_num" {
+					return MockRow{Values: []interface{}{"140005"}}
+				}
+				columns = append(columns, args[1].([]string))
+				return rows[args[0].(string)]
+			},
+		}, nil
+	}
+	err := CheckPlan(context.Background(), "foo", cfg)
+	assert.EqualError(t, err, `Table plan check failed: public.orders: column "__deleted" does not exist`)
+	assert.Equal(t, [][]string{{"line", "order_id"}, {SoftDeleteColumn}}, columns, "key and soft delete columns are checked")
+
+	rows[`"public"."orders"`] = MockRow{Values: []interface{}{true, []string{}, false}}
+	cfg.TablePolicies["public.orders"] = TablePolicy{Update: UpdatePolicyMerge}
+	cfg.TablePolicies["missing"] = TablePolicy{}
+	rows[`"missing"`] = MockRow{Values: []interface{}{false, []string{}, false}}
+	err = CheckPlan(context.Background(), "foo", cfg)
+	assert.EqualError(t, err, "Table plan check failed: missing: table does not exist; public.orders: primary key is missing; "+
+		"merge update policy requires PostgreSQL 15+, server version is 140005")
+
+	assert.NoError(t, CheckPlan(context.Background(), "foo", Config{}), "nothing to check")
+}
+
+func TestApplyTablePolicies(t *testing.T) {
+	logger, hook := test.NewNullLogger()
+	Logger = logger.WithField("method", "TestApplyTablePolicies")
+	var (
+		sqls     []string
+		affected = "UPDATE 1"
+	)
+	conn := MockDbExec{ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+		sqls = append(sqls, s)
+		return pgconn.CommandTag(affected), nil
+	}}
+	cfg := Config{TablePolicies: map[string]TablePolicy{
+		"public.orders": {Insert: InsertModeUpsert, Delete: DeletePolicySoft, SoftDeleteColumn: "is_deleted"},
+		"order_items":   {Insert: InsertModeIgnore, Update: UpdatePolicyChanged, Delete: DeletePolicySkip},
+	}}
+	orders := kafka.Message{Op: "c", SchemaName: "public", TableName: "orders",
+		Keys:   map[string]interface{}{"id": int64(1)},
+		Values: map[string]interface{}{"id": int64(1), "qty": int64(2)},
+	}
+	items := orders
+	items.TableName = "order_items"
+	ctx := context.Background()
+	apply := func(m kafka.Message) {
+		sqls = nil
+		_ = applyMessage(ctx, conn, cfg, m)
+	}
+
+	apply(orders)
+	assert.Equal(t, []string{`INSERT INTO "public"."orders"("id","qty") VALUES ($1,$2) ON CONFLICT ("id") DO UPDATE SET "qty"=EXCLUDED."qty"`}, sqls)
+	apply(items)
+	assert.Equal(t, []string{`INSERT INTO "public"."order_items"("id","qty") VALUES ($1,$2) ON CONFLICT ("id") DO NOTHING`}, sqls)
+	assert.False(t, isMultiRowInsert(cfg, items))
+
+	del := orders
+	del.Op, del.Values = "d", nil
+	apply(del)
+	assert.Equal(t, []string{`UPDATE "public"."orders" SET "is_deleted"=true WHERE ("id")=($1)`}, sqls, "soft delete")
+	del.TableName = "order_items"
+	apply(del)
+	assert.Empty(t, sqls, "deletes skipped")
+
+	upd := items
+	upd.Op = "u"
+	upd.Before = map[string]interface{}{"id": int64(1), "qty": int64(1)}
+	apply(upd)
+	assert.Equal(t, []string{`UPDATE "public"."order_items" SET ("qty")=($2) WHERE ("id")=($1)`}, sqls, "changed columns only")
+	upd.Before = upd.Values
+	apply(upd)
+	assert.Empty(t, sqls, "nothing changed")
+	assert.Empty(t, hook.AllEntries(), "neither errors nor no changes warnings")
+
+	// other tables keep the modes applying to all tables
+	other := orders
+	other.TableName = "customers"
+	apply(other)
+	assert.Equal(t, []string{`INSERT INTO "public"."customers"("id","qty") VALUES ($1,$2)`}, sqls)
+	affected = "UPDATE 0"
+	other.Op, other.Values = "d", nil
+	apply(other)
+	assert.Equal(t, []string{`DELETE FROM "public"."customers" WHERE ("id")=($1)`}, sqls)
+}
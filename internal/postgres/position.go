@@ -0,0 +1,143 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+)
+
+// PositionColumn is the column of the target tables holding the source position of the last change applied to the row
+const PositionColumn = "__source_lsn"
+
+// number of changes skipped as older than the ones already applied to the rows during session
+var staleChanges uint64
+
+// rowVersion is the column of the target rows holding the version of the last change applied to the row,
+// changes of older versions are skipped
+type rowVersion struct {
+	column string      // name of the column
+	value  interface{} // version of the CDC item
+	field  kafka.Field // describes the value, so it's bound the same way as the values of the row image
+}
+
+// guardsPosition returns true if changes of the CDC item older than the ones already applied to the row are skipped
+func guardsPosition(cfg Config, m kafka.Message) bool {
+	return cfg.PositionGuard && !cfg.AppendMode && m.Position > 0
+}
+
+// rowVersions returns the versions of the CDC item guarding the target row, none if changes are applied regardless
+func rowVersions(cfg Config, m kafka.Message) []rowVersion {
+	var versions []rowVersion
+	if guardsPosition(cfg, m) {
+		versions = append(versions, rowVersion{PositionColumn, m.Position, kafka.Field{Type: "int64"}})
+	}
+	if winsLastWrite(cfg, m) {
+		versions = append(versions, rowVersion{UpdatedColumn, updatedValue(m), kafka.Field{Type: "string", Name: logicalZonedTimestamp}})
+	}
+	return versions
+}
+
+// withVersions returns the CDC item writing its versions to the version columns of the row by inserts and updates
+func withVersions(cfg Config, m kafka.Message) kafka.Message {
+	versions := rowVersions(cfg, m)
+	if len(versions) == 0 {
+		return m
+	}
+	fields := make(map[string]kafka.Field, len(m.Fields)+len(versions))
+	for k, f := range m.Fields {
+		fields[k] = f
+	}
+	for _, v := range versions {
+		fields[v.column] = v.field
+	}
+	m.Fields = fields
+	if m.Op != "c" && m.Op != "u" && m.Op != "r" {
+		return m
+	}
+	values := make(map[string]interface{}, len(m.Values)+len(versions))
+	for k, v := range m.Values {
+		values[k] = v
+	}
+	for _, v := range versions {
+		values[v.column] = v.value
+	}
+	m.Values = values
+	return m
+}
+
+// guardVersions returns the condition matching rows which versions are not newer than the ones referenced by `ref`.
+// Version columns are prefixed by `qualifier`, e.g. the table alias. Rows written before the guard was enabled
+// have no version and are matched too
+func guardVersions(versions []rowVersion, qualifier string, ref func(v rowVersion) string) string {
+	conditions := make([]string, len(versions))
+	for i, v := range versions {
+		column := qualifier + strconv.Quote(v.column)
+		conditions[i] = "(" + column + " IS NULL OR " + column + "<=" + ref(v) + ")"
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+// bindVersions binds the versions of the CDC item as parameters following `args`, returns the condition comparing
+// them to the versions of the row and arguments
+func bindVersions(cfg Config, m kafka.Message, versions []rowVersion, args []interface{}) (string, []interface{}, error) {
+	refs := make(map[string]string, len(versions))
+	for _, v := range versions {
+		arg, ref, err := bindValue(cfg, m, v.column, v.value, len(args)+1)
+		if err != nil {
+			return "", nil, err
+		}
+		args = append(args, arg)
+		refs[v.column] = ref
+	}
+	return guardVersions(versions, "", func(v rowVersion) string { return refs[v.column] }), args, nil
+}
+
+// isStale returns true and counts the CDC item as stale if the row matching the key condition `match` holds a newer
+// version, i.e. the guard caused no changes rather than the row is missing. `args` are the arguments of `match`
+func isStale(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message, versions []rowVersion,
+	match string, args []interface{}) bool {
+	querier, ok := conn.(DBQuerierContext)
+	if !ok {
+		return false
+	}
+	args = append(make([]interface{}, 0, len(args)+len(versions)), args...)
+	newer := make([]string, len(versions))
+	for i, v := range versions {
+		arg, ref, err := bindValue(cfg, m, v.column, v.value, len(args)+1)
+		if err != nil {
+			return false
+		}
+		args = append(args, arg)
+		newer[i] = strconv.Quote(v.column) + ">" + ref
+	}
+	sql := fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s WHERE %s AND (%s))",
+		m.QualifiedTablename(), match, strings.Join(newer, " OR "))
+	var stale bool
+	if err := querier.QueryRow(ctx, sql, args...).Scan(&stale); err != nil {
+		loggerOf(cfg).WithError(err).Debug("Version of the row is unknown")
+		return false
+	}
+	if stale {
+		countStale(cfg, m)
+	}
+	return stale
+}
+
+// isStaleMatch is isStale for the row matched the same way updates and deletes of the CDC item match it
+func isStaleMatch(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message, versions []rowVersion) bool {
+	match, args, err := bindMatch(cfg, m, matchedColumns(cfg, m), nil)
+	if err != nil {
+		return false
+	}
+	return isStale(ctx, conn, cfg, m, versions, match, args)
+}
+
+// countStale counts the CDC item skipped as older than the row
+func countStale(cfg Config, m kafka.Message) {
+	atomic.AddUint64(&staleChanges, 1)
+	loggerOf(cfg).WithField("position", m.Position).WithField("offset", m.Offset).Debug("CDC item older than the row, skipped")
+}
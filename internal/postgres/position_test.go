@@ -0,0 +1,120 @@
+package postgres
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPositionGuard(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestPositionGuard")
+	var (
+		sqls     []string
+		args     [][]interface{}
+		affected = "UPDATE 1"
+		newer    = true
+	)
+	conn := MockDbQuerier{
+		MockDbExec: MockDbExec{ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sqls, args = append(sqls, s), append(args, a)
+			return pgconn.CommandTag(affected), nil
+		}},
+		QueryRowHandler: func(s string, a []interface{}) pgx.Row {
+			sqls, args = append(sqls, s), append(args, a)
+			return MockRow{Values: []interface{}{newer}}
+		},
+	}
+	msg := kafka.Message{
+		Op:        "u",
+		TableName: "orders",
+		Keys:      map[string]interface{}{"id": int64(1)},
+		Values:    map[string]interface{}{"id": int64(1), "qty": int64(5)},
+		Fields:    map[string]kafka.Field{"id": {Type: "int64"}, "qty": {Type: "int64"}},
+		Position:  42,
+	}
+	cfg := Config{PositionGuard: true}
+	ctx := context.Background()
+	apply := func(m kafka.Message) {
+		sqls, args = nil, nil
+		_, err := applyCDCItem(ctx, conn, cfg, prepareMessage(cfg, m))
+		assert.NoError(t, err)
+	}
+
+	apply(msg)
+	assert.Equal(t, []string{`UPDATE "orders" SET ("__source_lsn","id","qty")=($2,$3,$4) WHERE ("id")=($1) AND ("__source_lsn" IS NULL OR "__source_lsn"<=$5)`}, sqls)
+	assert.Equal(t, []interface{}{int64(1), int64(42), int64(1), int64(5), int64(42)}, args[0])
+	assert.NotContains(t, msg.Values, PositionColumn, "CDC item is not changed")
+
+	stale := atomic.LoadUint64(&staleChanges)
+	affected = "UPDATE 0"
+	apply(msg)
+	if assert.Len(t, sqls, 2) {
+		assert.Equal(t, `SELECT EXISTS (SELECT 1 FROM "orders" WHERE ("id")=($1) AND ("__source_lsn">$2))`, sqls[1])
+		assert.Equal(t, []interface{}{int64(1), int64(42)}, args[1])
+	}
+	assert.Equal(t, stale+1, atomic.LoadUint64(&staleChanges), "older than the row")
+	assert.False(t, changesRows(cfg, prepareMessage(cfg, msg)), "not warned about")
+
+	newer = false
+	apply(msg)
+	assert.Equal(t, stale+1, atomic.LoadUint64(&staleChanges), "row is missing")
+
+	del := msg
+	del.Op, del.Values = "d", nil
+	affected = "DELETE 0"
+	newer = true
+	apply(del)
+	if assert.Len(t, sqls, 2) {
+		assert.Equal(t, `DELETE FROM "orders" WHERE ("id")=($1) AND ("__source_lsn" IS NULL OR "__source_lsn"<=$2)`, sqls[0])
+		assert.Equal(t, []interface{}{int64(1), int64(42)}, args[0])
+	}
+	assert.Equal(t, stale+2, atomic.LoadUint64(&staleChanges))
+
+	cfg.DeleteMissing = map[string]string{"orders": DeleteMissingStrict}
+	_, err := applyCDCItem(ctx, conn, cfg, prepareMessage(cfg, del))
+	assert.NoError(t, err, "stale deletes don't fail")
+	newer = false
+	_, err = applyCDCItem(ctx, conn, cfg, prepareMessage(cfg, del))
+	assert.Error(t, err, "row is missing")
+	cfg.DeleteMissing = nil
+
+	ins := msg
+	ins.Op = "c"
+	affected = "INSERT 0 1"
+	apply(ins)
+	assert.Equal(t, []string{`INSERT INTO "orders"("__source_lsn","id","qty") VALUES ($1,$2,$3)`}, sqls)
+
+	cfg.InsertMode = InsertModeUpsert
+	affected = "INSERT 0 0"
+	apply(ins)
+	assert.Equal(t, []string{`INSERT INTO "orders"("__source_lsn","id","qty") VALUES ($1,$2,$3) ON CONFLICT ("id") ` +
+		`DO UPDATE SET "__source_lsn"=EXCLUDED."__source_lsn","qty"=EXCLUDED."qty" ` +
+		`WHERE ("orders"."__source_lsn" IS NULL OR "orders"."__source_lsn"<=EXCLUDED."__source_lsn")`}, sqls)
+	assert.Equal(t, stale+4, atomic.LoadUint64(&staleChanges), "conflicting row is newer")
+	cfg.InsertMode = ""
+
+	cfg.UpdateMode = UpdateModeMerge
+	affected = "MERGE 1"
+	apply(msg)
+	assert.Equal(t, []string{`MERGE INTO "orders" AS t USING (SELECT 1) AS s ` +
+		`ON (t."id")=($4) WHEN MATCHED AND (t."__source_lsn" IS NULL OR t."__source_lsn"<=$1) ` +
+		`THEN UPDATE SET "__source_lsn"=$1,"id"=$2,"qty"=$3 ` +
+		`WHEN NOT MATCHED THEN INSERT ("__source_lsn","id","qty") VALUES ($1,$2,$3)`}, sqls)
+	cfg.UpdateMode = ""
+
+	unknown := msg
+	unknown.Position = 0
+	affected = "UPDATE 1"
+	apply(unknown)
+	assert.Equal(t, []string{`UPDATE "orders" SET ("id","qty")=($2,$3) WHERE ("id")=($1)`}, sqls, "position unknown")
+
+	cfg.PositionGuard = false
+	apply(msg)
+	assert.Equal(t, []string{`UPDATE "orders" SET ("id","qty")=($2,$3) WHERE ("id")=($1)`}, sqls, "guard disabled")
+}
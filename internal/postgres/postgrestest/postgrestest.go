@@ -0,0 +1,133 @@
+// Package postgrestest provides test doubles of the target database, so the apply logic can be tested
+// without a running PostgreSQL server
+package postgrestest
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/jackc/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+// Statement is the SQL statement executed together with its arguments
+type Statement struct {
+	SQL  string
+	Args []interface{}
+}
+
+// MemExecutor records statements executed against it and returns configured results,
+// it implements postgres.DBExecutorContext and is safe for concurrent use
+type MemExecutor struct {
+	// RowsAffected is the number of rows reported as affected by each statement
+	RowsAffected int64
+	// Err is the error returned by each statement, statements failing are recorded too
+	Err error
+	// Handler overrides RowsAffected and Err per statement if set
+	Handler func(sql string, args []interface{}) (int64, error)
+
+	mu         sync.Mutex
+	statements []Statement
+}
+
+// NewMemExecutor returns the executor reporting `rowsAffected` rows affected by each statement
+func NewMemExecutor(rowsAffected int64) *MemExecutor {
+	return &MemExecutor{RowsAffected: rowsAffected}
+}
+
+// Exec records the statement and returns the command tag with the configured number of affected rows
+func (m *MemExecutor) Exec(ctx context.Context, sql string, arguments ...interface{}) (pgconn.CommandTag, error) {
+	m.mu.Lock()
+	m.statements = append(m.statements, Statement{SQL: sql, Args: append([]interface{}(nil), arguments...)})
+	rowsAffected, err, handler := m.RowsAffected, m.Err, m.Handler
+	m.mu.Unlock()
+	if handler != nil {
+		rowsAffected, err = handler(sql, arguments)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return commandTag(sql, rowsAffected), nil
+}
+
+// commandTag returns the command tag PostgreSQL reports for the statement affecting `rowsAffected` rows
+func commandTag(sql string, rowsAffected int64) pgconn.CommandTag {
+	verb := "SELECT"
+	if fields := strings.Fields(sql); len(fields) > 0 {
+		verb = strings.ToUpper(fields[0])
+	}
+	if verb == "INSERT" {
+		return pgconn.CommandTag(fmt.Sprintf("INSERT 0 %d", rowsAffected))
+	}
+	return pgconn.CommandTag(fmt.Sprintf("%s %d", verb, rowsAffected))
+}
+
+// Statements returns statements executed so far in the order of execution
+func (m *MemExecutor) Statements() []Statement {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]Statement(nil), m.statements...)
+}
+
+// SQL returns SQL of statements executed so far in the order of execution
+func (m *MemExecutor) SQL() []string {
+	statements := m.Statements()
+	sql := make([]string, len(statements))
+	for i, s := range statements {
+		sql[i] = s.SQL
+	}
+	return sql
+}
+
+// Last returns the statement executed last, ok is false if nothing is executed yet
+func (m *MemExecutor) Last() (s Statement, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.statements) == 0 {
+		return Statement{}, false
+	}
+	return m.statements[len(m.statements)-1], true
+}
+
+// Reset forgets statements executed so far
+func (m *MemExecutor) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.statements = nil
+}
+
+// AssertSQL asserts SQL of statements executed so far equals `expected` in the order of execution
+func (m *MemExecutor) AssertSQL(t assert.TestingT, expected ...string) bool {
+	if expected == nil {
+		expected = []string{}
+	}
+	return assert.Equal(t, expected, m.SQL(), "executed statements")
+}
+
+// AssertLast asserts the statement executed last has the `sql` and `args`
+func (m *MemExecutor) AssertLast(t assert.TestingT, sql string, args ...interface{}) bool {
+	s, ok := m.Last()
+	if !assert.True(t, ok, "no statement executed") {
+		return false
+	}
+	if args == nil {
+		args = []interface{}{}
+	}
+	if s.Args == nil {
+		s.Args = []interface{}{}
+	}
+	return assert.Equal(t, sql, s.SQL, "executed statement") && assert.Equal(t, args, s.Args, "statement arguments")
+}
+
+// AssertExecuted asserts a statement containing `fragment` is executed, e.g. a table name or a clause
+func (m *MemExecutor) AssertExecuted(t assert.TestingT, fragment string) bool {
+	sql := m.SQL()
+	for _, s := range sql {
+		if strings.Contains(s, fragment) {
+			return true
+		}
+	}
+	return assert.Fail(t, fmt.Sprintf("No statement containing %q executed", fragment), "executed statements: %q", sql)
+}
@@ -0,0 +1,75 @@
+package postgrestest_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/cybertec-postgresql/debezium2postgres/internal/postgres"
+	"github.com/cybertec-postgresql/debezium2postgres/internal/postgres/postgrestest"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ postgres.DBExecutorContext = (*postgrestest.MemExecutor)(nil)
+
+func TestMemExecutor(t *testing.T) {
+	m := postgrestest.NewMemExecutor(2)
+	m.AssertSQL(t)
+	_, ok := m.Last()
+	assert.False(t, ok)
+
+	ct, err := m.Exec(context.Background(), "INSERT INTO t VALUES ($1)", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "INSERT 0 2", ct.String())
+	assert.EqualValues(t, 2, ct.RowsAffected())
+	ct, err = m.Exec(context.Background(), "update t SET a = 1")
+	assert.NoError(t, err)
+	assert.Equal(t, "UPDATE 2", ct.String())
+	m.AssertSQL(t, "INSERT INTO t VALUES ($1)", "update t SET a = 1")
+	m.AssertLast(t, "update t SET a = 1")
+	m.AssertExecuted(t, "INSERT INTO t")
+	assert.Equal(t, []postgrestest.Statement{
+		{SQL: "INSERT INTO t VALUES ($1)", Args: []interface{}{1}},
+		{SQL: "update t SET a = 1", Args: nil},
+	}, m.Statements())
+
+	m.Err = errors.New("serialization failure")
+	_, err = m.Exec(context.Background(), "DELETE FROM t")
+	assert.EqualError(t, err, "serialization failure")
+	m.AssertLast(t, "DELETE FROM t")
+
+	m.Handler = func(sql string, args []interface{}) (int64, error) {
+		return int64(len(args)), nil
+	}
+	ct, err = m.Exec(context.Background(), "DELETE FROM t WHERE a = $1 OR a = $2", 1, 2)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, ct.RowsAffected())
+
+	m.Reset()
+	m.AssertSQL(t)
+
+	// failed assertions are reported
+	mockT := new(testing.T)
+	assert.False(t, m.AssertLast(mockT, "DELETE FROM t"))
+	assert.False(t, m.AssertExecuted(mockT, "DELETE"))
+}
+
+func TestApplyToMemExecutor(t *testing.T) {
+	postgres.Logger = logrus.New().WithField("method", "TestApplyToMemExecutor")
+	m := postgrestest.NewMemExecutor(1)
+	postgres.Connect = func(ctx context.Context, connString string) (postgres.DBExecutorContext, error) {
+		return m, nil
+	}
+	messages := make(chan kafka.Message, 2)
+	messages <- kafka.Message{Op: "c", TableName: "t", Values: map[string]interface{}{"id": json.Number("1")},
+		Fields: map[string]kafka.Field{"id": {Type: "int32"}}}
+	messages <- kafka.Message{Op: "d", TableName: "t", Keys: map[string]interface{}{"id": json.Number("1")},
+		Fields: map[string]kafka.Field{"id": {Type: "int32"}}}
+	postgres.Apply(context.Background(), "foo", postgres.Config{IdleTimeout: 100 * time.Millisecond}, messages)
+	m.AssertSQL(t, `INSERT INTO "t"("id") VALUES ($1)`, `DELETE FROM "t" WHERE ("id")=($1)`)
+	m.AssertLast(t, `DELETE FROM "t" WHERE ("id")=($1)`, int64(1))
+}
@@ -0,0 +1,133 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// sqlPreflight checks the table named by $1 exists, is writable and has the primary key to match rows by.
+// Missing tables report no privileges and no key
+const sqlPreflight = `SELECT t.oid IS NOT NULL,
+	coalesce(has_table_privilege(t.oid, 'INSERT'), false),
+	coalesce(has_table_privilege(t.oid, 'UPDATE'), false),
+	coalesce(has_table_privilege(t.oid, 'DELETE'), false),
+	EXISTS (SELECT 1 FROM pg_index WHERE indrelid = t.oid AND indisprimary)
+FROM (SELECT to_regclass($1) AS oid) t`
+
+// Preflight connects to the target database and checks each of `tables`, named as "table" or "schema.table", exists,
+// is granted INSERT, UPDATE and DELETE privileges and has the primary key columns to match updated and deleted rows.
+// All the problems found are reported in the returned error
+func Preflight(ctx context.Context, connString string, tables []string) error {
+	conn, err := Connect(ctx, connString)
+	if err != nil {
+		return err
+	}
+	if c, ok := conn.(interface{ Close() }); ok {
+		defer c.Close()
+	}
+	querier, ok := conn.(DBQuerierContext)
+	if !ok {
+		return errors.New("Target database connection doesn't support queries")
+	}
+	var problems []string
+	for _, table := range tables {
+		var (
+			exists, key bool
+			granted     [3]bool
+		)
+		name := pgx.Identifier(strings.Split(table, ".")).Sanitize()
+		err := querier.QueryRow(ctx, sqlPreflight, name).Scan(&exists, &granted[0], &granted[1], &granted[2], &key)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", table, err))
+			continue
+		}
+		if !exists {
+			problems = append(problems, table+": table does not exist")
+			continue
+		}
+		for i, privilege := range []string{"INSERT", "UPDATE", "DELETE"} {
+			if !granted[i] {
+				problems = append(problems, table+": "+privilege+" privilege is missing")
+			}
+		}
+		if !key {
+			problems = append(problems, table+": primary key is missing")
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New("Preflight check failed: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// sqlKeyColumns checks the table named by $1 exists and returns which of the columns $2 it lacks and whether a unique
+// index on exactly these columns exists, so they identify rows and can be used as the conflict target
+const sqlKeyColumns = `SELECT t.oid IS NOT NULL,
+	array(SELECT c FROM unnest($2::text[]) c WHERE NOT EXISTS (
+		SELECT 1 FROM pg_attribute WHERE attrelid = t.oid AND attname = c AND attnum > 0 AND NOT attisdropped)),
+	EXISTS (SELECT 1 FROM pg_index i WHERE i.indrelid = t.oid AND i.indisunique
+		AND i.indpred IS NULL AND i.indexprs IS NULL
+		AND (SELECT array_agg(attname::text ORDER BY attname::text) FROM pg_attribute
+			WHERE attrelid = t.oid AND attnum = ANY(i.indkey)) = (SELECT array_agg(c ORDER BY c) FROM unnest($2::text[]) c))
+FROM (SELECT to_regclass($1) AS oid) t`
+
+// CheckKeyColumns connects to the target database and checks `columns`, named as "table.column" or
+// "schema.table.column", exist and each table has the unique index on exactly the columns configured for it.
+// All the problems found are reported in the returned error
+func CheckKeyColumns(ctx context.Context, connString string, columns []string) error {
+	var tables []string
+	keys := make(map[string][]string)
+	for _, c := range columns {
+		i := strings.LastIndex(c, ".")
+		if i < 0 {
+			return fmt.Errorf("Invalid key column %q, table.column expected", c)
+		}
+		table := c[:i]
+		if _, ok := keys[table]; !ok {
+			tables = append(tables, table)
+		}
+		keys[table] = append(keys[table], c[i+1:])
+	}
+	conn, err := Connect(ctx, connString)
+	if err != nil {
+		return err
+	}
+	if c, ok := conn.(interface{ Close() }); ok {
+		defer c.Close()
+	}
+	querier, ok := conn.(DBQuerierContext)
+	if !ok {
+		return errors.New("Target database connection doesn't support queries")
+	}
+	var problems []string
+	for _, table := range tables {
+		var (
+			exists, unique bool
+			missing        []string
+		)
+		sort.Strings(keys[table])
+		name := pgx.Identifier(strings.Split(table, ".")).Sanitize()
+		err := querier.QueryRow(ctx, sqlKeyColumns, name, keys[table]).Scan(&exists, &missing, &unique)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s: %v", table, err))
+		case !exists:
+			problems = append(problems, table+": table does not exist")
+		case len(missing) > 0:
+			for _, c := range missing {
+				problems = append(problems, fmt.Sprintf("%s: key column %q does not exist", table, c))
+			}
+		case !unique:
+			problems = append(problems, fmt.Sprintf("%s: no unique index on key columns (%s)", table, strings.Join(keys[table], ", ")))
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New("Key columns check failed: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
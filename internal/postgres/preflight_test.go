@@ -0,0 +1,116 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type MockRow struct {
+	Values []interface{}
+	Err    error
+}
+
+func (r MockRow) Scan(dest ...interface{}) error {
+	if r.Err != nil {
+		return r.Err
+	}
+	for i, d := range dest {
+		switch d := d.(type) {
+		case *bool:
+			*d = r.Values[i].(bool)
+		case *int64:
+			*d = r.Values[i].(int64)
+		case *string:
+			*d = r.Values[i].(string)
+		case *[]string:
+			*d = r.Values[i].([]string)
+		case *[]bool:
+			*d = r.Values[i].([]bool)
+		case *interface{}:
+			*d = r.Values[i]
+		}
+	}
+	return nil
+}
+
+type MockDbQuerier struct {
+	MockDbExec
+	QueryRowHandler func(sql string, args []interface{}) pgx.Row
+}
+
+func (m MockDbQuerier) QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row {
+	return m.QueryRowHandler(sql, args)
+}
+
+func TestPreflight(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestPreflight")
+	rows := map[string]MockRow{
+		`"public"."orders"`:  {Values: []interface{}{true, true, true, true, true}},
+		`"customers"`:        {Values: []interface{}{true, true, false, false, true}},
+		`"audit"`:            {Values: []interface{}{true, true, true, true, false}},
+		`"missing"`:          {Values: []interface{}{false, false, false, false, false}},
+		`"public"."Invoice"`: {Err: errors.New("connection reset")},
+	}
+	Connect = func(ctx context.Context, connString string) (DBExecutorContext, error) {
+		return MockDbQuerier{
+			QueryRowHandler: func(sql string, args []interface{}) pgx.Row {
+				return rows[args[0].(string)]
+			},
+		}, nil
+	}
+	assert.NoError(t, Preflight(context.Background(), "foo", []string{"public.orders"}))
+
+	err := Preflight(context.Background(), "foo", []string{"public.orders", "customers", "audit", "missing", "public.Invoice"})
+	assert.EqualError(t, err, "Preflight check failed: "+
+		"customers: UPDATE privilege is missing; customers: DELETE privilege is missing; "+
+		"audit: primary key is missing; missing: table does not exist; public.Invoice: connection reset")
+
+	Connect = func(ctx context.Context, connString string) (DBExecutorContext, error) {
+		return MockDbExec{}, nil
+	}
+	assert.Error(t, Preflight(context.Background(), "foo", []string{"orders"}), "queries are not supported")
+
+	Connect = func(ctx context.Context, connString string) (DBExecutorContext, error) {
+		return nil, errors.New("connection refused")
+	}
+	assert.EqualError(t, Preflight(context.Background(), "foo", nil), "connection refused")
+}
+
+func TestCheckKeyColumns(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestCheckKeyColumns")
+	rows := map[string]MockRow{
+		`"public"."orders"`: {Values: []interface{}{true, []string{}, true}},
+		`"customers"`:       {Values: []interface{}{true, []string{}, false}},
+		`"audit"`:           {Values: []interface{}{true, []string{"ref"}, false}},
+		`"missing"`:         {Values: []interface{}{false, []string{"id"}, false}},
+		`"invoices"`:        {Err: errors.New("connection reset")},
+	}
+	var columns [][]string
+	Connect = func(ctx context.Context, connString string) (DBExecutorContext, error) {
+		return MockDbQuerier{
+			QueryRowHandler: func(sql string, args []interface{}) pgx.Row {
+				columns = append(columns, args[1].([]string))
+				return rows[args[0].(string)]
+			},
+		}, nil
+	}
+	assert.NoError(t, CheckKeyColumns(context.Background(), "foo", []string{"public.orders.tenant_id", "public.orders.external_id"}))
+	assert.Equal(t, [][]string{{"external_id", "tenant_id"}}, columns, "columns of the table checked together")
+
+	err := CheckKeyColumns(context.Background(), "foo", []string{"customers.email", "audit.ref", "missing.id", "invoices.no"})
+	assert.EqualError(t, err, "Key columns check failed: "+
+		"customers: no unique index on key columns (email); audit: key column \"ref\" does not exist; "+
+		"missing: table does not exist; invoices: connection reset")
+
+	assert.Error(t, CheckKeyColumns(context.Background(), "foo", []string{"id"}), "table is missing")
+
+	Connect = func(ctx context.Context, connString string) (DBExecutorContext, error) {
+		return MockDbExec{}, nil
+	}
+	assert.Error(t, CheckKeyColumns(context.Background(), "foo", []string{"orders.id"}), "queries are not supported")
+}
@@ -0,0 +1,41 @@
+package postgres
+
+import (
+	"context"
+	"time"
+)
+
+// rateLimiter is the token bucket limiting writes to `cfg.MaxWritesPerSecond`. The bucket holds a single token,
+// so writes are spread evenly instead of bursting at the start of each second
+type rateLimiter struct {
+	interval time.Duration // time to refill the token
+	next     time.Time     // time the token is available
+}
+
+// newRateLimiter returns the limiter for `perSecond` writes, nil means unlimited
+func newRateLimiter(perSecond float64) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// wait blocks until the next write is allowed, returns the context error if cancelled meanwhile
+func (r *rateLimiter) wait(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+	now := time.Now()
+	if r.next.After(now) {
+		t := time.NewTimer(r.next.Sub(now))
+		defer t.Stop()
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		now = r.next
+	}
+	r.next = now.Add(r.interval)
+	return nil
+}
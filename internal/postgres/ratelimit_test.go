@@ -0,0 +1,57 @@
+package postgres
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	assert.Nil(t, newRateLimiter(0), "unlimited")
+	var unlimited *rateLimiter
+	assert.NoError(t, unlimited.wait(context.Background()))
+
+	r := newRateLimiter(10)
+	assert.NoError(t, r.wait(context.Background()), "first write is not delayed")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, r.wait(ctx))
+}
+
+func TestApplyMaxWritesPerSecond(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestApplyMaxWritesPerSecond")
+	var (
+		mu     sync.Mutex
+		writes []time.Time
+	)
+	Connect = func(ctx context.Context, connString string) (DBExecutorContext, error) {
+		return MockDbExec{
+			ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+				mu.Lock()
+				defer mu.Unlock()
+				writes = append(writes, time.Now())
+				return pgconn.CommandTag("INSERT 0 1"), nil
+			},
+		}, nil
+	}
+	const (
+		burst = 20
+		rate  = 50
+	)
+	msgChan := make(chan kafka.Message, burst)
+	for i := 0; i < burst; i++ {
+		msgChan <- kafka.Message{Op: "c", TableName: "t", Values: map[string]interface{}{"id": i}}
+	}
+	started := time.Now()
+	Apply(context.Background(), "foo", Config{IdleTimeout: 100 * time.Millisecond, MaxWritesPerSecond: rate}, msgChan)
+	assert.Len(t, writes, burst)
+	// the first write isn't delayed
+	elapsed := writes[len(writes)-1].Sub(started)
+	assert.LessOrEqual(t, float64(len(writes)-1)/elapsed.Seconds(), float64(rate), "effective rate over the burst")
+}
@@ -0,0 +1,53 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+)
+
+// applyRecords applies the records batched into the CDC item in a single transaction, or a savepoint if `conn` is
+// a transaction already, so the CDC item is applied entirely or not at all. Records are applied one by one, each with
+// its own statement, and the total of rows affected is returned. Applying stops at the first failing record
+func applyRecords(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message) (int64, error) {
+	transactor, ok := conn.(DBTransactor)
+	if !ok {
+		return applyEachRecord(ctx, conn, cfg, m)
+	}
+	tx, err := transactor.Begin(ctx)
+	if err != nil {
+		return 0, classify(ErrDBExec, err)
+	}
+	rowsAffected, err := applyEachRecord(ctx, tx, cfg, m)
+	if err != nil {
+		_ = tx.Rollback(ctx)
+		return rowsAffected, err
+	}
+	return rowsAffected, classify(ErrDBExec, tx.Commit(ctx))
+}
+
+// applyEachRecord applies the records batched into the CDC item one by one and returns the total of rows affected
+func applyEachRecord(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message) (int64, error) {
+	var total int64
+	for _, r := range m.Records {
+		rowsAffected, err := applyDriftingCDCItem(ctx, conn, cfg, r)
+		total += rowsAffected
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// prepareRecords returns the CDC item with the batched records prepared the same way as the item itself
+func prepareRecords(cfg Config, m kafka.Message) kafka.Message {
+	if len(m.Records) == 0 {
+		return m
+	}
+	records := make([]kafka.Message, len(m.Records))
+	for i, r := range m.Records {
+		records[i] = prepareMessage(cfg, r)
+	}
+	m.Records = records
+	return m
+}
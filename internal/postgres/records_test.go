@@ -0,0 +1,68 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyRecords(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestApplyRecords")
+	var statements []string
+	var args [][]interface{}
+	conn := MockDbExec{ExecHandler: func(sql string, arguments []interface{}) (pgconn.CommandTag, error) {
+		statements = append(statements, sql)
+		args = append(args, arguments)
+		if arguments[0] == "fail" {
+			return nil, errors.New("check constraint violated")
+		}
+		return pgconn.CommandTag("INSERT 0 1"), nil
+	}}
+	record := func(id int64, name string) kafka.Message {
+		return kafka.Message{Op: "c", TableName: "t", Keys: map[string]interface{}{"id": id},
+			Values: map[string]interface{}{"id": id, "name": name}}
+	}
+	m := kafka.Message{Op: "c", TableName: "t", Keys: map[string]interface{}{"id": int64(0)}, Values: map[string]interface{}{},
+		Records: []kafka.Message{record(1, "a"), record(2, "b")}}
+	store := memoryOffsets{}
+	m.Topic, m.Offset = "foo", 7
+	assert.False(t, applyMessage(context.Background(), conn, Config{Offsets: store}, m))
+	assert.Equal(t, []string{
+		`INSERT INTO "t"("id","name") VALUES ($1,$2)`,
+		`INSERT INTO "t"("id","name") VALUES ($1,$2)`,
+	}, statements)
+	assert.Equal(t, [][]interface{}{{int64(1), "a"}, {int64(2), "b"}}, args)
+	assert.Equal(t, int64(7), store["foo"])
+
+	statements = nil
+	args = nil
+	m.Records = []kafka.Message{{Op: "c", TableName: "t", Values: map[string]interface{}{"name": "fail"}}, record(3, "c")}
+	rowsAffected, err := applyDriftingCDCItem(context.Background(), conn, Config{}, m)
+	assert.EqualError(t, err, "check constraint violated")
+	assert.Equal(t, int64(0), rowsAffected)
+	assert.Len(t, statements, 1, "records after the failing one aren't applied")
+
+	// records are applied in a single transaction, rolled back if any of them fails
+	statements = nil
+	var commits, rollbacks int
+	tx := MockDbTransactor{Tx: MockDbTx{MockDbExec: conn,
+		CommitHandler:   func() error { commits++; return nil },
+		RollbackHandler: func() error { rollbacks++; return nil },
+	}}
+	_, err = applyDriftingCDCItem(context.Background(), tx, Config{}, m)
+	assert.Error(t, err)
+	assert.Equal(t, 0, commits)
+	assert.Equal(t, 1, rollbacks)
+	m.Records = []kafka.Message{record(4, "d"), record(5, "e")}
+	rowsAffected, err = applyDriftingCDCItem(context.Background(), tx, Config{}, m)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, rowsAffected)
+	assert.Equal(t, 1, commits)
+
+	assert.False(t, collapsesRow(Config{}, m), "batched records aren't collapsed")
+}
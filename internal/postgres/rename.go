@@ -0,0 +1,71 @@
+package postgres
+
+import (
+	"strings"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+)
+
+// ColumnMapper returns the target column name for the source column `src`
+type ColumnMapper func(src string) (dst string)
+
+// RenameColumns returns column mappers renaming columns according to `renames` holding target column names keyed by
+// "table.column" or "schema.table.column". Columns not listed keep their names
+func RenameColumns(renames map[string]string) map[string]ColumnMapper {
+	tables := make(map[string]map[string]string)
+	for key, dst := range renames {
+		i := strings.LastIndexByte(key, '.')
+		if i < 0 {
+			continue
+		}
+		table, src := key[:i], key[i+1:]
+		if tables[table] == nil {
+			tables[table] = make(map[string]string)
+		}
+		tables[table][src] = dst
+	}
+	mappers := make(map[string]ColumnMapper, len(tables))
+	for table, columns := range tables {
+		columns := columns
+		mappers[table] = func(src string) string {
+			if dst, ok := columns[src]; ok {
+				return dst
+			}
+			return src
+		}
+	}
+	return mappers
+}
+
+// renameColumns returns the CDC item with columns renamed by the mapper of the table in `cfg.ColumnMappers`
+func renameColumns(cfg Config, message kafka.Message) kafka.Message {
+	mapper, ok := cfg.ColumnMappers[message.SchemaName+"."+message.TableName]
+	if !ok {
+		if mapper, ok = cfg.ColumnMappers[message.TableName]; !ok {
+			return message
+		}
+	}
+	message.Keys = renameKeys(mapper, message.Keys)
+	message.Values = renameKeys(mapper, message.Values)
+	message.Before = renameKeys(mapper, message.Before)
+	if message.Fields != nil {
+		fields := make(map[string]kafka.Field, len(message.Fields))
+		for k, f := range message.Fields {
+			fields[mapper(k)] = f
+		}
+		message.Fields = fields
+	}
+	return message
+}
+
+// renameKeys returns the copy of the row image with columns renamed by the `mapper`
+func renameKeys(mapper ColumnMapper, row map[string]interface{}) map[string]interface{} {
+	if row == nil {
+		return nil
+	}
+	renamed := make(map[string]interface{}, len(row))
+	for k, v := range row {
+		renamed[mapper(k)] = v
+	}
+	return renamed
+}
@@ -0,0 +1,71 @@
+package postgres
+
+import (
+	"context"
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenameColumns(t *testing.T) {
+	mappers := RenameColumns(map[string]string{
+		"orders.cust_id":        "customer_id",
+		"sales.orders.qty":      "quantity",
+		"invalid":               "ignored",
+		"public.orders.cust_id": "client_id",
+	})
+	assert.Len(t, mappers, 3)
+	assert.Equal(t, "customer_id", mappers["orders"]("cust_id"))
+	assert.Equal(t, "qty", mappers["orders"]("qty"))
+	assert.Equal(t, "quantity", mappers["sales.orders"]("qty"))
+
+	msg := kafka.Message{
+		SchemaName: "public",
+		TableName:  "orders",
+		Keys:       map[string]interface{}{"cust_id": int64(1)},
+		Values:     map[string]interface{}{"cust_id": int64(1), "qty": int64(2)},
+		Fields:     map[string]kafka.Field{"cust_id": {Type: "int64"}},
+	}
+	assert.Equal(t, msg, renameColumns(Config{}, msg))
+	renamed := renameColumns(Config{ColumnMappers: mappers}, msg)
+	assert.Equal(t, map[string]interface{}{"client_id": int64(1)}, renamed.Keys, "schema qualified mapper takes precedence")
+	assert.Equal(t, map[string]interface{}{"client_id": int64(1), "qty": int64(2)}, renamed.Values)
+	assert.Nil(t, renamed.Before)
+	assert.Contains(t, renamed.Fields, "client_id")
+	assert.Contains(t, msg.Values, "cust_id", "original message must not be modified")
+}
+
+func TestApplyRenamedColumns(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestApplyRenamedColumns")
+	var (
+		sql  string
+		args []interface{}
+	)
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql, args = s, a
+			return pgconn.CommandTag("UPDATE 1"), nil
+		},
+	}
+	cfg := Config{ColumnMappers: RenameColumns(map[string]string{"orders.cust_id": "customer_id"})}
+	msg := kafka.Message{
+		Op:        "u",
+		TableName: "orders",
+		Keys:      map[string]interface{}{"cust_id": int64(7)},
+		Values:    map[string]interface{}{"cust_id": int64(8)},
+	}
+	_ = applyMessage(context.Background(), conn, cfg, msg)
+	assert.Equal(t, `UPDATE "orders" SET ("customer_id")=($2) WHERE ("customer_id")=($1)`, sql)
+	assert.Equal(t, []interface{}{int64(7), int64(8)}, args)
+
+	msg.Op = "d"
+	_ = applyMessage(context.Background(), conn, cfg, msg)
+	assert.Equal(t, `DELETE FROM "orders" WHERE ("customer_id")=($1)`, sql)
+
+	msg.Op = "c"
+	_ = applyMessage(context.Background(), conn, cfg, msg)
+	assert.Equal(t, `INSERT INTO "orders"("customer_id") VALUES ($1)`, sql)
+}
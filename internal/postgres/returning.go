@@ -0,0 +1,77 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// returningColumns returns the columns inserts and updates of the target table of the CDC item return, configured
+// in `cfg.Returning` keyed by "schema.table" or "table"
+func returningColumns(cfg Config, m kafka.Message) []string {
+	if columns, ok := cfg.Returning[m.SchemaName+"."+m.TableName]; ok {
+		return columns
+	}
+	return cfg.Returning[m.TableName]
+}
+
+// returningClause returns the RETURNING clause of the statement writing the CDC item, empty string if its target
+// table returns no columns
+func returningClause(cfg Config, m kafka.Message) string {
+	columns := returningColumns(cfg, m)
+	if len(columns) == 0 {
+		return ""
+	}
+	quoted := make([]string, len(columns))
+	for i, c := range columns {
+		quoted[i] = pgx.Identifier{c}.Sanitize()
+	}
+	return " RETURNING " + strings.Join(quoted, ",")
+}
+
+// execReturning executes the insert or update of the CDC item. If its target table returns columns, the statement
+// is queried and the returned row is passed to `cfg.OnReturned`. Only the first row is returned, as CDC items
+// write single rows
+func execReturning(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message, sql string, args []interface{}) (pgconn.CommandTag, error) {
+	columns := returningColumns(cfg, m)
+	if len(columns) == 0 {
+		return conn.Exec(ctx, sql, args...)
+	}
+	querier, ok := conn.(DBQuerierContext)
+	if !ok {
+		return nil, errors.New("Target database connection doesn't support RETURNING")
+	}
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	err := querier.QueryRow(ctx, sql, args...).Scan(dest...)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return commandTag(sql, 0), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.OnReturned != nil {
+		row := make(map[string]interface{}, len(columns))
+		for i, c := range columns {
+			row[c] = values[i]
+		}
+		cfg.OnReturned(ctx, &m, row)
+	}
+	return commandTag(sql, 1), nil
+}
+
+// commandTag returns the command tag of the insert or update `sql` affecting `rows` rows
+func commandTag(sql string, rows int64) pgconn.CommandTag {
+	if strings.HasPrefix(sql, "INSERT") {
+		return pgconn.CommandTag(fmt.Sprintf("INSERT 0 %d", rows))
+	}
+	return pgconn.CommandTag(fmt.Sprintf("UPDATE %d", rows))
+}
@@ -0,0 +1,66 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReturning(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestReturning")
+	var (
+		queries  []string
+		returned []map[string]interface{}
+	)
+	nextID := int64(41)
+	conn := MockDbQuerier{
+		MockDbExec: MockDbExec{ExecHandler: func(sql string, arguments []interface{}) (pgconn.CommandTag, error) {
+			return nil, errors.New("RETURNING statements must be queried")
+		}},
+		QueryRowHandler: func(sql string, args []interface{}) pgx.Row {
+			queries = append(queries, sql)
+			if args[0] == "missing" {
+				return MockRow{Err: pgx.ErrNoRows}
+			}
+			nextID++
+			return MockRow{Values: []interface{}{nextID, "computed"}}
+		},
+	}
+	cfg := Config{
+		Returning: map[string][]string{"public.orders": {"id", "label"}},
+		OnReturned: func(ctx context.Context, m *kafka.Message, row map[string]interface{}) {
+			returned = append(returned, row)
+		},
+	}
+	m := kafka.Message{
+		Op:         "c",
+		SchemaName: "public",
+		TableName:  "orders",
+		Values:     map[string]interface{}{"name": "foo"},
+	}
+	rows, err := applyCDCItem(context.Background(), conn, cfg, m)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, rows)
+	assert.Equal(t, []string{`INSERT INTO "public"."orders"("name") VALUES ($1) RETURNING "id","label"`}, queries)
+	assert.Equal(t, []map[string]interface{}{{"id": int64(42), "label": "computed"}}, returned)
+
+	queries, returned = nil, nil
+	m.Op = "u"
+	m.Keys = map[string]interface{}{"name": "missing"}
+	rows, err = applyCDCItem(context.Background(), conn, cfg, m)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, rows, "no row matched")
+	assert.Equal(t, []string{`UPDATE "public"."orders" SET ("name")=($2) WHERE ("name")=($1) RETURNING "id","label"`}, queries)
+	assert.Empty(t, returned)
+
+	assert.False(t, isMultiRowInsert(cfg, m), "returning tables aren't batched")
+
+	_, err = applyCDCItem(context.Background(), MockDbExec{}, cfg, m)
+	assert.EqualError(t, err, "Target database connection doesn't support RETURNING")
+}
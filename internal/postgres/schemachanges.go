@@ -0,0 +1,46 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// schemaChangesTable returns the quoted name of the table logging schema changes made automatically, which may be
+// schema qualified
+func schemaChangesTable(cfg Config) string {
+	return pgx.Identifier(strings.Split(cfg.SchemaChangesTable, ".")).Sanitize()
+}
+
+// createSchemaChangesTable creates the schema changes log table if `cfg.SchemaChangesTable` is set, the target
+// schema may be changed automatically and the table doesn't exist yet
+func createSchemaChangesTable(ctx context.Context, conn DBExecutorContext, cfg Config) error {
+	if cfg.SchemaChangesTable == "" || (cfg.SchemaDrift != SchemaDriftAlter && !cfg.WidenColumns && !cfg.TranslateDDL) {
+		return nil
+	}
+	_, err := conn.Exec(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	changed_at timestamptz NOT NULL DEFAULT now(),
+	table_name text NOT NULL,
+	column_name text NOT NULL,
+	ddl text NOT NULL,
+	topic text,
+	partition integer,
+	"offset" bigint)`, schemaChangesTable(cfg)))
+	return classify(ErrDBExec, err)
+}
+
+// recordSchemaChange logs the `ddl` changing the `column` of the target table, executed to apply the CDC item, to
+// the schema changes log table if `cfg.SchemaChangesTable` is set
+func recordSchemaChange(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message, column, ddl string) error {
+	if cfg.SchemaChangesTable == "" {
+		return nil
+	}
+	_, err := conn.Exec(ctx,
+		fmt.Sprintf(`INSERT INTO %s(table_name, column_name, ddl, topic, partition, "offset") VALUES ($1, $2, $3, $4, $5, $6)`,
+			schemaChangesTable(cfg)),
+		m.QualifiedTablename(), column, ddl, m.Topic, m.Partition, m.Offset)
+	return classify(ErrDBExec, err)
+}
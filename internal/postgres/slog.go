@@ -0,0 +1,53 @@
+//go:build go1.21
+// +build go1.21
+
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// LevelTrace is the slog level of the trace messages, which are more verbose than the debug ones
+const LevelTrace = slog.LevelDebug - 4
+
+// slogLogger adapts the slog logger to FieldLogger
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger returns FieldLogger writing to the slog `logger`, fields are added as attributes
+func NewSlogLogger(logger *slog.Logger) FieldLogger {
+	return slogLogger{logger}
+}
+
+// WithField returns the logger adding the attribute to the messages
+func (l slogLogger) WithField(key string, value interface{}) FieldLogger {
+	return slogLogger{l.logger.With(key, value)}
+}
+
+// WithError returns the logger adding the error attribute to the messages
+func (l slogLogger) WithError(err error) FieldLogger {
+	return slogLogger{l.logger.With("error", err)}
+}
+
+func (l slogLogger) Trace(args ...interface{}) {
+	l.logger.Log(context.Background(), LevelTrace, fmt.Sprint(args...))
+}
+
+func (l slogLogger) Debug(args ...interface{}) {
+	l.logger.Debug(fmt.Sprint(args...))
+}
+
+func (l slogLogger) Info(args ...interface{}) {
+	l.logger.Info(fmt.Sprint(args...))
+}
+
+func (l slogLogger) Warning(args ...interface{}) {
+	l.logger.Warn(fmt.Sprint(args...))
+}
+
+func (l slogLogger) Error(args ...interface{}) {
+	l.logger.Error(fmt.Sprint(args...))
+}
@@ -0,0 +1,38 @@
+//go:build go1.21
+// +build go1.21
+
+package postgres
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlogLogger(t *testing.T) {
+	var out bytes.Buffer
+	handler := slog.NewTextHandler(&out, &slog.HandlerOptions{
+		Level: LevelTrace,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	})
+	l := NewSlogLogger(slog.New(handler)).WithField("table", "orders")
+	l.Trace("CDC value used")
+	l.WithField("rows", 3).Debug("Multi-row insert applied")
+	l.Info("Idle timeout exceeded")
+	l.Warning("CDC item caused no changes")
+	l.WithError(errors.New("connection reset")).Error("CDC item dropped")
+	assert.Equal(t, `level=DEBUG-4 msg="CDC value used" table=orders
+level=DEBUG msg="Multi-row insert applied" table=orders rows=3
+level=INFO msg="Idle timeout exceeded" table=orders
+level=WARN msg="CDC item caused no changes" table=orders
+level=ERROR msg="CDC item dropped" table=orders error="connection reset"
+`, out.String())
+}
@@ -0,0 +1,179 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+)
+
+// number of COPY statements loading snapshot rows and rows loaded by them during session
+var (
+	snapshotCopies   uint64
+	snapshotCopyRows uint64
+)
+
+// reCopyableRef matches parameter references COPY can load, i.e. bare or cast parameters. Values are parsed
+// by the input function of the column type then, so columns set by expressions are inserted instead
+var reCopyableRef = regexp.MustCompile(`^\$\d+(::[\w."]+(\[\])?)?$`)
+
+// copyEscaper escapes special characters of the COPY text format
+var copyEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+// copiesSnapshot returns true if the CDC item is the row of the initial snapshot to be loaded with COPY
+func copiesSnapshot(cfg Config, m kafka.Message) bool {
+	return cfg.SnapshotCopy && m.IsSnapshot() && m.SchemaChange == nil && m.TransactionBoundary == nil
+}
+
+// copyTable holds the snapshot rows of the table with the same columns waiting to be loaded
+type copyTable struct {
+	table   string          // qualified name of the target table
+	columns string          // quoted names of the loaded columns
+	data    bytes.Buffer    // rows in the COPY text format
+	items   []kafka.Message // CDC items of the rows, e.g. to insert them if COPY fails
+}
+
+// snapshotLoader buffers rows of the initial snapshot per table and loads them with COPY once `cfg.SnapshotCopySize`
+// rows are buffered, `cfg.SnapshotCopyInterval` passes or streamed changes begin
+type snapshotLoader struct {
+	tables map[string]*copyTable // keyed by table and columns
+	order  []string
+	rows   int
+}
+
+// add buffers the snapshot row of the CDC item. Rows COPY can't load, e.g. with columns set by expressions or
+// for targets without COPY support, are inserted right away, as the order of snapshot rows doesn't matter
+func (s *snapshotLoader) add(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message) {
+	prepared := prepareMessage(cfg, m)
+	columns, line, ok := copyRow(cfg, prepared)
+	if _, copier := conn.(DBCopier); !ok || !copier {
+		insertSnapshotRow(ctx, conn, cfg, m)
+		return
+	}
+	table := prepared.QualifiedTablename()
+	key := table + "(" + columns + ")"
+	t, found := s.tables[key]
+	if !found {
+		if s.tables == nil {
+			s.tables = make(map[string]*copyTable)
+		}
+		t = &copyTable{table: table, columns: columns}
+		s.tables[key] = t
+		s.order = append(s.order, key)
+	}
+	t.data.WriteString(line)
+	t.items = append(t.items, m)
+	s.rows++
+	if cfg.SnapshotCopySize > 0 && s.rows >= cfg.SnapshotCopySize {
+		s.load(ctx, conn, cfg)
+	}
+}
+
+// load copies the buffered snapshot rows into their tables. Rows of the tables COPY fails for are inserted one by one,
+// so the failing ones are reported separately
+func (s *snapshotLoader) load(ctx context.Context, conn DBExecutorContext, cfg Config) {
+	if s.rows == 0 {
+		return
+	}
+	copier := conn.(DBCopier)
+	for _, key := range s.order {
+		t := s.tables[key]
+		l := loggerOf(cfg).WithField("table", t.table).WithField("rows", len(t.items))
+		_, err := copier.CopyFromStdin(ctx, &t.data, fmt.Sprintf("COPY %s(%s) FROM STDIN", t.table, t.columns))
+		atomic.AddUint64(&tx, 1)
+		if err != nil {
+			l.WithError(classify(ErrDBExec, err)).Warning("Snapshot rows not copied, inserting them one by one")
+			for _, m := range t.items {
+				insertSnapshotRow(ctx, conn, cfg, m)
+			}
+			continue
+		}
+		atomic.AddUint64(&snapshotCopies, 1)
+		atomic.AddUint64(&snapshotCopyRows, uint64(len(t.items)))
+		for _, m := range t.items {
+			updateStats(m, nil)
+		}
+		saveOffsets(ctx, cfg, t.items...)
+		l.Debug("Snapshot rows copied")
+	}
+	s.tables, s.order, s.rows = nil, nil, 0
+}
+
+// insertSnapshotRow applies the snapshot row of the CDC item as the insert
+func insertSnapshotRow(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message) {
+	m.Op = "c"
+	_ = applyMessage(ctx, conn, cfg, m)
+}
+
+// copyRow returns the quoted names of the columns of the prepared snapshot row and the row in the COPY text format,
+// `ok` is false if the row has to be inserted instead, e.g. with the configured insert mode or conflict policy
+func copyRow(cfg Config, m kafka.Message) (columns string, line string, ok bool) {
+	m.Op = "c"
+	if !isMultiRowInsert(cfg, m) {
+		return "", "", false
+	}
+	fields, line, ok := copyLine(cfg, m, omitNullDefaults(cfg, m, m.Values))
+	return strings.Join(fields, ","), line, ok
+}
+
+// copyLine returns the quoted names of the columns of the `row` image of the CDC item and the row in the COPY text
+// format, `ok` is false if the row can't be loaded with COPY, e.g. with columns set by expressions
+func copyLine(cfg Config, m kafka.Message, row map[string]interface{}) (fields []string, line string, ok bool) {
+	fields, refs, args, err := bindRow(cfg, m, row, nil)
+	if err != nil || len(refs) != len(args) {
+		// failing values are reported by the insert
+		return nil, "", false
+	}
+	values := make([]string, len(args))
+	for i, arg := range args {
+		if !reCopyableRef.MatchString(refs[i]) {
+			return nil, "", false
+		}
+		if values[i], ok = copyValue(arg); !ok {
+			return nil, "", false
+		}
+	}
+	return fields, strings.Join(values, "\t") + "\n", true
+}
+
+// copyValue returns the statement parameter in the COPY text format, `ok` is false for values of other types
+func copyValue(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case nil:
+		return `\N`, true
+	case string:
+		return copyEscaper.Replace(v), true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		if v {
+			return "t", true
+		}
+		return "f", true
+	case int:
+		return strconv.Itoa(v), true
+	case int32:
+		return strconv.FormatInt(int64(v), 10), true
+	case int64:
+		return strconv.FormatInt(v, 10), true
+	case uint64:
+		return strconv.FormatUint(v, 10), true
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32), true
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), true
+	case []byte:
+		return `\\x` + hex.EncodeToString(v), true
+	case time.Time:
+		return v.Format("2006-01-02 15:04:05.999999999Z07:00"), true
+	}
+	return "", false
+}
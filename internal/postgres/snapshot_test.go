@@ -0,0 +1,125 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockCopier struct {
+	MockDbExec
+	CopyErr    error
+	statements *[]string
+}
+
+func (m mockCopier) CopyFromStdin(ctx context.Context, r io.Reader, sql string) (pgconn.CommandTag, error) {
+	data, _ := ioutil.ReadAll(r)
+	*m.statements = append(*m.statements, sql+"\n"+string(data))
+	return pgconn.CommandTag("COPY"), m.CopyErr
+}
+
+func newMockCopier(copyErr error) mockCopier {
+	var statements []string
+	return mockCopier{
+		MockDbExec: MockDbExec{
+			ExecHandler: func(sql string, a []interface{}) (pgconn.CommandTag, error) {
+				statements = append(statements, sql)
+				return pgconn.CommandTag("INSERT 0 1"), nil
+			},
+		},
+		CopyErr:    copyErr,
+		statements: &statements,
+	}
+}
+
+func TestCopyValue(t *testing.T) {
+	for _, c := range []struct {
+		value    interface{}
+		expected string
+	}{
+		{nil, `\N`},
+		{"a\tb\nc\\d", `a\tb\nc\\d`},
+		{json.Number("12.5"), "12.5"},
+		{true, "t"},
+		{int64(-42), "-42"},
+		{uint64(18446744073709551615), "18446744073709551615"},
+		{1.5, "1.5"},
+		{[]byte{0xde, 0xad}, `\\xdead`},
+		{time.Date(2021, 1, 2, 3, 4, 5, 600000000, time.UTC), "2021-01-02 03:04:05.6Z"},
+	} {
+		v, ok := copyValue(c.value)
+		assert.True(t, ok, "%v", c.value)
+		assert.Equal(t, c.expected, v)
+	}
+	_, ok := copyValue([]string{"a"})
+	assert.False(t, ok, "unsupported type")
+}
+
+func TestApplySnapshotCopy(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestApplySnapshotCopy")
+	snapshotRow := func(table string, id int) kafka.Message {
+		return kafka.Message{Op: "r", TableName: table, Snapshot: "true",
+			Values: map[string]interface{}{"id": json.Number(string(rune('0' + id))), "name": "n\t" + table}}
+	}
+	apply := func(conn DBExecutorContext, cfg Config) {
+		Connect = func(ctx context.Context, connString string) (DBExecutorContext, error) {
+			return conn, nil
+		}
+		msgChan := make(chan kafka.Message, 6)
+		msgChan <- snapshotRow("t", 1)
+		msgChan <- snapshotRow("u", 2)
+		msgChan <- snapshotRow("t", 3)
+		msgChan <- kafka.Message{Op: "c", TableName: "t", Values: map[string]interface{}{"id": 4}}
+		cfg.IdleTimeout = 100 * time.Millisecond
+		cfg.SnapshotCopy = true
+		Apply(context.Background(), "foo", cfg, msgChan)
+	}
+
+	conn := newMockCopier(nil)
+	apply(conn, Config{})
+	assert.Equal(t, []string{
+		"COPY \"t\"(\"id\",\"name\") FROM STDIN\n1\tn\\tt\n3\tn\\tt\n",
+		"COPY \"u\"(\"id\",\"name\") FROM STDIN\n2\tn\\tu\n",
+		`INSERT INTO "t"("id") VALUES ($1)`,
+	}, *conn.statements, "snapshot rows are loaded before streamed changes")
+	assert.EqualValues(t, 2, Stats().SnapshotCopies)
+	assert.EqualValues(t, 3, Stats().SnapshotCopyRows)
+
+	conn = newMockCopier(nil)
+	apply(conn, Config{SnapshotCopySize: 2})
+	assert.Equal(t, []string{
+		"COPY \"t\"(\"id\",\"name\") FROM STDIN\n1\tn\\tt\n",
+		"COPY \"u\"(\"id\",\"name\") FROM STDIN\n2\tn\\tu\n",
+		"COPY \"t\"(\"id\",\"name\") FROM STDIN\n3\tn\\tt\n",
+		`INSERT INTO "t"("id") VALUES ($1)`,
+	}, *conn.statements, "loaded once size is reached")
+
+	conn = newMockCopier(errors.New("permission denied"))
+	apply(conn, Config{})
+	assert.Len(t, *conn.statements, 6, "rows of failed COPY are inserted")
+	assert.Equal(t, `INSERT INTO "t"("id","name") VALUES ($1,$2)`, (*conn.statements)[1])
+
+	conn = newMockCopier(nil)
+	apply(conn, Config{ColumnExpressions: map[string]string{"u.name": "upper($id::text)"}})
+	assert.Equal(t, []string{
+		`INSERT INTO "u"("id","name") VALUES ($1,upper($1::text))`,
+		"COPY \"t\"(\"id\",\"name\") FROM STDIN\n1\tn\\tt\n3\tn\\tt\n",
+		`INSERT INTO "t"("id") VALUES ($1)`,
+	}, *conn.statements, "rows with expressions are inserted")
+
+	var statements []string
+	apply(MockDbExec{ExecHandler: func(sql string, a []interface{}) (pgconn.CommandTag, error) {
+		statements = append(statements, sql)
+		return pgconn.CommandTag("INSERT 0 1"), nil
+	}}, Config{})
+	assert.Len(t, statements, 4, "rows are inserted without COPY support")
+}
@@ -0,0 +1,335 @@
+package postgres
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// stagingPrefix is prepended to the name of the target table to name its staging table
+const stagingPrefix = "dbz2pg_staging_"
+
+// Kinds of staging tables
+const (
+	StagingUnlogged  = "unlogged"  // unlogged tables, which skip WAL and are emptied after a crash
+	StagingTemporary = "temporary" // temporary tables of the connection, which are emptied on commit
+	StagingOrdinary  = "ordinary"  // ordinary tables, e.g. for targets replicating all tables
+)
+
+// number of set-based merges of staged rows and CDC items applied by them during session
+var (
+	stagedMerges uint64
+	stagedItems  uint64
+)
+
+// staging holds qualified names of the staging tables used during session, so they are dropped on shutdown
+var staging struct {
+	sync.Mutex
+	tables map[string]bool
+}
+
+// stagesTable returns true if the prepared CDC item changes the row of the table applied through the staging table
+func stagesTable(cfg Config, m kafka.Message) bool {
+	if cfg.AppendMode || cfg.Ledger > "" || cfg.SchemaDrift > "" || cfg.WidenColumns || cfg.PositionGuard || hasApplyHooks(cfg) || m.SchemaChange != nil ||
+		m.TransactionBoundary != nil || len(m.Records) > 0 {
+		return false
+	}
+	if (m.Op != "c" && m.Op != "u" && m.Op != "d") || len(fanOutTargets(cfg, m)) > 0 || lastWriteWinsTable(cfg, m) ||
+		isHistoryTable(cfg, m) || archivesDeletes(cfg, m) || hasTablePolicy(cfg, m) || len(returningColumns(cfg, m)) > 0 {
+		return false
+	}
+	return cfg.StagingTables[m.TableName] || cfg.StagingTables[m.SchemaName+"."+m.TableName]
+}
+
+// copierOf returns the executor of `conn` able to load rows with COPY, i.e. the connection of transactions
+func copierOf(conn DBExecutorContext) (DBCopier, bool) {
+	if copier, ok := conn.(DBCopier); ok {
+		return copier, true
+	}
+	if tx, ok := conn.(pgx.Tx); ok && tx.Conn() != nil {
+		return pgConnCopier{tx.Conn().PgConn()}, true
+	}
+	return nil, false
+}
+
+// stagedGroup holds collapsed rows of the table staged and merged together, i.e. deletes or upserts of the same columns
+type stagedGroup struct {
+	deletes bool
+	message kafka.Message // the last CDC item of the group, describing the table
+	fields  []string      // quoted names of the staged columns
+	data    bytes.Buffer  // rows in the COPY text format
+}
+
+// applyStaged applies prepared CDC items of the staging tables with a set-based merge per table. Items of the table
+// are collapsed per key first, so only the last change of each row is applied. Tables with items that can't be staged,
+// e.g. without key or with columns set by expressions, are applied item by item instead
+func applyStaged(ctx context.Context, conn DBExecutorContext, cfg Config, items []kafka.Message) error {
+	var order []string
+	tables := make(map[string][]kafka.Message)
+	for _, m := range items {
+		table := m.QualifiedTablename()
+		if _, ok := tables[table]; !ok {
+			order = append(order, table)
+		}
+		tables[table] = append(tables[table], m)
+	}
+	for _, table := range order {
+		groups, ok := stageRows(cfg, collapseRows(cfg, tables[table]))
+		_, copier := copierOf(conn)
+		if !ok || !copier {
+			loggerOf(cfg).WithField("table", table).Debug("CDC items can't be staged, applying them one by one")
+			for _, m := range tables[table] {
+				if _, err := applyRecorded(ctx, conn, cfg, m); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if err := mergeStaged(ctx, conn, cfg, groups); err != nil {
+			return err
+		}
+		atomic.AddUint64(&stagedItems, uint64(len(tables[table])))
+	}
+	return nil
+}
+
+// collapseRows returns the last change of each row of the CDC items of the same table in the order of their first
+// change, or nil if any item lacks the key. Upserts following each other are combined, so columns missing in the later
+// ones, e.g. unchanged TOASTed values, keep their earlier values. Deletes discard preceding changes
+func collapseRows(cfg Config, items []kafka.Message) []kafka.Message {
+	var rows []kafka.Message
+	index := make(map[string]int)
+	for _, m := range items {
+		m, err := overrideKeys(cfg, m)
+		if err != nil || len(m.Keys) == 0 {
+			return nil
+		}
+		if m.Op == "c" {
+			m.Values = omitNullDefaults(cfg, m, m.Values)
+		}
+		key := rowKey(m.Keys)
+		i, seen := index[key]
+		if !seen {
+			index[key] = len(rows)
+			rows = append(rows, m)
+			continue
+		}
+		if prev := rows[i]; m.Op != "d" && prev.Op != "d" {
+			values := make(map[string]interface{}, len(prev.Values)+len(m.Values))
+			fields := make(map[string]kafka.Field, len(prev.Fields)+len(m.Fields))
+			for f, v := range prev.Values {
+				values[f] = v
+			}
+			for f, v := range m.Values {
+				values[f] = v
+			}
+			for f, field := range prev.Fields {
+				fields[f] = field
+			}
+			for f, field := range m.Fields {
+				fields[f] = field
+			}
+			m.Values, m.Fields = values, fields
+		}
+		rows[i] = m
+	}
+	return rows
+}
+
+// rowKey returns the string identifying the row by its key
+func rowKey(keys map[string]interface{}) string {
+	columns := make([]string, 0, len(keys))
+	for k := range keys {
+		columns = append(columns, k)
+	}
+	sort.Strings(columns)
+	var b strings.Builder
+	for _, k := range columns {
+		fmt.Fprintf(&b, "%s=%v\x00", k, keys[k])
+	}
+	return b.String()
+}
+
+// stageRows encodes collapsed rows in the COPY text format grouped by deletes and upserts of the same columns,
+// `ok` is false if any row can't be loaded with COPY
+func stageRows(cfg Config, rows []kafka.Message) (groups []*stagedGroup, ok bool) {
+	if len(rows) == 0 {
+		return nil, false
+	}
+	index := make(map[string]*stagedGroup)
+	for _, m := range rows {
+		row := m.Keys
+		if m.Op != "d" {
+			// the key is needed to match the existing row
+			row = make(map[string]interface{}, len(m.Values)+len(m.Keys))
+			for f, v := range m.Keys {
+				row[f] = v
+			}
+			for f, v := range m.Values {
+				row[f] = v
+			}
+		}
+		fields, line, ok := copyLine(cfg, m, row)
+		if !ok {
+			return nil, false
+		}
+		columns := strings.Join(fields, ",")
+		key := m.Op + "(" + columns + ")"
+		if m.Op != "d" {
+			key = "u(" + columns + ")"
+		}
+		g, found := index[key]
+		if !found {
+			g = &stagedGroup{deletes: m.Op == "d", fields: fields}
+			index[key] = g
+			groups = append(groups, g)
+		}
+		g.message = m
+		g.data.WriteString(line)
+	}
+	return groups, true
+}
+
+// mergeStaged loads each group of rows into the staging table of the target table with COPY and merges them into
+// the target with a single statement. The staging table is truncated before loading, so rows are never merged twice
+func mergeStaged(ctx context.Context, conn DBExecutorContext, cfg Config, groups []*stagedGroup) (err error) {
+	copier, _ := copierOf(conn)
+	table := groups[0].message.QualifiedTablename()
+	stagingTable, err := createStaging(ctx, conn, cfg, groups)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			// e.g. columns changed, so the staging table is recreated by the next batch
+			forgetStaging(stagingTable)
+		}
+	}()
+	for _, g := range groups {
+		if _, err = conn.Exec(ctx, "TRUNCATE "+stagingTable); err != nil {
+			return classify(ErrDBExec, err)
+		}
+		columns := strings.Join(g.fields, ",")
+		if _, err = copier.CopyFromStdin(ctx, &g.data, fmt.Sprintf("COPY %s(%s) FROM STDIN", stagingTable, columns)); err != nil {
+			return classify(ErrDBExec, err)
+		}
+		if _, err = conn.Exec(ctx, mergeStatement(cfg, table, stagingTable, g)); err != nil {
+			return classify(ErrDBExec, err)
+		}
+		atomic.AddUint64(&tx, 3)
+		atomic.AddUint64(&stagedMerges, 1)
+		loggerOf(cfg).WithField("table", table).WithField("deletes", g.deletes).Debug("Staged rows merged")
+	}
+	return nil
+}
+
+// stagingName returns the qualified name of the staging table of the target table of the CDC item
+func stagingName(cfg Config, m kafka.Message) string {
+	schema := cfg.StagingSchema
+	if cfg.StagingKind == StagingTemporary {
+		schema = "pg_temp"
+	}
+	stage := m
+	stage.TableName = stagingPrefix + m.TableName
+	if schema > "" {
+		if m.SchemaName > "" {
+			// tables of different schemas share the staging schema
+			stage.TableName = stagingPrefix + m.SchemaName + "_" + m.TableName
+		}
+		stage.SchemaName = schema
+	}
+	return stage.QualifiedTablename()
+}
+
+// createStaging creates the staging table for the groups of rows of the target table unless it exists. Columns are
+// the ones of the target table with their types but without constraints, so the rows are loaded and merged as typed
+// by the target. Staging tables not used during session yet are recreated, so stale ones, e.g. left by a crash or
+// with other columns, are never used
+func createStaging(ctx context.Context, conn DBExecutorContext, cfg Config, groups []*stagedGroup) (string, error) {
+	m := groups[0].message
+	name := stagingName(cfg, m)
+	var statements []string
+	if !usesStaging(name) {
+		if cfg.StagingSchema > "" && cfg.StagingKind != StagingTemporary {
+			statements = append(statements, "CREATE SCHEMA IF NOT EXISTS "+pgx.Identifier{cfg.StagingSchema}.Sanitize())
+		}
+		statements = append(statements, "DROP TABLE IF EXISTS "+name)
+	}
+	kind, onCommit := "UNLOGGED ", ""
+	switch cfg.StagingKind {
+	case StagingTemporary:
+		kind, onCommit = "TEMPORARY ", " ON COMMIT DELETE ROWS"
+	case StagingOrdinary:
+		kind = ""
+	}
+	statements = append(statements,
+		fmt.Sprintf("CREATE %sTABLE IF NOT EXISTS %s%s AS SELECT * FROM %s WITH NO DATA", kind, name, onCommit, m.QualifiedTablename()))
+	for _, sql := range statements {
+		if _, err := conn.Exec(ctx, sql); err != nil {
+			forgetStaging(name)
+			return name, classify(ErrDBExec, err)
+		}
+	}
+	staging.Lock()
+	defer staging.Unlock()
+	if staging.tables == nil {
+		staging.tables = make(map[string]bool)
+	}
+	staging.tables[name] = true
+	return name, nil
+}
+
+// hasStagingTables returns true if any staging table is used during session
+func hasStagingTables() bool {
+	staging.Lock()
+	defer staging.Unlock()
+	return len(staging.tables) > 0
+}
+
+// usesStaging returns true if the staging table is already used during session
+func usesStaging(name string) bool {
+	staging.Lock()
+	defer staging.Unlock()
+	return staging.tables[name]
+}
+
+// forgetStaging marks the staging table as not used during session, so it's recreated on next use
+func forgetStaging(name string) {
+	staging.Lock()
+	defer staging.Unlock()
+	delete(staging.tables, name)
+}
+
+// mergeStatement returns the statement deleting or upserting rows of the target table staged in `stagingTable`
+func mergeStatement(cfg Config, table string, stagingTable string, g *stagedGroup) string {
+	if g.deletes {
+		conditions := make([]string, len(g.fields))
+		for i, c := range g.fields {
+			conditions[i] = "t." + c + "=s." + c
+		}
+		return fmt.Sprintf("DELETE FROM %s AS t USING %s AS s WHERE %s", table, stagingTable, strings.Join(conditions, " AND "))
+	}
+	columns := strings.Join(g.fields, ",")
+	conflicting := conflictColumns(cfg, g.message)
+	return fmt.Sprintf("INSERT INTO %s(%s) SELECT %s FROM %s ON CONFLICT %s%s",
+		table, columns, columns, stagingTable, conflictTarget(conflicting), upsertAction(g.fields, conflicting))
+}
+
+// dropStagingTables drops the staging tables used during session
+func dropStagingTables(ctx context.Context, conn DBExecutorContext, cfg Config) {
+	staging.Lock()
+	defer staging.Unlock()
+	for table := range staging.tables {
+		if _, err := conn.Exec(ctx, "DROP TABLE IF EXISTS "+table); err != nil {
+			loggerOf(cfg).WithField("table", table).WithError(err).Warning("Staging table not dropped")
+		}
+	}
+	staging.tables = nil
+}
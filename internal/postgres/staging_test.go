@@ -0,0 +1,152 @@
+package postgres
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockCopierTx struct {
+	MockDbTx
+	statements *[]string
+}
+
+func (m mockCopierTx) CopyFromStdin(ctx context.Context, r io.Reader, sql string) (pgconn.CommandTag, error) {
+	data, _ := ioutil.ReadAll(r)
+	*m.statements = append(*m.statements, sql+"\n"+string(data))
+	return pgconn.CommandTag("COPY"), nil
+}
+
+type mockCopierTransactor struct {
+	MockDbExec
+	Tx mockCopierTx
+}
+
+func (m mockCopierTransactor) Begin(ctx context.Context) (pgx.Tx, error) {
+	return m.Tx, nil
+}
+
+func TestCollapseRows(t *testing.T) {
+	key := func(id int) map[string]interface{} {
+		return map[string]interface{}{"id": json.Number(string(rune('0' + id)))}
+	}
+	rows := collapseRows(Config{}, []kafka.Message{
+		{Op: "c", Keys: key(1), Values: map[string]interface{}{"id": json.Number("1"), "a": "a1", "b": "b1"}},
+		{Op: "c", Keys: key(2), Values: map[string]interface{}{"id": json.Number("2"), "a": "a2"}},
+		{Op: "u", Keys: key(1), Values: map[string]interface{}{"id": json.Number("1"), "a": "a1'"}},
+		{Op: "d", Keys: key(2)},
+		{Op: "d", Keys: key(3)},
+		{Op: "c", Keys: key(3), Values: map[string]interface{}{"id": json.Number("3"), "a": "a3"}},
+	})
+	assert.Len(t, rows, 3)
+	assert.Equal(t, map[string]interface{}{"id": json.Number("1"), "a": "a1'", "b": "b1"}, rows[0].Values, "unchanged columns kept")
+	assert.Equal(t, "d", rows[1].Op, "delete wins")
+	assert.Equal(t, "c", rows[2].Op, "insert after delete")
+	assert.Equal(t, map[string]interface{}{"id": json.Number("3"), "a": "a3"}, rows[2].Values)
+
+	assert.Nil(t, collapseRows(Config{}, []kafka.Message{{Op: "c", Values: map[string]interface{}{"a": "a1"}}}), "no key")
+}
+
+func TestApplyBatchStaging(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestApplyBatchStaging")
+	var statements []string
+	exec := MockDbExec{
+		ExecHandler: func(sql string, a []interface{}) (pgconn.CommandTag, error) {
+			statements = append(statements, sql)
+			return pgconn.CommandTag("INSERT 0 1"), nil
+		},
+	}
+	conn := mockCopierTransactor{
+		MockDbExec: exec,
+		Tx:         mockCopierTx{MockDbTx: MockDbTx{MockDbExec: exec}, statements: &statements},
+	}
+	key := func(id string) map[string]interface{} { return map[string]interface{}{"id": json.Number(id)} }
+	cfg := Config{StagingTables: map[string]bool{"public.events": true}}
+	applyBatch(context.Background(), conn, cfg, []kafka.Message{
+		{Op: "c", SchemaName: "public", TableName: "events", Keys: key("1"), Values: map[string]interface{}{"id": json.Number("1"), "v": "a"},
+			Fields: map[string]kafka.Field{"id": {Type: "int32"}, "v": {Type: "string"}}},
+		{Op: "c", SchemaName: "public", TableName: "other", Keys: key("1"), Values: map[string]interface{}{"id": json.Number("1")}},
+		{Op: "u", SchemaName: "public", TableName: "events", Keys: key("1"), Values: map[string]interface{}{"id": json.Number("1"), "v": "b"}},
+		{Op: "d", SchemaName: "public", TableName: "events", Keys: key("2")},
+	})
+	assert.Equal(t, []string{
+		`INSERT INTO "public"."other"("id") VALUES ($1)`,
+		`DROP TABLE IF EXISTS "public"."dbz2pg_staging_events"`,
+		`CREATE UNLOGGED TABLE IF NOT EXISTS "public"."dbz2pg_staging_events" AS SELECT * FROM "public"."events" WITH NO DATA`,
+		`TRUNCATE "public"."dbz2pg_staging_events"`,
+		"COPY \"public\".\"dbz2pg_staging_events\"(\"id\",\"v\") FROM STDIN\n1\tb\n",
+		`INSERT INTO "public"."events"("id","v") SELECT "id","v" FROM "public"."dbz2pg_staging_events" ON CONFLICT ("id") DO UPDATE SET "v"=EXCLUDED."v"`,
+		`TRUNCATE "public"."dbz2pg_staging_events"`,
+		"COPY \"public\".\"dbz2pg_staging_events\"(\"id\") FROM STDIN\n2\n",
+		`DELETE FROM "public"."events" AS t USING "public"."dbz2pg_staging_events" AS s WHERE t."id"=s."id"`,
+	}, statements)
+
+	statements = nil
+	cfg.ColumnExpressions = map[string]string{"events.v": "upper($id::text)"}
+	applyBatch(context.Background(), conn, cfg, []kafka.Message{
+		{Op: "c", SchemaName: "public", TableName: "events", Keys: key("3"), Values: map[string]interface{}{"id": json.Number("3"), "v": "c"}},
+	})
+	assert.Equal(t, []string{`INSERT INTO "public"."events"("id","v") VALUES ($1,upper($1::text))`}, statements, "expressions can't be staged")
+
+	statements = nil
+	dropStagingTables(context.Background(), conn, Config{})
+	assert.Equal(t, []string{`DROP TABLE IF EXISTS "public"."dbz2pg_staging_events"`}, statements)
+}
+
+func TestStagingKinds(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestStagingKinds")
+	var (
+		statements []string
+		copyErr    error
+	)
+	exec := MockDbExec{
+		ExecHandler: func(sql string, a []interface{}) (pgconn.CommandTag, error) {
+			statements = append(statements, sql)
+			return pgconn.CommandTag("INSERT 0 1"), copyErr
+		},
+	}
+	conn := mockCopierTransactor{
+		MockDbExec: exec,
+		Tx:         mockCopierTx{MockDbTx: MockDbTx{MockDbExec: exec}, statements: &statements},
+	}
+	batch := []kafka.Message{{Op: "u", SchemaName: "sales", TableName: "events", Keys: map[string]interface{}{"id": json.Number("1")},
+		Values: map[string]interface{}{"id": json.Number("1")}, Fields: map[string]kafka.Field{"id": {Type: "int64"}, "note": {Type: "string"}}}}
+	ctx := context.Background()
+
+	cfg := Config{StagingTables: map[string]bool{"events": true}, StagingKind: StagingTemporary}
+	applyBatch(ctx, conn, cfg, batch)
+	assert.Equal(t, []string{
+		`DROP TABLE IF EXISTS "pg_temp"."dbz2pg_staging_sales_events"`,
+		`CREATE TEMPORARY TABLE IF NOT EXISTS "pg_temp"."dbz2pg_staging_sales_events" ON COMMIT DELETE ROWS AS SELECT * FROM "sales"."events" WITH NO DATA`,
+	}, statements[:2])
+	statements = nil
+	applyBatch(ctx, conn, cfg, batch)
+	assert.Equal(t, `CREATE TEMPORARY TABLE IF NOT EXISTS "pg_temp"."dbz2pg_staging_sales_events" ON COMMIT DELETE ROWS AS SELECT * FROM "sales"."events" WITH NO DATA`,
+		statements[0], "reused on next batch")
+
+	statements = nil
+	cfg.StagingKind, cfg.StagingSchema = StagingOrdinary, "dbz2pg"
+	applyBatch(ctx, conn, cfg, batch)
+	assert.Equal(t, []string{
+		`CREATE SCHEMA IF NOT EXISTS "dbz2pg"`,
+		`DROP TABLE IF EXISTS "dbz2pg"."dbz2pg_staging_sales_events"`,
+		`CREATE TABLE IF NOT EXISTS "dbz2pg"."dbz2pg_staging_sales_events" AS SELECT * FROM "sales"."events" WITH NO DATA`,
+		`TRUNCATE "dbz2pg"."dbz2pg_staging_sales_events"`,
+	}, statements[:4])
+	assert.True(t, usesStaging(`"dbz2pg"."dbz2pg_staging_sales_events"`))
+
+	copyErr = errors.New("column \"added\" does not exist")
+	applyBatch(ctx, conn, cfg, batch)
+	assert.False(t, usesStaging(`"dbz2pg"."dbz2pg_staging_sales_events"`), "recreated after failure")
+	copyErr = nil
+	dropStagingTables(ctx, conn, Config{})
+}
@@ -0,0 +1,113 @@
+package postgres
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+)
+
+// ApplyStats is a snapshot of the apply progress, e.g. for health and stats endpoints
+type ApplyStats struct {
+	Transactions      uint64    // number of statements executed against the target
+	UnsupportedOps    uint64    // number of CDC items with unsupported operation
+	SkippedDuplicates uint64    // number of inserts skipped as duplicates of existing rows
+	MissingDeletes    uint64    // number of deletes of rows already missing tolerated by the table policy
+	DuplicateUpdates  uint64    // number of inserts applied as updates after failing with duplicate key
+	MultiRowInserts   uint64    // number of multi-row inserts of batches
+	MultiRowInsertAvg float64   // average number of rows inserted by multi-row inserts
+	BulkDeletes       uint64    // number of deletes of batches matching several keys
+	BulkDeleteAvg     float64   // average number of keys matched by bulk deletes
+	PartitionsCreated uint64    // number of partitions created for the inserted rows
+	ArchivedDeletes   uint64    // number of deleted rows archived
+	CollapsedChanges  uint64    // number of CDC items of batches collapsed into later changes of the same rows
+	IsolatedFailures  uint64    // number of CDC items of batches failed and rolled back to their savepoints
+	SnapshotCopies    uint64    // number of COPY statements loading snapshot rows
+	SnapshotCopyRows  uint64    // number of snapshot rows loaded with COPY
+	StagedMerges      uint64    // number of set-based merges of rows loaded into staging tables
+	StagedItems       uint64    // number of CDC items applied by merges of staging tables
+	StaleChanges      uint64    // number of CDC items skipped as older than the rows by the position guard or last-write-wins
+	EvolvedColumns    uint64    // number of columns added to the target tables by schema drift handling
+	WidenedColumns    uint64    // number of target columns widened for the values too large for them
+	DriftedColumns    uint64    // number of differences of the Debezium schemas from the target tables reported
+	Messages          uint64    // number of CDC items processed
+	MessagesPerSecond float64   // average processing rate since Apply started
+	LastOffset        int64     // offset of the last processed CDC item
+	LastApplied       time.Time // time the last CDC item was processed
+	LastError         error     // the last error occurred, if any
+}
+
+// stats holds apply progress of the current session
+var stats struct {
+	sync.Mutex
+	started     time.Time
+	messages    uint64
+	lastOffset  int64
+	lastApplied time.Time
+	lastError   error
+}
+
+// resetStats starts a new stats session
+func resetStats() {
+	stats.Lock()
+	defer stats.Unlock()
+	stats.started = time.Now()
+	stats.messages = 0
+	stats.lastOffset = 0
+	stats.lastApplied = time.Time{}
+	stats.lastError = nil
+}
+
+// updateStats records the result of applying `message`
+func updateStats(message kafka.Message, err error) {
+	stats.Lock()
+	defer stats.Unlock()
+	stats.messages++
+	stats.lastOffset = message.Offset
+	stats.lastApplied = time.Now()
+	if err != nil {
+		stats.lastError = err
+	}
+}
+
+// Stats returns the snapshot of the apply progress, it's safe to call concurrently with Apply
+func Stats() ApplyStats {
+	stats.Lock()
+	defer stats.Unlock()
+	s := ApplyStats{
+		Transactions:      atomic.LoadUint64(&tx),
+		UnsupportedOps:    atomic.LoadUint64(&unsupportedOps),
+		SkippedDuplicates: atomic.LoadUint64(&skippedDuplicates),
+		MissingDeletes:    atomic.LoadUint64(&missingDeletes),
+		DuplicateUpdates:  atomic.LoadUint64(&duplicateUpdates),
+		MultiRowInserts:   atomic.LoadUint64(&multiRowInserts),
+		BulkDeletes:       atomic.LoadUint64(&bulkDeletes),
+		PartitionsCreated: atomic.LoadUint64(&partitionsCreated),
+		ArchivedDeletes:   atomic.LoadUint64(&archivedDeletes),
+		CollapsedChanges:  atomic.LoadUint64(&collapsedChanges),
+		IsolatedFailures:  atomic.LoadUint64(&isolatedFailures),
+		SnapshotCopies:    atomic.LoadUint64(&snapshotCopies),
+		SnapshotCopyRows:  atomic.LoadUint64(&snapshotCopyRows),
+		StagedMerges:      atomic.LoadUint64(&stagedMerges),
+		StagedItems:       atomic.LoadUint64(&stagedItems),
+		StaleChanges:      atomic.LoadUint64(&staleChanges),
+		EvolvedColumns:    atomic.LoadUint64(&evolvedColumns),
+		WidenedColumns:    atomic.LoadUint64(&widenedColumns),
+		DriftedColumns:    atomic.LoadUint64(&driftedColumns),
+		Messages:          stats.messages,
+		LastOffset:        stats.lastOffset,
+		LastApplied:       stats.lastApplied,
+		LastError:         stats.lastError,
+	}
+	if s.MultiRowInserts > 0 {
+		s.MultiRowInsertAvg = float64(atomic.LoadUint64(&multiRowInsertRows)) / float64(s.MultiRowInserts)
+	}
+	if s.BulkDeletes > 0 {
+		s.BulkDeleteAvg = float64(atomic.LoadUint64(&bulkDeleteRows)) / float64(s.BulkDeletes)
+	}
+	if elapsed := time.Since(stats.started).Seconds(); !stats.started.IsZero() && elapsed > 0 {
+		s.MessagesPerSecond = float64(stats.messages) / elapsed
+	}
+	return s
+}
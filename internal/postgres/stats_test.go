@@ -0,0 +1,46 @@
+package postgres
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStats(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestStats")
+	Connect = func(ctx context.Context, connString string) (DBExecutorContext, error) {
+		return &MockDbExec{}, nil
+	}
+	resetStats()
+	assert.Zero(t, Stats().Messages)
+
+	var msgChan chan kafka.Message = make(chan kafka.Message, 3)
+	for offset, op := range []string{"r", "x", "r"} {
+		msg := kafka.Message{Op: op}
+		msg.Offset = int64(offset + 1)
+		msgChan <- msg
+	}
+	before := time.Now()
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			_ = Stats() // concurrent readers are safe
+		}
+	}()
+	Apply(context.Background(), "foo", Config{IdleTimeout: 100 * time.Millisecond}, msgChan)
+	wg.Wait()
+
+	s := Stats()
+	assert.Equal(t, uint64(3), s.Messages)
+	assert.Equal(t, int64(3), s.LastOffset)
+	assert.True(t, s.LastApplied.After(before))
+	assert.Error(t, s.LastError, "unsupported operation of the second message")
+	assert.Greater(t, s.MessagesPerSecond, float64(0))
+}
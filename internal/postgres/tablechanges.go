@@ -0,0 +1,244 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// tableDefinitions holds columns of the tables as described by the last schema change applied, keyed by the source
+// table id, so columns dropped or renamed by the next change are told apart
+var tableDefinitions = struct {
+	sync.Mutex
+	columns map[string][]kafka.TableColumn
+}{columns: make(map[string][]kafka.TableColumn)}
+
+// sourceTypes maps source type names of the structured table changes to PostgreSQL types, `length` and `scale`
+// are appended to the types taking them if declared
+var sourceTypes = map[string]string{
+	"TINYINT": "smallint", "SMALLINT": "smallint", "INT2": "smallint",
+	"MEDIUMINT": "integer", "INT": "integer", "INTEGER": "integer", "INT4": "integer", "SERIAL": "integer",
+	"BIGINT": "bigint", "INT8": "bigint", "BIGSERIAL": "bigint",
+	"DECIMAL": "numeric", "NUMERIC": "numeric", "NUMBER": "numeric",
+	"FLOAT": "real", "REAL": "real", "FLOAT4": "real",
+	"DOUBLE": "double precision", "DOUBLE PRECISION": "double precision", "FLOAT8": "double precision",
+	"BOOL": "boolean", "BOOLEAN": "boolean", "BIT": "boolean",
+	"CHAR": "character", "NCHAR": "character", "BPCHAR": "character",
+	"VARCHAR": "character varying", "NVARCHAR": "character varying",
+	"VARCHAR2": "character varying", "NVARCHAR2": "character varying",
+	"TEXT": "text", "TINYTEXT": "text", "MEDIUMTEXT": "text", "LONGTEXT": "text", "CLOB": "text", "NCLOB": "text",
+	"ENUM": "text", "SET": "text",
+	"DATE": "date", "TIME": "time", "DATETIME": "timestamp", "DATETIME2": "timestamp", "TIMESTAMP": "timestamp",
+	"TIMESTAMP WITHOUT TIME ZONE": "timestamp", "TIMESTAMP WITH TIME ZONE": "timestamptz",
+	"TIMESTAMP WITH LOCAL TIME ZONE": "timestamptz", "TIMESTAMPTZ": "timestamptz", "DATETIMEOFFSET": "timestamptz",
+	"BINARY": "bytea", "VARBINARY": "bytea", "BLOB": "bytea", "TINYBLOB": "bytea", "MEDIUMBLOB": "bytea",
+	"LONGBLOB": "bytea", "BYTEA": "bytea", "RAW": "bytea",
+	"JSON": "jsonb", "JSONB": "jsonb", "UUID": "uuid", "UNIQUEIDENTIFIER": "uuid",
+}
+
+// jdbcTimestampWithTimezone is the java.sql.Types code of the types with time zone, e.g. of MySQL TIMESTAMP
+const jdbcTimestampWithTimezone = 2014
+
+// sizedTypes holds the PostgreSQL types taking the declared length and scale of the source columns
+var sizedTypes = map[string]bool{"numeric": true, "character": true, "character varying": true}
+
+// tableColumnType returns the PostgreSQL type of the source column of the structured table change, text for the
+// types unknown. Unsigned integers get the type wide enough for their range
+func tableColumnType(c kafka.TableColumn) string {
+	name := strings.ToUpper(strings.TrimSpace(c.TypeName))
+	unsigned := strings.HasSuffix(name, " UNSIGNED")
+	name = strings.TrimSuffix(name, " UNSIGNED")
+	t, ok := sourceTypes[name]
+	if !ok {
+		return "text"
+	}
+	switch {
+	case t == "timestamp" && c.JDBCType == jdbcTimestampWithTimezone:
+		// MySQL TIMESTAMP columns hold UTC time
+		return "timestamptz"
+	case name == "BIT" && c.Length > 1:
+		return fmt.Sprintf("bit(%d)", c.Length)
+	case unsigned && t == "smallint" && name != "TINYINT":
+		return "integer"
+	case unsigned && t == "integer":
+		return "bigint"
+	case unsigned && t == "bigint":
+		return "numeric(20)"
+	case sizedTypes[t] && c.Length > 0 && c.Scale > 0:
+		return fmt.Sprintf("%s(%d,%d)", t, c.Length, c.Scale)
+	case sizedTypes[t] && c.Length > 0:
+		return fmt.Sprintf("%s(%d)", t, c.Length)
+	}
+	return t
+}
+
+// tableChangeTarget returns the target table of the structured table change, with only the table name as the CDC
+// item of the table to name the target the same way data changes do. Ids of three parts, e.g. of SQL Server or
+// Oracle, are "database.schema.table", ids of two parts, e.g. of MySQL, are "database.table"
+func tableChangeTarget(cfg Config, tc kafka.TableChange) kafka.Message {
+	parts := splitTableID(tc.ID)
+	m := kafka.Message{TableName: parts[len(parts)-1]}
+	if len(parts) > 2 {
+		m.SchemaName = parts[len(parts)-2]
+	}
+	return foldCase(cfg.CaseFold, m)
+}
+
+// splitTableID returns the parts of the table id, which may be quoted with double quotes
+func splitTableID(id string) []string {
+	var (
+		parts  []string
+		part   strings.Builder
+		quoted bool
+	)
+	for i := 0; i < len(id); i++ {
+		switch c := id[i]; {
+		case c == '"' && quoted && i+1 < len(id) && id[i+1] == '"':
+			part.WriteByte(c)
+			i++
+		case c == '"':
+			quoted = !quoted
+		case c == '.' && !quoted:
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(c)
+		}
+	}
+	return append(parts, part.String())
+}
+
+// targetColumn returns the name of the target column of the source `column` of the table, renamed and case folded
+// the same way the columns of the data changes are
+func targetColumn(cfg Config, table kafka.Message, column string) string {
+	table.Values = map[string]interface{}{column: nil}
+	for c := range renameColumns(cfg, foldCase(cfg.CaseFold, table)).Values {
+		return c
+	}
+	return column
+}
+
+// translateTableChange returns the DDL statements making the target table match the structured table change:
+// CREATE and DROP create and drop the table, ALTER adds the columns missing and, if the previous structure of the
+// table is known, drops the columns removed or renames the column if it's the only one replaced by another one
+func translateTableChange(cfg Config, tc kafka.TableChange, previous []kafka.TableColumn) ([]string, error) {
+	target := tableChangeTarget(cfg, tc)
+	table := target.QualifiedTablename()
+	column := func(name string) string {
+		return pgx.Identifier{targetColumn(cfg, target, name)}.Sanitize()
+	}
+	switch strings.ToUpper(tc.Type) {
+	case "CREATE":
+		definitions := make([]string, 0, len(tc.Columns)+1)
+		for _, c := range tc.Columns {
+			definition := column(c.Name) + " " + tableColumnType(c)
+			if !c.Optional {
+				definition += " NOT NULL"
+			}
+			definitions = append(definitions, definition)
+		}
+		if len(tc.PrimaryKey) > 0 {
+			keys := make([]string, len(tc.PrimaryKey))
+			for i, k := range tc.PrimaryKey {
+				keys[i] = column(k)
+			}
+			definitions = append(definitions, "PRIMARY KEY ("+strings.Join(keys, ", ")+")")
+		}
+		return []string{fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", table, strings.Join(definitions, ", "))}, nil
+	case "DROP":
+		if !cfg.AllowDestructiveDDL {
+			return nil, errDestructiveDDL
+		}
+		return []string{"DROP TABLE IF EXISTS " + table}, nil
+	case "ALTER":
+	default:
+		return nil, nil
+	}
+	current := make(map[string]bool, len(tc.Columns))
+	for _, c := range tc.Columns {
+		current[c.Name] = true
+	}
+	known := make(map[string]string, len(previous))
+	var dropped []kafka.TableColumn
+	for _, c := range previous {
+		known[c.Name] = tableColumnType(c)
+		if !current[c.Name] {
+			dropped = append(dropped, c)
+		}
+	}
+	var added, retyped []kafka.TableColumn
+	for _, c := range tc.Columns {
+		// without the previous structure all the columns are added if missing
+		previousType, ok := known[c.Name]
+		switch {
+		case !ok:
+			added = append(added, c)
+		case previousType != tableColumnType(c):
+			// types are only widened unless destructive changes are allowed, as narrowing rounds or truncates values
+			if !widens(previousType, tableColumnType(c)) && !cfg.AllowDestructiveDDL {
+				return nil, errDestructiveDDL
+			}
+			retyped = append(retyped, c)
+		}
+	}
+	if len(previous) > 0 && len(dropped) == 1 && len(added) == 1 && len(retyped) == 0 &&
+		known[dropped[0].Name] == tableColumnType(added[0]) {
+		return []string{fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s", table, column(dropped[0].Name), column(added[0].Name))}, nil
+	}
+	var statements []string
+	if len(dropped) > 0 && !cfg.AllowDestructiveDDL {
+		return nil, errDestructiveDDL
+	}
+	for _, c := range dropped {
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s DROP COLUMN IF EXISTS %s", table, column(c.Name)))
+	}
+	for _, c := range added {
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s ADD COLUMN IF NOT EXISTS %s %s", table, column(c.Name), tableColumnType(c)))
+	}
+	for _, c := range retyped {
+		// values are converted by the assignment casts, so widened columns keep them all
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s", table, column(c.Name), tableColumnType(c)))
+	}
+	return statements, nil
+}
+
+// applyTableChanges translates the structured table changes of the schema change event to PostgreSQL DDL and executes
+// it against the target database. Executed statements are logged to `cfg.SchemaChangesTable` if set
+func applyTableChanges(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message) (int64, error) {
+	tableDefinitions.Lock()
+	defer tableDefinitions.Unlock()
+	for _, tc := range m.SchemaChange.TableChanges {
+		if len(tc.Columns) == 0 && !strings.EqualFold(tc.Type, "DROP") {
+			return 0, classify(ErrMissingField, errors.New("Structure of the changed table is missing"))
+		}
+		statements, err := translateTableChange(cfg, tc, tableDefinitions.columns[tc.ID])
+		if err != nil {
+			return 0, err
+		}
+		target := tableChangeTarget(cfg, tc)
+		target.Topic, target.Partition, target.Offset = m.Topic, m.Partition, m.Offset
+		for _, sql := range statements {
+			loggerOf(cfg).WithField("op", "ddl").WithField("ddl", sql).Warning("Applying translated schema change")
+			_, err := conn.Exec(ctx, sql)
+			atomic.AddUint64(&tx, 1)
+			if err != nil {
+				return 0, classify(ErrDBExec, err)
+			}
+			if err := recordSchemaChange(ctx, conn, cfg, target, "", sql); err != nil {
+				return 0, err
+			}
+		}
+		if strings.EqualFold(tc.Type, "DROP") {
+			delete(tableDefinitions.columns, tc.ID)
+		} else {
+			tableDefinitions.columns[tc.ID] = tc.Columns
+		}
+	}
+	return 0, nil
+}
@@ -0,0 +1,156 @@
+package postgres
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/cybertec-postgresql/debezium2postgres/internal/postgres/postgrestest"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTableColumnType(t *testing.T) {
+	for expected, c := range map[string]kafka.TableColumn{
+		"integer":                {TypeName: "INT", Length: 11},
+		"bigint":                 {TypeName: "INT UNSIGNED"},
+		"numeric(20)":            {TypeName: "bigint unsigned"},
+		"smallint":               {TypeName: "TINYINT UNSIGNED"},
+		"numeric(10,2)":          {TypeName: "DECIMAL", Length: 10, Scale: 2},
+		"numeric(10)":            {TypeName: "NUMERIC", Length: 10},
+		"character varying(200)": {TypeName: "VARCHAR", Length: 200},
+		"character(3)":           {TypeName: "bpchar", Length: 3},
+		"boolean":                {TypeName: "BIT", Length: 1},
+		"bit(8)":                 {TypeName: "BIT", Length: 8},
+		"timestamp":              {TypeName: "DATETIME", Length: 6},
+		"jsonb":                  {TypeName: "JSON"},
+		"text":                   {TypeName: "GEOMETRY"},
+	} {
+		assert.Equal(t, expected, tableColumnType(c), c.TypeName)
+	}
+	// timestamps have no time zone unless declared, except the MySQL ones holding UTC time
+	assert.Equal(t, "timestamp", tableColumnType(kafka.TableColumn{TypeName: "TIMESTAMP", JDBCType: 93}))
+	assert.Equal(t, "timestamptz", tableColumnType(kafka.TableColumn{TypeName: "TIMESTAMP", JDBCType: 2014}))
+	assert.Equal(t, "timestamptz", tableColumnType(kafka.TableColumn{TypeName: "timestamp with time zone"}))
+}
+
+func TestSplitTableID(t *testing.T) {
+	assert.Equal(t, []string{"inventory", "customers"}, splitTableID(`"inventory"."customers"`))
+	assert.Equal(t, []string{"db", "dbo", "my.table"}, splitTableID(`db.dbo."my.table"`))
+	assert.Equal(t, []string{`a"b`}, splitTableID(`"a""b"`))
+
+	target := tableChangeTarget(Config{}, kafka.TableChange{ID: `"inventory"."customers"`})
+	assert.Equal(t, `"customers"`, target.QualifiedTablename())
+	target = tableChangeTarget(Config{CaseFold: CaseFoldLower}, kafka.TableChange{ID: "db.dbo.ORDERS"})
+	assert.Equal(t, `"dbo"."orders"`, target.QualifiedTablename())
+}
+
+func TestTranslateTableChange(t *testing.T) {
+	columns := []kafka.TableColumn{
+		{Name: "id", TypeName: "INT"},
+		{Name: "name", TypeName: "VARCHAR", Length: 50, Optional: true},
+	}
+	create := kafka.TableChange{Type: "CREATE", ID: "inventory.customers", PrimaryKey: []string{"id"}, Columns: columns}
+	statements, err := translateTableChange(Config{}, create, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`CREATE TABLE IF NOT EXISTS "customers" ("id" integer NOT NULL, "name" character varying(50), PRIMARY KEY ("id"))`}, statements)
+
+	// without the previous structure missing columns are added
+	added := append(append([]kafka.TableColumn{}, columns...), kafka.TableColumn{Name: "phone", TypeName: "VARCHAR", Length: 20, Optional: true})
+	alter := kafka.TableChange{Type: "ALTER", ID: "inventory.customers", Columns: added}
+	statements, err = translateTableChange(Config{}, alter, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		`ALTER TABLE "customers" ADD COLUMN IF NOT EXISTS "id" integer`,
+		`ALTER TABLE "customers" ADD COLUMN IF NOT EXISTS "name" character varying(50)`,
+		`ALTER TABLE "customers" ADD COLUMN IF NOT EXISTS "phone" character varying(20)`,
+	}, statements)
+	statements, err = translateTableChange(Config{}, alter, columns)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`ALTER TABLE "customers" ADD COLUMN IF NOT EXISTS "phone" character varying(20)`}, statements)
+
+	// the only column replaced is renamed, renamed target columns are used
+	renamed := kafka.TableChange{Type: "ALTER", ID: "inventory.customers", Columns: []kafka.TableColumn{columns[0], {Name: "full_name", TypeName: "VARCHAR", Length: 50}}}
+	cfg := Config{ColumnMappers: map[string]ColumnMapper{"customers": func(c string) string { return strings.ToUpper(c) }}}
+	statements, err = translateTableChange(cfg, renamed, columns)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`ALTER TABLE "customers" RENAME COLUMN "NAME" TO "FULL_NAME"`}, statements)
+
+	// the column replaced by one of another type is dropped
+	replaced := kafka.TableChange{Type: "ALTER", ID: "inventory.customers", Columns: []kafka.TableColumn{columns[0], {Name: "age", TypeName: "INT"}}}
+	_, err = translateTableChange(Config{}, replaced, columns)
+	assert.Equal(t, errDestructiveDDL, err)
+	statements, err = translateTableChange(Config{AllowDestructiveDDL: true}, replaced, columns)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		`ALTER TABLE "customers" DROP COLUMN IF EXISTS "name"`,
+		`ALTER TABLE "customers" ADD COLUMN IF NOT EXISTS "age" integer`,
+	}, statements)
+
+	// changed column types are altered
+	retyped := kafka.TableChange{Type: "ALTER", ID: "inventory.customers",
+		Columns: []kafka.TableColumn{{Name: "id", TypeName: "BIGINT"}, columns[1]}}
+	statements, err = translateTableChange(Config{}, retyped, columns)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`ALTER TABLE "customers" ALTER COLUMN "id" TYPE bigint`}, statements)
+
+	// narrowing types or reducing the scale is destructive
+	narrowed := kafka.TableChange{Type: "ALTER", ID: "inventory.customers",
+		Columns: []kafka.TableColumn{columns[0], {Name: "name", TypeName: "VARCHAR", Length: 20, Optional: true}}}
+	_, err = translateTableChange(Config{}, narrowed, columns)
+	assert.Equal(t, errDestructiveDDL, err)
+	amounts := []kafka.TableColumn{{Name: "amount", TypeName: "DECIMAL", Length: 10, Scale: 2}}
+	rounded := kafka.TableChange{Type: "ALTER", ID: "inventory.orders",
+		Columns: []kafka.TableColumn{{Name: "amount", TypeName: "DECIMAL", Length: 10, Scale: 1}}}
+	_, err = translateTableChange(Config{}, rounded, amounts)
+	assert.Equal(t, errDestructiveDDL, err)
+	statements, err = translateTableChange(Config{AllowDestructiveDDL: true}, rounded, amounts)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`ALTER TABLE "orders" ALTER COLUMN "amount" TYPE numeric(10,1)`}, statements)
+
+	dropped := kafka.TableChange{Type: "ALTER", ID: "inventory.customers", Columns: columns[:1]}
+	_, err = translateTableChange(Config{}, dropped, columns)
+	assert.Equal(t, errDestructiveDDL, err)
+	statements, err = translateTableChange(Config{AllowDestructiveDDL: true}, dropped, columns)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`ALTER TABLE "customers" DROP COLUMN IF EXISTS "name"`}, statements)
+
+	drop := kafka.TableChange{Type: "DROP", ID: "inventory.customers"}
+	_, err = translateTableChange(Config{}, drop, nil)
+	assert.Equal(t, errDestructiveDDL, err)
+	statements, err = translateTableChange(Config{AllowDestructiveDDL: true}, drop, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`DROP TABLE IF EXISTS "customers"`}, statements)
+}
+
+func TestApplyTableChanges(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestApplyTableChanges")
+	conn := postgrestest.NewMemExecutor(0)
+	change := func(typ string, columns ...string) kafka.Message {
+		tc := kafka.TableChange{Type: typ, ID: "shop.items"}
+		for _, c := range columns {
+			tc.Columns = append(tc.Columns, kafka.TableColumn{Name: c, TypeName: "INT", Optional: true})
+		}
+		return kafka.Message{SchemaChange: &kafka.SchemaChange{DDL: "ALTER TABLE `items` ...", TableChanges: []kafka.TableChange{tc}}}
+	}
+	cfg := Config{TranslateDDL: true}
+	_, err := applyCDCItem(context.Background(), conn, cfg, change("CREATE", "id"))
+	assert.NoError(t, err)
+	_, err = applyCDCItem(context.Background(), conn, cfg, change("ALTER", "id", "qty"))
+	assert.NoError(t, err)
+	_, err = applyCDCItem(context.Background(), conn, cfg, change("ALTER", "id", "quantity"))
+	assert.NoError(t, err)
+	conn.AssertSQL(t,
+		`CREATE TABLE IF NOT EXISTS "items" ("id" integer)`,
+		`ALTER TABLE "items" ADD COLUMN IF NOT EXISTS "qty" integer`,
+		`ALTER TABLE "items" RENAME COLUMN "qty" TO "quantity"`,
+	)
+
+	// structure missing, e.g. if the connector doesn't provide it
+	m := change("ALTER")
+	_, err = applyCDCItem(context.Background(), conn, cfg, m)
+	assert.Error(t, err)
+	_, err = applyCDCItem(context.Background(), conn, Config{}, m)
+	assert.NoError(t, err, "schema changes are skipped unless applied or translated")
+}
@@ -0,0 +1,147 @@
+package postgres
+
+import (
+	"context"
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+)
+
+// transactionGroup holds CDC items of the source transaction received so far
+type transactionGroup struct {
+	messages []kafka.Message
+	began    bool      // BEGIN marker received
+	ended    bool      // END marker received
+	expected int64     // number of CDC items announced by the END marker
+	flushed  int64     // number of CDC items already applied before the transaction is complete
+	started  time.Time // time the first event of the transaction was received
+}
+
+// transactions buffers CDC items by the source transaction id until the transaction is complete
+type transactions map[string]*transactionGroup
+
+// isTransactional returns true if the CDC item is grouped by the source transaction if `cfg.GroupTransactions` is set
+func isTransactional(cfg Config, m kafka.Message) bool {
+	return cfg.GroupTransactions && m.TransactionID > "" && m.SchemaChange == nil
+}
+
+// add buffers the CDC item or handles the transaction boundary and returns CDC items of the source transaction once
+// it's complete, i.e. the END marker is received together with all the CDC items it announces. Markers and data
+// changes come from different topics, so they may arrive in any order. The transaction whose BEGIN marker wasn't
+// received, e.g. when consuming resumed in the middle of it, is complete with the END marker, as its CDC items
+// consumed before are never received again. CDC items received so far are returned once
+// `cfg.TransactionMaxEvents` of them are buffered, so large transactions are applied in parts
+func (txs transactions) add(cfg Config, m kafka.Message) []kafka.Message {
+	g, ok := txs[m.TransactionID]
+	if !ok {
+		g = &transactionGroup{started: time.Now()}
+		txs[m.TransactionID] = g
+	}
+	switch {
+	case m.TransactionBoundary == nil:
+		g.messages = append(g.messages, m)
+	case m.TransactionBoundary.Status == "BEGIN":
+		g.began = true
+	case m.TransactionBoundary.Status == "END":
+		g.ended = true
+		g.expected = m.TransactionBoundary.EventCount
+	}
+	l := loggerOf(cfg).WithField("transaction", m.TransactionID)
+	switch {
+	case g.ended && g.flushed+int64(len(g.messages)) >= g.expected:
+	case g.ended && !g.began:
+		l.WithField("events", len(g.messages)).WithField("expected", g.expected-g.flushed).
+			Warning("Source transaction resumed without BEGIN marker, applying the CDC items received")
+	case cfg.TransactionMaxEvents > 0 && len(g.messages) >= cfg.TransactionMaxEvents:
+		l.WithField("events", len(g.messages)).Warning("Source transaction too large, applying the CDC items received so far")
+		messages := g.messages
+		g.flushed += int64(len(messages))
+		g.messages = nil
+		return messages
+	default:
+		return nil
+	}
+	delete(txs, m.TransactionID)
+	return g.messages
+}
+
+// expire returns CDC items of the source transactions incomplete for longer than `cfg.TransactionTimeout`, e.g.
+// whose END marker is never received, and forgets the transactions
+func (txs transactions) expire(cfg Config, now time.Time) [][]kafka.Message {
+	if cfg.TransactionTimeout <= 0 {
+		return nil
+	}
+	var expired [][]kafka.Message
+	for id, g := range txs {
+		if now.Sub(g.started) < cfg.TransactionTimeout {
+			continue
+		}
+		delete(txs, id)
+		if len(g.messages) == 0 {
+			continue
+		}
+		loggerOf(cfg).WithField("transaction", id).WithField("events", len(g.messages)).
+			Warning("Source transaction incomplete within the timeout, applying the CDC items received")
+		expired = append(expired, g.messages)
+	}
+	return expired
+}
+
+// apply buffers the CDC item and applies the source transaction atomically once it's complete, as well as
+// the expired incomplete ones. Returns true if applying must stop
+func (txs transactions) apply(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message) bool {
+	if group := txs.add(cfg, m); len(group) > 0 && applyTransaction(ctx, conn, cfg, group) {
+		return true
+	}
+	return txs.applyExpired(ctx, conn, cfg)
+}
+
+// applyExpired applies CDC items of the source transactions incomplete for longer than `cfg.TransactionTimeout`,
+// returns true if applying must stop
+func (txs transactions) applyExpired(ctx context.Context, conn DBExecutorContext, cfg Config) bool {
+	for _, group := range txs.expire(cfg, time.Now()) {
+		if applyTransaction(ctx, conn, cfg, group) {
+			return true
+		}
+	}
+	return false
+}
+
+// discard drops CDC items of incomplete source transactions, so they are never applied partially
+func (txs transactions) discard(cfg Config) {
+	for id, g := range txs {
+		loggerOf(cfg).WithField("transaction", id).WithField("events", len(g.messages)).
+			Warning("Incomplete source transaction not applied")
+		delete(txs, id)
+	}
+}
+
+// applyTransaction applies CDC items of the source transaction in a single target transaction. If any item fails,
+// none of them is applied and all of them are handled according to the error policy. Returns true if applying must
+// stop
+func applyTransaction(ctx context.Context, conn DBExecutorContext, cfg Config, group []kafka.Message) bool {
+	l := loggerOf(cfg).WithField("transaction", group[0].TransactionID).WithField("events", len(group))
+	transactor, ok := conn.(DBTransactor)
+	if !ok {
+		l.Warning("Target doesn't support transactions, applying CDC items one by one")
+		return applyOneByOne(ctx, conn, cfg, group)
+	}
+	// items of the source transaction are never applied partially
+	cfg.IsolateItems = false
+	_, err := applyInTx(ctx, transactor, cfg, group)
+	for _, m := range group {
+		updateStats(m, err)
+	}
+	if err != nil {
+		l.WithError(err).Error("Source transaction failed")
+		for _, m := range group {
+			if handleFailure(ctx, cfg, m, err) {
+				return true
+			}
+		}
+		return false
+	}
+	saveOffsets(ctx, cfg, group...)
+	l.Debug("Source transaction committed")
+	return false
+}
@@ -0,0 +1,140 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTransactionsAdd(t *testing.T) {
+	txs := make(transactions)
+	end := kafka.Message{TransactionID: "tx1", TransactionBoundary: &kafka.TransactionBoundary{Status: "END", ID: "tx1", EventCount: 2}}
+	assert.Nil(t, txs.add(Config{}, kafka.Message{TransactionID: "tx1", TransactionBoundary: &kafka.TransactionBoundary{Status: "BEGIN", ID: "tx1"}}))
+	assert.Nil(t, txs.add(Config{}, kafka.Message{Op: "c", TransactionID: "tx1"}))
+	assert.Nil(t, txs.add(Config{}, end), "END marker arrived before all the changes")
+	assert.Len(t, txs.add(Config{}, kafka.Message{Op: "u", TransactionID: "tx1"}), 2)
+	assert.Empty(t, txs)
+
+	end.TransactionBoundary.EventCount = 0
+	assert.Empty(t, txs.add(Config{}, end), "transaction without changes")
+	assert.Empty(t, txs)
+}
+
+func TestTransactionsLimits(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestTransactionsLimits")
+	txs := make(transactions)
+	begin := kafka.Message{TransactionID: "tx1", TransactionBoundary: &kafka.TransactionBoundary{Status: "BEGIN", ID: "tx1"}}
+	end := kafka.Message{TransactionID: "tx1", TransactionBoundary: &kafka.TransactionBoundary{Status: "END", ID: "tx1", EventCount: 3}}
+
+	// consuming resumed in the middle of the transaction, its first change is never received again
+	assert.Nil(t, txs.add(Config{}, kafka.Message{Op: "u", TransactionID: "tx1"}))
+	assert.Len(t, txs.add(Config{}, end), 1, "transaction without BEGIN marker is complete with the END one")
+	assert.Empty(t, txs)
+
+	// large transactions are applied in parts
+	cfg := Config{TransactionMaxEvents: 2}
+	assert.Nil(t, txs.add(cfg, begin))
+	assert.Nil(t, txs.add(cfg, kafka.Message{Op: "c", TransactionID: "tx1"}))
+	assert.Len(t, txs.add(cfg, kafka.Message{Op: "c", TransactionID: "tx1"}), 2)
+	assert.Nil(t, txs.add(cfg, end))
+	assert.Len(t, txs.add(cfg, kafka.Message{Op: "c", TransactionID: "tx1"}), 1)
+	assert.Empty(t, txs)
+
+	// transactions never ending are applied after the timeout
+	cfg = Config{TransactionTimeout: time.Minute}
+	assert.Nil(t, txs.add(cfg, begin))
+	assert.Nil(t, txs.add(cfg, kafka.Message{Op: "c", TransactionID: "tx1"}))
+	assert.Nil(t, txs.add(cfg, kafka.Message{TransactionID: "tx2", TransactionBoundary: &kafka.TransactionBoundary{Status: "BEGIN", ID: "tx2"}}))
+	assert.Empty(t, txs.expire(cfg, time.Now()))
+	assert.Empty(t, txs.expire(Config{}, time.Now().Add(time.Hour)), "no timeout")
+	expired := txs.expire(cfg, time.Now().Add(2*time.Minute))
+	assert.Equal(t, [][]kafka.Message{{{Op: "c", TransactionID: "tx1"}}}, expired, "transactions without changes are forgotten")
+	assert.Empty(t, txs)
+}
+
+func TestApplyGroupTransactions(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestApplyGroupTransactions")
+	var inTx, outOfTx, commits int
+	conn := MockDbTransactor{
+		MockDbExec: MockDbExec{
+			ExecHandler: func(sql string, arguments []interface{}) (pgconn.CommandTag, error) {
+				outOfTx++
+				return pgconn.CommandTag("INSERT 0 1"), nil
+			},
+		},
+		Tx: MockDbTx{
+			MockDbExec: MockDbExec{
+				ExecHandler: func(sql string, arguments []interface{}) (pgconn.CommandTag, error) {
+					inTx++
+					return pgconn.CommandTag("INSERT 0 1"), nil
+				},
+			},
+			CommitHandler: func() error {
+				commits++
+				return nil
+			},
+		},
+	}
+	Connect = func(ctx context.Context, connString string) (DBExecutorContext, error) {
+		return conn, nil
+	}
+	msgChan := make(chan kafka.Message, 8)
+	msgChan <- kafka.Message{TransactionID: "tx1", TransactionBoundary: &kafka.TransactionBoundary{Status: "BEGIN", ID: "tx1"}}
+	for i := 0; i < 3; i++ {
+		msgChan <- kafka.Message{Op: "c", TableName: "t", TransactionID: "tx1", Values: map[string]interface{}{"id": i}}
+	}
+	msgChan <- kafka.Message{TransactionID: "tx1", TransactionBoundary: &kafka.TransactionBoundary{Status: "END", ID: "tx1", EventCount: 3}}
+	// incomplete transaction is never applied
+	msgChan <- kafka.Message{Op: "c", TableName: "t", TransactionID: "tx2", Values: map[string]interface{}{"id": 4}}
+	Apply(context.Background(), "foo", Config{IdleTimeout: 100 * time.Millisecond, GroupTransactions: true}, msgChan)
+	assert.Equal(t, 1, inTx, "inserts combined into a multi-row insert")
+	assert.Equal(t, 1, commits)
+	assert.Equal(t, 0, outOfTx)
+
+	// failing transaction is rolled back as a whole and not retried one by one
+	inTx, commits = 0, 0
+	deadLetters := make(chan kafka.DeadLetter, 8)
+	conn.Tx.CommitHandler = func() error { return errors.New("serialization failure") }
+	for i := 0; i < 3; i++ {
+		msgChan <- kafka.Message{Op: "c", TableName: "t", TransactionID: "tx3", Values: map[string]interface{}{"id": i}}
+	}
+	msgChan <- kafka.Message{TransactionID: "tx3", TransactionBoundary: &kafka.TransactionBoundary{Status: "END", ID: "tx3", EventCount: 3}}
+	Apply(context.Background(), "foo", Config{IdleTimeout: 100 * time.Millisecond, GroupTransactions: true,
+		ErrorPolicy: ErrorPolicyDLQ, DeadLetters: deadLetters}, msgChan)
+	assert.Equal(t, 1, inTx)
+	assert.Equal(t, 0, outOfTx)
+	assert.Len(t, deadLetters, 3)
+
+	// with the halt policy applying stops at the failing transaction, offsets of later ones aren't saved
+	inTx = 0
+	store := memoryOffsets{}
+	for i, id := range []string{"tx5", "tx6"} {
+		msgChan <- kafka.Message{Op: "c", TableName: "t", TransactionID: id, Values: map[string]interface{}{"id": i},
+			Message: kafkago.Message{Topic: "foo", Offset: int64(2 * i)}}
+		msgChan <- kafka.Message{TransactionID: id, Message: kafkago.Message{Topic: "foo", Offset: int64(2*i + 1)},
+			TransactionBoundary: &kafka.TransactionBoundary{Status: "END", ID: id, EventCount: 1}}
+	}
+	Apply(context.Background(), "foo", Config{IdleTimeout: 100 * time.Millisecond, GroupTransactions: true,
+		ErrorPolicy: ErrorPolicyHalt, Offsets: store}, msgChan)
+	assert.Equal(t, 1, inTx, "the later transaction isn't applied")
+	assert.Empty(t, store)
+	assert.Len(t, deadLetters, 3)
+	conn.Tx.CommitHandler = nil
+	for len(msgChan) > 0 {
+		<-msgChan
+	}
+
+	// transaction markers are skipped unless grouping is enabled
+	msgChan <- kafka.Message{TransactionID: "tx4", TransactionBoundary: &kafka.TransactionBoundary{Status: "BEGIN", ID: "tx4"}}
+	msgChan <- kafka.Message{Op: "c", TableName: "t", TransactionID: "tx4", Values: map[string]interface{}{"id": 1}}
+	Apply(context.Background(), "foo", Config{IdleTimeout: 100 * time.Millisecond, DeadLetters: deadLetters}, msgChan)
+	assert.Equal(t, 1, outOfTx)
+	assert.Len(t, deadLetters, 3)
+}
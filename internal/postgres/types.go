@@ -0,0 +1,1147 @@
+package postgres
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+)
+
+// Debezium logical type names
+const (
+	logicalJSON      = "io.debezium.data.Json"
+	logicalXML       = "io.debezium.data.Xml"
+	logicalEnum      = "io.debezium.data.Enum"
+	logicalEnumSet   = "io.debezium.data.EnumSet"
+	logicalUUID      = "io.debezium.data.Uuid"
+	logicalLtree     = "io.debezium.data.Ltree"
+	logicalBits      = "io.debezium.data.Bits"
+	logicalPoint     = "io.debezium.data.geometry.Point"
+	logicalGeometry  = "io.debezium.data.geometry.Geometry"
+	logicalGeography = "io.debezium.data.geometry.Geography"
+
+	logicalDate                 = "io.debezium.time.Date"
+	logicalTimestamp            = "io.debezium.time.Timestamp"
+	logicalMicroTimestamp       = "io.debezium.time.MicroTimestamp"
+	logicalNanoTimestamp        = "io.debezium.time.NanoTimestamp"
+	logicalTime                 = "io.debezium.time.Time"
+	logicalMicroTime            = "io.debezium.time.MicroTime"
+	logicalNanoTime             = "io.debezium.time.NanoTime"
+	logicalInterval             = "io.debezium.time.Interval"
+	logicalMicroDuration        = "io.debezium.time.MicroDuration"
+	logicalDecimal              = "org.apache.kafka.connect.data.Decimal"
+	logicalVariableScaleDecimal = "io.debezium.data.VariableScaleDecimal"
+
+	// temporal types used with time.precision.mode=connect
+	logicalConnectDate      = "org.apache.kafka.connect.data.Date"
+	logicalConnectTime      = "org.apache.kafka.connect.data.Time"
+	logicalConnectTimestamp = "org.apache.kafka.connect.data.Timestamp"
+	// sent as ISO 8601 strings
+	logicalZonedTimestamp = "io.debezium.time.ZonedTimestamp"
+)
+
+// sourceColumnType is the schema parameter holding the source column type if `column.propagate.source.type` is enabled
+const sourceColumnType = "__debezium.source.column.type"
+
+// bindValue converts the CDC value of the `column` to the statement parameter and returns it together with
+// the SQL expression referencing it as the n-th parameter
+func bindValue(cfg Config, message kafka.Message, column string, v interface{}, n int) (interface{}, string, error) {
+	arg, ref, err := bindParam(cfg, message, column, v, n)
+	return arg, ref, classify(ErrPayloadDecode, err)
+}
+
+// bindParam converts the CDC value according to the Debezium schema, see bindValue
+func bindParam(cfg Config, message kafka.Message, column string, v interface{}, n int) (interface{}, string, error) {
+	f := message.Fields[column]
+	if convert, ok := lookupConverter(f); ok {
+		arg, err := convert(f, v)
+		return arg, placeholder(n, castFor(cfg, message, column)), err
+	}
+	switch f.Name {
+	case logicalPoint:
+		return convertPoint(cfg, v, n)
+	case logicalGeometry, logicalGeography:
+		return convertGeometry(cfg, f.Name, v, n)
+	}
+	cast := castFor(cfg, message, column)
+	if isBinary(f, cast) {
+		arg, err := convertBinary(cfg.BinaryHandling, f, v)
+		return arg, placeholder(n, cast), err
+	}
+	if sign := infinity(f, v); sign != 0 {
+		return convertInfinity(cfg, sign), placeholder(n, cast), nil
+	}
+	if special, ok := v.(string); ok && specialNumbers[special] != 0 {
+		if arg, ok := convertSpecialNumber(cfg, f, cast, special); ok {
+			return arg, placeholder(n, cast), nil
+		}
+	}
+	if isDecimal(f, cast) {
+		arg, err := convertDecimalValue(cfg.DecimalHandling, f, column, v)
+		return arg, placeholder(n, cast), err
+	}
+	arg, err := convertValue(f, column, cast, v)
+	if err == nil && (cast == "money" || strings.EqualFold(f.Parameters[sourceColumnType], "money")) {
+		arg, err = convertMoney(arg)
+		if cast == "money" {
+			// numeric input doesn't depend on lc_monetary of the target
+			return arg, placeholder(n, "numeric") + "::money", err
+		}
+	}
+	return arg, placeholder(n, cast), err
+}
+
+// logicalCasts maps Debezium logical types sent as strings to the PostgreSQL types they need to be cast to
+var logicalCasts = map[string]string{
+	logicalJSON:    "jsonb",
+	logicalXML:     "xml",
+	logicalEnumSet: "text[]",
+	logicalUUID:    "uuid",
+	logicalLtree:   "ltree",
+	// zoned timestamps are normalized to UTC by the connector
+	logicalZonedTimestamp: "timestamptz",
+	// interval.handling.mode=string and numeric respectively
+	logicalInterval:      "interval",
+	logicalMicroDuration: "interval",
+}
+
+// sourceTypeCasts lists PostgreSQL types, including extension ones, the values are cast to if the source column type
+// is propagated, as their values are sent as plain strings
+var sourceTypeCasts = map[string]bool{
+	"inet":     true,
+	"cidr":     true,
+	"macaddr":  true,
+	"macaddr8": true,
+	"money":    true,
+	"ltree":    true,
+	"citext":   true,
+	"tsvector": true,
+	"tsquery":  true,
+	// range types
+	"int4range": true,
+	"int8range": true,
+	"numrange":  true,
+	"tsrange":   true,
+	"tstzrange": true,
+	"daterange": true,
+	// system types
+	"oid":    true,
+	"xid":    true,
+	"xid8":   true,
+	"pg_lsn": true,
+}
+
+// castFor returns the PostgreSQL type the parameter for `column` should be cast to, or empty string if none needed.
+// Types configured explicitly take precedence over the ones derived from the Debezium logical type
+func castFor(cfg Config, message kafka.Message, column string) string {
+	if cast, ok := lookupColumn(cfg.ColumnTypes, message, column); ok {
+		return cast
+	}
+	if cast, ok := logicalCasts[message.Fields[column].Name]; ok {
+		return cast
+	}
+	if message.Fields[column].Name == logicalEnum {
+		// enum type name is known only if propagated from the source
+		return message.Fields[column].Parameters[sourceColumnType]
+	}
+	if t := strings.ToLower(message.Fields[column].Parameters[sourceColumnType]); sourceTypeCasts[t] {
+		return t
+	}
+	if message.Fields[column].Type == "map" {
+		return "hstore"
+	}
+	return ""
+}
+
+// lookupColumn returns the setting of the `column` keyed by "schema.table.column" or "table.column"
+func lookupColumn(settings map[string]string, message kafka.Message, column string) (string, bool) {
+	if s, ok := settings[message.SchemaName+"."+message.TableName+"."+column]; ok {
+		return s, true
+	}
+	s, ok := settings[message.TableName+"."+column]
+	return s, ok
+}
+
+// hasColumn returns true if the `column` keyed by "schema.table.column" or "table.column" is in the `set`
+func hasColumn(set map[string]bool, message kafka.Message, column string) bool {
+	return set[message.SchemaName+"."+message.TableName+"."+column] || set[message.TableName+"."+column]
+}
+
+// convertValue validates the CDC value of the `column` described by field `f` and converts it
+// to the statement parameter of the `cast` type
+func convertValue(f kafka.Field, column string, cast string, v interface{}) (interface{}, error) {
+	if convert, ok := lookupConverter(f); ok {
+		return convert(f, v)
+	}
+	switch f.Name {
+	case logicalEnum:
+		return v, checkEnum(f, column, v)
+	case logicalEnumSet:
+		return convertEnumSet(f, cast, v), nil
+	case logicalUUID:
+		return convertUUID(v), nil
+	case logicalBits:
+		return convertBits(f.Parameters["length"], v)
+	case logicalDate, logicalConnectDate:
+		return convertEpoch(v, 24*time.Hour)
+	case logicalTimestamp, logicalConnectTimestamp:
+		return convertEpoch(v, time.Millisecond)
+	case logicalMicroTimestamp:
+		return convertEpoch(v, time.Microsecond)
+	case logicalNanoTimestamp:
+		return convertEpoch(v, time.Nanosecond)
+	case logicalTime, logicalConnectTime:
+		return convertTimeOfDay(v, time.Millisecond)
+	case logicalMicroTime:
+		return convertTimeOfDay(v, time.Microsecond)
+	case logicalNanoTime:
+		return convertTimeOfDay(v, time.Nanosecond)
+	case logicalInterval, logicalMicroDuration:
+		return convertInterval(v)
+	case logicalDecimal:
+		if isUnsigned(f) && f.Parameters["scale"] == "0" {
+			return convertUnsignedDecimal(v)
+		}
+		return convertDecimal(f.Parameters["scale"], v)
+	case logicalVariableScaleDecimal:
+		if d, ok := v.(map[string]interface{}); ok {
+			return convertDecimal(fmt.Sprint(d["scale"]), d["value"])
+		}
+		return v, nil
+	}
+	if _, ok := v.(bool); ok && isBitType(cast, f.Parameters[sourceColumnType]) {
+		return convertBits("1", v)
+	}
+	switch cast {
+	case "inet", "cidr":
+		return convertInet(cast, v)
+	case "macaddr", "macaddr8":
+		return convertMAC(v)
+	case "int4range", "int8range", "numrange", "tsrange", "tstzrange", "daterange":
+		return convertRange(cast, v)
+	case "oid", "xid", "xid8":
+		return convertUnsigned(cast, v)
+	case "pg_lsn":
+		return convertLSN(v)
+	}
+	switch f.Type {
+	case "array":
+		return convertArray(f, column, v)
+	case "map":
+		return convertMap(cast, v)
+	case "struct":
+		return convertStruct(f, column, v)
+	}
+	if n, ok := v.(json.Number); ok {
+		return convertNumber(f, n)
+	}
+	return v, nil
+}
+
+// convertNumber converts JSON number to the Go type matching the Kafka Connect type of the field.
+// If the field type is unknown, integers are preferred and float is used as the last resort
+func convertNumber(f kafka.Field, n json.Number) (interface{}, error) {
+	switch f.Type {
+	case "int8", "int16", "int32":
+		return n.Int64()
+	case "int64":
+		return convertInt64(f, n)
+	case "float", "double":
+		return n.Float64()
+	}
+	if i, err := n.Int64(); err == nil {
+		return i, nil
+	}
+	if u, err := strconv.ParseUint(n.String(), 10, 64); err == nil {
+		return u, nil
+	}
+	return n.Float64()
+}
+
+// isUnsigned returns true if the source column type propagated from MySQL is unsigned, e.g. BIGINT UNSIGNED
+func isUnsigned(f kafka.Field) bool {
+	return strings.Contains(strings.ToUpper(f.Parameters[sourceColumnType]), "UNSIGNED")
+}
+
+// convertInt64 converts the int64 value, values above the signed maximum are promoted to uint64. Unsigned BIGINT
+// values sent with bigint.unsigned.handling.mode=long wrap around to negative, they are restored if the source type
+// is propagated
+func convertInt64(f kafka.Field, n json.Number) (interface{}, error) {
+	i, err := n.Int64()
+	if err != nil {
+		if u, uerr := strconv.ParseUint(n.String(), 10, 64); uerr == nil {
+			return u, nil
+		}
+		return nil, err
+	}
+	if i < 0 && isUnsigned(f) {
+		return uint64(i), nil
+	}
+	return i, nil
+}
+
+// convertUnsignedDecimal converts the unsigned integer sent as the decimal of zero scale, i.e. with
+// bigint.unsigned.handling.mode=precise, to uint64
+func convertUnsignedDecimal(v interface{}) (interface{}, error) {
+	encoded, ok := v.(string)
+	if !ok {
+		return v, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	// big-endian two's complement, so values above the signed maximum have the leading zero byte
+	n := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 || !n.IsUint64() {
+		return nil, fmt.Errorf("Invalid unsigned integer value: %q", encoded)
+	}
+	return n.Uint64(), nil
+}
+
+// checkEnum returns error if value is not listed in the allowed values of the enum field
+func checkEnum(f kafka.Field, column string, v interface{}) error {
+	allowed, ok := f.Parameters["allowed"]
+	if !ok || v == nil {
+		return nil
+	}
+	for _, a := range allowedValues(allowed) {
+		if a == v {
+			return nil
+		}
+	}
+	return fmt.Errorf("Value %v of enum column %q is not one of allowed values: %s", v, column, allowed)
+}
+
+// allowedValues splits comma separated allowed values of the enum or set field. Values containing commas are
+// single-quoted the way MySQL declares them, e.g. 'a,b','c', quotes within them are doubled
+func allowedValues(allowed string) []string {
+	var values []string
+	for len(allowed) > 0 {
+		if allowed[0] != '\'' {
+			i := strings.IndexByte(allowed, ',')
+			if i < 0 {
+				return append(values, allowed)
+			}
+			values, allowed = append(values, allowed[:i]), allowed[i+1:]
+			continue
+		}
+		var value strings.Builder
+		i := 1
+		for ; i < len(allowed); i++ {
+			if allowed[i] == '\'' {
+				if i+1 < len(allowed) && allowed[i+1] == '\'' {
+					i++
+				} else {
+					break
+				}
+			}
+			value.WriteByte(allowed[i])
+		}
+		values = append(values, value.String())
+		if i >= len(allowed) {
+			break
+		}
+		allowed = strings.TrimPrefix(allowed[i+1:], ",")
+	}
+	return values
+}
+
+// convertEnumSet splits comma separated MySQL SET value into the array elements, unless
+// the column is cast to a non-array type. Elements are matched greedily against the allowed values of the field,
+// so the ones containing commas are kept whole, the value is split at every comma if they are missing.
+// Empty set results in the empty array, not NULL
+func convertEnumSet(f kafka.Field, cast string, v interface{}) interface{} {
+	set, ok := v.(string)
+	if !ok || !strings.HasSuffix(cast, "[]") {
+		return v
+	}
+	if set == "" {
+		return []string{}
+	}
+	allowed, ok := f.Parameters["allowed"]
+	if !ok {
+		return strings.Split(set, ",")
+	}
+	values := allowedValues(allowed)
+	var elements []string
+	for set != "" {
+		element := set
+		if i := strings.IndexByte(set, ','); i >= 0 {
+			element = set[:i]
+		}
+		for _, a := range values {
+			if len(a) > len(element) && (set == a || strings.HasPrefix(set, a+",")) {
+				element = a
+			}
+		}
+		elements, set = append(elements, element), set[len(element):]
+		if set != "" {
+			set = set[1:]
+		}
+	}
+	return elements
+}
+
+// convertUUID normalises UUID representations, e.g. uppercase and braced {...} ones produced by SQL Server
+func convertUUID(v interface{}) interface{} {
+	uuid, ok := v.(string)
+	if !ok {
+		return v
+	}
+	return strings.ToLower(strings.TrimSuffix(strings.TrimPrefix(uuid, "{"), "}"))
+}
+
+// convertBits converts base64 encoded little-endian bytes to the bit string of the declared `length`.
+// Trailing zero bytes may be omitted by Debezium. BIT(1) columns are sent as booleans
+func convertBits(length string, v interface{}) (interface{}, error) {
+	switch b := v.(type) {
+	case bool:
+		if b {
+			return "1", nil
+		}
+		return "0", nil
+	case string:
+		bytes, err := base64.StdEncoding.DecodeString(b)
+		if err != nil {
+			return nil, err
+		}
+		n, err := strconv.Atoi(length)
+		if err != nil {
+			n = 8 * len(bytes)
+		}
+		bits := make([]byte, n)
+		for i := 0; i < n; i++ {
+			bits[n-1-i] = '0'
+			if i/8 < len(bytes) && bytes[i/8]&(1<<(i%8)) != 0 {
+				bits[n-1-i] = '1'
+			}
+		}
+		return string(bits), nil
+	}
+	return v, nil
+}
+
+// isBitType returns true if either the cast or the source column type is the bit string type
+func isBitType(types ...string) bool {
+	for _, t := range types {
+		t = strings.ToLower(t)
+		if t == "bit" || t == "varbit" || strings.HasPrefix(t, "bit(") || strings.HasPrefix(t, "bit varying") {
+			return true
+		}
+	}
+	return false
+}
+
+// Binary handling modes mirroring Debezium `binary.handling.mode` for binary columns sent as strings
+const (
+	BinaryHandlingBytes         = "bytes"
+	BinaryHandlingBase64        = "base64"
+	BinaryHandlingBase64URLSafe = "base64-url-safe"
+	BinaryHandlingHex           = "hex"
+)
+
+// binarySourceTypes lists source column types holding binary data
+var binarySourceTypes = map[string]bool{"bytea": true, "binary": true, "varbinary": true, "blob": true,
+	"tinyblob": true, "mediumblob": true, "longblob": true, "image": true, "raw": true}
+
+// isBinary returns true if the field holds binary data, either sent as bytes or as string encoded
+// according to binary.handling.mode of the connector
+func isBinary(f kafka.Field, cast string) bool {
+	if f.Name != "" { // logical types based on bytes, e.g. decimals
+		return false
+	}
+	return f.Type == "bytes" || cast == "bytea" || binarySourceTypes[strings.ToLower(f.Parameters[sourceColumnType])]
+}
+
+// convertBinary decodes binary value to bytes. Bytes fields are always base64 encoded by the JSON converter,
+// string ones are decoded according to the `mode`
+func convertBinary(mode string, f kafka.Field, v interface{}) (interface{}, error) {
+	s, ok := v.(string)
+	if !ok {
+		return v, nil
+	}
+	if f.Type == "bytes" {
+		return base64.StdEncoding.DecodeString(s)
+	}
+	switch mode {
+	case BinaryHandlingBase64:
+		return base64.StdEncoding.DecodeString(s)
+	case BinaryHandlingBase64URLSafe:
+		return base64.URLEncoding.DecodeString(s)
+	case BinaryHandlingHex:
+		return hex.DecodeString(s)
+	}
+	return v, nil
+}
+
+// specialNumbers maps special floating point values sent by Debezium as strings to their infinity sign
+var specialNumbers = map[string]int{"NaN": 2, "Infinity": 1, "-Infinity": -1}
+
+// convertSpecialNumber converts NaN or infinity sent for the float or numeric field to the statement parameter,
+// special numeric values are converted to NULL if `cfg.SpecialNumericAsNull` is set, e.g. for targets that don't
+// support numeric infinity. Returns false if the field is of other type
+func convertSpecialNumber(cfg Config, f kafka.Field, cast string, special string) (interface{}, bool) {
+	sourceType := strings.ToLower(f.Parameters[sourceColumnType])
+	switch {
+	case f.Name == logicalDecimal || f.Name == logicalVariableScaleDecimal ||
+		strings.HasPrefix(cast, "numeric") || strings.HasPrefix(cast, "decimal") ||
+		strings.HasPrefix(sourceType, "numeric") || strings.HasPrefix(sourceType, "decimal"):
+		if cfg.SpecialNumericAsNull {
+			return nil, true
+		}
+		return special, true
+	case f.Type == "float" || f.Type == "double":
+		if special == "NaN" {
+			return math.NaN(), true
+		}
+		return math.Inf(specialNumbers[special]), true
+	}
+	return nil, false
+}
+
+// convertMoney converts money value, either decimal or formatted string, to the numeric literal
+func convertMoney(v interface{}) (interface{}, error) {
+	var s string
+	switch m := v.(type) {
+	case nil:
+		return nil, nil
+	case float64:
+		return strconv.FormatFloat(m, 'f', -1, 64), nil
+	case int64:
+		return strconv.FormatInt(m, 10), nil
+	case string:
+		s = m
+	default:
+		s = fmt.Sprint(m)
+	}
+	// strip currency symbols and group separators, accounting notation means negative amount
+	negative := strings.HasPrefix(strings.TrimSpace(s), "(") && strings.HasSuffix(strings.TrimSpace(s), ")")
+	amount := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return -1
+	}, s)
+	if negative {
+		amount = "-" + amount
+	}
+	if _, ok := new(big.Rat).SetString(amount); !ok {
+		return nil, fmt.Errorf("Invalid money value: %q", s)
+	}
+	return amount, nil
+}
+
+// convertInet normalises IP address for the inet or cidr column: zone identifiers are stripped, as PostgreSQL
+// doesn't accept them, and host masks of inet values are dropped. Address is kept in its textual form, so
+// IPv6 addresses with embedded IPv4 notation are not converted to IPv4 ones
+func convertInet(cast string, v interface{}) (interface{}, error) {
+	s, ok := v.(string)
+	if !ok {
+		return v, nil
+	}
+	addr, mask := strings.ToLower(strings.TrimSpace(s)), ""
+	if i := strings.IndexByte(addr, '/'); i >= 0 {
+		addr, mask = addr[:i], addr[i:]
+	}
+	if i := strings.IndexByte(addr, '%'); i >= 0 {
+		addr = addr[:i]
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, fmt.Errorf("Invalid %s value: %q", cast, s)
+	}
+	if cast == "inet" && (mask == "/128" || mask == "/32" && !strings.Contains(addr, ":")) {
+		mask = ""
+	}
+	return addr + mask, nil
+}
+
+// convertUnsigned returns the text representation of the oid or transaction id, as there are no Go types
+// to bind them directly
+func convertUnsigned(cast string, v interface{}) (interface{}, error) {
+	var s string
+	switch n := v.(type) {
+	case json.Number:
+		s = n.String()
+	case string:
+		s = strings.TrimSpace(n)
+	default:
+		return v, nil
+	}
+	if _, err := strconv.ParseUint(s, 10, 64); err != nil {
+		return nil, fmt.Errorf("Invalid %s value: %q", cast, s)
+	}
+	return s, nil
+}
+
+var reLSN = regexp.MustCompile(`^[0-9A-Fa-f]{1,8}/[0-9A-Fa-f]{1,8}$`)
+
+// convertLSN returns the X/Y text representation of the log sequence number sent either as text or as a number
+func convertLSN(v interface{}) (interface{}, error) {
+	switch lsn := v.(type) {
+	case json.Number:
+		n, err := strconv.ParseUint(lsn.String(), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid pg_lsn value: %q", lsn)
+		}
+		return fmt.Sprintf("%X/%X", n>>32, uint32(n)), nil
+	case string:
+		s := strings.TrimSpace(lsn)
+		if !reLSN.MatchString(s) {
+			return nil, fmt.Errorf("Invalid pg_lsn value: %q", lsn)
+		}
+		return strings.ToUpper(s), nil
+	}
+	return v, nil
+}
+
+// CharPaddingTrim is the char padding setting stripping trailing spaces, other settings are the widths to pad to
+const CharPaddingTrim = "trim"
+
+// padChar normalises padding of the fixed-width char value according to the `padding` setting, i.e. strips
+// trailing spaces or pads it with spaces to the configured width
+func padChar(padding string, v interface{}) (interface{}, error) {
+	s, ok := v.(string)
+	if !ok {
+		return v, nil
+	}
+	if padding == CharPaddingTrim {
+		return strings.TrimRight(s, " "), nil
+	}
+	width, err := strconv.Atoi(padding)
+	if err != nil || width <= 0 {
+		return nil, fmt.Errorf("Invalid char padding %q, either %q or width expected", padding, CharPaddingTrim)
+	}
+	if n := utf8.RuneCountInString(s); n < width {
+		s += strings.Repeat(" ", width-n)
+	}
+	return s, nil
+}
+
+// convertRange returns the range literal of the value sent either as its text representation, e.g. "[1,10)" or "empty",
+// or as the struct of lower and upper bounds, their inclusiveness flags and the empty flag.
+// Missing or NULL bounds mean the range is unbounded on that side
+func convertRange(cast string, v interface{}) (interface{}, error) {
+	switch r := v.(type) {
+	case string:
+		s := strings.TrimSpace(r)
+		if strings.EqualFold(s, "empty") {
+			return "empty", nil
+		}
+		if len(s) < 3 || !strings.ContainsAny(s[:1], "[(") || !strings.ContainsAny(s[len(s)-1:], "])") ||
+			!strings.Contains(s, ",") {
+			return nil, fmt.Errorf("Invalid %s value: %q", cast, r)
+		}
+		return s, nil
+	case map[string]interface{}:
+		if empty, _ := r["empty"].(bool); empty {
+			return "empty", nil
+		}
+		lower, upper := "(", ")"
+		if inc, ok := r["lower_inc"].(bool); inc || !ok && r["lower"] != nil {
+			lower = "["
+		}
+		if inc, _ := r["upper_inc"].(bool); inc {
+			upper = "]"
+		}
+		return lower + rangeBound(r["lower"]) + "," + rangeBound(r["upper"]) + upper, nil
+	}
+	return v, nil
+}
+
+// rangeBound returns the range literal representation of the bound, empty string for unbounded side
+func rangeBound(v interface{}) string {
+	switch b := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return quoteLiteral(b)
+	}
+	return fmt.Sprint(v)
+}
+
+// convertMAC normalises MAC address of any notation accepted by PostgreSQL to the lowercase colon separated one
+func convertMAC(v interface{}) (interface{}, error) {
+	s, ok := v.(string)
+	if !ok {
+		return v, nil
+	}
+	mac, err := net.ParseMAC(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	return mac.String(), nil
+}
+
+// convertPoint converts geometry point struct to the native point or, if target uses PostGIS, to the WKB geometry
+func convertPoint(cfg Config, v interface{}, n int) (interface{}, string, error) {
+	point, ok := v.(map[string]interface{})
+	if cfg.PostGIS {
+		return convertGeometry(cfg, logicalGeometry, v, n)
+	}
+	if !ok {
+		return v, placeholder(n, "point"), nil
+	}
+	var xy [2]float64
+	for i, c := range []string{"x", "y"} {
+		switch coordinate := point[c].(type) {
+		case nil:
+			return nil, "", classify(ErrMissingField, fmt.Errorf("Point coordinate %q is missing", c))
+		case json.Number:
+			f, err := coordinate.Float64()
+			if err != nil {
+				return nil, "", fmt.Errorf("Point coordinate %q is not a number: %v", c, coordinate)
+			}
+			xy[i] = f
+		case float64:
+			xy[i] = coordinate
+		default:
+			return nil, "", fmt.Errorf("Point coordinate %q is not a number: %v", c, coordinate)
+		}
+	}
+	return fmt.Sprintf("(%s,%s)", strconv.FormatFloat(xy[0], 'g', -1, 64), strconv.FormatFloat(xy[1], 'g', -1, 64)),
+		placeholder(n, "point"), nil
+}
+
+// convertGeometry converts geometry struct to the PostGIS geometry or geography, or to the raw WKB bytes
+// if target doesn't use PostGIS. Geographies with the SRID are built as geometries and cast, the SRID is left out
+// if it's missing, so PostGIS applies its own default
+func convertGeometry(cfg Config, logical string, v interface{}, n int) (interface{}, string, error) {
+	geom, ok := v.(map[string]interface{})
+	if !cfg.PostGIS {
+		if !ok {
+			return v, placeholder(n, "bytea"), nil
+		}
+		wkb, _, err := decodeWKB(geom)
+		return wkb, placeholder(n, "bytea"), err
+	}
+	fn := "ST_GeomFromWKB"
+	if logical == logicalGeography {
+		fn = "ST_GeogFromWKB"
+	}
+	if !ok {
+		return v, fmt.Sprintf("%s($%d)", fn, n), nil
+	}
+	wkb, srid, err := decodeWKB(geom)
+	switch {
+	case err != nil:
+		return nil, "", err
+	case srid == "":
+		return wkb, fmt.Sprintf("%s($%d)", fn, n), nil
+	case logical == logicalGeography:
+		return wkb, fmt.Sprintf("ST_GeomFromWKB($%d, %s)::geography", n, srid), nil
+	}
+	return wkb, fmt.Sprintf("%s($%d, %s)", fn, n, srid), nil
+}
+
+// decodeWKB returns WKB bytes and SRID, if specified, of the geometry struct
+func decodeWKB(geom map[string]interface{}) ([]byte, string, error) {
+	encoded, ok := geom["wkb"].(string)
+	if !ok {
+		return nil, "", errors.New("Geometry WKB is missing")
+	}
+	wkb, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, "", err
+	}
+	srid, ok := geom["srid"].(json.Number)
+	if !ok {
+		return wkb, "", nil
+	}
+	if _, err := srid.Int64(); err != nil {
+		return nil, "", err
+	}
+	return wkb, srid.String(), nil
+}
+
+// convertEpoch converts number of `unit`s since epoch to the time in UTC
+func convertEpoch(v interface{}, unit time.Duration) (interface{}, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v, nil
+	}
+	i, err := n.Int64()
+	if err != nil {
+		return nil, err
+	}
+	if unit == 24*time.Hour {
+		return time.Unix(i*86400, 0).UTC(), nil
+	}
+	// split to seconds first, so dates far in the future don't overflow the duration
+	perSecond := int64(time.Second / unit)
+	return time.Unix(i/perSecond, i%perSecond*int64(unit)).UTC(), nil
+}
+
+// convertTimeOfDay converts number of `unit`s since midnight to the time literal with microsecond precision
+func convertTimeOfDay(v interface{}, unit time.Duration) (interface{}, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v, nil
+	}
+	i, err := n.Int64()
+	if err != nil {
+		return nil, err
+	}
+	d := time.Duration(i) * unit
+	literal := fmt.Sprintf("%02d:%02d:%02d", int64(d/time.Hour), int64(d/time.Minute%60), int64(d/time.Second%60))
+	if us := int64(d % time.Second / time.Microsecond); us != 0 {
+		literal += strings.TrimRight(fmt.Sprintf(".%06d", us), "0")
+	}
+	return literal, nil
+}
+
+var reISODuration = regexp.MustCompile(`^P(?:[-+]?\d+(?:\.\d+)?[YMWD])*(?:T(?:[-+]?\d+(?:\.\d+)?[HMS])+)?$`)
+
+// convertInterval converts the interval sent either as ISO 8601 duration, e.g. "P1Y2M3DT4H5M6S", or as number
+// of microseconds to the interval literal
+func convertInterval(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		if d := strings.ToUpper(strings.TrimSpace(v)); d != "P" && reISODuration.MatchString(d) {
+			return d, nil
+		}
+		return nil, fmt.Errorf("Invalid ISO 8601 duration %q", v)
+	case json.Number:
+		us, err := v.Int64()
+		if err != nil {
+			return nil, err
+		}
+		return strconv.FormatInt(us, 10) + " microseconds", nil
+	}
+	return v, nil
+}
+
+// Debezium sends infinite PostgreSQL timestamps as these epoch values, infinite dates as int32 extremes
+const (
+	positiveInfinityTimestamp = 9223372036825200000
+	negativeInfinityTimestamp = -9223372036832400000
+)
+
+// Bounds infinite temporal values are clamped to if `cfg.ClampInfinity` is set
+var (
+	maxTimestamp = time.Date(9999, 12, 31, 23, 59, 59, 999999000, time.UTC)
+	minTimestamp = time.Date(1, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// infinity returns 1 or -1 if the value of the temporal field is the positive or negative infinity, 0 otherwise
+func infinity(f kafka.Field, v interface{}) int {
+	if !strings.HasPrefix(f.Name, "io.debezium.time.") && f.Name != logicalConnectDate && f.Name != logicalConnectTimestamp {
+		return 0
+	}
+	date := f.Name == logicalDate || f.Name == logicalConnectDate
+	switch t := v.(type) {
+	case string:
+		switch strings.ToLower(t) {
+		case "infinity", "+infinity":
+			return 1
+		case "-infinity":
+			return -1
+		}
+	case json.Number:
+		i, err := t.Int64()
+		switch {
+		case err != nil:
+			return 0
+		case date && i >= math.MaxInt32, i >= positiveInfinityTimestamp:
+			return 1
+		case date && i <= math.MinInt32, i <= negativeInfinityTimestamp:
+			return -1
+		}
+	}
+	return 0
+}
+
+// convertInfinity returns infinite temporal value of the `sign`, clamped to the supported range if `cfg.ClampInfinity` is set
+func convertInfinity(cfg Config, sign int) interface{} {
+	switch {
+	case cfg.ClampInfinity && sign > 0:
+		return maxTimestamp
+	case cfg.ClampInfinity:
+		return minTimestamp
+	case sign > 0:
+		return "infinity"
+	}
+	return "-infinity"
+}
+
+// Decimal handling modes mirroring Debezium `decimal.handling.mode`, i.e. how decimal values are sent
+const (
+	DecimalHandlingPrecise = "precise" // Decimal or VariableScaleDecimal logical types encoded as bytes
+	DecimalHandlingString  = "string"  // text representation
+	DecimalHandlingDouble  = "double"  // JSON number, which may lose precision at the source
+)
+
+var reDecimal = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$`)
+
+// isDecimal returns true if the field is a decimal one, i.e. is of the decimal logical type or the column is
+// of the numeric type, either configured or propagated from the source. Money values are converted separately
+func isDecimal(f kafka.Field, cast string) bool {
+	if cast == "money" || strings.EqualFold(f.Parameters[sourceColumnType], "money") {
+		return false
+	}
+	if f.Name == logicalDecimal || f.Name == logicalVariableScaleDecimal {
+		return true
+	}
+	for _, t := range []string{cast, strings.ToLower(f.Parameters[sourceColumnType])} {
+		if strings.HasPrefix(t, "numeric") || strings.HasPrefix(t, "decimal") {
+			return true
+		}
+	}
+	return false
+}
+
+// decimalHandling returns the decimal handling mode the value is sent with, derived from the schema if present
+// and from the value otherwise
+func decimalHandling(f kafka.Field, v interface{}) string {
+	switch {
+	case f.Name == logicalDecimal || f.Name == logicalVariableScaleDecimal:
+		return DecimalHandlingPrecise
+	case f.Type == "string":
+		return DecimalHandlingString
+	case f.Type == "float" || f.Type == "double":
+		return DecimalHandlingDouble
+	}
+	switch d := v.(type) {
+	case json.Number, float64:
+		return DecimalHandlingDouble
+	case string:
+		if reDecimal.MatchString(strings.TrimSpace(d)) {
+			return DecimalHandlingString
+		}
+	}
+	return DecimalHandlingPrecise
+}
+
+// convertDecimalValue converts decimal value sent in any of the decimal handling modes to its text representation.
+// If the `mode` is set, values sent in other modes are reported as errors
+func convertDecimalValue(mode string, f kafka.Field, column string, v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	sent := decimalHandling(f, v)
+	if mode > "" && mode != sent {
+		return nil, fmt.Errorf("Decimal value of column %q is sent in %s mode, but %s mode is expected; "+
+			"check decimal.handling.mode of the connector", column, sent, mode)
+	}
+	switch d := v.(type) {
+	case map[string]interface{}: // VariableScaleDecimal
+		return convertDecimal(fmt.Sprint(d["scale"]), d["value"])
+	case json.Number:
+		return plainDecimal(d.String())
+	case float64:
+		return strconv.FormatFloat(d, 'f', -1, 64), nil
+	case string:
+		if sent == DecimalHandlingString {
+			if !reDecimal.MatchString(strings.TrimSpace(d)) {
+				return nil, fmt.Errorf("Invalid decimal value of column %q: %q", column, d)
+			}
+			return plainDecimal(strings.TrimSpace(d))
+		}
+		if f.Name != logicalDecimal {
+			return nil, fmt.Errorf("Cannot decode decimal value of column %q without its scale in the schema", column)
+		}
+		if isUnsigned(f) && f.Parameters["scale"] == "0" {
+			return convertUnsignedDecimal(d)
+		}
+		return convertDecimal(f.Parameters["scale"], d)
+	}
+	return v, nil
+}
+
+// maxDecimalExponent limits the exponent of decimals in scientific notation to the digits numeric may hold
+const maxDecimalExponent = 131072
+
+// plainDecimal returns the decimal literal in positional notation, e.g. 12000000.55 for 1.200000055E7, so the value
+// is stored exactly whatever the text input of the target type is
+func plainDecimal(s string) (string, error) {
+	i := strings.IndexAny(s, "eE")
+	if i < 0 {
+		return s, nil
+	}
+	exp, err := strconv.Atoi(s[i+1:])
+	if err != nil || exp > maxDecimalExponent || exp < -maxDecimalExponent {
+		return "", fmt.Errorf("Invalid decimal exponent: %q", s)
+	}
+	mantissa, sign := s[:i], ""
+	if strings.HasPrefix(mantissa, "-") || strings.HasPrefix(mantissa, "+") {
+		if mantissa[0] == '-' {
+			sign = "-"
+		}
+		mantissa = mantissa[1:]
+	}
+	whole, frac := mantissa, ""
+	if j := strings.IndexByte(mantissa, '.'); j >= 0 {
+		whole, frac = mantissa[:j], mantissa[j+1:]
+	}
+	digits, point := whole+frac, len(whole)+exp
+	switch {
+	case point < 0:
+		digits, point = strings.Repeat("0", -point)+digits, 0
+	case point > len(digits):
+		digits += strings.Repeat("0", point-len(digits))
+	}
+	whole, frac = strings.TrimLeft(digits[:point], "0"), strings.TrimRight(digits[point:], "0")
+	if whole == "" {
+		whole = "0"
+	}
+	if frac == "" {
+		return sign + whole, nil
+	}
+	return sign + whole + "." + frac, nil
+}
+
+// convertDecimal converts base64 encoded unscaled value of the decimal with `scale` to the exact numeric string.
+// Values not encoded as bytes, e.g. with decimal.handling.mode=string, are returned as is
+func convertDecimal(scale string, v interface{}) (interface{}, error) {
+	encoded, ok := v.(string)
+	if !ok {
+		return v, nil
+	}
+	s, err := strconv.Atoi(scale)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid decimal scale %q: %w", scale, err)
+	}
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	// big-endian two's complement
+	unscaled := new(big.Int).SetBytes(b)
+	if len(b) > 0 && b[0]&0x80 != 0 {
+		unscaled.Sub(unscaled, new(big.Int).Lsh(big.NewInt(1), uint(len(b)*8)))
+	}
+	denom := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(s)), nil)
+	return new(big.Rat).SetFrac(unscaled, denom).FloatString(s), nil
+}
+
+// convertArray converts array elements according to the items schema and returns the PostgreSQL array literal,
+// so the value is accepted by any array type of the target column
+func convertArray(f kafka.Field, column string, v interface{}) (interface{}, error) {
+	elems, ok := v.([]interface{})
+	if !ok {
+		return v, nil
+	}
+	return arrayLiteral(f, column, elems)
+}
+
+func arrayLiteral(f kafka.Field, column string, elems []interface{}) (string, error) {
+	var items kafka.Field
+	if f.Items != nil {
+		items = *f.Items
+	}
+	literal := make([]string, 0, len(elems))
+	for _, e := range elems {
+		if nested, ok := e.([]interface{}); ok && items.Type == "array" {
+			l, err := arrayLiteral(items, column, nested)
+			if err != nil {
+				return "", err
+			}
+			literal = append(literal, l)
+			continue
+		}
+		v, err := convertValue(items, column, "", e)
+		if err != nil {
+			return "", err
+		}
+		literal = append(literal, arrayElement(v))
+	}
+	return "{" + strings.Join(literal, ",") + "}", nil
+}
+
+// convertMap converts map to the hstore literal if column is cast to hstore, or to JSON if it's cast to json or jsonb
+func convertMap(cast string, v interface{}) (interface{}, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return v, nil
+	}
+	switch cast {
+	case "json", "jsonb":
+		b, err := json.Marshal(m)
+		return string(b), err
+	case "hstore":
+		return hstoreLiteral(m), nil
+	}
+	return v, nil
+}
+
+// convertStruct converts the struct to the composite type literal with attributes in the order declared by the schema,
+// which is the order of the source composite type. A NULL struct stays NULL, while NULL attributes are left empty
+func convertStruct(f kafka.Field, column string, v interface{}) (interface{}, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return v, nil
+	}
+	return compositeLiteral(f, column, m)
+}
+
+func compositeLiteral(f kafka.Field, column string, m map[string]interface{}) (string, error) {
+	attrs := make([]string, 0, len(f.Fields))
+	for _, attr := range f.Fields {
+		e := m[attr.Attribute]
+		if e == nil {
+			attrs = append(attrs, "")
+			continue
+		}
+		// nested structs and arrays are converted to literals quoted as a whole
+		e, err := convertValue(attr, column, "", e)
+		if err != nil {
+			return "", err
+		}
+		attrs = append(attrs, arrayElement(e))
+	}
+	return "(" + strings.Join(attrs, ",") + ")", nil
+}
+
+// hstoreLiteral returns the hstore representation of the map, keys are sorted to produce stable output
+func hstoreLiteral(m map[string]interface{}) string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(m))
+	for _, k := range keys {
+		v := "NULL"
+		if m[k] != nil {
+			v = quoteLiteral(fmt.Sprint(m[k]))
+		}
+		pairs = append(pairs, quoteLiteral(k)+"=>"+v)
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// arrayElement returns the array literal representation of the element
+func arrayElement(v interface{}) string {
+	var s string
+	switch e := v.(type) {
+	case nil:
+		return "NULL"
+	case int64, float64:
+		return fmt.Sprint(e)
+	case bool:
+		return strconv.FormatBool(e)
+	case time.Time:
+		s = e.Format("2006-01-02 15:04:05.999999999")
+	default:
+		s = fmt.Sprint(e)
+	}
+	return quoteLiteral(s)
+}
+
+// quoteLiteral double quotes the array element, composite attribute or hstore key or value escaping backslashes and quotes
+func quoteLiteral(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
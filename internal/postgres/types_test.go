@@ -0,0 +1,1270 @@
+package postgres
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	kafkago "github.com/segmentio/kafka-go"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlaceholder(t *testing.T) {
+	assert.Equal(t, "$1", placeholder(1, ""))
+	assert.Equal(t, "$2::jsonb", placeholder(2, "jsonb"))
+}
+
+func TestCastFor(t *testing.T) {
+	msg := kafka.Message{
+		SchemaName: "public",
+		TableName:  "orders",
+		Fields: map[string]kafka.Field{
+			"doc":    {Type: "string", Name: logicalJSON},
+			"body":   {Type: "string", Name: logicalXML},
+			"status": {Type: "string", Name: logicalEnum},
+		},
+	}
+	assert.Equal(t, "", castFor(Config{}, msg, "id"))
+	assert.Equal(t, "jsonb", castFor(Config{}, msg, "doc"))
+	assert.Equal(t, "xml", castFor(Config{}, msg, "body"))
+	assert.Equal(t, "", castFor(Config{}, msg, "status"), "enum type name is unknown")
+	cfg := Config{ColumnTypes: map[string]string{"orders.status": "order_status", "public.orders.doc": "json"}}
+	assert.Equal(t, "order_status", castFor(cfg, msg, "status"))
+	assert.Equal(t, "json", castFor(cfg, msg, "doc"), "configured type overrides logical one")
+	msg.Fields["status"] = kafka.Field{Type: "string", Name: logicalEnum, Parameters: map[string]string{sourceColumnType: "order_status"}}
+	assert.Equal(t, "order_status", castFor(Config{}, msg, "status"), "enum type propagated from the source")
+}
+
+func TestConfiguredCasts(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestConfiguredCasts")
+	var sql string
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql = s
+			return pgconn.CommandTag("UPDATE 1"), nil
+		},
+	}
+	msg := kafka.Message{
+		SchemaName: "public",
+		TableName:  "orders",
+		Keys:       map[string]interface{}{"code": "A-1"},
+		Values:     map[string]interface{}{"status": "new"},
+	}
+	cfg := Config{ColumnTypes: map[string]string{"orders.status": "order_status", "public.orders.code": "order_code"}}
+	_, err := updateCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `UPDATE "public"."orders" SET ("status")=($2::order_status) WHERE ("code")=($1::order_code)`, sql)
+	_, err = deleteCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `DELETE FROM "public"."orders" WHERE ("code")=($1::order_code)`, sql, "domain typed key")
+}
+
+func TestEnumFields(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestEnumFields")
+	var sql string
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql = s
+			return pgconn.CommandTag("INSERT 0 1"), nil
+		},
+	}
+	msg := kafka.Message{
+		TableName: "orders",
+		Values:    map[string]interface{}{"status": "shipped"},
+		Fields: map[string]kafka.Field{"status": {
+			Type:       "string",
+			Name:       logicalEnum,
+			Parameters: map[string]string{"allowed": "new,shipped,delivered"},
+		}},
+	}
+	cfg := Config{ColumnTypes: map[string]string{"orders.status": "order_status"}}
+	_, err := insertCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "orders"("status") VALUES ($1::order_status)`, sql)
+
+	msg.Values["status"] = nil
+	_, err = insertCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err, "NULL is always allowed")
+
+	msg.Values["status"] = "lost"
+	_, err = insertCDCItem(context.Background(), conn, cfg, msg)
+	assert.EqualError(t, err, `Value lost of enum column "status" is not one of allowed values: new,shipped,delivered`)
+}
+
+func TestJSONFields(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestJSONFields")
+	var (
+		sql  string
+		args []interface{}
+	)
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql, args = s, a
+			return pgconn.CommandTag("INSERT 0 1"), nil
+		},
+	}
+	msg := kafka.Message{
+		TableName: "docs",
+		Keys:      map[string]interface{}{"id": 1},
+		Values:    map[string]interface{}{"doc": `{"a": {"b": [1, 2, {"c": null}]}}`},
+		Fields:    map[string]kafka.Field{"doc": {Type: "string", Name: logicalJSON, Optional: true}},
+	}
+	_, err := insertCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "docs"("doc") VALUES ($1::jsonb)`, sql)
+	assert.Equal(t, []interface{}{`{"a": {"b": [1, 2, {"c": null}]}}`}, args, "nested document passed unchanged")
+
+	msg.Values["doc"] = "null"
+	_, err = updateCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `UPDATE "docs" SET ("doc")=($2::jsonb) WHERE ("id")=($1)`, sql)
+	assert.Equal(t, []interface{}{1, "null"}, args, "JSON null literal")
+
+	msg.Values["doc"] = nil
+	_, err = updateCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{1, nil}, args, "SQL NULL")
+}
+
+func TestXMLFields(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestXMLFields")
+	var (
+		sql  string
+		args []interface{}
+	)
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql, args = s, a
+			return pgconn.CommandTag("INSERT 0 1"), nil
+		},
+	}
+	doc := `<?xml version="1.0"?><!DOCTYPE note SYSTEM "note.dtd"><n:note xmlns:n="urn:example:note"><n:to>Tove</n:to></n:note>`
+	msg := kafka.Message{
+		TableName: "notes",
+		Values:    map[string]interface{}{"body": doc},
+		Fields:    map[string]kafka.Field{"body": {Type: "string", Name: logicalXML}},
+	}
+	_, err := insertCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "notes"("body") VALUES ($1::xml)`, sql)
+	assert.Equal(t, []interface{}{doc}, args, "document with namespaces passed unchanged")
+}
+
+func TestConvertNumber(t *testing.T) {
+	v, err := convertValue(kafka.Field{Type: "int64"}, "id", "", json.Number("9007199254740993"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(9007199254740993), v, "bigint round-trips exactly")
+
+	v, err = convertValue(kafka.Field{Type: "double"}, "price", "", json.Number("12.5"))
+	assert.NoError(t, err)
+	assert.Equal(t, 12.5, v)
+
+	v, err = convertValue(kafka.Field{Type: "float"}, "ratio", "", json.Number("42"))
+	assert.NoError(t, err)
+	assert.Equal(t, 42.0, v, "whole float values stay floats")
+
+	v, err = convertValue(kafka.Field{}, "unknown", "", json.Number("42"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), v)
+
+	v, err = convertValue(kafka.Field{}, "unknown", "", json.Number("4.2"))
+	assert.NoError(t, err)
+	assert.Equal(t, 4.2, v)
+
+	_, err = convertValue(kafka.Field{Type: "int32"}, "id", "", json.Number("4.2"))
+	assert.Error(t, err, "not an integer")
+}
+
+func TestEnumSetFields(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestEnumSetFields")
+	var (
+		sql  string
+		args []interface{}
+	)
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql, args = s, a
+			return pgconn.CommandTag("INSERT 0 1"), nil
+		},
+	}
+	msg := kafka.Message{
+		TableName: "posts",
+		Values:    map[string]interface{}{"tags": "news,sports"},
+		Fields: map[string]kafka.Field{"tags": {
+			Type:       "string",
+			Name:       logicalEnumSet,
+			Parameters: map[string]string{"allowed": "news,sports,weather"},
+		}},
+	}
+	_, err := insertCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "posts"("tags") VALUES ($1::text[])`, sql)
+	assert.Equal(t, []interface{}{[]string{"news", "sports"}}, args)
+
+	msg.Values["tags"] = ""
+	_, err = insertCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{[]string{}}, args, "empty set")
+
+	msg.Values["tags"] = nil
+	_, err = insertCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{nil}, args, "NULL")
+
+	msg.Values["tags"] = "news,sports"
+	_, err = insertCDCItem(context.Background(), conn, Config{ColumnTypes: map[string]string{"posts.tags": "text"}}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "posts"("tags") VALUES ($1::text)`, sql)
+	assert.Equal(t, []interface{}{"news,sports"}, args, "raw string kept")
+
+	// elements containing commas are told by the allowed values
+	msg.Fields["tags"].Parameters["allowed"] = "'news','sports, local','it''s'"
+	msg.Values["tags"] = "news,sports, local,it's"
+	_, err = insertCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{[]string{"news", "sports, local", "it's"}}, args)
+
+	delete(msg.Fields["tags"].Parameters, "allowed")
+	_, err = insertCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{[]string{"news", "sports", " local", "it's"}}, args, "split at every comma")
+}
+
+func TestAllowedValues(t *testing.T) {
+	assert.Equal(t, []string{"a", "b", "c"}, allowedValues("a,b,c"))
+	assert.Equal(t, []string{"a,b", "c", "it's", ""}, allowedValues("'a,b',c,'it''s',''"))
+	assert.Nil(t, allowedValues(""))
+}
+
+func TestUUIDFields(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestUUIDFields")
+	var (
+		sql  string
+		args []interface{}
+	)
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql, args = s, a
+			return pgconn.CommandTag("DELETE 1"), nil
+		},
+	}
+	msg := kafka.Message{
+		TableName: "devices",
+		Keys:      map[string]interface{}{"id": "{6F9619FF-8B86-D011-B42D-00C04FC964FF}"},
+		Fields:    map[string]kafka.Field{"id": {Type: "string", Name: logicalUUID}},
+	}
+	_, err := deleteCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `DELETE FROM "devices" WHERE ("id")=($1::uuid)`, sql)
+	assert.Equal(t, []interface{}{"6f9619ff-8b86-d011-b42d-00c04fc964ff"}, args)
+
+	assert.Equal(t, "6f9619ff-8b86-d011-b42d-00c04fc964ff", convertUUID("6f9619ff-8b86-d011-b42d-00c04fc964ff"))
+	assert.Nil(t, convertUUID(nil))
+}
+
+func TestPointFields(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestPointFields")
+	var (
+		sql  string
+		args []interface{}
+	)
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql, args = s, a
+			return pgconn.CommandTag("INSERT 0 1"), nil
+		},
+	}
+	// POINT(1 2) in WKB
+	wkb := []byte{1, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 240, 63, 0, 0, 0, 0, 0, 0, 0, 64}
+	msg := kafka.Message{
+		TableName: "places",
+		Values: map[string]interface{}{"location": map[string]interface{}{
+			"x":    json.Number("1"),
+			"y":    json.Number("2"),
+			"wkb":  "AQEAAAAAAAAAAADwPwAAAAAAAAAA",
+			"srid": json.Number("4326"),
+		}},
+		Fields: map[string]kafka.Field{"location": {Type: "struct", Name: logicalPoint}},
+	}
+	_, err := insertCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "places"("location") VALUES ($1::point)`, sql)
+	assert.Equal(t, []interface{}{"(1,2)"}, args)
+
+	delete(msg.Values["location"].(map[string]interface{}), "y")
+	_, err = insertCDCItem(context.Background(), conn, Config{}, msg)
+	assert.True(t, errors.Is(err, ErrMissingField), "missing coordinate")
+	msg.Values["location"].(map[string]interface{})["y"] = "north"
+	_, err = insertCDCItem(context.Background(), conn, Config{}, msg)
+	assert.True(t, errors.Is(err, ErrPayloadDecode), "coordinate not a number")
+	msg.Values["location"].(map[string]interface{})["y"] = json.Number("2.5")
+	_, err = insertCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"(1,2.5)"}, args)
+
+	msg.Values["location"].(map[string]interface{})["wkb"] = base64.StdEncoding.EncodeToString(wkb)
+	_, err = insertCDCItem(context.Background(), conn, Config{PostGIS: true}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "places"("location") VALUES (ST_GeomFromWKB($1, 4326))`, sql)
+	assert.Equal(t, []interface{}{wkb}, args)
+
+	delete(msg.Values["location"].(map[string]interface{}), "srid")
+	_, err = insertCDCItem(context.Background(), conn, Config{PostGIS: true}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "places"("location") VALUES (ST_GeomFromWKB($1))`, sql)
+
+	msg.Values["location"].(map[string]interface{})["wkb"] = "not base64!"
+	_, err = insertCDCItem(context.Background(), conn, Config{PostGIS: true}, msg)
+	assert.Error(t, err)
+
+	msg.Values["location"] = nil
+	_, err = insertCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{nil}, args, "NULL point")
+}
+
+func TestGeometryFields(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestGeometryFields")
+	var (
+		sql  string
+		args []interface{}
+	)
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql, args = s, a
+			return pgconn.CommandTag("INSERT 0 1"), nil
+		},
+	}
+	// POLYGON((0 0,1 0,1 1,0 0))
+	polygon := []byte{1, 3, 0, 0, 0, 1, 0, 0, 0, 4, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 240, 63, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 240, 63, 0, 0, 0, 0, 0, 0, 240, 63,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	// MULTILINESTRING((0 0,1 1))
+	multilinestring := []byte{1, 5, 0, 0, 0, 1, 0, 0, 0,
+		1, 2, 0, 0, 0, 2, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 240, 63, 0, 0, 0, 0, 0, 0, 240, 63}
+	msg := kafka.Message{
+		TableName: "areas",
+		Values: map[string]interface{}{"area": map[string]interface{}{
+			"wkb":  base64.StdEncoding.EncodeToString(polygon),
+			"srid": json.Number("3857"),
+		}},
+		Fields: map[string]kafka.Field{"area": {Type: "struct", Name: logicalGeometry}},
+	}
+	_, err := insertCDCItem(context.Background(), conn, Config{PostGIS: true}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "areas"("area") VALUES (ST_GeomFromWKB($1, 3857))`, sql)
+	assert.Equal(t, []interface{}{polygon}, args)
+
+	_, err = insertCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "areas"("area") VALUES ($1::bytea)`, sql, "raw WKB without PostGIS")
+	assert.Equal(t, []interface{}{polygon}, args)
+
+	msg.Values["area"] = map[string]interface{}{
+		"wkb":  base64.StdEncoding.EncodeToString(multilinestring),
+		"srid": nil,
+	}
+	_, err = insertCDCItem(context.Background(), conn, Config{PostGIS: true}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "areas"("area") VALUES (ST_GeomFromWKB($1))`, sql, "default SRID")
+	assert.Equal(t, []interface{}{multilinestring}, args)
+
+	msg.Fields["area"] = kafka.Field{Type: "struct", Name: logicalGeography}
+	_, err = insertCDCItem(context.Background(), conn, Config{PostGIS: true}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "areas"("area") VALUES (ST_GeogFromWKB($1))`, sql)
+
+	msg.Values["area"].(map[string]interface{})["srid"] = json.Number("4269")
+	_, err = insertCDCItem(context.Background(), conn, Config{PostGIS: true}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "areas"("area") VALUES (ST_GeomFromWKB($1, 4269)::geography)`, sql)
+
+	msg.Values["area"] = nil
+	_, err = insertCDCItem(context.Background(), conn, Config{PostGIS: true}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{nil}, args)
+
+	msg.Values["area"] = map[string]interface{}{"wkb": "AQ==", "srid": json.Number("4.5")}
+	_, err = insertCDCItem(context.Background(), conn, Config{PostGIS: true}, msg)
+	assert.Error(t, err, "SRID must be integer")
+}
+
+func TestTemporalAndDecimalValues(t *testing.T) {
+	v, err := convertValue(kafka.Field{Type: "int32", Name: logicalDate}, "d", "", json.Number("18628"))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), v)
+
+	v, err = convertValue(kafka.Field{Type: "int64", Name: logicalTimestamp}, "ts", "", json.Number("1609459200123"))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2021, 1, 1, 0, 0, 0, 123000000, time.UTC), v)
+
+	v, err = convertValue(kafka.Field{Type: "int64", Name: logicalMicroTimestamp}, "ts", "", json.Number("1609459200123456"))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2021, 1, 1, 0, 0, 0, 123456000, time.UTC), v)
+
+	v, err = convertValue(kafka.Field{Type: "int64", Name: logicalNanoTimestamp}, "ts", "", json.Number("1609459200123456789"))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2021, 1, 1, 0, 0, 0, 123456789, time.UTC), v)
+
+	_, err = convertValue(kafka.Field{Type: "int64", Name: logicalTimestamp}, "ts", "", json.Number("1.5"))
+	assert.Error(t, err)
+
+	v, err = convertValue(kafka.Field{Type: "int32", Name: logicalDate}, "d", "", nil)
+	assert.NoError(t, err)
+	assert.Nil(t, v)
+
+	// 1234 and -1234 unscaled
+	decimal := kafka.Field{Type: "bytes", Name: logicalDecimal, Parameters: map[string]string{"scale": "2"}}
+	v, err = convertValue(decimal, "price", "", base64.StdEncoding.EncodeToString([]byte{0x04, 0xd2}))
+	assert.NoError(t, err)
+	assert.Equal(t, "12.34", v)
+	v, err = convertValue(decimal, "price", "", base64.StdEncoding.EncodeToString([]byte{0xfb, 0x2e}))
+	assert.NoError(t, err)
+	assert.Equal(t, "-12.34", v)
+	_, err = convertValue(kafka.Field{Type: "bytes", Name: logicalDecimal}, "price", "", "BNI=")
+	assert.Error(t, err, "scale is missing")
+
+	v, err = convertValue(kafka.Field{Type: "struct", Name: logicalVariableScaleDecimal}, "n", "",
+		map[string]interface{}{"scale": json.Number("3"), "value": base64.StdEncoding.EncodeToString([]byte{0x04, 0xd2})})
+	assert.NoError(t, err)
+	assert.Equal(t, "1.234", v)
+}
+
+func TestArrayFields(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestArrayFields")
+	var (
+		sql  string
+		args []interface{}
+	)
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql, args = s, a
+			return pgconn.CommandTag("INSERT 0 1"), nil
+		},
+	}
+	msg := kafka.Message{
+		TableName: "arrays",
+		Values: map[string]interface{}{
+			"ints":    []interface{}{json.Number("1"), nil, json.Number("3")},
+			"texts":   []interface{}{"a,b", `say "hi"`, `back\slash`, nil},
+			"prices":  []interface{}{base64.StdEncoding.EncodeToString([]byte{0x04, 0xd2})},
+			"moments": []interface{}{json.Number("1609459200123456")},
+			"matrix":  []interface{}{[]interface{}{json.Number("1"), json.Number("2")}, []interface{}{json.Number("3"), nil}},
+			"empty":   []interface{}{},
+			"missing": nil,
+		},
+		Fields: map[string]kafka.Field{
+			"ints":    {Type: "array", Items: &kafka.Field{Type: "int32", Optional: true}},
+			"texts":   {Type: "array", Items: &kafka.Field{Type: "string", Optional: true}},
+			"prices":  {Type: "array", Items: &kafka.Field{Type: "bytes", Name: logicalDecimal, Parameters: map[string]string{"scale": "2"}}},
+			"moments": {Type: "array", Items: &kafka.Field{Type: "int64", Name: logicalMicroTimestamp}},
+			"matrix":  {Type: "array", Items: &kafka.Field{Type: "array", Items: &kafka.Field{Type: "int32", Optional: true}}},
+			"empty":   {Type: "array", Items: &kafka.Field{Type: "int32"}},
+			"missing": {Type: "array", Items: &kafka.Field{Type: "int32"}},
+		},
+	}
+	for column, expected := range map[string]interface{}{
+		"ints":    "{1,NULL,3}",
+		"texts":   `{"a,b","say \"hi\"","back\\slash",NULL}`,
+		"prices":  `{"12.34"}`,
+		"moments": `{"2021-01-01 00:00:00.123456"}`,
+		"matrix":  "{{1,2},{3,NULL}}",
+		"empty":   "{}",
+		"missing": nil,
+	} {
+		m := msg
+		m.Values = map[string]interface{}{column: msg.Values[column]}
+		_, err := insertCDCItem(context.Background(), conn, Config{}, m)
+		assert.NoError(t, err)
+		assert.Equal(t, `INSERT INTO "arrays"("`+column+`") VALUES ($1)`, sql)
+		assert.Equal(t, []interface{}{expected}, args, column)
+	}
+}
+
+func TestMapFields(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestMapFields")
+	var (
+		sql  string
+		args []interface{}
+	)
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql, args = s, a
+			return pgconn.CommandTag("INSERT 0 1"), nil
+		},
+	}
+	msg := kafka.Message{
+		TableName: "products",
+		Values: map[string]interface{}{"attrs": map[string]interface{}{
+			"color":      "red",
+			"a=>b":       `say "hi"`,
+			`back\slash`: "1",
+			"size":       nil,
+		}},
+		Fields: map[string]kafka.Field{"attrs": {Type: "map"}},
+	}
+	_, err := insertCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "products"("attrs") VALUES ($1::hstore)`, sql)
+	assert.Equal(t, []interface{}{`"a=>b"=>"say \"hi\"", "back\\slash"=>"1", "color"=>"red", "size"=>NULL`}, args)
+
+	_, err = insertCDCItem(context.Background(), conn, Config{ColumnTypes: map[string]string{"products.attrs": "jsonb"}}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{`{"a=\u003eb":"say \"hi\"","back\\slash":"1","color":"red","size":null}`}, args)
+
+	msg.Values["attrs"] = nil
+	_, err = insertCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{nil}, args)
+}
+
+func TestNetworkFields(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestNetworkFields")
+	for _, c := range []struct{ cast, value, expected string }{
+		{"inet", "192.0.2.1", "192.0.2.1"},
+		{"inet", "192.0.2.1/32", "192.0.2.1"},
+		{"inet", "192.0.2.0/24", "192.0.2.0/24"},
+		{"inet", "FE80::1%eth0", "fe80::1"},
+		{"inet", "fe80::1%eth0/64", "fe80::1/64"},
+		{"inet", "::FFFF:192.0.2.1", "::ffff:192.0.2.1"},
+		{"inet", "64:ff9b::192.0.2.33/128", "64:ff9b::192.0.2.33"},
+		{"inet", "::ffff:192.0.2.1/32", "::ffff:192.0.2.1/32"},
+		{"cidr", "10.0.0.0/32", "10.0.0.0/32"},
+		{"cidr", "2001:DB8::/32", "2001:db8::/32"},
+		{"macaddr", "08-00-2B-01-02-03", "08:00:2b:01:02:03"},
+		{"macaddr", "0800.2b01.0203", "08:00:2b:01:02:03"},
+		{"macaddr8", "08:00:2B:01:02:03:04:05", "08:00:2b:01:02:03:04:05"},
+	} {
+		v, err := convertValue(kafka.Field{Type: "string"}, "addr", c.cast, c.value)
+		assert.NoError(t, err, c.value)
+		assert.Equal(t, c.expected, v, c.value)
+	}
+	_, err := convertValue(kafka.Field{Type: "string"}, "addr", "inet", "192.0.2.256")
+	assert.Error(t, err)
+	_, err = convertValue(kafka.Field{Type: "string"}, "addr", "macaddr", "08:00:2b")
+	assert.Error(t, err)
+	v, err := convertValue(kafka.Field{Type: "string", Optional: true}, "addr", "inet", nil)
+	assert.NoError(t, err)
+	assert.Nil(t, v)
+
+	var (
+		sql  string
+		args []interface{}
+	)
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql, args = s, a
+			return pgconn.CommandTag("DELETE 1"), nil
+		},
+	}
+	msg := kafka.Message{
+		TableName: "hosts",
+		Keys:      map[string]interface{}{"ip": "::FFFF:192.0.2.1%1"},
+		Fields: map[string]kafka.Field{"ip": {
+			Type:       "string",
+			Parameters: map[string]string{sourceColumnType: "INET"},
+		}},
+	}
+	_, err = deleteCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `DELETE FROM "hosts" WHERE ("ip")=($1::inet)`, sql)
+	assert.Equal(t, []interface{}{"::ffff:192.0.2.1"}, args)
+}
+
+func TestBitsFields(t *testing.T) {
+	bits := func(length string) kafka.Field {
+		return kafka.Field{Type: "bytes", Name: logicalBits, Parameters: map[string]string{"length": length}}
+	}
+	for _, c := range []struct {
+		length   string
+		value    interface{}
+		expected interface{}
+	}{
+		{"1", true, "1"},
+		{"1", false, "0"},
+		{"1", base64.StdEncoding.EncodeToString([]byte{0x01}), "1"},
+		{"4", base64.StdEncoding.EncodeToString([]byte{0x0a}), "1010"},
+		{"8", base64.StdEncoding.EncodeToString([]byte{0xa5}), "10100101"},
+		{"10", base64.StdEncoding.EncodeToString([]byte{0x01, 0x02}), "1000000001"},
+		{"12", base64.StdEncoding.EncodeToString([]byte{0x05}), "000000000101"},
+		{"3", "", "000"},
+		{"", base64.StdEncoding.EncodeToString([]byte{0x80}), "10000000"},
+		{"8", nil, nil},
+	} {
+		v, err := convertValue(bits(c.length), "flags", "", c.value)
+		assert.NoError(t, err)
+		assert.Equal(t, c.expected, v, "%s %v", c.length, c.value)
+	}
+	_, err := convertValue(bits("8"), "flags", "", "not base64!")
+	assert.Error(t, err)
+
+	// BIT(1) columns are described as plain booleans
+	v, err := convertValue(kafka.Field{Type: "boolean", Parameters: map[string]string{sourceColumnType: "BIT"}}, "flag", "", true)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", v)
+	v, err = convertValue(kafka.Field{Type: "boolean"}, "flag", "bit(1)", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "0", v)
+	v, err = convertValue(kafka.Field{Type: "boolean"}, "flag", "", true)
+	assert.NoError(t, err)
+	assert.Equal(t, true, v)
+}
+
+func TestMoneyFields(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestMoneyFields")
+	for _, c := range []struct {
+		value    interface{}
+		expected interface{}
+	}{
+		{"1234.56", "1234.56"},
+		{"$1,234.56", "1234.56"},
+		{"-$0.99", "-0.99"},
+		{"($12.00)", "-12.00"},
+		{json.Number("12.5"), "12.5"},
+		{nil, nil},
+	} {
+		v, err := convertMoney(c.value)
+		assert.NoError(t, err)
+		assert.Equal(t, c.expected, v, c.value)
+	}
+	_, err := convertMoney("n/a")
+	assert.Error(t, err)
+
+	var (
+		sql  string
+		args []interface{}
+	)
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql, args = s, a
+			return pgconn.CommandTag("DELETE 1"), nil
+		},
+	}
+	msg := kafka.Message{
+		TableName: "prices",
+		Before:    map[string]interface{}{"amount": base64.StdEncoding.EncodeToString([]byte{0x30, 0x39})},
+		Fields: map[string]kafka.Field{"amount": {
+			Type:       "bytes",
+			Name:       logicalDecimal,
+			Parameters: map[string]string{"scale": "2", sourceColumnType: "MONEY"},
+		}},
+	}
+	_, err = deleteCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `DELETE FROM "prices" WHERE ("amount")=($1::numeric::money)`, sql)
+	assert.Equal(t, []interface{}{"123.45"}, args)
+
+	msg.Before["amount"] = "$123.45"
+	msg.Fields["amount"] = kafka.Field{Type: "string", Parameters: map[string]string{sourceColumnType: "MONEY"}}
+	_, err = deleteCDCItem(context.Background(), conn, Config{ColumnTypes: map[string]string{"prices.amount": "numeric"}}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `DELETE FROM "prices" WHERE ("amount")=($1::numeric)`, sql, "money column migrated to numeric")
+	assert.Equal(t, []interface{}{"123.45"}, args)
+
+	msg.Before["amount"] = 123.45
+	msg.Fields["amount"] = kafka.Field{Type: "double"}
+	_, err = deleteCDCItem(context.Background(), conn, Config{ColumnTypes: map[string]string{"prices.amount": "money"}}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `DELETE FROM "prices" WHERE ("amount")=($1::numeric::money)`, sql)
+	assert.Equal(t, []interface{}{"123.45"}, args)
+}
+
+func TestSpecialNumbers(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestSpecialNumbers")
+	msg := kafka.Message{
+		TableName: "measures",
+		Fields: map[string]kafka.Field{
+			"f": {Type: "double"},
+			"n": {Type: "bytes", Name: logicalDecimal, Parameters: map[string]string{"scale": "2"}},
+			"s": {Type: "string"},
+		},
+	}
+	arg, ref, err := bindValue(Config{}, msg, "f", "NaN", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "$1", ref)
+	assert.True(t, math.IsNaN(arg.(float64)))
+	arg, _, _ = bindValue(Config{}, msg, "f", "-Infinity", 1)
+	assert.True(t, math.IsInf(arg.(float64), -1))
+	arg, _, err = bindValue(Config{}, msg, "n", "Infinity", 1)
+	assert.NoError(t, err, "decimal is not decoded")
+	assert.Equal(t, "Infinity", arg)
+	arg, _, _ = bindValue(Config{SpecialNumericAsNull: true}, msg, "n", "NaN", 1)
+	assert.Nil(t, arg)
+	arg, _, _ = bindValue(Config{SpecialNumericAsNull: true}, msg, "f", "NaN", 1)
+	assert.True(t, math.IsNaN(arg.(float64)), "float values are kept")
+	arg, _, _ = bindValue(Config{}, msg, "s", "NaN", 1)
+	assert.Equal(t, "NaN", arg, "text values are kept")
+
+	var (
+		sql  string
+		args []interface{}
+	)
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql, args = s, a
+			return pgconn.CommandTag("DELETE 1"), nil
+		},
+	}
+	msg.Keys = map[string]interface{}{"n": "NaN"}
+	_, err = deleteCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `DELETE FROM "measures" WHERE ("n")=($1)`, sql)
+	assert.Equal(t, []interface{}{"NaN"}, args)
+	_, err = deleteCDCItem(context.Background(), conn, Config{SpecialNumericAsNull: true}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `DELETE FROM "measures" WHERE ("n") IS NOT DISTINCT FROM ($1)`, sql, "NaN converted to NULL")
+	assert.Equal(t, []interface{}{nil}, args)
+}
+
+func TestBinaryFields(t *testing.T) {
+	data := []byte{0xde, 0xad, 0xbe, 0xef, 0xfb, 0xff}
+	msg := kafka.Message{
+		TableName: "files",
+		Fields: map[string]kafka.Field{
+			"bytes":  {Type: "bytes"},
+			"string": {Type: "string", Parameters: map[string]string{sourceColumnType: "BYTEA"}},
+			"text":   {Type: "string"},
+		},
+	}
+	arg, ref, err := bindValue(Config{}, msg, "bytes", base64.StdEncoding.EncodeToString(data), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "$1", ref)
+	assert.Equal(t, data, arg)
+	arg, _, err = bindValue(Config{BinaryHandling: BinaryHandlingHex}, msg, "bytes", nil, 1)
+	assert.NoError(t, err)
+	assert.Nil(t, arg)
+	_, _, err = bindValue(Config{}, msg, "bytes", "not base64!", 1)
+	assert.Error(t, err)
+
+	for mode, encoded := range map[string]string{
+		BinaryHandlingBase64:        base64.StdEncoding.EncodeToString(data),
+		BinaryHandlingBase64URLSafe: base64.URLEncoding.EncodeToString(data),
+		BinaryHandlingHex:           "deadbeeffbff",
+	} {
+		arg, _, err = bindValue(Config{BinaryHandling: mode}, msg, "string", encoded, 1)
+		assert.NoError(t, err, mode)
+		assert.Equal(t, data, arg, mode)
+	}
+	_, _, err = bindValue(Config{BinaryHandling: BinaryHandlingHex}, msg, "string", "xyz", 1)
+	assert.Error(t, err)
+
+	cfg := Config{BinaryHandling: BinaryHandlingHex, ColumnTypes: map[string]string{"files.text": "bytea"}}
+	arg, ref, err = bindValue(cfg, msg, "text", "DEADBEEFFBFF", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "$1::bytea", ref)
+	assert.Equal(t, data, arg)
+	arg, _, _ = bindValue(Config{BinaryHandling: BinaryHandlingHex}, msg, "text", "cafe", 1)
+	assert.Equal(t, "cafe", arg, "text values are kept")
+}
+
+func TestInfiniteTemporalValues(t *testing.T) {
+	msg := kafka.Message{
+		TableName: "events",
+		Fields: map[string]kafka.Field{
+			"d":  {Type: "int32", Name: logicalDate},
+			"ts": {Type: "int64", Name: logicalMicroTimestamp},
+			"zt": {Type: "string", Name: "io.debezium.time.ZonedTimestamp"},
+			"n":  {Type: "int64"},
+		},
+	}
+	for _, c := range []struct {
+		column   string
+		value    interface{}
+		expected interface{}
+		clamped  interface{}
+	}{
+		{"d", json.Number("2147483647"), "infinity", maxTimestamp},
+		{"d", json.Number("-2147483648"), "-infinity", minTimestamp},
+		{"ts", json.Number("9223372036825200000"), "infinity", maxTimestamp},
+		{"ts", json.Number("-9223372036832400000"), "-infinity", minTimestamp},
+		{"zt", "infinity", "infinity", maxTimestamp},
+		{"zt", "-Infinity", "-infinity", minTimestamp},
+		{"d", json.Number("1"), time.Date(1970, 1, 2, 0, 0, 0, 0, time.UTC), time.Date(1970, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"n", json.Number("9223372036825200000"), int64(9223372036825200000), int64(9223372036825200000)},
+	} {
+		v, _, err := bindValue(Config{}, msg, c.column, c.value, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, c.expected, v, "%s %v", c.column, c.value)
+		v, _, err = bindValue(Config{ClampInfinity: true}, msg, c.column, c.value, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, c.clamped, v, "%s %v clamped", c.column, c.value)
+	}
+
+	// far future timestamps don't overflow
+	v, err := convertEpoch(json.Number("32503680000000000"), time.Microsecond)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(3000, 1, 1, 0, 0, 0, 0, time.UTC), v)
+	v, err = convertEpoch(json.Number("-1500"), time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(1969, 12, 31, 23, 59, 58, 500000000, time.UTC), v)
+}
+
+func TestLtreeFields(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestLtreeFields")
+	var sql string
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql = s
+			return pgconn.CommandTag("UPDATE 1"), nil
+		},
+	}
+	msg := kafka.Message{
+		TableName: "categories",
+		Keys:      map[string]interface{}{"path": "Top.Science"},
+		Values:    map[string]interface{}{"parent": "Top"},
+		Fields: map[string]kafka.Field{
+			"path":   {Type: "string", Name: logicalLtree},
+			"parent": {Type: "string", Parameters: map[string]string{sourceColumnType: "LTREE"}},
+		},
+	}
+	_, err := updateCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `UPDATE "categories" SET ("parent")=($2::ltree) WHERE ("path")=($1::ltree)`, sql)
+
+	msg.Fields = nil
+	_, err = updateCDCItem(context.Background(), conn, Config{ColumnTypes: map[string]string{"categories.path": "ltree", "categories.parent": "ltree"}}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `UPDATE "categories" SET ("parent")=($2::ltree) WHERE ("path")=($1::ltree)`, sql, "configured types")
+}
+
+func TestTextSearchFields(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestTextSearchFields")
+	var (
+		sql  string
+		args []interface{}
+	)
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql, args = s, a
+			return pgconn.CommandTag("UPDATE 1"), nil
+		},
+	}
+	msg := kafka.Message{
+		TableName: "posts",
+		Keys:      map[string]interface{}{"id": int64(1)},
+		Values:    map[string]interface{}{"search": "'fat':2A 'rat':3"},
+		Fields: map[string]kafka.Field{
+			"search": {Type: "string", Parameters: map[string]string{sourceColumnType: "TSVECTOR"}},
+		},
+	}
+	_, err := insertCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "posts"("search") VALUES ($1::tsvector)`, sql, "weights are kept")
+	assert.Equal(t, []interface{}{"'fat':2A 'rat':3"}, args)
+
+	cfg := Config{ColumnExpressions: map[string]string{"posts.search": "to_tsvector('english', $title || ' ' || ${Body})"}}
+	msg.Values = map[string]interface{}{"title": "Fat rat", "Body": "text", "search": "'fat':1 'rat':2"}
+	_, err = insertCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Contains(t, sql, `,"search") VALUES (`)
+	assert.Regexp(t, `to_tsvector\('english', \$\d \|\| ' ' \|\| \$\d\)\)$`, sql)
+	assert.Len(t, args, 2, "recomputed value is not bound")
+
+	// title is unchanged, so search is not recomputed
+	msg.Values = map[string]interface{}{"Body": "text", "search": "'text':1"}
+	_, err = updateCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `UPDATE "posts" SET ("Body")=($2) WHERE ("id")=($1)`, sql)
+
+	// guarded insert matches the key despite the computed column
+	cfg.InsertMode = InsertModeGuarded
+	msg.Values = map[string]interface{}{"title": "Fat rat", "Body": "text"}
+	_, err = insertCDCItem(context.Background(), conn, cfg, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{int64(1)}, args[2:])
+
+	ref, ok := expandExpression("lower($a) || $b", map[string]string{"a": "$1::text", "b": "$2"})
+	assert.True(t, ok)
+	assert.Equal(t, "lower($1::text) || $2", ref)
+	_, ok = expandExpression("lower($c)", map[string]string{"a": "$1"})
+	assert.False(t, ok)
+}
+
+func TestCompositeFields(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestCompositeFields")
+	var (
+		sql  string
+		args []interface{}
+	)
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql, args = s, a
+			return pgconn.CommandTag("INSERT 0 1"), nil
+		},
+	}
+	address := kafka.Field{Type: "struct", Optional: true, Fields: []kafka.Field{
+		{Type: "string", Optional: true, Attribute: "street"},
+		{Type: "int32", Optional: true, Attribute: "zip"},
+		{Type: "array", Optional: true, Attribute: "lines", Items: &kafka.Field{Type: "string"}},
+		{Type: "struct", Optional: true, Attribute: "geo", Fields: []kafka.Field{
+			{Type: "double", Attribute: "lat"},
+			{Type: "double", Attribute: "lon"},
+		}},
+	}}
+	msg := kafka.Message{
+		TableName: "customers",
+		Values: map[string]interface{}{"address": map[string]interface{}{
+			"street": `Main "St"`,
+			"zip":    json.Number("12345"),
+			"lines":  []interface{}{"a", "b"},
+			"geo":    map[string]interface{}{"lat": json.Number("1.5"), "lon": json.Number("2")},
+		}},
+		Fields: map[string]kafka.Field{"address": address},
+	}
+	_, err := insertCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO "customers"("address") VALUES ($1)`, sql)
+	assert.Equal(t, []interface{}{`("Main \"St\"",12345,"{\"a\",\"b\"}","(1.5,2)")`}, args)
+
+	// struct with NULL attributes differs from NULL struct
+	msg.Values = map[string]interface{}{"address": map[string]interface{}{"street": nil, "zip": nil, "lines": nil, "geo": nil}}
+	_, err = insertCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"(,,,)"}, args)
+
+	msg.Values = map[string]interface{}{"address": nil}
+	_, err = insertCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{nil}, args)
+}
+
+func TestRangeFields(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestRangeFields")
+	var (
+		sql  string
+		args []interface{}
+	)
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql, args = s, a
+			return pgconn.CommandTag("DELETE 1"), nil
+		},
+	}
+	for _, c := range []struct {
+		value    interface{}
+		expected interface{}
+	}{
+		{"[1,10)", "[1,10)"},
+		{" EMPTY ", "empty"},
+		{"(,5]", "(,5]"},
+		{`["2019-03-31 15:30:00+00",infinity)`, `["2019-03-31 15:30:00+00",infinity)`},
+		{map[string]interface{}{"lower": json.Number("1"), "upper": json.Number("10")}, "[1,10)"},
+		{map[string]interface{}{"lower": nil, "upper": "2020-01-01", "upper_inc": true}, `(,"2020-01-01"]`},
+		{map[string]interface{}{"lower": json.Number("1"), "lower_inc": false, "upper": nil}, "(1,)"},
+		{map[string]interface{}{"empty": true}, "empty"},
+		{nil, nil},
+	} {
+		v, err := convertRange("int4range", c.value)
+		assert.NoError(t, err)
+		assert.Equal(t, c.expected, v, c.value)
+	}
+	_, err := convertRange("daterange", "2020-01-01")
+	assert.Error(t, err)
+
+	msg := kafka.Message{
+		TableName: "bookings",
+		Keys:      map[string]interface{}{"period": "[2020-01-01,2020-02-01)"},
+		Fields: map[string]kafka.Field{
+			"period": {Type: "string", Parameters: map[string]string{sourceColumnType: "DATERANGE"}},
+		},
+	}
+	_, err = deleteCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `DELETE FROM "bookings" WHERE ("period")=($1::daterange)`, sql)
+	assert.Equal(t, []interface{}{"[2020-01-01,2020-02-01)"}, args)
+
+	msg.Fields = nil
+	msg.Keys = map[string]interface{}{"period": "empty"}
+	_, err = deleteCDCItem(context.Background(), conn, Config{ColumnTypes: map[string]string{"bookings.period": "tstzrange"}}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `DELETE FROM "bookings" WHERE ("period")=($1::tstzrange)`, sql)
+	assert.Equal(t, []interface{}{"empty"}, args)
+}
+
+func TestIntervalFields(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestIntervalFields")
+	var (
+		sql  string
+		args []interface{}
+	)
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql, args = s, a
+			return pgconn.CommandTag("DELETE 1"), nil
+		},
+	}
+	for _, c := range []struct {
+		value    interface{}
+		expected interface{}
+	}{
+		{"P1Y2M3DT4H5M6S", "P1Y2M3DT4H5M6S"},
+		{"P0Y0M0DT-1H0M0.5S", "P0Y0M0DT-1H0M0.5S"},
+		{" pt0s", "PT0S"},
+		{json.Number("3723000000"), "3723000000 microseconds"},
+		{nil, nil},
+	} {
+		v, err := convertInterval(c.value)
+		assert.NoError(t, err)
+		assert.Equal(t, c.expected, v, c.value)
+	}
+	for _, invalid := range []interface{}{"P", "PT", "1 day", "P1H", json.Number("1.5")} {
+		_, err := convertInterval(invalid)
+		assert.Error(t, err, invalid)
+	}
+
+	msg := kafka.Message{
+		TableName: "plans",
+		Keys:      map[string]interface{}{"period": "P1Y2M3DT4H5M6S", "seats": "[1,10)"},
+		Fields: map[string]kafka.Field{
+			"period": {Type: "string", Name: logicalInterval},
+			"seats":  {Type: "string", Parameters: map[string]string{sourceColumnType: "INT4RANGE"}},
+		},
+	}
+	_, err := deleteCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	if args[0] == "[1,10)" {
+		assert.Equal(t, `DELETE FROM "plans" WHERE ("seats","period")=($1::int4range,$2::interval)`, sql)
+		assert.Equal(t, []interface{}{"[1,10)", "P1Y2M3DT4H5M6S"}, args)
+	} else {
+		assert.Equal(t, `DELETE FROM "plans" WHERE ("period","seats")=($1::interval,$2::int4range)`, sql)
+		assert.Equal(t, []interface{}{"P1Y2M3DT4H5M6S", "[1,10)"}, args)
+	}
+
+	msg.Keys = map[string]interface{}{"period": json.Number("86400000000")}
+	msg.Fields["period"] = kafka.Field{Type: "int64", Name: logicalMicroDuration}
+	_, err = deleteCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Equal(t, `DELETE FROM "plans" WHERE ("period")=($1::interval)`, sql)
+	assert.Equal(t, []interface{}{"86400000000 microseconds"}, args)
+}
+
+func TestUnsignedFields(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestUnsignedFields")
+	unsigned := map[string]string{sourceColumnType: "BIGINT UNSIGNED"}
+	msg := kafka.Message{
+		TableName: "counters",
+		Fields: map[string]kafka.Field{
+			"long":    {Type: "int64", Parameters: unsigned},
+			"signed":  {Type: "int64"},
+			"precise": {Type: "bytes", Name: logicalDecimal, Parameters: map[string]string{"scale": "0", sourceColumnType: "BIGINT UNSIGNED"}},
+			"untyped": {},
+		},
+	}
+	for _, c := range []struct {
+		column   string
+		value    interface{}
+		expected interface{}
+	}{
+		// just above the signed maximum
+		{"long", json.Number("-9223372036854775808"), uint64(9223372036854775808)},
+		{"long", json.Number("-1"), uint64(18446744073709551615)},
+		{"long", json.Number("9223372036854775807"), int64(9223372036854775807)},
+		{"signed", json.Number("-1"), int64(-1)},
+		{"signed", json.Number("9223372036854775808"), uint64(9223372036854775808)},
+		{"untyped", json.Number("9223372036854775808"), uint64(9223372036854775808)},
+		{"precise", "AIAAAAAAAAAA", uint64(9223372036854775808)},
+		{"precise", "AP//////////", uint64(18446744073709551615)},
+		{"precise", "AQ==", uint64(1)},
+		{"precise", nil, nil},
+	} {
+		arg, _, err := bindValue(Config{}, msg, c.column, c.value, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, c.expected, arg, "%s: %v", c.column, c.value)
+	}
+	for _, invalid := range []string{"gA==", "AQAAAAAAAAAAAA=="} {
+		_, _, err := bindValue(Config{}, msg, "precise", invalid, 1)
+		assert.Error(t, err, invalid)
+	}
+	_, _, err := bindValue(Config{}, msg, "signed", json.Number("18446744073709551616"), 1)
+	assert.Error(t, err, "out of range")
+}
+
+func TestSystemTypeFields(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestSystemTypeFields")
+	var (
+		sql  string
+		args []interface{}
+	)
+	conn := MockDbExec{
+		ExecHandler: func(s string, a []interface{}) (pgconn.CommandTag, error) {
+			sql, args = s, a
+			return pgconn.CommandTag("UPDATE 1"), nil
+		},
+	}
+	msg := kafka.Message{
+		TableName: "checkpoints",
+		Keys:      map[string]interface{}{"relid": json.Number("16384")},
+		Values:    map[string]interface{}{"xmin": json.Number("4294967295"), "lsn": "16/b374d848"},
+		Fields: map[string]kafka.Field{
+			"relid": {Type: "int64", Parameters: map[string]string{sourceColumnType: "OID"}},
+			"xmin":  {Type: "int64", Parameters: map[string]string{sourceColumnType: "XID"}},
+			"lsn":   {Type: "string", Parameters: map[string]string{sourceColumnType: "PG_LSN"}},
+		},
+	}
+	_, err := updateCDCItem(context.Background(), conn, Config{}, msg)
+	assert.NoError(t, err)
+	assert.Contains(t, sql, `WHERE ("relid")=($1::oid)`)
+	assert.Contains(t, sql, `$2::`)
+	assert.ElementsMatch(t, []interface{}{"16384", "4294967295", "16/B374D848"}, args)
+
+	v, err := convertLSN(json.Number("97500059720"))
+	assert.NoError(t, err)
+	assert.Equal(t, "16/B374D848", v)
+	_, err = convertLSN("16-B374D848")
+	assert.Error(t, err)
+	_, err = convertLSN("123456789/0")
+	assert.Error(t, err)
+	_, err = convertUnsigned("xid", json.Number("-1"))
+	assert.Error(t, err)
+}
+
+func TestDecimalHandling(t *testing.T) {
+	precise := kafka.Field{Type: "bytes", Name: logicalDecimal, Parameters: map[string]string{"scale": "2"}}
+	variable := kafka.Field{Type: "struct", Name: logicalVariableScaleDecimal}
+	str := kafka.Field{Type: "string", Parameters: map[string]string{sourceColumnType: "NUMERIC"}}
+	double := kafka.Field{Type: "double", Parameters: map[string]string{sourceColumnType: "DECIMAL"}}
+	for _, c := range []struct {
+		f        kafka.Field
+		value    interface{}
+		mode     string
+		expected interface{}
+	}{
+		{precise, base64.StdEncoding.EncodeToString([]byte{0x30, 0x39}), DecimalHandlingPrecise, "123.45"},
+		{variable, map[string]interface{}{"scale": json.Number("1"), "value": "AQ=="}, DecimalHandlingPrecise, "0.1"},
+		{str, "12345678901234567890.123", DecimalHandlingString, "12345678901234567890.123"},
+		{double, json.Number("123.45"), DecimalHandlingDouble, "123.45"},
+		// no schema, shape of the value decides
+		{kafka.Field{}, json.Number("1e3"), DecimalHandlingDouble, "1000"},
+		// scientific notation is expanded, so the value is stored exactly
+		{double, json.Number("1.200000055E7"), DecimalHandlingDouble, "12000000.55"},
+		{double, 12000000.55, DecimalHandlingDouble, "12000000.55"},
+		{double, json.Number("-1.5e-3"), DecimalHandlingDouble, "-0.0015"},
+		{str, "12.5E+2", DecimalHandlingString, "1250"},
+		{kafka.Field{}, " -0.5 ", DecimalHandlingString, "-0.5"},
+		{kafka.Field{}, map[string]interface{}{"scale": json.Number("0"), "value": "AQ=="}, DecimalHandlingPrecise, "1"},
+		{str, nil, DecimalHandlingPrecise, nil},
+	} {
+		v, err := convertDecimalValue("", c.f, "amount", c.value)
+		assert.NoError(t, err)
+		assert.Equal(t, c.expected, v, c.value)
+		v, err = convertDecimalValue(c.mode, c.f, "amount", c.value)
+		assert.NoError(t, err)
+		assert.Equal(t, c.expected, v, c.value)
+	}
+
+	assert.Equal(t, DecimalHandlingDouble, decimalHandling(double, "1.5"), "the schema type decides over the value")
+	assert.Equal(t, DecimalHandlingDouble, decimalHandling(kafka.Field{Type: "float"}, "1.5"))
+
+	_, err := convertDecimalValue(DecimalHandlingPrecise, double, "amount", json.Number("1.5"))
+	assert.EqualError(t, err, `Decimal value of column "amount" is sent in double mode, but precise mode is expected; `+
+		`check decimal.handling.mode of the connector`)
+	_, err = convertDecimalValue("", str, "amount", "n/a")
+	assert.Error(t, err)
+	_, err = convertDecimalValue("", kafka.Field{}, "amount", "AQ==")
+	assert.Error(t, err, "scale is unknown without schema")
+	_, err = convertDecimalValue("", double, "amount", json.Number("1e999999"))
+	assert.Error(t, err, "exponent beyond numeric range")
+
+	msg := kafka.Message{
+		TableName: "prices",
+		Values:    map[string]interface{}{"amount": json.Number("0.1")},
+		Fields:    map[string]kafka.Field{"amount": double},
+	}
+	arg, ref, err := bindValue(Config{}, msg, "amount", msg.Values["amount"], 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "0.1", arg, "double is not rounded to the binary float")
+	arg, _, err = bindValue(Config{}, msg, "amount", json.Number("12000000.55"), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "12000000.55", arg, "exact decimal string")
+	assert.Equal(t, "$1", ref)
+	_, _, err = bindValue(Config{DecimalHandling: DecimalHandlingString}, msg, "amount", msg.Values["amount"], 1)
+	assert.True(t, errors.Is(err, ErrPayloadDecode))
+}
+
+func TestTimePrecisionModes(t *testing.T) {
+	values := map[string]interface{}{
+		"d":  json.Number("18628"),
+		"ts": json.Number("1609459200123"),
+		"t":  json.Number("45296789"),
+	}
+	expected := map[string]interface{}{
+		"d":  time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		"ts": time.Date(2021, 1, 1, 0, 0, 0, 123000000, time.UTC),
+		"t":  "12:34:56.789",
+	}
+	for mode, fields := range map[string]map[string]kafka.Field{
+		"adaptive": {
+			"d":  {Type: "int32", Name: logicalDate},
+			"ts": {Type: "int64", Name: logicalTimestamp},
+			"t":  {Type: "int32", Name: logicalTime},
+		},
+		"connect": {
+			"d":  {Type: "int32", Name: logicalConnectDate},
+			"ts": {Type: "int64", Name: logicalConnectTimestamp},
+			"t":  {Type: "int32", Name: logicalConnectTime},
+		},
+	} {
+		for column, f := range fields {
+			v, err := convertValue(f, column, "", values[column])
+			assert.NoError(t, err)
+			assert.Equal(t, expected[column], v, "%s mode, column %s", mode, column)
+		}
+		assert.Equal(t, 1, infinity(fields["d"], json.Number("2147483647")), mode)
+		assert.Equal(t, "date", fieldType(Config{}, fields["d"]), mode)
+		assert.Equal(t, "time", fieldType(Config{}, fields["t"]), mode)
+	}
+
+	v, err := convertTimeOfDay(json.Number("45296789012"), time.Microsecond)
+	assert.NoError(t, err)
+	assert.Equal(t, "12:34:56.789012", v)
+	v, err = convertTimeOfDay(json.Number("86400000000000"), time.Nanosecond)
+	assert.NoError(t, err)
+	assert.Equal(t, "24:00:00", v)
+}
+
+func TestSchemaDrivenConversion(t *testing.T) {
+	m, err := kafka.NewMessage(kafkago.Message{
+		Key: []byte(`{"schema":{"type":"struct","fields":[{"type":"int64","optional":false,"field":"id"}]},"payload":{"id":9007199254740993}}`),
+		Value: []byte(`{"schema":{"type":"struct","fields":[` +
+			`{"type":"struct","optional":true,"field":"before","fields":[{"type":"int64","optional":false,"field":"id"}]},` +
+			`{"type":"struct","optional":true,"field":"after","fields":[` +
+			`{"type":"int64","optional":false,"field":"id"},` +
+			`{"type":"double","optional":true,"field":"ratio"},` +
+			`{"type":"int32","optional":true,"field":"count"},` +
+			`{"type":"boolean","optional":true,"field":"active"},` +
+			`{"type":"int32","optional":true,"name":"io.debezium.time.Date","field":"day"},` +
+			`{"type":"int64","optional":true,"name":"io.debezium.time.MicroTimestamp","field":"at"},` +
+			`{"type":"bytes","optional":true,"name":"org.apache.kafka.connect.data.Decimal","parameters":{"scale":"2"},"field":"price"}]}]},` +
+			`"payload":{"op":"u","before":{"id":9007199254740993},"after":{"id":9007199254740993,"ratio":1,"count":3,` +
+			`"active":true,"day":18628,"at":1609459200123456,"price":"MDk="},"source":{"table":"t"}}}`),
+	})
+	assert.NoError(t, err)
+	expected := map[string]interface{}{
+		"id":     int64(9007199254740993),
+		"ratio":  float64(1),
+		"count":  int64(3),
+		"active": true,
+		"day":    time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+		"at":     time.Date(2021, 1, 1, 0, 0, 0, 123456000, time.UTC),
+		"price":  "123.45",
+	}
+	for column, v := range m.Values {
+		arg, _, err := bindValue(Config{}, *m, column, v, 1)
+		assert.NoError(t, err)
+		assert.Equal(t, expected[column], arg, column)
+	}
+	arg, _, err := bindValue(Config{}, *m, "id", m.Keys["id"], 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(9007199254740993), arg, "integers above 2^53 keep precision")
+}
@@ -0,0 +1,152 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+)
+
+// widenedColumns counts target columns widened by `cfg.WidenColumns`
+var widenedColumns uint64
+
+// SQLSTATEs of values not fitting the target columns
+const (
+	sqlstateNumericOutOfRange = "22003"
+	sqlstateStringTruncation  = "22001"
+)
+
+// sourceColumnLength is the schema parameter holding the source column length if `column.propagate.source.type`
+// is enabled
+const sourceColumnLength = "__debezium.source.column.length"
+
+var (
+	reVarchar = regexp.MustCompile(`^character varying\((\d+)\)$`)
+	reNumeric = regexp.MustCompile(`^numeric\((\d+),(\d+)\)$`)
+)
+
+// integerRanks orders integer types by their range
+var integerRanks = map[string]int{"smallint": 1, "integer": 2, "bigint": 3}
+
+// isOverflow returns true if `err` reports the value out of range or too long for the target column
+func isOverflow(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && (pgErr.Code == sqlstateNumericOutOfRange || pgErr.Code == sqlstateStringTruncation)
+}
+
+// sourceType returns the PostgreSQL type of the `column` matching the Debezium schema, with the length or precision
+// of the source column if propagated
+func sourceType(cfg Config, m kafka.Message, column string) string {
+	t := columnType(cfg, m, column)
+	f := m.Fields[column]
+	switch {
+	case t == "text" && f.Type == "string" && castFor(cfg, m, column) == "":
+		source := strings.ToUpper(f.Parameters[sourceColumnType])
+		if length, err := strconv.Atoi(f.Parameters[sourceColumnLength]); err == nil && strings.Contains(source, "VARCHAR") {
+			return fmt.Sprintf("character varying(%d)", length)
+		}
+	case t == "numeric" && f.Name == logicalDecimal:
+		precision, err := strconv.Atoi(f.Parameters["connect.decimal.precision"])
+		scale, scaleErr := strconv.Atoi(f.Parameters["scale"])
+		if err == nil && scaleErr == nil {
+			return fmt.Sprintf("numeric(%d,%d)", precision, scale)
+		}
+	}
+	return t
+}
+
+// widens returns true if the type `to` holds all the values of the type `from` and more, so changing the column
+// type loses nothing. Types are named as format_type() does
+func widens(from, to string) bool {
+	from, to = strings.ToLower(from), strings.ToLower(to)
+	if from == to {
+		return false
+	}
+	if rank, ok := integerRanks[from]; ok {
+		return integerRanks[to] > rank || to == "numeric"
+	}
+	if from == "real" {
+		return to == "double precision"
+	}
+	if m := reNumeric.FindStringSubmatch(from); m != nil {
+		if to == "numeric" {
+			return true
+		}
+		n := reNumeric.FindStringSubmatch(to)
+		if n == nil {
+			return false
+		}
+		p, _ := strconv.Atoi(m[1])
+		s, _ := strconv.Atoi(m[2])
+		p2, _ := strconv.Atoi(n[1])
+		s2, _ := strconv.Atoi(n[2])
+		return s2 >= s && p2-s2 >= p-s
+	}
+	if m := reVarchar.FindStringSubmatch(from); m != nil {
+		if to == "text" || to == "character varying" {
+			return true
+		}
+		n := reVarchar.FindStringSubmatch(to)
+		if n == nil {
+			return false
+		}
+		length, _ := strconv.Atoi(m[1])
+		length2, _ := strconv.Atoi(n[1])
+		return length2 > length
+	}
+	return false
+}
+
+// widenColumns changes types of the target columns of the CDC item narrower than the types of the Debezium schema to
+// the wider ones, returns the number of columns changed. Columns are never narrowed
+func widenColumns(ctx context.Context, conn DBExecutorContext, cfg Config, m kafka.Message) (int, error) {
+	querier, ok := conn.(DBQuerierContext)
+	if !ok {
+		return 0, nil
+	}
+	var (
+		exists         bool
+		columns, types []string
+	)
+	table := m.QualifiedTablename()
+	if err := querier.QueryRow(ctx, sqlTableColumns, table).Scan(&exists, &columns, &types); err != nil {
+		return 0, classify(ErrDBExec, err)
+	}
+	targetTypes := make(map[string]string, len(columns))
+	for i, c := range columns {
+		targetTypes[c] = types[i]
+	}
+	names := make([]string, 0, len(m.Values))
+	for c := range m.Values {
+		names = append(names, c)
+	}
+	sort.Strings(names)
+	widened := 0
+	for _, c := range names {
+		target, ok := targetTypes[c]
+		to := sourceType(cfg, m, c)
+		if !ok || !widens(target, to) {
+			continue
+		}
+		ddl := fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s", table, pgx.Identifier{c}.Sanitize(), to)
+		if _, err := conn.Exec(ctx, ddl); err != nil {
+			return widened, classify(ErrDBExec, err)
+		}
+		if err := recordSchemaChange(ctx, conn, cfg, m, c, ddl); err != nil {
+			return widened, err
+		}
+		atomic.AddUint64(&widenedColumns, 1)
+		loggerOf(cfg).WithField("table", table).WithField("column", c).WithField("from", target).WithField("to", to).
+			Warning("Target table evolved: column type widened")
+		widened++
+	}
+	return widened, nil
+}
@@ -0,0 +1,111 @@
+package postgres
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/cybertec-postgresql/debezium2postgres/internal/kafka"
+	"github.com/jackc/pgconn"
+	pgx "github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWidens(t *testing.T) {
+	for _, c := range []struct {
+		from, to string
+		widens   bool
+	}{
+		{"integer", "bigint", true},
+		{"smallint", "integer", true},
+		{"integer", "numeric", true},
+		{"bigint", "integer", false},
+		{"integer", "integer", false},
+		{"integer", "text", false},
+		{"real", "double precision", true},
+		{"double precision", "real", false},
+		{"numeric(10,2)", "numeric(12,2)", true},
+		{"numeric(10,2)", "numeric(12,4)", true},
+		{"numeric(10,2)", "numeric(10,4)", false},
+		{"numeric(10,2)", "numeric", true},
+		{"numeric", "numeric(10,2)", false},
+		{"character varying(50)", "character varying(200)", true},
+		{"character varying(50)", "text", true},
+		{"character varying(200)", "character varying(50)", false},
+		{"text", "character varying(50)", false},
+		{"character(5)", "text", false},
+	} {
+		assert.Equal(t, c.widens, widens(c.from, c.to), c.from+" -> "+c.to)
+	}
+}
+
+func TestSourceType(t *testing.T) {
+	m := kafka.Message{TableName: "items", Fields: map[string]kafka.Field{
+		"name":  {Type: "string", Parameters: map[string]string{sourceColumnType: "VARCHAR", sourceColumnLength: "200"}},
+		"code":  {Type: "string", Parameters: map[string]string{sourceColumnType: "BPCHAR", sourceColumnLength: "5"}},
+		"note":  {Type: "string"},
+		"price": {Type: "bytes", Name: logicalDecimal, Parameters: map[string]string{"scale": "2", "connect.decimal.precision": "12"}},
+		"id":    {Type: "int64"},
+	}}
+	assert.Equal(t, "character varying(200)", sourceType(Config{}, m, "name"))
+	assert.Equal(t, "text", sourceType(Config{}, m, "code"))
+	assert.Equal(t, "text", sourceType(Config{}, m, "note"))
+	assert.Equal(t, "numeric(12,2)", sourceType(Config{}, m, "price"))
+	assert.Equal(t, "bigint", sourceType(Config{}, m, "id"))
+	assert.Equal(t, "citext", sourceType(Config{ColumnTypes: map[string]string{"items.name": "citext"}}, m, "name"))
+}
+
+func TestWidenColumns(t *testing.T) {
+	Logger = logrus.New().WithField("method", "TestWidenColumns")
+	var statements []string
+	widened := false
+	conn := MockDbQuerier{
+		MockDbExec: MockDbExec{ExecHandler: func(sql string, arguments []interface{}) (pgconn.CommandTag, error) {
+			statements = append(statements, sql)
+			switch {
+			case strings.HasPrefix(sql, "ALTER TABLE"):
+				widened = true
+				return pgconn.CommandTag("ALTER TABLE"), nil
+			case strings.HasPrefix(sql, `INSERT INTO "dbz2pg_schema_changes"`):
+				assert.Equal(t, []interface{}{`"items"`, "id", `ALTER TABLE "items" ALTER COLUMN "id" TYPE bigint`, "", 0, int64(0)}, arguments)
+				return pgconn.CommandTag("INSERT 0 1"), nil
+			case !widened:
+				return nil, &pgconn.PgError{Code: "22003", Message: "integer out of range"}
+			}
+			return pgconn.CommandTag("INSERT 0 1"), nil
+		}},
+		QueryRowHandler: func(sql string, args []interface{}) pgx.Row {
+			return MockRow{Values: []interface{}{true, []string{"id", "qty"}, []string{"integer", "bigint"}}}
+		},
+	}
+	m := kafka.Message{
+		Op:        "c",
+		TableName: "items",
+		Values:    map[string]interface{}{"id": int64(3000000000), "qty": int64(1)},
+		Fields:    map[string]kafka.Field{"id": {Type: "int64"}, "qty": {Type: "int32"}},
+	}
+	evolved := Stats().WidenedColumns
+	_, err := applyDriftingCDCItem(context.Background(), conn, Config{}, m)
+	assert.True(t, isOverflow(err), "widening is opt-in")
+	assert.Len(t, statements, 1)
+
+	statements = nil
+	cfg := Config{WidenColumns: true, SchemaChangesTable: "dbz2pg_schema_changes"}
+	rows, err := applyDriftingCDCItem(context.Background(), conn, cfg, m)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, rows)
+	if assert.Len(t, statements, 4) {
+		assert.Equal(t, `ALTER TABLE "items" ALTER COLUMN "id" TYPE bigint`, statements[1], "qty isn't narrowed")
+		assert.Equal(t, `INSERT INTO "items"("id","qty") VALUES ($1,$2)`, statements[3])
+	}
+	assert.Equal(t, evolved+1, Stats().WidenedColumns)
+
+	// nothing to widen, the error is returned after a single retry check
+	statements = nil
+	widened = false
+	m.Fields["id"] = kafka.Field{Type: "int32"}
+	_, err = applyDriftingCDCItem(context.Background(), conn, cfg, m)
+	assert.Error(t, err)
+	assert.Len(t, statements, 1)
+}
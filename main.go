@@ -3,6 +3,8 @@ package main
 import (
 	"context"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/cybertec-postgresql/debezium2postgres/internal/cmdparser"
@@ -22,8 +24,225 @@ func main() {
 	log := initLog(cmdOpts.LogLevel)
 	log.WithField("options", cmdOpts).Debug("Starting CDC migration...")
 
-	// create channel for passing messages to database worker
-	var msgChannel chan kafka.Message = make(chan kafka.Message, 16)
-	kafka.Consume(context.Background(), cmdOpts.Kafka, cmdOpts.Topic, msgChannel)
-	postgres.Apply(context.Background(), cmdOpts.Postgres, time.Duration(cmdOpts.Timeout)*time.Second, msgChannel)
+	// stop applying on interrupt, messages already consumed are applied within the grace period
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		cancel()
+	}()
+	if cmdOpts.ApplicationName > "" || cmdOpts.StatementCacheMode > "" {
+		postgres.Connect = postgres.ConnectWithOptions(postgres.ConnOptions{
+			ApplicationName:    cmdOpts.ApplicationName,
+			StatementCacheMode: cmdOpts.StatementCacheMode,
+		})
+	}
+	var expressionFields map[string]bool
+	if len(cmdOpts.ColumnExpressions) > 0 {
+		if expressionFields, err = postgres.CheckColumnExpressions(ctx, cmdOpts.Postgres, cmdOpts.ColumnExpressions); err != nil {
+			log.Error(err)
+			osExit(1)
+		}
+	}
+	cfg := postgres.Config{
+		IdleTimeout:          time.Duration(cmdOpts.Timeout) * time.Second,
+		BatchSize:            cmdOpts.BatchSize,
+		FlushInterval:        cmdOpts.FlushInterval,
+		CollapseBatches:      cmdOpts.CollapseBatches,
+		IsolateItems:         cmdOpts.IsolateItems,
+		ErrorPolicy:          cmdOpts.ErrorPolicy,
+		StagingKind:          cmdOpts.StagingKind,
+		StagingSchema:        cmdOpts.StagingSchema,
+		MaxWritesPerSecond:   cmdOpts.MaxWritesPerSecond,
+		SnapshotCopy:         cmdOpts.SnapshotCopy,
+		SnapshotCopySize:     cmdOpts.SnapshotCopySize,
+		SnapshotCopyInterval: cmdOpts.SnapshotCopyInterval,
+		ShutdownGrace:        time.Duration(cmdOpts.ShutdownGrace) * time.Second,
+		GroupTransactions:    cmdOpts.GroupTransactions,
+		TransactionMaxEvents: cmdOpts.TransactionMaxEvents,
+		TransactionTimeout:   cmdOpts.TransactionTimeout,
+		Ledger:               cmdOpts.Ledger,
+		ColumnTypes:          cmdOpts.ColumnTypes,
+		ColumnExpressions:    cmdOpts.ColumnExpressions,
+		ExpressionFields:     expressionFields,
+		CharPadding:          cmdOpts.CharPadding,
+		PostGIS:              cmdOpts.PostGIS,
+		AppendMode:           cmdOpts.AppendMode,
+		SchemaDrift:          cmdOpts.SchemaDrift,
+		WidenColumns:         cmdOpts.WidenColumns,
+		SchemaChangesTable:   cmdOpts.SchemaChangesTable,
+		AutoCreateTables:     cmdOpts.AutoCreateTables || cmdOpts.AutoCreateDryRun,
+		CaseFold:             cmdOpts.CaseFold,
+		ApplyDDL:             cmdOpts.ApplyDDL,
+		AllowDestructiveDDL:  cmdOpts.AllowDestructiveDDL,
+		TranslateDDL:         cmdOpts.TranslateDDL,
+		InsertMode:           cmdOpts.InsertMode,
+		SchemaDefaults:       cmdOpts.SchemaDefaults,
+		InsertConflicts:      cmdOpts.InsertConflicts,
+		DeleteMissing:        cmdOpts.DeleteMissing,
+		IdempotentDeletes:    cmdOpts.IdempotentDeletes,
+		MatchReplicaIdentity: cmdOpts.MatchReplicaIdentity,
+		UpdateMode:           cmdOpts.UpdateMode,
+		PositionGuard:        cmdOpts.PositionGuard,
+		UpsertUpdates:        cmdOpts.UpsertUpdates,
+		SpecialNumericAsNull: cmdOpts.SpecialNumericAsNull,
+		BinaryHandling:       cmdOpts.BinaryHandling,
+		DecimalHandling:      cmdOpts.DecimalHandling,
+		ClampInfinity:        cmdOpts.ClampInfinity,
+		UpsertTables:         toSet(cmdOpts.UpsertTables),
+		UpdateOnDuplicate:    toSet(cmdOpts.UpdateOnDuplicate),
+		StagingTables:        toSet(cmdOpts.StagingTables),
+		LastWriteWins:        toSet(cmdOpts.LastWriteWins),
+		ArchiveDeletes:       toSet(cmdOpts.ArchiveDeletes),
+		KeyColumns:           toSet(cmdOpts.KeyColumns),
+		ConflictKeys:         toSet(cmdOpts.ConflictKeys),
+		CaseInsensitive:      toSet(cmdOpts.CaseInsensitive),
+		FlattenStructs:       toSet(cmdOpts.FlattenStructs),
+		NullToDefault:        toSet(cmdOpts.NullToDefault),
+		Views:                toSet(cmdOpts.Views),
+	}
+	if cmdOpts.LagThreshold > 0 {
+		cfg.LagThreshold = cmdOpts.LagThreshold
+		cfg.OnLag = func(lag time.Duration) {
+			log.WithField("lag", lag).Warning("Applying lags behind the source")
+		}
+		cfg.OnLagRecovered = func(lag time.Duration) {
+			log.WithField("lag", lag).Print("Applying caught up with the source")
+		}
+	}
+	if len(cmdOpts.ColumnRenames) > 0 {
+		cfg.ColumnMappers = postgres.RenameColumns(cmdOpts.ColumnRenames)
+	}
+	if len(cmdOpts.Partitions) > 0 {
+		cfg.Partitions = make(map[string]postgres.PartitionSpec)
+		for table, s := range cmdOpts.Partitions {
+			spec, err := postgres.ParsePartitionSpec(s)
+			if err != nil {
+				log.Error(err)
+				osExit(1)
+			}
+			cfg.Partitions[table] = spec
+		}
+	}
+	if len(cmdOpts.TablePolicies) > 0 {
+		cfg.TablePolicies = make(map[string]postgres.TablePolicy)
+		for table, s := range cmdOpts.TablePolicies {
+			policy, err := postgres.ParseTablePolicy(s)
+			if err != nil {
+				log.Error(err)
+				osExit(1)
+			}
+			cfg.TablePolicies[table] = policy
+		}
+	}
+	if len(cmdOpts.MetadataColumns) > 0 {
+		cfg.MetadataColumns = make(map[string]map[string]string)
+		for _, s := range cmdOpts.MetadataColumns {
+			table, metadata, column, err := postgres.ParseMetadataColumn(s)
+			if err != nil {
+				log.Error(err)
+				osExit(1)
+			}
+			if cfg.MetadataColumns[table] == nil {
+				cfg.MetadataColumns[table] = make(map[string]string)
+			}
+			cfg.MetadataColumns[table][metadata] = column
+		}
+	}
+	if len(cmdOpts.HistoryTables) > 0 {
+		cfg.HistoryTables = make(map[string]postgres.HistorySpec)
+		for _, s := range cmdOpts.HistoryTables {
+			table, spec, err := postgres.ParseHistoryTable(s)
+			if err != nil {
+				log.Error(err)
+				osExit(1)
+			}
+			cfg.HistoryTables[table] = spec
+		}
+	}
+	if cmdOpts.AutoCreateDryRun {
+		cfg.AutoCreateOutput = os.Stdout
+	}
+	if cmdOpts.AutoEvolve {
+		if cfg.SchemaDrift == postgres.SchemaDriftSkip {
+			log.Error("--auto-evolve conflicts with --schema-drift=skip")
+			osExit(1)
+		}
+		cfg.SchemaDrift = postgres.SchemaDriftAlter
+	}
+	// consume starts consuming the topics, resuming them after the `offsets` if not nil
+	consume := func(offsets kafka.OffsetStore) <-chan kafka.Message {
+		// create channel for passing messages to database worker
+		var msgChannel chan kafka.Message = make(chan kafka.Message, 16)
+		if cmdOpts.SchemaTopic > "" {
+			// schema changes are consumed separately to apply them before the data changes needing them
+			kafka.Consume(context.Background(), cmdOpts.Kafka, cmdOpts.Topic, offsets, cmdOpts.StartOffset, cmdOpts.EndOffset, cmdOpts.ValueCompression, msgChannel, cmdOpts.SchemaTopic)
+			return kafka.ConsumeSchemaChanges(context.Background(), cmdOpts.Kafka, cmdOpts.SchemaTopic, offsets, cmdOpts.ValueCompression, msgChannel)
+		}
+		kafka.Consume(context.Background(), cmdOpts.Kafka, cmdOpts.Topic, offsets, cmdOpts.StartOffset, cmdOpts.EndOffset, cmdOpts.ValueCompression, msgChannel)
+		return msgChannel
+	}
+	if cmdOpts.Command == "validate-schema" {
+		// validated before any setup of the target, so nothing is changed there
+		diffs, err := postgres.ValidateSchema(ctx, cmdOpts.Postgres, cfg, consume(nil))
+		if err != nil {
+			log.Error(err)
+			osExit(1)
+		}
+		if len(diffs) > 0 {
+			log.WithField("differences", len(diffs)).Error("Schema drift detected")
+			osExit(1)
+		}
+		log.Info("No schema drift detected")
+		return
+	}
+	if len(cmdOpts.Preflight) > 0 {
+		if err := postgres.Preflight(ctx, cmdOpts.Postgres, cmdOpts.Preflight); err != nil {
+			log.Error(err)
+			osExit(1)
+		}
+	}
+	if len(cmdOpts.KeyColumns) > 0 {
+		if err := postgres.CheckKeyColumns(ctx, cmdOpts.Postgres, cmdOpts.KeyColumns); err != nil {
+			log.Error(err)
+			osExit(1)
+		}
+	}
+	var offsets kafka.OffsetStore
+	if cmdOpts.OffsetTable > "" {
+		if offsets, err = postgres.NewOffsetTable(ctx, cmdOpts.Postgres, cmdOpts.OffsetTable); err != nil {
+			log.Error(err)
+			osExit(1)
+		}
+	}
+	cfg.Offsets = offsets
+	messages := consume(offsets)
+	if cmdOpts.DLQTopic > "" {
+		// create channel for passing messages that cannot be applied to the dead-letter producer
+		var dlqChannel chan kafka.DeadLetter = make(chan kafka.DeadLetter, 16)
+		go kafka.ProduceDeadLetters(context.Background(), cmdOpts.Kafka, cmdOpts.DLQTopic, dlqChannel)
+		cfg.DeadLetters = dlqChannel
+	}
+	if len(cfg.TablePolicies) > 0 {
+		if err := postgres.CheckPlan(ctx, cmdOpts.Postgres, cfg); err != nil {
+			log.Error(err)
+			osExit(1)
+		}
+		postgres.LogPlan(cfg)
+	}
+	postgres.Apply(ctx, cmdOpts.Postgres, cfg, messages)
+}
+
+// toSet returns the set of the `items`, nil if there are none
+func toSet(items []string) map[string]bool {
+	if len(items) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(items))
+	for _, item := range items {
+		set[item] = true
+	}
+	return set
 }
@@ -9,6 +9,11 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+func TestToSet(t *testing.T) {
+	assert.Nil(t, toSet(nil))
+	assert.Equal(t, map[string]bool{"orders": true, "public.items": true}, toSet([]string{"orders", "public.items"}))
+}
+
 func TestMain(t *testing.T) {
 	osExit = func(code int) {
 		t.Logf("os.Exit(%d) called", code)